@@ -0,0 +1,86 @@
+// fsmigrate copies the whole file system, tree and content, from a repository backend to another one, for instance
+// from Neo4j to PostgreSQL. The ids are kept: the links and bookmarks of the users keep working.
+//
+// Stop the API first. The repositories are configured through the environment variables of their type, as for the
+// API (NEO4J_*, POSTGRES_URL, SQLITE_FILE, BOLT_FILE), and the destination must hold its root folder only:
+//
+//	POSTGRES_URL=postgres://... go run ./cmd/fsmigrate -from neo4j -to postgres -from-blobs tmp-files
+//
+// Without -to-blobs, the contents stay where they are and the destination references them there.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/loisfa/remote-file-system/api/fsmigration"
+	"github.com/loisfa/remote-file-system/api/fsrepository"
+	"github.com/loisfa/remote-file-system/api/fsstorage"
+)
+
+func main() {
+	from := flag.String("from", "", "type of the source repository")
+	to := flag.String("to", "", "type of the destination repository")
+	fromBlobs := flag.String("from-blobs", "", "directory of the source blob store, BLOB_STORE_DIR when empty")
+	toBlobs := flag.String("to-blobs", "", "directory of the destination blob store, the contents are not copied when empty")
+	flag.Parse()
+
+	if *from == "" || *to == "" {
+		exitWithError(fmt.Errorf("both -from and -to are required"))
+	}
+	if *from == *to {
+		// both would be configured by the same environment variables
+		exitWithError(fmt.Errorf("the source and destination repositories must have different types"))
+	}
+
+	source := fsrepository.NewFileSystemRepositoryOfType(*from)
+	defer closeRepository(source)
+	dest, ok := fsrepository.NewFileSystemRepositoryOfType(*to).(fsrepository.IImportableRepository)
+	if !ok {
+		exitWithError(fmt.Errorf("the %s repository does not support imports", *to))
+	}
+	defer closeRepository(dest)
+
+	var sourceBlobs fsstorage.BlobStore
+	if *fromBlobs != "" {
+		sourceBlobs = newLocalBlobStore(*fromBlobs)
+	} else {
+		sourceBlobs = fsstorage.NewBlobStore()
+	}
+	var destBlobs fsstorage.BlobStore
+	if *toBlobs != "" {
+		destBlobs = newLocalBlobStore(*toBlobs)
+	}
+
+	report, err := fsmigration.Migrate(context.Background(), source, sourceBlobs, dest, destBlobs)
+	if err != nil {
+		closeRepository(source)
+		closeRepository(dest)
+		exitWithError(err)
+	}
+	fmt.Printf("Migrated and verified %d folders, %d files, %d file versions, %d contents (%d bytes) and %d trash entries\n",
+		report.Folders, report.Files, report.FileVersions, report.Blobs, report.Bytes, report.TrashEntries)
+}
+
+func newLocalBlobStore(dir string) fsstorage.BlobStore {
+	store, err := fsstorage.NewLocalBlobStore(dir)
+	if err != nil {
+		exitWithError(err)
+	}
+	return store
+}
+
+// closeRepository releases the connections of the repositories which hold some
+func closeRepository(repo fsrepository.IFileSystemRepository) {
+	if closer, ok := repo.(io.Closer); ok {
+		closer.Close()
+	}
+}
+
+func exitWithError(err error) {
+	fmt.Fprintf(os.Stderr, "Migration failed: %v\n", err)
+	os.Exit(1)
+}
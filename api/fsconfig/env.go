@@ -0,0 +1,26 @@
+// Package fsconfig reads the configuration of the api from the environment variables
+package fsconfig
+
+import (
+	"fmt"
+	"os"
+)
+
+// GetEnvOrDefault returns the value of the environment variable, or the default value when it is not set
+func GetEnvOrDefault(name string, defaultValue string) string {
+	return getEnvOrDefault(name, defaultValue, fmt.Sprintf("'%s'", defaultValue))
+}
+
+// GetSecretEnvOrDefault is GetEnvOrDefault for the secrets, which are not printed
+func GetSecretEnvOrDefault(name string, defaultValue string) string {
+	return getEnvOrDefault(name, defaultValue, "******")
+}
+
+func getEnvOrDefault(name string, defaultValue string, printedDefault string) string {
+	value := os.Getenv(name)
+	if len(value) == 0 {
+		fmt.Printf("Could not find environment variable %s. Fallback to default %s\n", name, printedDefault)
+		return defaultValue
+	}
+	return value
+}
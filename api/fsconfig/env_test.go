@@ -0,0 +1,24 @@
+package fsconfig
+
+import (
+	"os"
+	"testing"
+)
+
+func TestGetEnvOrDefault(t *testing.T) {
+	const name = "FSCONFIG_TEST_VARIABLE"
+	defer os.Unsetenv(name)
+
+	os.Unsetenv(name)
+	if value := GetEnvOrDefault(name, "default"); value != "default" {
+		t.Fatalf("expected the default value, got %q", value)
+	}
+
+	os.Setenv(name, "configured")
+	if value := GetEnvOrDefault(name, "default"); value != "configured" {
+		t.Fatalf("expected the configured value, got %q", value)
+	}
+	if value := GetSecretEnvOrDefault(name, "default"); value != "configured" {
+		t.Fatalf("expected the configured value, got %q", value)
+	}
+}
@@ -0,0 +1,398 @@
+package fsmigration
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+
+	"github.com/loisfa/remote-file-system/api/fsmodel"
+	"github.com/loisfa/remote-file-system/api/fsrepository"
+	"github.com/loisfa/remote-file-system/api/fsstorage"
+	"github.com/pkg/errors"
+)
+
+const (
+	DestinationNotEmpty = "Destination not empty" // the destination holds other items than its root and trash folders
+	RootFoldersDiffer   = "Root folders differ"   // the ids are kept: both root folders, and both trash folders, must have the same id
+	VerificationFailed  = "Verification failed"   // the destination does not hold what was copied, or a content does not match its digest
+
+	// progress is printed every progressInterval items copied
+	progressInterval = 1000
+)
+
+// Report counts what was copied, the root and trash folders excluded
+type Report struct {
+	Folders      int // the trashed folders included, as the files
+	Files        int
+	Blobs        int // distinct contents: files may share their content
+	Bytes        int64
+	TrashEntries int
+	FileVersions int
+}
+
+// Migrate copies the whole tree of the source repository into the destination one, ids, names, hierarchy and
+// timestamps included, then checks that the destination holds the same tree. The trash folder and its entries are
+// copied along, as the versions of the files. The destination must hold its root folder and its empty trash folder only. The root folder is renamed
+// as the source one: its modification time is the one of the migration.
+//
+// The content of the files is copied from sourceBlobs into destBlobs under the same keys, once per key. When destBlobs
+// is nil, the destination repository keeps referencing the contents of sourceBlobs: they are only read to check them.
+// Either way, the SHA-256 of every content is checked against the digest of the files at the end.
+//
+// The tree is walked one folder at a time, nothing is read all at once. The source must not change during the
+// migration: stop the API first.
+func Migrate(ctx context.Context, source fsrepository.IFileSystemRepository, sourceBlobs fsstorage.BlobStore,
+	dest fsrepository.IImportableRepository, destBlobs fsstorage.BlobStore) (*Report, error) {
+	rootID, trashID, err := errorIfRootFoldersDiffer(ctx, source, dest)
+	if err != nil {
+		return nil, err
+	}
+	if err := errorIfNotEmpty(ctx, dest, rootID, trashID); err != nil {
+		return nil, err
+	}
+
+	root, err := source.GetFolder(ctx, rootID)
+	if err != nil {
+		return nil, err
+	}
+	if err := dest.UpdateFolder(ctx, rootID, root.Name); err != nil {
+		return nil, err
+	}
+
+	report := Report{}
+	checksums := make(map[string]string) // blob key => SHA-256 of the content read from the source
+	copyContent := func(blobKey string) error {
+		if _, copied := checksums[blobKey]; copied {
+			return nil
+		}
+		info, err := copyBlob(sourceBlobs, destBlobs, blobKey)
+		if err != nil {
+			return err
+		}
+		checksums[blobKey] = info.SHA256
+		report.Blobs++
+		report.Bytes += info.Size
+		return nil
+	}
+	err = walk(ctx, source, []string{rootID, trashID}, func(folders []fsmodel.Folder, files []fsmodel.File) error {
+		for _, folder := range folders {
+			if err := dest.ImportFolder(ctx, folder); err != nil {
+				return errors.WithMessage(err, fmt.Sprintf("Could not import folder %s", folder.Id))
+			}
+			report.Folders++
+			printProgress(report)
+		}
+
+		for _, file := range files {
+			if err := copyContent(file.BlobKey); err != nil {
+				return errors.WithMessage(err, fmt.Sprintf("Could not copy the content of file %s", file.Id))
+			}
+
+			if err := dest.ImportFile(ctx, file); err != nil {
+				return errors.WithMessage(err, fmt.Sprintf("Could not import file %s", file.Id))
+			}
+			report.Files++
+			printProgress(report)
+
+			versions, err := source.GetFileVersions(ctx, file.Id)
+			if err != nil {
+				return err
+			}
+			for _, version := range *versions {
+				if err := copyContent(version.BlobKey); err != nil {
+					return errors.WithMessage(err, fmt.Sprintf("Could not copy the content of version %d of file %s", version.Number, file.Id))
+				}
+				if err := dest.CreateFileVersion(ctx, file.Id, version); err != nil {
+					return errors.WithMessage(err, fmt.Sprintf("Could not import version %d of file %s", version.Number, file.Id))
+				}
+				report.FileVersions++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// the trashed items are imported first
+	entries, err := source.GetTrashEntries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range *entries {
+		if err := dest.ImportTrashEntry(ctx, entry); err != nil {
+			return nil, errors.WithMessage(err, fmt.Sprintf("Could not import the trash entry of item %s", entry.ItemId))
+		}
+		report.TrashEntries++
+	}
+
+	if destBlobs == nil {
+		destBlobs = sourceBlobs
+	}
+	if err := verify(ctx, source, dest, rootID, trashID, destBlobs, checksums, report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// errorIfRootFoldersDiffer returns the ids of the root and trash folders, which both repositories must share
+func errorIfRootFoldersDiffer(ctx context.Context, source fsrepository.IFileSystemRepository, dest fsrepository.IFileSystemRepository) (string, string, error) {
+	sourceRootID, err := source.GetRootFolderID(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	destRootID, err := dest.GetRootFolderID(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	if *sourceRootID != *destRootID {
+		return "", "", errors.WithMessage(errors.New(RootFoldersDiffer),
+			fmt.Sprintf("The source root folder is %s, the destination one is %s", *sourceRootID, *destRootID))
+	}
+
+	sourceTrashID, err := source.GetTrashFolderID(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	destTrashID, err := dest.GetTrashFolderID(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	if *sourceTrashID != *destTrashID {
+		return "", "", errors.WithMessage(errors.New(RootFoldersDiffer),
+			fmt.Sprintf("The source trash folder is %s, the destination one is %s", *sourceTrashID, *destTrashID))
+	}
+	return *sourceRootID, *sourceTrashID, nil
+}
+
+func errorIfNotEmpty(ctx context.Context, dest fsrepository.IFileSystemRepository, rootID string, trashID string) error {
+	for _, folderID := range []string{rootID, trashID} {
+		folders, err := dest.GetFoldersIn(ctx, folderID)
+		if err != nil {
+			return err
+		}
+		files, err := dest.GetFilesIn(ctx, folderID)
+		if err != nil {
+			return err
+		}
+		if len(*folders) > 0 || len(*files) > 0 {
+			return errors.WithMessage(errors.New(DestinationNotEmpty),
+				fmt.Sprintf("The destination folder %s holds %d folders and %d files", folderID, len(*folders), len(*files)))
+		}
+	}
+
+	entries, err := dest.GetTrashEntries(ctx)
+	if err != nil {
+		return err
+	}
+	if len(*entries) > 0 {
+		return errors.WithMessage(errors.New(DestinationNotEmpty),
+			fmt.Sprintf("The destination trash holds %d entries", len(*entries)))
+	}
+	return nil
+}
+
+// walk visits the folders breadth first, starting from the top folders given, so that a parent is always visited
+// before its children
+func walk(ctx context.Context, repo fsrepository.IFileSystemRepository, topFolderIDs []string, visit func(folders []fsmodel.Folder, files []fsmodel.File) error) error {
+	folderIDs := append([]string{}, topFolderIDs...)
+	for len(folderIDs) > 0 {
+		folderID := folderIDs[0]
+		folderIDs = folderIDs[1:]
+
+		folders, err := repo.GetFoldersIn(ctx, folderID)
+		if err != nil {
+			return err
+		}
+		files, err := repo.GetFilesIn(ctx, folderID)
+		if err != nil {
+			return err
+		}
+		if err := visit(*folders, *files); err != nil {
+			return err
+		}
+
+		for _, folder := range *folders {
+			folderIDs = append(folderIDs, folder.Id)
+		}
+	}
+	return nil
+}
+
+// copyBlob streams the content from a store to the other one, and returns its size and SHA-256. With no destination
+// store, the content is only read.
+func copyBlob(sourceBlobs fsstorage.BlobStore, destBlobs fsstorage.BlobStore, blobKey string) (*fsstorage.BlobInfo, error) {
+	if destBlobs == nil {
+		return checksumBlob(sourceBlobs, blobKey)
+	}
+
+	blob, err := sourceBlobs.Get(blobKey)
+	if err != nil {
+		return nil, err
+	}
+	defer blob.Close()
+
+	return destBlobs.Put(blobKey, blob)
+}
+
+func checksumBlob(blobs fsstorage.BlobStore, blobKey string) (*fsstorage.BlobInfo, error) {
+	blob, err := blobs.Get(blobKey)
+	if err != nil {
+		return nil, err
+	}
+	defer blob.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, blob)
+	if err != nil {
+		return nil, err
+	}
+	return &fsstorage.BlobInfo{Key: blobKey, Size: size, SHA256: hex.EncodeToString(hash.Sum(nil))}, nil
+}
+
+// verify walks the source and destination trees side by side, compares the trash entries, and reads every content
+// back from the destination store
+func verify(ctx context.Context, source fsrepository.IFileSystemRepository, dest fsrepository.IFileSystemRepository, rootID string, trashID string,
+	destBlobs fsstorage.BlobStore, checksums map[string]string, report Report) error {
+	sourceRoot, err := source.GetFolder(ctx, rootID)
+	if err != nil {
+		return err
+	}
+	destRoot, err := dest.GetFolder(ctx, rootID)
+	if err != nil {
+		return err
+	}
+	if destRoot.Name != sourceRoot.Name {
+		return verificationError("The root folder is named %s instead of %s", destRoot.Name, sourceRoot.Name)
+	}
+
+	verified := Report{}
+	err = walk(ctx, source, []string{rootID, trashID}, func(folders []fsmodel.Folder, files []fsmodel.File) error {
+		for _, folder := range folders {
+			destFolder, err := dest.GetFolder(ctx, folder.Id)
+			if err != nil {
+				return verificationError("Could not read folder %s: %v", folder.Id, err)
+			}
+			if destFolder.Name != folder.Name || destFolder.ParentId == nil || *destFolder.ParentId != *folder.ParentId {
+				return verificationError("Folder %s is %+v instead of %+v", folder.Id, *destFolder, folder)
+			}
+			verified.Folders++
+		}
+
+		for _, file := range files {
+			destFile, err := dest.GetFile(ctx, file.Id)
+			if err != nil {
+				return verificationError("Could not read file %s: %v", file.Id, err)
+			}
+			if *destFile != file {
+				return verificationError("File %s is %+v instead of %+v", file.Id, *destFile, file)
+			}
+			if file.Digest != "" && file.Digest != checksums[file.BlobKey] {
+				return verificationError("The content of file %s has the SHA-256 %s instead of its digest %s",
+					file.Id, checksums[file.BlobKey], file.Digest)
+			}
+			verified.Files++
+
+			if err := verifyFileVersions(ctx, source, dest, file.Id, checksums); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// the destination holds nothing more than the source: count what its own tree holds
+	counted := Report{}
+	err = walk(ctx, dest, []string{rootID, trashID}, func(folders []fsmodel.Folder, files []fsmodel.File) error {
+		counted.Folders += len(folders)
+		counted.Files += len(files)
+		for _, file := range files {
+			versions, err := dest.GetFileVersions(ctx, file.Id)
+			if err != nil {
+				return err
+			}
+			counted.FileVersions += len(*versions)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if counted.Folders != report.Folders || counted.Files != report.Files ||
+		verified.Folders != report.Folders || verified.Files != report.Files {
+		return verificationError("Copied %d folders and %d files, the source holds %d and %d, the destination %d and %d",
+			report.Folders, report.Files, verified.Folders, verified.Files, counted.Folders, counted.Files)
+	}
+	if counted.FileVersions != report.FileVersions {
+		return verificationError("Copied %d file versions, the destination holds %d", report.FileVersions, counted.FileVersions)
+	}
+
+	sourceEntries, err := source.GetTrashEntries(ctx)
+	if err != nil {
+		return err
+	}
+	destEntries, err := dest.GetTrashEntries(ctx)
+	if err != nil {
+		return err
+	}
+	if len(*destEntries) != len(*sourceEntries) || len(*destEntries) != report.TrashEntries {
+		return verificationError("Copied %d trash entries, the source holds %d, the destination %d",
+			report.TrashEntries, len(*sourceEntries), len(*destEntries))
+	}
+	for i, entry := range *sourceEntries {
+		if (*destEntries)[i] != entry {
+			return verificationError("Trash entry %s is %+v instead of %+v", entry.ItemId, (*destEntries)[i], entry)
+		}
+	}
+
+	for blobKey, checksum := range checksums {
+		info, err := checksumBlob(destBlobs, blobKey)
+		if err != nil {
+			return verificationError("Could not read the content %s: %v", blobKey, err)
+		}
+		if info.SHA256 != checksum {
+			return verificationError("The content %s has the SHA-256 %s instead of %s", blobKey, info.SHA256, checksum)
+		}
+	}
+	return nil
+}
+
+// verifyFileVersions checks the destination file has the versions of the source one, and their content its digest
+func verifyFileVersions(ctx context.Context, source fsrepository.IFileSystemRepository, dest fsrepository.IFileSystemRepository,
+	fileID string, checksums map[string]string) error {
+	sourceVersions, err := source.GetFileVersions(ctx, fileID)
+	if err != nil {
+		return err
+	}
+	destVersions, err := dest.GetFileVersions(ctx, fileID)
+	if err != nil {
+		return verificationError("Could not read the versions of file %s: %v", fileID, err)
+	}
+	if len(*destVersions) != len(*sourceVersions) {
+		return verificationError("File %s has %d versions instead of %d", fileID, len(*destVersions), len(*sourceVersions))
+	}
+	for i, version := range *sourceVersions {
+		if (*destVersions)[i] != version {
+			return verificationError("Version %d of file %s is %+v instead of %+v", version.Number, fileID, (*destVersions)[i], version)
+		}
+		if version.Digest != "" && version.Digest != checksums[version.BlobKey] {
+			return verificationError("The content of version %d of file %s has the SHA-256 %s instead of its digest %s",
+				version.Number, fileID, checksums[version.BlobKey], version.Digest)
+		}
+	}
+	return nil
+}
+
+func verificationError(format string, args ...interface{}) error {
+	return errors.WithMessage(errors.New(VerificationFailed), fmt.Sprintf(format, args...))
+}
+
+func printProgress(report Report) {
+	if (report.Folders+report.Files)%progressInterval == 0 {
+		fmt.Printf("Copied %d folders and %d files\n", report.Folders, report.Files)
+	}
+}
@@ -0,0 +1,254 @@
+package fsmigration
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/loisfa/remote-file-system/api/fsmodel"
+	"github.com/loisfa/remote-file-system/api/fsrepository"
+	"github.com/loisfa/remote-file-system/api/fsstorage"
+	"github.com/pkg/errors"
+)
+
+func newTempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "migration-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func newBlobStore(t *testing.T) fsstorage.LocalBlobStore {
+	blobs, err := fsstorage.NewLocalBlobStore(newTempDir(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return blobs
+}
+
+func newBoltRepository(t *testing.T) fsrepository.BoltFileSystemRepository {
+	repo, err := fsrepository.NewBoltFileSystemRepository(filepath.Join(newTempDir(t), "dest.bolt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { repo.Close() })
+	return repo
+}
+
+// newSource returns a file system with nested folders, a content shared by two files, a legacy file without digest,
+// a file with versions, a trashed folder, and gaps in the ids left by deleted items
+func newSource(t *testing.T) (fsrepository.MemoryFileSystemRepository, fsstorage.LocalBlobStore) {
+	ctx := context.Background()
+	repo := fsrepository.NewMemoryFileSystemRepository()
+	blobs := newBlobStore(t)
+	rootID := getRootFolderID(t, repo)
+
+	photosID := createFolder(t, repo, "Photos", rootID)
+	deletedID := createFolder(t, repo, "Deleted", rootID)
+	holidaysID := createFolder(t, repo, "Holidays", photosID)
+	createFolder(t, repo, "Empty", holidaysID)
+	assertNoError(t, repo.DeleteFolderAndContent(ctx, deletedID))
+
+	shared := putContent(t, blobs, "shared content")
+	createFile(t, repo, "beach.jpg", shared, holidaysID)
+	createFile(t, repo, "beach (copy).jpg", shared, photosID)
+	deletedFileID := createFile(t, repo, "deleted.txt", putContent(t, blobs, "deleted"), rootID)
+	assertNoError(t, repo.DeleteFile(ctx, deletedFileID))
+	notesID := createFile(t, repo, "notes.txt", putContent(t, blobs, "notes"), rootID)
+	notes, err := repo.GetFile(ctx, notesID)
+	assertNoError(t, err)
+	oldNotes := fsmodel.FileVersion{Number: 1, FileContent: putContent(t, blobs, "old notes"), CreatedAt: notes.CreatedAt.Add(-time.Hour)}
+	assertNoError(t, repo.CreateFileVersion(ctx, notesID, oldNotes))
+	assertNoError(t, repo.CreateFileVersion(ctx, notesID, fsmodel.FileVersion{Number: 2, FileContent: notes.FileContent, CreatedAt: notes.CreatedAt}))
+
+	_, err = blobs.Put("legacy.txt", strings.NewReader("legacy content"))
+	assertNoError(t, err)
+	createFile(t, repo, "legacy.txt", fsmodel.FileContent{BlobKey: "legacy.txt"}, photosID)
+
+	trashedID := createFolder(t, repo, "Trashed", rootID)
+	createFile(t, repo, "trashed.txt", putContent(t, blobs, "trashed"), trashedID)
+	trashID, err := repo.GetTrashFolderID(ctx)
+	assertNoError(t, err)
+	assertNoError(t, repo.CreateTrashEntry(ctx, fsmodel.TrashEntry{ItemId: trashedID, Kind: fsmodel.FolderKind, Name: "Trashed", ParentId: rootID}))
+	assertNoError(t, repo.MoveFolder(ctx, trashedID, *trashID, trashedID))
+
+	return repo, blobs
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	source, sourceBlobs := newSource(t)
+	dest := newBoltRepository(t)
+	destBlobs := newBlobStore(t)
+
+	report, err := Migrate(ctx, source, sourceBlobs, dest, destBlobs)
+	assertNoError(t, err)
+	assertEqual(t, *report, Report{Folders: 4, Files: 5, Blobs: 5, TrashEntries: 1, FileVersions: 2,
+		Bytes: int64(len("shared content") + len("notes") + len("old notes") + len("legacy content") + len("trashed"))})
+
+	assertSameTree(t, source, dest, getRootFolderID(t, source))
+	trashID, err := source.GetTrashFolderID(ctx)
+	assertNoError(t, err)
+	assertSameTree(t, source, dest, *trashID)
+	sourceEntries, err := source.GetTrashEntries(ctx)
+	assertNoError(t, err)
+	destEntries, err := dest.GetTrashEntries(ctx)
+	assertNoError(t, err)
+	assertEqual(t, *destEntries, *sourceEntries)
+	assertContent(t, destBlobs, "legacy.txt", "legacy content")
+	assertContent(t, destBlobs, fsstorage.ContentKey(sha256Of("old notes")), "old notes")
+	// the deleted content is not referenced anymore: it is not copied
+	_, err = destBlobs.Stat(fsstorage.ContentKey(sha256Of("deleted")))
+	assertErrorCode(t, err, fsstorage.BlobNotFound)
+
+	// the destination creates ids no migrated item has
+	folderID, err := dest.CreateFolder(ctx, "New folder", getRootFolderID(t, dest))
+	assertNoError(t, err)
+	if _, err := source.GetFolder(ctx, *folderID); err == nil {
+		t.Fatalf("folder %s created with the id of a migrated folder", *folderID)
+	}
+}
+
+func TestMigrateToSQLite(t *testing.T) {
+	ctx := context.Background()
+	source, sourceBlobs := newSource(t)
+	dest, err := fsrepository.NewSQLiteFileSystemRepository(filepath.Join(newTempDir(t), "dest.db"))
+	assertNoError(t, err)
+	defer dest.Close()
+
+	// without destination store, the contents stay where they are
+	report, err := Migrate(ctx, source, sourceBlobs, dest, nil)
+	assertNoError(t, err)
+	assertEqual(t, report.Files, 5)
+	assertSameTree(t, source, dest, getRootFolderID(t, source))
+}
+
+func TestMigrateIntoNonEmptyDestination(t *testing.T) {
+	ctx := context.Background()
+	source, sourceBlobs := newSource(t)
+	dest := fsrepository.NewMemoryFileSystemRepository()
+	createFolder(t, dest, "Existing", getRootFolderID(t, dest))
+
+	_, err := Migrate(ctx, source, sourceBlobs, dest, newBlobStore(t))
+	assertErrorCode(t, err, DestinationNotEmpty)
+}
+
+func TestMigrateCorruptedContent(t *testing.T) {
+	ctx := context.Background()
+	source, sourceBlobs := newSource(t)
+	notesKey := fsstorage.ContentKey(sha256Of("notes"))
+	_, err := sourceBlobs.Put(notesKey, strings.NewReader("corrupted notes"))
+	assertNoError(t, err)
+
+	_, err = Migrate(ctx, source, sourceBlobs, fsrepository.NewMemoryFileSystemRepository(), newBlobStore(t))
+	assertErrorCode(t, err, VerificationFailed)
+}
+
+func assertSameTree(t *testing.T, source fsrepository.IFileSystemRepository, dest fsrepository.IFileSystemRepository, folderID string) {
+	t.Helper()
+	ctx := context.Background()
+	sourceFolder, err := source.GetFolder(ctx, folderID)
+	assertNoError(t, err)
+	destFolder, err := dest.GetFolder(ctx, folderID)
+	assertNoError(t, err)
+	if sourceFolder.ParentId == nil {
+		// the destination root folder is renamed as the source one: the migration modifies it
+		destFolder.ModifiedAt = sourceFolder.ModifiedAt
+	}
+	assertEqual(t, *destFolder, *sourceFolder)
+
+	sourceFiles, err := source.GetFilesIn(ctx, folderID)
+	assertNoError(t, err)
+	destFiles, err := dest.GetFilesIn(ctx, folderID)
+	assertNoError(t, err)
+	assertEqual(t, len(*destFiles), len(*sourceFiles))
+	for i := range *sourceFiles {
+		assertEqual(t, (*destFiles)[i], (*sourceFiles)[i])
+	}
+
+	sourceFolders, err := source.GetFoldersIn(ctx, folderID)
+	assertNoError(t, err)
+	destFolders, err := dest.GetFoldersIn(ctx, folderID)
+	assertNoError(t, err)
+	assertEqual(t, len(*destFolders), len(*sourceFolders))
+	for i, folder := range *sourceFolders {
+		assertEqual(t, (*destFolders)[i].Id, folder.Id)
+		assertSameTree(t, source, dest, folder.Id)
+	}
+}
+
+func assertContent(t *testing.T, blobs fsstorage.BlobStore, blobKey string, expected string) {
+	t.Helper()
+	blob, err := blobs.Get(blobKey)
+	assertNoError(t, err)
+	defer blob.Close()
+	content, err := ioutil.ReadAll(blob)
+	assertNoError(t, err)
+	assertEqual(t, string(content), expected)
+}
+
+func putContent(t *testing.T, blobs fsstorage.BlobStore, content string) fsmodel.FileContent {
+	t.Helper()
+	info, err := fsstorage.PutContentAddressed(blobs, strings.NewReader(content))
+	assertNoError(t, err)
+	return fsmodel.FileContent{BlobKey: info.Key, Digest: info.SHA256}
+}
+
+func sha256Of(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func getRootFolderID(t *testing.T, repo fsrepository.IFileSystemRepository) string {
+	t.Helper()
+	ctx := context.Background()
+	rootID, err := repo.GetRootFolderID(ctx)
+	assertNoError(t, err)
+	return *rootID
+}
+
+func createFolder(t *testing.T, repo fsrepository.IFileSystemRepository, name string, parentID string) string {
+	t.Helper()
+	ctx := context.Background()
+	folderID, err := repo.CreateFolder(ctx, name, parentID)
+	assertNoError(t, err)
+	return *folderID
+}
+
+func createFile(t *testing.T, repo fsrepository.IFileSystemRepository, name string, content fsmodel.FileContent, parentID string) string {
+	t.Helper()
+	ctx := context.Background()
+	fileID, err := repo.CreateFile(ctx, name, content, parentID)
+	assertNoError(t, err)
+	return *fileID
+}
+
+func assertEqual(t *testing.T, actual interface{}, expected interface{}) {
+	t.Helper()
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, actual)
+	}
+}
+
+func assertNoError(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func assertErrorCode(t *testing.T, err error, code string) {
+	t.Helper()
+	if err == nil || errors.Cause(err).Error() != code {
+		t.Fatalf("expected error %s, got %v", code, err)
+	}
+}
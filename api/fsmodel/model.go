@@ -1,14 +1,117 @@
 package fsmodel
 
+import "time"
+
+// The ids of the folders and files are opaque strings. The items created before they were have kept the integer id
+// they had as their LegacyId, for the clients which still use it: the integers of two sequences, one for the folders
+// and one for the files, starting at 1. 0 was the root folder.
+
 type File struct {
-	Id       int
+	Id       string
+	LegacyId int // 0 for the files created since the opaque ids
 	Name     string
-	Path     string
-	ParentId int // TODO should fill it!
+	ParentId string
+	FileContent
+	CreatedAt  time.Time // zero for the files created before the timestamps were recorded
+	ModifiedAt time.Time // the creation until the file is modified, zero as CreatedAt
+}
+
+// UnknownSize is the size of the contents stored before the sizes were recorded
+const UnknownSize = -1
+
+// FileContent references the content of a file inside the blob store. Several files can share the same content.
+type FileContent struct {
+	BlobKey     string
+	Digest      string // hex encoded SHA-256 of the content, empty for legacy files
+	Size        int64  // in bytes, UnknownSize for legacy files
+	ContentType string // MIME type, detected when the content is uploaded, empty for legacy files
 }
 
 type Folder struct {
-	Id       int
-	Name     string
-	ParentId *int // nil in case of root folder
+	Id         string
+	LegacyId   int // 0 for the root folder and the folders created since the opaque ids
+	Name       string
+	ParentId   *string   // nil in case of root folder
+	CreatedAt  time.Time // zero for the root folder and the folders created before the timestamps were recorded
+	ModifiedAt time.Time // the last time the folder was renamed, or a direct child added, removed or renamed, zero until then as CreatedAt
+}
+
+// FileVersion is a content a file had, or has: the newest version is the current content of the file. The files
+// whose content was never replaced have no version recorded.
+type FileVersion struct {
+	Number int // from 1, in the order the versions were created
+	FileContent
+	CreatedAt time.Time
+}
+
+// TrashEntry is a deleted folder or file, kept inside the trash folder until it is restored or purged. The entry
+// remembers what the item was before, since it is renamed by its id inside the trash.
+type TrashEntry struct {
+	ItemId    string
+	Kind      ItemKind
+	Name      string // the name the item had
+	ParentId  string // the folder the item was deleted from
+	TrashedAt time.Time
+}
+
+// ChildCounts counts the direct children of a folder
+type ChildCounts struct {
+	Folders int
+	Files   int
+}
+
+// FolderContent is a folder along with a page of its direct children
+type FolderContent struct {
+	Folder  Folder
+	Folders []Folder
+	Files   []File
+	Next    *ListPosition // where the next page starts, nil on the last page
+	// the direct children of the folder and of the listed folders, all of them and not only the page, by folder id
+	ChildCounts map[string]ChildCounts
+	Trashed     bool // the folder is the trash folder or one of its descendants
+}
+
+// SortKey orders the children of a folder. The folders always come before the files.
+type SortKey string
+
+const (
+	SortByName     SortKey = "name"     // natural order, case insensitive: "photo 2" comes before "photo 10"
+	SortByType     SortKey = "type"     // extension of the files, then name
+	SortBySize     SortKey = "size"     // size of the files, then name, the unknown sizes first: the folders by name
+	SortByModified SortKey = "modified" // modification, then name, the unknown times first
+)
+
+type ItemKind string
+
+const (
+	FolderKind ItemKind = "folder"
+	FileKind   ItemKind = "file"
+)
+
+// NamedChild is a direct child of a folder, as much of it as its name is concerned
+type NamedChild struct {
+	Kind ItemKind
+	Id   string
+	Name string
+}
+
+// ListOptions select and order the children of a folder. The zero value lists all of them by name.
+type ListOptions struct {
+	Sort       SortKey
+	Descending bool
+	Kind       ItemKind      // folders and files when empty
+	Extension  string        // files with this extension only, lower case and without dot
+	NameGlob   string        // names matching the pattern only: * for any characters, ? for a single one. Case sensitive.
+	Limit      int           // number of children of the page, all of them when 0
+	After      *ListPosition // the page starts after this child, from the first one when nil
+}
+
+// ListPosition is the place of a child inside a listing: the page which follows it starts right after it, even
+// though it was renamed, moved or deleted meanwhile
+type ListPosition struct {
+	Kind       ItemKind
+	Name       string
+	Id         string
+	Size       int64     // of the file when the listing is by size, 0 otherwise
+	ModifiedAt time.Time // of the child when the listing is by modification, zero otherwise
 }
@@ -0,0 +1,1382 @@
+package fsrepository
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/loisfa/remote-file-system/api/fsmodel"
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	boltRootFolderName  = "Root folder"
+	boltTrashFolderName = "Trash"
+
+	boltFolderKind byte = 'd'
+	boltFileKind   byte = 'f'
+
+	// another process holding the file would make the opening wait forever
+	boltOpenTimeout = 5 * time.Second
+)
+
+var (
+	boltFoldersBucket         = []byte("folders")           // folder id -> boltFolder
+	boltFilesBucket           = []byte("files")             // file id -> boltFile
+	boltChildrenBucket        = []byte("children")          // parent folder id + 0 + child name -> kind + child id
+	boltBlobRefsBucket        = []byte("blob_refs")         // blob key + 0 + file id (+ 0 + version number for a file version) -> nothing
+	boltOrphanBlobsBucket     = []byte("orphan_blobs")      // blob key -> nothing
+	boltFolderLegacyIDsBucket = []byte("folder_legacy_ids") // legacy id -> folder id
+	boltFileLegacyIDsBucket   = []byte("file_legacy_ids")   // legacy id -> file id
+	boltTrashBucket           = []byte("trash")             // item id -> boltTrashEntry
+	boltFileVersionsBucket    = []byte("file_versions")     // file id + 0 + version number -> boltFileVersion
+)
+
+// BoltFileSystemRepository stores the file system in a single bbolt file, no database server needed. The write
+// transactions of bbolt are serialized: the checks need no lock of their own.
+type BoltFileSystemRepository struct {
+	db *bolt.DB
+	tx *bolt.Tx // set inside ExecuteInTransaction
+}
+
+type boltFolder struct {
+	Name       string    `json:"name"`
+	ParentID   *string   `json:"parentId,omitempty"` // nil for the root folder
+	LegacyID   int       `json:"legacyId,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+	ModifiedAt time.Time `json:"modifiedAt"`
+}
+
+type boltFile struct {
+	Name        string    `json:"name"`
+	ParentID    string    `json:"parentId"`
+	BlobKey     string    `json:"blobKey"`
+	Digest      string    `json:"digest"`
+	LegacyID    int       `json:"legacyId,omitempty"`
+	Size        *int64    `json:"size,omitempty"` // nil when unknown
+	ContentType string    `json:"contentType,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+	ModifiedAt  time.Time `json:"modifiedAt"`
+}
+
+type boltFileVersion struct {
+	BlobKey     string    `json:"blobKey"`
+	Digest      string    `json:"digest"`
+	Size        *int64    `json:"size,omitempty"` // nil when unknown
+	ContentType string    `json:"contentType,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+type boltTrashEntry struct {
+	Kind      fsmodel.ItemKind `json:"kind"`
+	Name      string           `json:"name"`
+	ParentID  string           `json:"parentId"`
+	TrashedAt time.Time        `json:"trashedAt"`
+}
+
+// NewBoltFileSystemRepository opens (or creates) the bbolt file, with the root folder inside, and migrates its layout
+func NewBoltFileSystemRepository(file string) (BoltFileSystemRepository, error) {
+	db, err := bolt.Open(file, 0600, &bolt.Options{Timeout: boltOpenTimeout})
+	if err != nil {
+		return BoltFileSystemRepository{}, errors.WithMessage(err, fmt.Sprintf("Could not open the bbolt file %s", file))
+	}
+
+	if err := migrateBolt(db); err != nil {
+		db.Close()
+		return BoltFileSystemRepository{}, err
+	}
+
+	return BoltFileSystemRepository{db: db}, nil
+}
+
+// Close releases the file, which another process can open then
+func (repo BoltFileSystemRepository) Close() error {
+	return repo.db.Close()
+}
+
+func (repo BoltFileSystemRepository) ExecuteInTransaction(ctx context.Context, work func(repo IFileSystemRepository) error) error {
+	return repo.update(ctx, func(repo BoltFileSystemRepository) error {
+		return work(repo)
+	})
+}
+
+// update runs the work in its own write transaction, or in the one of ExecuteInTransaction. bbolt knows nothing of
+// contexts: the context is checked before the work, and before the commit.
+func (repo BoltFileSystemRepository) update(ctx context.Context, work func(repo BoltFileSystemRepository) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if repo.tx != nil {
+		return work(repo)
+	}
+	return repo.db.Update(func(tx *bolt.Tx) error {
+		// waiting for the other write transactions may have taken a while
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := work(BoltFileSystemRepository{db: repo.db, tx: tx}); err != nil {
+			return err
+		}
+		return ctx.Err()
+	})
+}
+
+// view runs the work in its own read transaction, or in the one of ExecuteInTransaction
+func (repo BoltFileSystemRepository) view(ctx context.Context, work func(repo BoltFileSystemRepository) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if repo.tx != nil {
+		return work(repo)
+	}
+	return repo.db.View(func(tx *bolt.Tx) error {
+		return work(BoltFileSystemRepository{db: repo.db, tx: tx})
+	})
+}
+
+func (repo BoltFileSystemRepository) UpdateFolder(ctx context.Context, folderID string, folderName string) error {
+	return repo.update(ctx, func(repo BoltFileSystemRepository) error {
+		folder, err := repo.getFolder(folderID)
+		if err != nil {
+			return err
+		}
+
+		modifiedAt := currentTime()
+		if folder.ParentID != nil {
+			if err := repo.renameChild(*folder.ParentID, folder.Name, *folder.ParentID, folderName, boltFolderKind, folderID); err != nil {
+				return err
+			}
+			if err := repo.touchFolder(*folder.ParentID, modifiedAt); err != nil {
+				return err
+			}
+		}
+		folder.Name = folderName
+		folder.ModifiedAt = modifiedAt
+		return repo.putFolder(folderID, folder)
+	})
+}
+
+func (repo BoltFileSystemRepository) UpdateFile(ctx context.Context, fileID string, fileName string, contentType string) error {
+	return repo.update(ctx, func(repo BoltFileSystemRepository) error {
+		file, err := repo.getFile(fileID)
+		if err != nil {
+			return err
+		}
+
+		modifiedAt := currentTime()
+		if fileName != file.Name {
+			if err := repo.renameChild(file.ParentID, file.Name, file.ParentID, fileName, boltFileKind, fileID); err != nil {
+				return err
+			}
+			if err := repo.touchFolder(file.ParentID, modifiedAt); err != nil {
+				return err
+			}
+		}
+		file.Name = fileName
+		if contentType != "" {
+			file.ContentType = contentType
+		}
+		file.ModifiedAt = modifiedAt
+		return repo.putFile(fileID, file)
+	})
+}
+
+func (repo BoltFileSystemRepository) MoveFolder(ctx context.Context, folderID string, destFolderID string, folderName string) error {
+	return repo.update(ctx, func(repo BoltFileSystemRepository) error {
+		folder, err := repo.getFolder(folderID)
+		if err != nil {
+			return err
+		}
+		if _, err := repo.getFolder(destFolderID); err != nil {
+			return err
+		}
+
+		isInside, err := repo.isFolderInside(destFolderID, folderID)
+		if err != nil {
+			return err
+		}
+		if folderID == destFolderID || isInside {
+			return errors.WithMessage(
+				errors.New(FolderMovedInsideItself),
+				fmt.Sprintf("Folder %s cannot be moved inside folder %s", folderID, destFolderID))
+		}
+
+		if folder.ParentID == nil {
+			// the root folder is nobody's child yet
+			if err := repo.errorIfNameConflict(destFolderID, folderName, boltFolderKind, folderID); err != nil {
+				return err
+			}
+			if err := repo.putChild(destFolderID, folderName, boltFolderKind, folderID); err != nil {
+				return err
+			}
+		} else if err := repo.renameChild(*folder.ParentID, folder.Name, destFolderID, folderName, boltFolderKind, folderID); err != nil {
+			return err
+		}
+
+		modifiedAt := currentTime()
+		if folder.ParentID != nil {
+			if err := repo.touchFolder(*folder.ParentID, modifiedAt); err != nil {
+				return err
+			}
+		}
+		if err := repo.touchFolder(destFolderID, modifiedAt); err != nil {
+			return err
+		}
+		folder.Name = folderName
+		folder.ParentID = &destFolderID
+		return repo.putFolder(folderID, folder)
+	})
+}
+
+func (repo BoltFileSystemRepository) MoveFile(ctx context.Context, fileID string, destFolderID string, fileName string) error {
+	return repo.update(ctx, func(repo BoltFileSystemRepository) error {
+		file, err := repo.getFile(fileID)
+		if err != nil {
+			return err
+		}
+		if _, err := repo.getFolder(destFolderID); err != nil {
+			return err
+		}
+
+		if err := repo.renameChild(file.ParentID, file.Name, destFolderID, fileName, boltFileKind, fileID); err != nil {
+			return err
+		}
+		modifiedAt := currentTime()
+		if err := repo.touchFolder(file.ParentID, modifiedAt); err != nil {
+			return err
+		}
+		if err := repo.touchFolder(destFolderID, modifiedAt); err != nil {
+			return err
+		}
+		file.Name = fileName
+		file.ParentID = destFolderID
+		return repo.putFile(fileID, file)
+	})
+}
+
+// LockFolderSubtree only counts the subtree: bbolt runs a single write transaction at a time
+func (repo BoltFileSystemRepository) LockFolderSubtree(ctx context.Context, folderID string) (*int, error) {
+	count := 0
+	err := repo.view(ctx, func(repo BoltFileSystemRepository) error {
+		if _, err := repo.getFolder(folderID); err != nil {
+			return err
+		}
+
+		var countSubtree func(folderID string) error
+		countSubtree = func(folderID string) error {
+			children, err := repo.children(folderID)
+			if err != nil {
+				return err
+			}
+			count++
+			for _, child := range children {
+				if child.kind != boltFolderKind {
+					count++
+					continue
+				}
+				if err := countSubtree(child.id); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		return countSubtree(folderID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &count, nil
+}
+
+func (repo BoltFileSystemRepository) DeleteFolderAndContent(ctx context.Context, folderID string) error {
+	return repo.update(ctx, func(repo BoltFileSystemRepository) error {
+		folder, err := repo.getFolder(folderID)
+		if err != nil {
+			return err
+		}
+
+		deletedBlobKeys := make([]string, 0)
+		var deleteSubtree func(folderID string, folder boltFolder) error
+		deleteSubtree = func(folderID string, folder boltFolder) error {
+			children, err := repo.children(folderID)
+			if err != nil {
+				return err
+			}
+			for _, child := range children {
+				if child.kind == boltFolderKind {
+					childFolder, err := repo.getFolder(child.id)
+					if err != nil {
+						return err
+					}
+					if err := deleteSubtree(child.id, childFolder); err != nil {
+						return err
+					}
+					continue
+				}
+
+				file, err := repo.getFile(child.id)
+				if err != nil {
+					return err
+				}
+				blobKeys, err := repo.deleteFile(child.id, file)
+				if err != nil {
+					return err
+				}
+				deletedBlobKeys = append(deletedBlobKeys, blobKeys...)
+			}
+			if err := repo.deleteChildren(folderID); err != nil {
+				return err
+			}
+			if folder.LegacyID != 0 {
+				if err := repo.tx.Bucket(boltFolderLegacyIDsBucket).Delete(boltLegacyID(folder.LegacyID)); err != nil {
+					return err
+				}
+			}
+			return repo.tx.Bucket(boltFoldersBucket).Delete(boltID(folderID))
+		}
+		if err := deleteSubtree(folderID, folder); err != nil {
+			return err
+		}
+
+		if folder.ParentID != nil {
+			if err := repo.tx.Bucket(boltChildrenBucket).Delete(boltChildKey(*folder.ParentID, folder.Name)); err != nil {
+				return err
+			}
+			if err := repo.touchFolder(*folder.ParentID, currentTime()); err != nil {
+				return err
+			}
+		}
+		return repo.recordOrphanBlobKeys(deletedBlobKeys)
+	})
+}
+
+func (repo BoltFileSystemRepository) DeleteFile(ctx context.Context, fileID string) error {
+	return repo.update(ctx, func(repo BoltFileSystemRepository) error {
+		file, err := repo.getFile(fileID)
+		if err != nil {
+			return err
+		}
+
+		if err := repo.tx.Bucket(boltChildrenBucket).Delete(boltChildKey(file.ParentID, file.Name)); err != nil {
+			return err
+		}
+		if err := repo.touchFolder(file.ParentID, currentTime()); err != nil {
+			return err
+		}
+		deletedBlobKeys, err := repo.deleteFile(fileID, file)
+		if err != nil {
+			return err
+		}
+		return repo.recordOrphanBlobKeys(deletedBlobKeys)
+	})
+}
+
+func (repo BoltFileSystemRepository) GetOrphanBlobKeys(ctx context.Context) (*[]string, error) {
+	blobKeys := make([]string, 0)
+	err := repo.view(ctx, func(repo BoltFileSystemRepository) error {
+		return repo.tx.Bucket(boltOrphanBlobsBucket).ForEach(func(key, _ []byte) error {
+			blobKeys = append(blobKeys, string(key))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &blobKeys, nil
+}
+
+func (repo BoltFileSystemRepository) IsBlobReferenced(ctx context.Context, blobKey string) (*bool, error) {
+	var referenced bool
+	err := repo.view(ctx, func(repo BoltFileSystemRepository) error {
+		referenced = repo.isBlobReferenced(blobKey)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &referenced, nil
+}
+
+func (repo BoltFileSystemRepository) RemoveOrphanBlobKey(ctx context.Context, blobKey string) error {
+	return repo.update(ctx, func(repo BoltFileSystemRepository) error {
+		return repo.tx.Bucket(boltOrphanBlobsBucket).Delete([]byte(blobKey))
+	})
+}
+
+func (repo BoltFileSystemRepository) GetFile(ctx context.Context, fileID string) (*fsmodel.File, error) {
+	var file fsmodel.File
+	err := repo.view(ctx, func(repo BoltFileSystemRepository) error {
+		record, err := repo.getFile(fileID)
+		if err != nil {
+			return err
+		}
+		file = record.toFile(fileID)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+func (repo BoltFileSystemRepository) ExistsFile(ctx context.Context, fileID string) (*bool, error) {
+	return repo.exists(ctx, boltFilesBucket, fileID)
+}
+
+func (repo BoltFileSystemRepository) ExistsUntrashedFile(ctx context.Context, fileID string) (*bool, error) {
+	var exists bool
+	err := repo.view(ctx, func(repo BoltFileSystemRepository) error {
+		if repo.tx.Bucket(boltFilesBucket).Get(boltID(fileID)) == nil {
+			return nil
+		}
+		file, err := repo.getFile(fileID)
+		if err != nil {
+			return err
+		}
+		trashed, err := repo.isTrashed(file.ParentID)
+		exists = !trashed
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &exists, nil
+}
+
+func (repo BoltFileSystemRepository) GetFilesIn(ctx context.Context, folderID string) (*[]fsmodel.File, error) {
+	files := make([]fsmodel.File, 0)
+	err := repo.view(ctx, func(repo BoltFileSystemRepository) error {
+		children, err := repo.children(folderID)
+		if err != nil {
+			return err
+		}
+		for _, child := range children {
+			if child.kind != boltFileKind {
+				continue
+			}
+			file, err := repo.getFile(child.id)
+			if err != nil {
+				return err
+			}
+			files = append(files, file.toFile(child.id))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &files, nil
+}
+
+// GetChildrenWithNamePrefix scans the range of the children index holding the names starting with the prefix
+func (repo BoltFileSystemRepository) GetChildrenWithNamePrefix(ctx context.Context, folderID string, namePrefix string) (*[]fsmodel.NamedChild, error) {
+	children := make([]fsmodel.NamedChild, 0)
+	err := repo.view(ctx, func(repo BoltFileSystemRepository) error {
+		folderPrefix := boltChildPrefix(folderID)
+		prefix := append(append([]byte{}, folderPrefix...), namePrefix...)
+		cursor := repo.tx.Bucket(boltChildrenBucket).Cursor()
+		for key, value := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, value = cursor.Next() {
+			if len(value) < 2 {
+				return errors.Errorf("Corrupted child index entry inside folder %s", folderID)
+			}
+			kind := fsmodel.FileKind
+			if value[0] == boltFolderKind {
+				kind = fsmodel.FolderKind
+			}
+			children = append(children, fsmodel.NamedChild{Kind: kind, Id: string(value[1:]), Name: string(key[len(folderPrefix):])})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &children, nil
+}
+
+func (repo BoltFileSystemRepository) GetFolder(ctx context.Context, folderID string) (*fsmodel.Folder, error) {
+	var folder fsmodel.Folder
+	err := repo.view(ctx, func(repo BoltFileSystemRepository) error {
+		record, err := repo.getFolder(folderID)
+		if err != nil {
+			return err
+		}
+		folder = record.toFolder(folderID)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &folder, nil
+}
+
+func (repo BoltFileSystemRepository) GetRootFolderID(ctx context.Context) (*string, error) {
+	rootID := rootFolderID
+	err := repo.view(ctx, func(repo BoltFileSystemRepository) error {
+		_, err := repo.getFolder(rootID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &rootID, nil
+}
+
+func (repo BoltFileSystemRepository) IsRootFolder(ctx context.Context, folderID string) (*bool, error) {
+	var isRoot bool
+	err := repo.view(ctx, func(repo BoltFileSystemRepository) error {
+		if _, err := repo.getFolder(folderID); err != nil {
+			return err
+		}
+		isRoot = folderID == rootFolderID
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &isRoot, nil
+}
+
+func (repo BoltFileSystemRepository) IsFolderInside(ctx context.Context, folderID string, ancestorFolderID string) (*bool, error) {
+	var isInside bool
+	err := repo.view(ctx, func(repo BoltFileSystemRepository) error {
+		if _, err := repo.getFolder(ancestorFolderID); err != nil {
+			return err
+		}
+		var err error
+		isInside, err = repo.isFolderInside(folderID, ancestorFolderID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &isInside, nil
+}
+
+func (repo BoltFileSystemRepository) ExistsFolder(ctx context.Context, folderID string) (*bool, error) {
+	return repo.exists(ctx, boltFoldersBucket, folderID)
+}
+
+func (repo BoltFileSystemRepository) ExistsUntrashedFolder(ctx context.Context, folderID string) (*bool, error) {
+	var exists bool
+	err := repo.view(ctx, func(repo BoltFileSystemRepository) error {
+		if repo.tx.Bucket(boltFoldersBucket).Get(boltID(folderID)) == nil {
+			return nil
+		}
+		trashed, err := repo.isTrashed(folderID)
+		exists = !trashed
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &exists, nil
+}
+
+func (repo BoltFileSystemRepository) GetFoldersIn(ctx context.Context, folderID string) (*[]fsmodel.Folder, error) {
+	folders := make([]fsmodel.Folder, 0)
+	err := repo.view(ctx, func(repo BoltFileSystemRepository) error {
+		children, err := repo.children(folderID)
+		if err != nil {
+			return err
+		}
+		for _, child := range children {
+			if child.kind != boltFolderKind {
+				continue
+			}
+			folder, err := repo.getFolder(child.id)
+			if err != nil {
+				return err
+			}
+			folders = append(folders, folder.toFolder(child.id))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &folders, nil
+}
+
+func (repo BoltFileSystemRepository) GetFolderIDByLegacyID(ctx context.Context, legacyID int) (*string, error) {
+	return repo.idByLegacyID(ctx, boltFolderLegacyIDsBucket, legacyID, legacyFolderNotFoundError)
+}
+
+func (repo BoltFileSystemRepository) GetFileIDByLegacyID(ctx context.Context, legacyID int) (*string, error) {
+	return repo.idByLegacyID(ctx, boltFileLegacyIDsBucket, legacyID, legacyFileNotFoundError)
+}
+
+// GetFolderContent selects the children by the names of the children index, and only reads the ones of the page: all
+// the ones of the kind when their keys do not derive from their names
+func (repo BoltFileSystemRepository) GetFolderContent(ctx context.Context, folderID string, options fsmodel.ListOptions) (*fsmodel.FolderContent, error) {
+	var content fsmodel.FolderContent
+	err := repo.view(ctx, func(repo BoltFileSystemRepository) error {
+		record, err := repo.getFolder(folderID)
+		if err != nil {
+			return err
+		}
+		content.Folder = record.toFolder(folderID)
+		if content.Trashed, err = repo.isTrashed(folderID); err != nil {
+			return err
+		}
+
+		children, err := repo.children(folderID)
+		if err != nil {
+			return err
+		}
+		folderChildren := make([]listedChild, 0)
+		fileChildren := make([]listedChild, 0)
+		for i, child := range children {
+			switch child.kind {
+			case boltFolderKind:
+				folderChildren = append(folderChildren, listedChild{name: child.name, id: child.id, index: i})
+			case boltFileKind:
+				fileChildren = append(fileChildren, listedChild{name: child.name, id: child.id, index: i})
+			}
+		}
+		content.ChildCounts = map[string]fsmodel.ChildCounts{
+			folderID: {Folders: len(folderChildren), Files: len(fileChildren)},
+		}
+
+		folders := make([]fsmodel.Folder, 0)
+		if listsFolders(options) {
+			if err := repo.readListKeys(options, fsmodel.FolderKind, folderChildren); err != nil {
+				return err
+			}
+			for _, child := range selectChildren(options, fsmodel.FolderKind, folderChildren) {
+				folder, err := repo.getFolder(child.id)
+				if err != nil {
+					return err
+				}
+				if content.ChildCounts[child.id], err = repo.childCounts(child.id); err != nil {
+					return err
+				}
+				folders = append(folders, folder.toFolder(child.id))
+			}
+		}
+		files := make([]fsmodel.File, 0)
+		if listsFiles(options) {
+			if err := repo.readListKeys(options, fsmodel.FileKind, fileChildren); err != nil {
+				return err
+			}
+			for _, child := range selectChildren(options, fsmodel.FileKind, fileChildren) {
+				file, err := repo.getFile(child.id)
+				if err != nil {
+					return err
+				}
+				files = append(files, file.toFile(child.id))
+			}
+		}
+		content.Folders, content.Files, content.Next = pageOf(options, folders, files)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &content, nil
+}
+
+// readListKeys completes the children of the kind with the keys of the listing read from their records, when the keys do
+// not derive from their names
+func (repo BoltFileSystemRepository) readListKeys(options fsmodel.ListOptions, kind fsmodel.ItemKind, children []listedChild) error {
+	if listedByName(options.Sort, kind) {
+		return nil
+	}
+	for i, child := range children {
+		if kind == fsmodel.FolderKind {
+			folder, err := repo.getFolder(child.id)
+			if err != nil {
+				return err
+			}
+			children[i] = folderChild(folder.toFolder(child.id), child.index)
+			continue
+		}
+		file, err := repo.getFile(child.id)
+		if err != nil {
+			return err
+		}
+		children[i] = fileChild(file.toFile(child.id), child.index)
+	}
+	return nil
+}
+
+func (repo BoltFileSystemRepository) CreateFile(ctx context.Context, fileName string, content fsmodel.FileContent, folderParentID string) (*string, error) {
+	var fileID string
+	err := repo.update(ctx, func(repo BoltFileSystemRepository) error {
+		if _, err := repo.getFolder(folderParentID); err != nil {
+			return err
+		}
+		if err := repo.errorIfNameConflict(folderParentID, fileName, boltFileKind, noItemID); err != nil {
+			return err
+		}
+
+		fileID = newID()
+		createdAt := currentTime()
+		file := boltFileOf(fsmodel.File{Name: fileName, ParentId: folderParentID, FileContent: content, CreatedAt: createdAt, ModifiedAt: createdAt})
+		if err := repo.putFile(fileID, file); err != nil {
+			return err
+		}
+		if err := repo.putChild(folderParentID, fileName, boltFileKind, fileID); err != nil {
+			return err
+		}
+		if err := repo.touchFolder(folderParentID, createdAt); err != nil {
+			return err
+		}
+		return repo.tx.Bucket(boltOrphanBlobsBucket).Delete([]byte(content.BlobKey))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &fileID, nil
+}
+
+func (repo BoltFileSystemRepository) CreateFolder(ctx context.Context, folderName string, folderParentID string) (*string, error) {
+	var folderID string
+	err := repo.update(ctx, func(repo BoltFileSystemRepository) error {
+		if _, err := repo.getFolder(folderParentID); err != nil {
+			return err
+		}
+		if err := repo.errorIfNameConflict(folderParentID, folderName, boltFolderKind, noItemID); err != nil {
+			return err
+		}
+
+		folderID = newID()
+		parentID := folderParentID
+		createdAt := currentTime()
+		if err := repo.putFolder(folderID, boltFolder{Name: folderName, ParentID: &parentID, CreatedAt: createdAt, ModifiedAt: createdAt}); err != nil {
+			return err
+		}
+		if err := repo.putChild(folderParentID, folderName, boltFolderKind, folderID); err != nil {
+			return err
+		}
+		return repo.touchFolder(folderParentID, createdAt)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &folderID, nil
+}
+
+// The functions below expect to run inside a transaction
+
+func (repo BoltFileSystemRepository) ImportFolder(ctx context.Context, folder fsmodel.Folder) error {
+	if folder.ParentId == nil {
+		return rootFolderImportedError(folder.Id)
+	}
+	return repo.update(ctx, func(repo BoltFileSystemRepository) error {
+		if repo.isIDTaken(boltFoldersBucket, boltFolderLegacyIDsBucket, folder.Id, folder.LegacyId) {
+			return idAlreadyExistsError(dbFolder, folder.Id, folder.LegacyId)
+		}
+		if _, err := repo.getFolder(*folder.ParentId); err != nil {
+			return err
+		}
+		if err := repo.errorIfNameConflict(*folder.ParentId, folder.Name, boltFolderKind, noItemID); err != nil {
+			return err
+		}
+
+		if err := repo.putFolder(folder.Id, boltFolderOf(folder)); err != nil {
+			return err
+		}
+		return repo.putChild(*folder.ParentId, folder.Name, boltFolderKind, folder.Id)
+	})
+}
+
+func (repo BoltFileSystemRepository) ImportFile(ctx context.Context, file fsmodel.File) error {
+	return repo.update(ctx, func(repo BoltFileSystemRepository) error {
+		if repo.isIDTaken(boltFilesBucket, boltFileLegacyIDsBucket, file.Id, file.LegacyId) {
+			return idAlreadyExistsError(dbFile, file.Id, file.LegacyId)
+		}
+		if _, err := repo.getFolder(file.ParentId); err != nil {
+			return err
+		}
+		if err := repo.errorIfNameConflict(file.ParentId, file.Name, boltFileKind, noItemID); err != nil {
+			return err
+		}
+
+		record := boltFileOf(file)
+		if err := repo.putFile(file.Id, record); err != nil {
+			return err
+		}
+		if err := repo.putChild(file.ParentId, file.Name, boltFileKind, file.Id); err != nil {
+			return err
+		}
+		return repo.tx.Bucket(boltOrphanBlobsBucket).Delete([]byte(file.BlobKey))
+	})
+}
+
+func (repo BoltFileSystemRepository) GetTrashFolderID(ctx context.Context) (*string, error) {
+	trashID := trashFolderID
+	err := repo.view(ctx, func(repo BoltFileSystemRepository) error {
+		_, err := repo.getFolder(trashID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &trashID, nil
+}
+
+func (repo BoltFileSystemRepository) CreateTrashEntry(ctx context.Context, entry fsmodel.TrashEntry) error {
+	entry.TrashedAt = currentTime()
+	return repo.ImportTrashEntry(ctx, entry)
+}
+
+func (repo BoltFileSystemRepository) GetTrashEntries(ctx context.Context) (*[]fsmodel.TrashEntry, error) {
+	entries := make([]fsmodel.TrashEntry, 0)
+	err := repo.view(ctx, func(repo BoltFileSystemRepository) error {
+		return repo.tx.Bucket(boltTrashBucket).ForEach(func(key, value []byte) error {
+			var entry boltTrashEntry
+			if err := json.Unmarshal(value, &entry); err != nil {
+				return err
+			}
+			entries = append(entries, entry.toTrashEntry(string(key)))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].TrashedAt.Equal(entries[j].TrashedAt) {
+			return entries[i].TrashedAt.After(entries[j].TrashedAt)
+		}
+		return entries[i].ItemId < entries[j].ItemId
+	})
+	return &entries, nil
+}
+
+func (repo BoltFileSystemRepository) DeleteTrashEntry(ctx context.Context, itemID string) (*fsmodel.TrashEntry, error) {
+	var entry fsmodel.TrashEntry
+	err := repo.update(ctx, func(repo BoltFileSystemRepository) error {
+		trash := repo.tx.Bucket(boltTrashBucket)
+		value := trash.Get(boltID(itemID))
+		if value == nil {
+			return trashEntryNotFoundError(itemID)
+		}
+		var record boltTrashEntry
+		if err := json.Unmarshal(value, &record); err != nil {
+			return err
+		}
+		entry = record.toTrashEntry(itemID)
+		return trash.Delete(boltID(itemID))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func (repo BoltFileSystemRepository) ImportTrashEntry(ctx context.Context, entry fsmodel.TrashEntry) error {
+	return repo.update(ctx, func(repo BoltFileSystemRepository) error {
+		trash := repo.tx.Bucket(boltTrashBucket)
+		if trash.Get(boltID(entry.ItemId)) != nil {
+			return trashEntryAlreadyExistsError(entry.ItemId)
+		}
+		record := boltTrashEntry{Kind: entry.Kind, Name: entry.Name, ParentID: entry.ParentId, TrashedAt: entry.TrashedAt}
+		return putBoltRecord(trash, entry.ItemId, record)
+	})
+}
+
+func (repo BoltFileSystemRepository) UpdateFileContent(ctx context.Context, fileID string, content fsmodel.FileContent) error {
+	return repo.update(ctx, func(repo BoltFileSystemRepository) error {
+		file, err := repo.getFile(fileID)
+		if err != nil {
+			return err
+		}
+		if err := repo.tx.Bucket(boltBlobRefsBucket).Delete(boltBlobRefKey(file.BlobKey, fileID)); err != nil {
+			return err
+		}
+
+		updated := file.toFile(fileID)
+		updated.FileContent = content
+		updated.ModifiedAt = currentTime()
+		if err := repo.putFile(fileID, boltFileOf(updated)); err != nil {
+			return err
+		}
+		if err := repo.tx.Bucket(boltOrphanBlobsBucket).Delete([]byte(content.BlobKey)); err != nil {
+			return err
+		}
+		return repo.recordOrphanBlobKeys([]string{file.BlobKey})
+	})
+}
+
+func (repo BoltFileSystemRepository) GetFileVersions(ctx context.Context, fileID string) (*[]fsmodel.FileVersion, error) {
+	var versions []fsmodel.FileVersion
+	err := repo.view(ctx, func(repo BoltFileSystemRepository) error {
+		if _, err := repo.getFile(fileID); err != nil {
+			return err
+		}
+		var err error
+		versions, err = repo.fileVersions(fileID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &versions, nil
+}
+
+func (repo BoltFileSystemRepository) CreateFileVersion(ctx context.Context, fileID string, version fsmodel.FileVersion) error {
+	return repo.update(ctx, func(repo BoltFileSystemRepository) error {
+		if _, err := repo.getFile(fileID); err != nil {
+			return err
+		}
+		versions := repo.tx.Bucket(boltFileVersionsBucket)
+		key := boltFileVersionKey(fileID, version.Number)
+		if versions.Get(key) != nil {
+			return fileVersionAlreadyExistsError(fileID, version.Number)
+		}
+
+		value, err := json.Marshal(boltFileVersionOf(version))
+		if err != nil {
+			return err
+		}
+		if err := versions.Put(key, value); err != nil {
+			return err
+		}
+		if err := repo.tx.Bucket(boltBlobRefsBucket).Put(boltFileVersionRefKey(version.BlobKey, fileID, version.Number), []byte{}); err != nil {
+			return err
+		}
+		return repo.tx.Bucket(boltOrphanBlobsBucket).Delete([]byte(version.BlobKey))
+	})
+}
+
+func (repo BoltFileSystemRepository) DeleteFileVersion(ctx context.Context, fileID string, number int) error {
+	return repo.update(ctx, func(repo BoltFileSystemRepository) error {
+		value := repo.tx.Bucket(boltFileVersionsBucket).Get(boltFileVersionKey(fileID, number))
+		if value == nil {
+			return fileVersionNotFoundError(fileID, number)
+		}
+		var version boltFileVersion
+		if err := json.Unmarshal(value, &version); err != nil {
+			return err
+		}
+
+		if err := repo.deleteFileVersion(fileID, number, version.BlobKey); err != nil {
+			return err
+		}
+		return repo.recordOrphanBlobKeys([]string{version.BlobKey})
+	})
+}
+
+func (repo BoltFileSystemRepository) getFolder(folderID string) (boltFolder, error) {
+	var folder boltFolder
+	value := repo.tx.Bucket(boltFoldersBucket).Get(boltID(folderID))
+	if value == nil {
+		return folder, folderNotFoundError(folderID)
+	}
+	return folder, json.Unmarshal(value, &folder)
+}
+
+// isTrashed tells whether the folder is the trash folder or one of its descendants
+func (repo BoltFileSystemRepository) isTrashed(folderID string) (bool, error) {
+	if folderID == trashFolderID {
+		return true, nil
+	}
+	return repo.isFolderInside(folderID, trashFolderID)
+}
+
+func (repo BoltFileSystemRepository) getFile(fileID string) (boltFile, error) {
+	var file boltFile
+	value := repo.tx.Bucket(boltFilesBucket).Get(boltID(fileID))
+	if value == nil {
+		return file, fileNotFoundError(fileID)
+	}
+	return file, json.Unmarshal(value, &file)
+}
+
+func (repo BoltFileSystemRepository) exists(ctx context.Context, bucket []byte, itemID string) (*bool, error) {
+	var exists bool
+	err := repo.view(ctx, func(repo BoltFileSystemRepository) error {
+		exists = repo.tx.Bucket(bucket).Get(boltID(itemID)) != nil
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &exists, nil
+}
+
+func (repo BoltFileSystemRepository) idByLegacyID(ctx context.Context, bucket []byte, legacyID int, notFoundError func(int) error) (*string, error) {
+	var itemID string
+	err := repo.view(ctx, func(repo BoltFileSystemRepository) error {
+		value := repo.tx.Bucket(bucket).Get(boltLegacyID(legacyID))
+		if value == nil {
+			return notFoundError(legacyID)
+		}
+		itemID = string(value)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &itemID, nil
+}
+
+func (repo BoltFileSystemRepository) isIDTaken(bucket []byte, legacyIDsBucket []byte, itemID string, legacyID int) bool {
+	if repo.tx.Bucket(bucket).Get(boltID(itemID)) != nil {
+		return true
+	}
+	return legacyID != 0 && repo.tx.Bucket(legacyIDsBucket).Get(boltLegacyID(legacyID)) != nil
+}
+
+func (repo BoltFileSystemRepository) isFolderInside(folderID string, ancestorFolderID string) (bool, error) {
+	folder, err := repo.getFolder(folderID)
+	if err != nil {
+		return false, err
+	}
+	for folder.ParentID != nil {
+		if *folder.ParentID == ancestorFolderID {
+			return true, nil
+		}
+		if folder, err = repo.getFolder(*folder.ParentID); err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+type boltChild struct {
+	kind byte
+	id   string
+	name string
+}
+
+// children lists the items directly inside the folder, by id
+func (repo BoltFileSystemRepository) children(folderID string) ([]boltChild, error) {
+	children := make([]boltChild, 0)
+	prefix := boltChildPrefix(folderID)
+	cursor := repo.tx.Bucket(boltChildrenBucket).Cursor()
+	for key, value := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, value = cursor.Next() {
+		if len(value) < 2 {
+			return nil, errors.Errorf("Corrupted child index entry inside folder %s", folderID)
+		}
+		children = append(children, boltChild{kind: value[0], id: string(value[1:]), name: string(key[len(prefix):])})
+	}
+	sort.Slice(children, func(i, j int) bool { return children[i].id < children[j].id })
+	return children, nil
+}
+
+// childCounts counts the items directly inside the folder, without reading them
+func (repo BoltFileSystemRepository) childCounts(folderID string) (fsmodel.ChildCounts, error) {
+	counts := fsmodel.ChildCounts{}
+	prefix := boltChildPrefix(folderID)
+	cursor := repo.tx.Bucket(boltChildrenBucket).Cursor()
+	for key, value := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, value = cursor.Next() {
+		if len(value) < 2 {
+			return counts, errors.Errorf("Corrupted child index entry inside folder %s", folderID)
+		}
+		switch value[0] {
+		case boltFolderKind:
+			counts.Folders++
+		case boltFileKind:
+			counts.Files++
+		}
+	}
+	return counts, nil
+}
+
+// deleteChildren removes the entries of the folder from the children index, leaving the items to the caller
+func (repo BoltFileSystemRepository) deleteChildren(folderID string) error {
+	prefix := boltChildPrefix(folderID)
+	cursor := repo.tx.Bucket(boltChildrenBucket).Cursor()
+	for key, _ := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, _ = cursor.Seek(prefix) {
+		if err := cursor.Delete(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// errorIfNameConflict: the given item (noItemID for none) is the one being named, it does not conflict with itself
+func (repo BoltFileSystemRepository) errorIfNameConflict(folderID string, name string, kind byte, itemID string) error {
+	value := repo.tx.Bucket(boltChildrenBucket).Get(boltChildKey(folderID, name))
+	if value != nil && !bytes.Equal(value, boltChildValue(kind, itemID)) {
+		return nameAlreadyExistsError(folderID, name)
+	}
+	return nil
+}
+
+func (repo BoltFileSystemRepository) putChild(folderID string, name string, kind byte, itemID string) error {
+	return repo.tx.Bucket(boltChildrenBucket).Put(boltChildKey(folderID, name), boltChildValue(kind, itemID))
+}
+
+// renameChild moves the entry of the item inside the children index
+func (repo BoltFileSystemRepository) renameChild(folderID string, name string, destFolderID string, destName string, kind byte, itemID string) error {
+	if err := repo.errorIfNameConflict(destFolderID, destName, kind, itemID); err != nil {
+		return err
+	}
+	if err := repo.tx.Bucket(boltChildrenBucket).Delete(boltChildKey(folderID, name)); err != nil {
+		return err
+	}
+	return repo.putChild(destFolderID, destName, kind, itemID)
+}
+
+// putFolder writes the folder along with its legacy id, leaving the children index to the caller
+func (repo BoltFileSystemRepository) putFolder(folderID string, folder boltFolder) error {
+	if err := putBoltRecord(repo.tx.Bucket(boltFoldersBucket), folderID, folder); err != nil {
+		return err
+	}
+	if folder.LegacyID == 0 {
+		return nil
+	}
+	return repo.tx.Bucket(boltFolderLegacyIDsBucket).Put(boltLegacyID(folder.LegacyID), boltID(folderID))
+}
+
+// touchFolder records that a direct child of the folder was added, removed or renamed
+func (repo BoltFileSystemRepository) touchFolder(folderID string, modifiedAt time.Time) error {
+	folder, err := repo.getFolder(folderID)
+	if err != nil {
+		return err
+	}
+	folder.ModifiedAt = modifiedAt
+	return repo.putFolder(folderID, folder)
+}
+
+// putFile writes the file along with its legacy id and the reference to its content, leaving the children index to
+// the caller
+func (repo BoltFileSystemRepository) putFile(fileID string, file boltFile) error {
+	if err := putBoltRecord(repo.tx.Bucket(boltFilesBucket), fileID, file); err != nil {
+		return err
+	}
+	if err := repo.tx.Bucket(boltBlobRefsBucket).Put(boltBlobRefKey(file.BlobKey, fileID), []byte{}); err != nil {
+		return err
+	}
+	if file.LegacyID == 0 {
+		return nil
+	}
+	return repo.tx.Bucket(boltFileLegacyIDsBucket).Put(boltLegacyID(file.LegacyID), boltID(fileID))
+}
+
+// deleteFile deletes the file along with its versions, leaving the children index to the caller, and returns the
+// content keys they referenced
+func (repo BoltFileSystemRepository) deleteFile(fileID string, file boltFile) ([]string, error) {
+	versions, err := repo.fileVersions(fileID)
+	if err != nil {
+		return nil, err
+	}
+	blobKeys := []string{file.BlobKey}
+	for _, version := range versions {
+		if err := repo.deleteFileVersion(fileID, version.Number, version.BlobKey); err != nil {
+			return nil, err
+		}
+		blobKeys = append(blobKeys, version.BlobKey)
+	}
+
+	if err := repo.tx.Bucket(boltBlobRefsBucket).Delete(boltBlobRefKey(file.BlobKey, fileID)); err != nil {
+		return nil, err
+	}
+	if file.LegacyID != 0 {
+		if err := repo.tx.Bucket(boltFileLegacyIDsBucket).Delete(boltLegacyID(file.LegacyID)); err != nil {
+			return nil, err
+		}
+	}
+	return blobKeys, repo.tx.Bucket(boltFilesBucket).Delete(boltID(fileID))
+}
+
+// fileVersions reads the versions of the file, the newest first
+func (repo BoltFileSystemRepository) fileVersions(fileID string) ([]fsmodel.FileVersion, error) {
+	versions := make([]fsmodel.FileVersion, 0)
+	prefix := boltFileVersionPrefix(fileID)
+	cursor := repo.tx.Bucket(boltFileVersionsBucket).Cursor()
+	for key, value := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, value = cursor.Next() {
+		if len(key) != len(prefix)+8 {
+			return nil, errors.Errorf("Corrupted version entry of file %s", fileID)
+		}
+		var version boltFileVersion
+		if err := json.Unmarshal(value, &version); err != nil {
+			return nil, err
+		}
+		number := int(binary.BigEndian.Uint64(key[len(prefix):]))
+		versions = append(versions, version.toFileVersion(number))
+	}
+	// the keys are sorted by number, the oldest first
+	for i, j := 0, len(versions)-1; i < j; i, j = i+1, j-1 {
+		versions[i], versions[j] = versions[j], versions[i]
+	}
+	return versions, nil
+}
+
+// deleteFileVersion deletes the version along with the reference to its content
+func (repo BoltFileSystemRepository) deleteFileVersion(fileID string, number int, blobKey string) error {
+	if err := repo.tx.Bucket(boltBlobRefsBucket).Delete(boltFileVersionRefKey(blobKey, fileID, number)); err != nil {
+		return err
+	}
+	return repo.tx.Bucket(boltFileVersionsBucket).Delete(boltFileVersionKey(fileID, number))
+}
+
+func (repo BoltFileSystemRepository) isBlobReferenced(blobKey string) bool {
+	prefix := boltBlobRefPrefix(blobKey)
+	key, _ := repo.tx.Bucket(boltBlobRefsBucket).Cursor().Seek(prefix)
+	return key != nil && bytes.HasPrefix(key, prefix)
+}
+
+func (repo BoltFileSystemRepository) recordOrphanBlobKeys(blobKeys []string) error {
+	orphanBlobs := repo.tx.Bucket(boltOrphanBlobsBucket)
+	for _, blobKey := range blobKeys {
+		if blobKey == "" || repo.isBlobReferenced(blobKey) {
+			continue
+		}
+		if err := orphanBlobs.Put([]byte(blobKey), []byte{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (folder boltFolder) toFolder(folderID string) fsmodel.Folder {
+	return fsmodel.Folder{
+		Id:         folderID,
+		LegacyId:   folder.LegacyID,
+		Name:       folder.Name,
+		ParentId:   folder.ParentID,
+		CreatedAt:  folder.CreatedAt,
+		ModifiedAt: folder.ModifiedAt,
+	}
+}
+
+// boltFolderOf is the record of the folder, its id aside
+func boltFolderOf(folder fsmodel.Folder) boltFolder {
+	var parentID *string
+	if folder.ParentId != nil {
+		id := *folder.ParentId
+		parentID = &id
+	}
+	return boltFolder{
+		Name:       folder.Name,
+		ParentID:   parentID,
+		LegacyID:   folder.LegacyId,
+		CreatedAt:  folder.CreatedAt,
+		ModifiedAt: folder.ModifiedAt,
+	}
+}
+
+func (file boltFile) toFile(fileID string) fsmodel.File {
+	size := int64(fsmodel.UnknownSize)
+	if file.Size != nil {
+		size = *file.Size
+	}
+	return fsmodel.File{
+		Id:          fileID,
+		LegacyId:    file.LegacyID,
+		Name:        file.Name,
+		ParentId:    file.ParentID,
+		FileContent: fsmodel.FileContent{BlobKey: file.BlobKey, Digest: file.Digest, Size: size, ContentType: file.ContentType},
+		CreatedAt:   file.CreatedAt,
+		ModifiedAt:  file.ModifiedAt,
+	}
+}
+
+// boltFileOf is the record of the file, its id aside
+func boltFileOf(file fsmodel.File) boltFile {
+	var size *int64
+	if file.Size != fsmodel.UnknownSize {
+		size = &file.Size
+	}
+	return boltFile{
+		Name:        file.Name,
+		ParentID:    file.ParentId,
+		BlobKey:     file.BlobKey,
+		Digest:      file.Digest,
+		LegacyID:    file.LegacyId,
+		Size:        size,
+		ContentType: file.ContentType,
+		CreatedAt:   file.CreatedAt,
+		ModifiedAt:  file.ModifiedAt,
+	}
+}
+
+func (version boltFileVersion) toFileVersion(number int) fsmodel.FileVersion {
+	size := int64(fsmodel.UnknownSize)
+	if version.Size != nil {
+		size = *version.Size
+	}
+	return fsmodel.FileVersion{
+		Number:      number,
+		FileContent: fsmodel.FileContent{BlobKey: version.BlobKey, Digest: version.Digest, Size: size, ContentType: version.ContentType},
+		CreatedAt:   version.CreatedAt,
+	}
+}
+
+// boltFileVersionOf is the record of the version, its number aside
+func boltFileVersionOf(version fsmodel.FileVersion) boltFileVersion {
+	var size *int64
+	if version.Size != fsmodel.UnknownSize {
+		size = &version.Size
+	}
+	return boltFileVersion{
+		BlobKey:     version.BlobKey,
+		Digest:      version.Digest,
+		Size:        size,
+		ContentType: version.ContentType,
+		CreatedAt:   version.CreatedAt,
+	}
+}
+
+func (entry boltTrashEntry) toTrashEntry(itemID string) fsmodel.TrashEntry {
+	return fsmodel.TrashEntry{
+		ItemId:    itemID,
+		Kind:      entry.Kind,
+		Name:      entry.Name,
+		ParentId:  entry.ParentID,
+		TrashedAt: entry.TrashedAt,
+	}
+}
+
+func putBoltRecord(bucket *bolt.Bucket, id string, record interface{}) error {
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(boltID(id), value)
+}
+
+func boltID(id string) []byte {
+	return []byte(id)
+}
+
+// boltLegacyID encodes the legacy id in big endian, so that the keys are sorted by legacy id
+func boltLegacyID(legacyID int) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(legacyID))
+	return key
+}
+
+// the ids contain no 0 byte: the prefix of a folder is no prefix of another one
+func boltChildPrefix(folderID string) []byte {
+	return append(boltID(folderID), 0)
+}
+
+func boltChildKey(folderID string, name string) []byte {
+	return append(boltChildPrefix(folderID), name...)
+}
+
+func boltChildValue(kind byte, itemID string) []byte {
+	return append([]byte{kind}, boltID(itemID)...)
+}
+
+// the blob keys contain no 0 byte: the prefix of a blob key is no prefix of another one
+func boltBlobRefPrefix(blobKey string) []byte {
+	return append([]byte(blobKey), 0)
+}
+
+func boltBlobRefKey(blobKey string, fileID string) []byte {
+	return append(boltBlobRefPrefix(blobKey), boltID(fileID)...)
+}
+
+func boltFileVersionPrefix(fileID string) []byte {
+	return append(boltID(fileID), 0)
+}
+
+// the numbers are encoded in big endian, so that the versions of a file are sorted by number
+func boltFileVersionKey(fileID string, number int) []byte {
+	return append(boltFileVersionPrefix(fileID), boltLegacyID(number)...)
+}
+
+func boltFileVersionRefKey(blobKey string, fileID string, number int) []byte {
+	return append(append(boltBlobRefKey(blobKey, fileID), 0), boltLegacyID(number)...)
+}
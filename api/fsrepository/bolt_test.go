@@ -0,0 +1,156 @@
+package fsrepository
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/loisfa/remote-file-system/api/fsmodel"
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestBoltFileSurvivesRestart(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "bolt-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "test.bolt")
+
+	repo, err := NewBoltFileSystemRepository(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	folderID, err := repo.CreateFolder(ctx, "kept", rootFolderID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileID, err := repo.CreateFile(ctx, "file.txt", fsmodel.FileContent{BlobKey: "sha256-abc", Digest: "abc"}, *folderID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.DeleteFile(ctx, *fileID); err != nil {
+		t.Fatal(err)
+	}
+	repo.Close()
+
+	repo, err = NewBoltFileSystemRepository(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.Close()
+
+	folders, err := repo.GetFoldersIn(ctx, rootFolderID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*folders) != 1 || (*folders)[0].Id != *folderID || (*folders)[0].Name != "kept" {
+		t.Fatalf("unexpected folders %+v", *folders)
+	}
+
+	// the id of a deleted file is never given again
+	newFileID, err := repo.CreateFile(ctx, "file.txt", fsmodel.FileContent{BlobKey: "sha256-def", Digest: "def"}, *folderID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *newFileID == *fileID {
+		t.Fatalf("the file id %s was given again", *newFileID)
+	}
+}
+
+func TestBoltMigratesIntegerIDs(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "bolt-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "test.bolt")
+
+	// a file of version 1: no meta bucket, the items keyed by their integer id
+	db, err := bolt.Open(file, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if err := createBoltBuckets(tx); err != nil {
+			return err
+		}
+		rootID := 0
+		for key, value := range map[int]interface{}{
+			1: boltFolderV1{Name: "photos", ParentID: &rootID},
+			2: boltFolderV1{Name: "music", ParentID: &rootID},
+		} {
+			if err := putBoltV1Record(tx.Bucket(boltFoldersBucket), key, value); err != nil {
+				return err
+			}
+		}
+		if err := putBoltV1Record(tx.Bucket(boltFilesBucket), 1, boltFileV1{Name: "cat.jpg", ParentID: 1, BlobKey: "sha256-abc", Digest: "abc"}); err != nil {
+			return err
+		}
+		return tx.Bucket(boltOrphanBlobsBucket).Put([]byte("sha256-def"), []byte{})
+	})
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo, err := NewBoltFileSystemRepository(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.Close()
+
+	if rootID, err := repo.GetRootFolderID(ctx); err != nil || *rootID != rootFolderID {
+		t.Fatalf("unexpected root folder %v (error: %v)", rootID, err)
+	}
+	folderID, err := repo.GetFolderIDByLegacyID(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	folder, err := repo.GetFolder(ctx, *folderID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if folder.LegacyId != 1 || folder.Name != "photos" || folder.ParentId == nil || *folder.ParentId != rootFolderID {
+		t.Fatalf("unexpected migrated folder %+v", *folder)
+	}
+	fileID, err := repo.GetFileIDByLegacyID(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := repo.GetFilesIn(ctx, *folderID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*files) != 1 || (*files)[0].Id != *fileID || (*files)[0].LegacyId != 1 || (*files)[0].Name != "cat.jpg" || (*files)[0].BlobKey != "sha256-abc" {
+		t.Fatalf("unexpected migrated files %+v", *files)
+	}
+	if referenced, _ := repo.IsBlobReferenced(ctx, "sha256-abc"); !*referenced {
+		t.Fatal("the migrated file does not reference its content anymore")
+	}
+	if orphans, _ := repo.GetOrphanBlobKeys(ctx); len(*orphans) != 1 || (*orphans)[0] != "sha256-def" {
+		t.Fatalf("unexpected orphan blob keys %v", *orphans)
+	}
+	assertErrorCodeIs(t, repo.ImportFolder(ctx, fsmodel.Folder{Id: newID(), LegacyId: 2, Name: "taken", ParentId: folderID}), IdAlreadyExists)
+}
+
+func putBoltV1Record(bucket *bolt.Bucket, id int, record interface{}) error {
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(boltLegacyID(id), value)
+}
+
+func assertErrorCodeIs(t *testing.T, err error, code string) {
+	t.Helper()
+	if err == nil || errors.Cause(err).Error() != code {
+		t.Fatalf("expected the error %s, got %v", code, err)
+	}
+}
@@ -0,0 +1,187 @@
+package fsrepository
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	boltMetaBucket = []byte("meta") // version -> version of the layout of the buckets
+	boltVersionKey = []byte("version")
+)
+
+// boltMigrations are the successive layouts of the buckets: never change a released migration, append a new one. The
+// version of a file is the number of migrations applied, recorded inside the meta bucket. The files created before the
+// meta bucket have none: they are at version 1.
+var boltMigrations = []func(tx *bolt.Tx) error{
+	// 1: folders and files keyed by their integer id, and the root folder
+	createBoltBuckets,
+	// 2: the opaque ids, the integer ids kept as legacy ids
+	migrateBoltToOpaqueIDs,
+	// 3: the trash folder and the trash entries
+	createBoltTrash,
+	// 4: the file versions
+	createBoltFileVersions,
+}
+
+// the records of version 1, keyed by their integer id in big endian
+type boltFolderV1 struct {
+	Name     string `json:"name"`
+	ParentID *int   `json:"parentId,omitempty"` // nil for the root folder
+}
+
+type boltFileV1 struct {
+	Name     string `json:"name"`
+	ParentID int    `json:"parentId"`
+	BlobKey  string `json:"blobKey"`
+	Digest   string `json:"digest"`
+}
+
+// migrateBolt brings the layout of the file to the last version. bbolt lets a single process open the file: the
+// migrations run in a single transaction, no other instance can run them at the same time.
+func migrateBolt(db *bolt.DB) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		version := 0
+		if meta := tx.Bucket(boltMetaBucket); meta != nil {
+			version = int(binary.BigEndian.Uint64(meta.Get(boltVersionKey)))
+		} else if tx.Bucket(boltFoldersBucket) != nil {
+			version = 1
+		}
+		if version >= len(boltMigrations) {
+			return nil
+		}
+
+		for v := version; v < len(boltMigrations); v++ {
+			if err := boltMigrations[v](tx); err != nil {
+				return errors.WithMessage(err, fmt.Sprintf("Could not migrate the bbolt file to version %d", v+1))
+			}
+		}
+		meta, err := tx.CreateBucketIfNotExists(boltMetaBucket)
+		if err != nil {
+			return err
+		}
+		return meta.Put(boltVersionKey, boltLegacyID(len(boltMigrations)))
+	})
+}
+
+func createBoltBuckets(tx *bolt.Tx) error {
+	for _, bucket := range [][]byte{boltFoldersBucket, boltFilesBucket, boltChildrenBucket, boltBlobRefsBucket, boltOrphanBlobsBucket} {
+		if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+			return err
+		}
+	}
+	value, err := json.Marshal(boltFolderV1{Name: boltRootFolderName})
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(boltFoldersBucket).Put(boltLegacyID(0), value)
+}
+
+// createBoltTrash creates the trash folder, which has no parent as the root folder
+func createBoltTrash(tx *bolt.Tx) error {
+	if _, err := tx.CreateBucket(boltTrashBucket); err != nil {
+		return err
+	}
+	repo := BoltFileSystemRepository{tx: tx}
+	return repo.putFolder(trashFolderID, boltFolder{Name: boltTrashFolderName})
+}
+
+func createBoltFileVersions(tx *bolt.Tx) error {
+	_, err := tx.CreateBucket(boltFileVersionsBucket)
+	return err
+}
+
+// migrateBoltToOpaqueIDs gives every item a new id, and the root folder the id of every root folder. The indexes are
+// rebuilt from the items.
+func migrateBoltToOpaqueIDs(tx *bolt.Tx) error {
+	folderIDs := make(map[int]string)
+	folders := make(map[int]boltFolderV1)
+	legacyFolderIDs := make([]int, 0)
+	err := tx.Bucket(boltFoldersBucket).ForEach(func(key, value []byte) error {
+		var folder boltFolderV1
+		if err := json.Unmarshal(value, &folder); err != nil {
+			return err
+		}
+		legacyID := int(binary.BigEndian.Uint64(key))
+		folders[legacyID] = folder
+		legacyFolderIDs = append(legacyFolderIDs, legacyID)
+		if folder.ParentID == nil {
+			folderIDs[legacyID] = rootFolderID
+		} else {
+			folderIDs[legacyID] = newID()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	files := make(map[int]boltFileV1)
+	legacyFileIDs := make([]int, 0)
+	err = tx.Bucket(boltFilesBucket).ForEach(func(key, value []byte) error {
+		var file boltFileV1
+		if err := json.Unmarshal(value, &file); err != nil {
+			return err
+		}
+		legacyID := int(binary.BigEndian.Uint64(key))
+		files[legacyID] = file
+		legacyFileIDs = append(legacyFileIDs, legacyID)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, bucket := range [][]byte{boltFoldersBucket, boltFilesBucket, boltChildrenBucket, boltBlobRefsBucket} {
+		if err := tx.DeleteBucket(bucket); err != nil {
+			return err
+		}
+	}
+	for _, bucket := range [][]byte{boltFoldersBucket, boltFilesBucket, boltChildrenBucket, boltBlobRefsBucket, boltFolderLegacyIDsBucket, boltFileLegacyIDsBucket} {
+		if _, err := tx.CreateBucket(bucket); err != nil {
+			return err
+		}
+	}
+
+	repo := BoltFileSystemRepository{tx: tx}
+	for _, legacyID := range legacyFolderIDs {
+		folder := folders[legacyID]
+		record := boltFolder{Name: folder.Name}
+		if folder.ParentID != nil {
+			parentID, found := folderIDs[*folder.ParentID]
+			if !found {
+				return errors.Errorf("Folder %d is inside folder %d, which does not exist", legacyID, *folder.ParentID)
+			}
+			record.ParentID = &parentID
+			record.LegacyID = legacyID
+		}
+		if err := repo.putFolder(folderIDs[legacyID], record); err != nil {
+			return err
+		}
+		if record.ParentID != nil {
+			if err := repo.putChild(*record.ParentID, record.Name, boltFolderKind, folderIDs[legacyID]); err != nil {
+				return err
+			}
+		}
+	}
+	for _, legacyID := range legacyFileIDs {
+		file := files[legacyID]
+		parentID, found := folderIDs[file.ParentID]
+		if !found {
+			return errors.Errorf("File %d is inside folder %d, which does not exist", legacyID, file.ParentID)
+		}
+		fileID := newID()
+		record := boltFile{Name: file.Name, ParentID: parentID, BlobKey: file.BlobKey, Digest: file.Digest, LegacyID: legacyID}
+		if err := repo.putFile(fileID, record); err != nil {
+			return err
+		}
+		if err := repo.putChild(parentID, file.Name, boltFileKind, fileID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
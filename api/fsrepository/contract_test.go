@@ -0,0 +1,91 @@
+package fsrepository_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/loisfa/remote-file-system/api/fsrepository"
+	"github.com/loisfa/remote-file-system/api/fsrepository/repositorytest"
+)
+
+const (
+	// NEO4J_CONTRACT_TESTS runs the contract tests against the Neo4j database configured by the NEO4J_* environment
+	// variables as well. NB: the tests delete every folder and file of that database.
+	NEO4J_CONTRACT_TESTS = "NEO4J_CONTRACT_TESTS"
+	// POSTGRES_CONTRACT_TESTS runs the contract tests against the PostgreSQL database given by POSTGRES_URL as well.
+	// NB: the tests delete every folder and file of that database.
+	POSTGRES_CONTRACT_TESTS = "POSTGRES_CONTRACT_TESTS"
+)
+
+func TestMemoryRepositoryContract(t *testing.T) {
+	repositorytest.RunContractTests(t, func(t *testing.T) fsrepository.IFileSystemRepository {
+		return fsrepository.NewMemoryFileSystemRepository()
+	})
+}
+
+func TestNeo4JRepositoryContract(t *testing.T) {
+	if len(os.Getenv(NEO4J_CONTRACT_TESTS)) == 0 {
+		t.Skipf("Set the environment variable %s to run the contract tests against Neo4j", NEO4J_CONTRACT_TESTS)
+	}
+
+	repo := fsrepository.NewNeo4JFileSystemRepository()
+	repositorytest.RunContractTests(t, func(t *testing.T) fsrepository.IFileSystemRepository {
+		if err := fsrepository.ResetNeo4JFileSystemRepository(repo); err != nil {
+			t.Fatal(err)
+		}
+		return repo
+	})
+}
+
+func TestSQLiteRepositoryContract(t *testing.T) {
+	repositorytest.RunContractTests(t, func(t *testing.T) fsrepository.IFileSystemRepository {
+		dir, err := ioutil.TempDir("", "sqlite-contract-test-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { os.RemoveAll(dir) })
+
+		repo, err := fsrepository.NewSQLiteFileSystemRepository(filepath.Join(dir, "test.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { repo.Close() })
+		return repo
+	})
+}
+
+func TestBoltRepositoryContract(t *testing.T) {
+	repositorytest.RunContractTests(t, func(t *testing.T) fsrepository.IFileSystemRepository {
+		dir, err := ioutil.TempDir("", "bolt-contract-test-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { os.RemoveAll(dir) })
+
+		repo, err := fsrepository.NewBoltFileSystemRepository(filepath.Join(dir, "test.bolt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { repo.Close() })
+		return repo
+	})
+}
+
+func TestPostgresRepositoryContract(t *testing.T) {
+	if len(os.Getenv(POSTGRES_CONTRACT_TESTS)) == 0 {
+		t.Skipf("Set the environment variable %s to run the contract tests against PostgreSQL", POSTGRES_CONTRACT_TESTS)
+	}
+
+	repo, err := fsrepository.NewPostgresFileSystemRepository(os.Getenv(fsrepository.POSTGRES_URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	repositorytest.RunContractTests(t, func(t *testing.T) fsrepository.IFileSystemRepository {
+		if err := fsrepository.ResetSQLFileSystemRepository(repo); err != nil {
+			t.Fatal(err)
+		}
+		return repo
+	})
+}
@@ -0,0 +1,39 @@
+package fsrepository
+
+import (
+	"context"
+)
+
+// resetNeo4JQuery deletes every folder, file, file version, orphan content and trash entry, then recreates the root
+// and trash folders
+const resetNeo4JQuery = `MATCH (n) WHERE n:Folder OR n:File OR n:FileVersion OR n:OrphanBlob OR n:TrashEntry
+	DETACH DELETE n
+	WITH count(*) AS deleted
+	CREATE (:Folder {id: $rootFolderID, name: 'Root folder', is_root: true})
+	CREATE (:Folder {id: $trashFolderID, name: 'Trash', sort_name: 'trash'})`
+
+// ResetNeo4JFileSystemRepository leaves the database with the root folder and the empty trash folder only
+func ResetNeo4JFileSystemRepository(repo Neo4JFileSystemRepository) error {
+	return executeUpdateQuery(repo.writeTransaction(context.Background()))(resetNeo4JQuery, map[string]interface{}{
+		"rootFolderID":  rootFolderID,
+		"trashFolderID": trashFolderID,
+	})
+}
+
+// ResetSQLFileSystemRepository leaves the database with the root folder and the empty trash folder only
+func ResetSQLFileSystemRepository(repo SQLFileSystemRepository) error {
+	repo = repo.withContext(context.Background())
+	for _, statement := range []string{
+		`DELETE FROM file_versions`,
+		`DELETE FROM files`,
+		`DELETE FROM orphan_blobs`,
+		`DELETE FROM trash_entries`,
+		`DELETE FROM folders WHERE parent_id IS NOT NULL`,
+		`UPDATE folders SET name = 'Root folder' WHERE is_root`,
+	} {
+		if err := repo.exec(statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,18 @@
+package fsrepository
+
+import (
+	"github.com/google/uuid"
+)
+
+// rootFolderID is the id of the root folder in every repository, so that the trees of two repositories line up: the
+// nil UUID
+const rootFolderID = "00000000-0000-0000-0000-000000000000"
+
+// trashFolderID is the id of the trash folder of the root folder in every repository, for the same reason
+const trashFolderID = "00000000-0000-0000-0000-000000000001"
+
+// newID returns the id of a new folder or file: a UUID version 7, which tells nothing of the number of items, and
+// whose first bits are the time of creation, as the indexes of the databases like
+func newID() string {
+	return uuid.Must(uuid.NewV7()).String()
+}
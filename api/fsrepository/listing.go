@@ -0,0 +1,352 @@
+package fsrepository
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/loisfa/remote-file-system/api/fsmodel"
+)
+
+// The children of a folder are ordered by keys derived from their name, stored along with it so that the databases
+// can sort and page through them
+const (
+	dbSortName  = "sort_name" // see naturalSortKey
+	dbExtension = "extension" // see fileExtension, files only
+)
+
+// naturalSortKey orders the names naturally once compared byte by byte: case insensitive, and the numbers by value.
+// Each run of digits is prefixed by its length, leading zeros removed: "photo 2" gives "photo 0012", "photo 10"
+// gives "photo 00210".
+func naturalSortKey(name string) string {
+	lower := strings.ToLower(name)
+	var key strings.Builder
+	for i := 0; i < len(lower); {
+		if !isDigit(lower[i]) {
+			key.WriteByte(lower[i])
+			i++
+			continue
+		}
+
+		end := i
+		for end < len(lower) && isDigit(lower[end]) {
+			end++
+		}
+		number := strings.TrimLeft(lower[i:end], "0")
+		if number == "" {
+			number = "0"
+		}
+		fmt.Fprintf(&key, "%03d%s", len(number), number)
+		i = end
+	}
+	return key.String()
+}
+
+// sortKeyPrefixRange bounds the sort keys of the names starting with the prefix, the upper bound being nil when there
+// is none. The digits ending the prefix are left out, since the names may carry on the number: the range also holds
+// other names, to be filtered out.
+func sortKeyPrefixRange(namePrefix string) (string, *string) {
+	if !utf8.ValidString(namePrefix) {
+		return "", nil
+	}
+	lower := naturalSortKey(strings.TrimRight(namePrefix, "0123456789"))
+	return lower, prefixUpperBound(lower)
+}
+
+// prefixUpperBound is the smallest string greater than the strings starting with the prefix, compared byte by byte,
+// nil when there is none
+func prefixUpperBound(prefix string) *string {
+	runes := []rune(prefix)
+	for i := len(runes) - 1; i >= 0; i-- {
+		switch runes[i] {
+		case unicode.MaxRune:
+			continue
+		case 0xD7FF: // the surrogates are not valid runes
+			runes[i] = 0xE000
+		default:
+			runes[i]++
+		}
+		upper := string(runes[:i+1])
+		return &upper
+	}
+	return nil
+}
+
+func isDigit(char byte) bool {
+	return '0' <= char && char <= '9'
+}
+
+// fileExtension is the lower case extension of the file name, without dot: none for "README" or ".bashrc"
+func fileExtension(name string) string {
+	dot := strings.LastIndex(name, ".")
+	if dot <= 0 || dot == len(name)-1 {
+		return ""
+	}
+	return strings.ToLower(name[dot+1:])
+}
+
+// globRegexp translates the name glob of the list options into a regular expression matching whole names, which
+// both Go and Neo4j understand
+func globRegexp(glob string) string {
+	var expr strings.Builder
+	expr.WriteString("(?s)^")
+	for _, char := range glob {
+		switch char {
+		case '*':
+			expr.WriteString(".*")
+		case '?':
+			expr.WriteString(".")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(char)))
+		}
+	}
+	expr.WriteString("$")
+	return expr.String()
+}
+
+// listKeyNames are the columns, or properties, which order the children of the kind, the id aside
+func listKeyNames(sortKey fsmodel.SortKey, kind fsmodel.ItemKind) []string {
+	switch {
+	case sortKey == fsmodel.SortByType && kind == fsmodel.FileKind:
+		return []string{dbExtension, dbSortName}
+	case sortKey == fsmodel.SortBySize && kind == fsmodel.FileKind:
+		return []string{dbSize, dbSortName}
+	case sortKey == fsmodel.SortByModified:
+		return []string{dbModified, dbSortName}
+	}
+	return []string{dbSortName}
+}
+
+// unknownListKeys are the values the unknown keys, stored as nil, are ordered as: before the known ones
+var unknownListKeys = map[string]int64{dbSize: fsmodel.UnknownSize, dbModified: 0}
+
+// listKeyExpression orders by the key inside a query, the variable being the one of the child followed by its dot, or
+// empty
+func listKeyExpression(variable string, name string) string {
+	if unknown, nullable := unknownListKeys[name]; nullable {
+		return fmt.Sprintf("coalesce(%s%s, %d)", variable, name, unknown)
+	}
+	return variable + name
+}
+
+// listKeys are the values of listKeyNames for the child of the kind
+func listKeys(sortKey fsmodel.SortKey, kind fsmodel.ItemKind, child listedChild) []interface{} {
+	names := listKeyNames(sortKey, kind)
+	keys := make([]interface{}, 0, len(names))
+	for _, name := range names {
+		switch name {
+		case dbExtension:
+			keys = append(keys, fileExtension(child.name))
+		case dbSize:
+			keys = append(keys, child.size)
+		case dbModified:
+			keys = append(keys, sortMillis(child.modifiedAt))
+		default:
+			keys = append(keys, naturalSortKey(child.name))
+		}
+	}
+	return keys
+}
+
+// listedByName tells whether the keys ordering the children of the kind derive from their names only
+func listedByName(sortKey fsmodel.SortKey, kind fsmodel.ItemKind) bool {
+	for _, name := range listKeyNames(sortKey, kind) {
+		if name != dbSortName && name != dbExtension {
+			return false
+		}
+	}
+	return true
+}
+
+// sortMillis is the time as milliseconds since the epoch, as unixMillis, the zero time being ordered as unknown
+func sortMillis(t time.Time) int64 {
+	if t.IsZero() {
+		return unknownListKeys[dbModified]
+	}
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// listsFolders tells whether the page may hold folders: they come before the files
+func listsFolders(options fsmodel.ListOptions) bool {
+	return options.Kind != fsmodel.FileKind && options.Extension == "" &&
+		(options.After == nil || options.After.Kind == fsmodel.FolderKind)
+}
+
+func listsFiles(options fsmodel.ListOptions) bool {
+	return options.Kind != fsmodel.FolderKind
+}
+
+// listedAfter is the position the children of the kind are listed after, nil when from the first one
+func listedAfter(options fsmodel.ListOptions, kind fsmodel.ItemKind) *fsmodel.ListPosition {
+	if options.After != nil && options.After.Kind == kind {
+		return options.After
+	}
+	return nil
+}
+
+// fetchLimit is the number of children of each kind to read for a page: one more than the page holds tells
+// whether another page follows. 0 for all of them.
+func fetchLimit(options fsmodel.ListOptions) int {
+	if options.Limit == 0 {
+		return 0
+	}
+	return options.Limit + 1
+}
+
+// pageOf assembles the page from the folders and the files listed after the position, in order, and at most
+// fetchLimit of each
+func pageOf(options fsmodel.ListOptions, folders []fsmodel.Folder, files []fsmodel.File) ([]fsmodel.Folder, []fsmodel.File, *fsmodel.ListPosition) {
+	if options.Limit == 0 {
+		return folders, files, nil
+	}
+
+	if len(folders) > options.Limit {
+		folders = folders[:options.Limit]
+		return folders, make([]fsmodel.File, 0), positionOf(options, fsmodel.FolderKind, folderChild(folders[len(folders)-1], 0))
+	}
+	remaining := options.Limit - len(folders)
+	if len(files) <= remaining {
+		return folders, files, nil
+	}
+
+	files = files[:remaining]
+	if len(files) == 0 {
+		return folders, files, positionOf(options, fsmodel.FolderKind, folderChild(folders[len(folders)-1], 0))
+	}
+	return folders, files, positionOf(options, fsmodel.FileKind, fileChild(files[len(files)-1], 0))
+}
+
+// positionOf is the position of the child of the kind, along with the keys of the listing which do not derive from its
+// name
+func positionOf(options fsmodel.ListOptions, kind fsmodel.ItemKind, child listedChild) *fsmodel.ListPosition {
+	position := fsmodel.ListPosition{Kind: kind, Name: child.name, Id: child.id}
+	for _, name := range listKeyNames(options.Sort, kind) {
+		switch name {
+		case dbSize:
+			position.Size = child.size
+		case dbModified:
+			position.ModifiedAt = child.modifiedAt
+		}
+	}
+	return &position
+}
+
+// listedChild is a child of a folder held in memory, index being its place in the slice it comes from. The size is
+// the one of a file only.
+type listedChild struct {
+	name       string
+	id         string
+	size       int64
+	modifiedAt time.Time
+	index      int
+}
+
+func folderChild(folder fsmodel.Folder, index int) listedChild {
+	return listedChild{name: folder.Name, id: folder.Id, modifiedAt: folder.ModifiedAt, index: index}
+}
+
+func fileChild(file fsmodel.File, index int) listedChild {
+	return listedChild{name: file.Name, id: file.Id, size: file.Size, modifiedAt: file.ModifiedAt, index: index}
+}
+
+// positionChild is the child at the position, as much of it as the listing is concerned
+func positionChild(position fsmodel.ListPosition) listedChild {
+	return listedChild{name: position.Name, id: position.Id, size: position.Size, modifiedAt: position.ModifiedAt}
+}
+
+// listInMemory lists the children as the databases do, for the repositories which hold them in memory. The
+// children come in any order.
+func listInMemory(options fsmodel.ListOptions, folders []fsmodel.Folder, files []fsmodel.File) ([]fsmodel.Folder, []fsmodel.File, *fsmodel.ListPosition) {
+	listedFolders := make([]fsmodel.Folder, 0)
+	if listsFolders(options) {
+		children := make([]listedChild, 0, len(folders))
+		for i, folder := range folders {
+			children = append(children, folderChild(folder, i))
+		}
+		for _, child := range selectChildren(options, fsmodel.FolderKind, children) {
+			listedFolders = append(listedFolders, folders[child.index])
+		}
+	}
+
+	listedFiles := make([]fsmodel.File, 0)
+	if listsFiles(options) {
+		children := make([]listedChild, 0, len(files))
+		for i, file := range files {
+			children = append(children, fileChild(file, i))
+		}
+		for _, child := range selectChildren(options, fsmodel.FileKind, children) {
+			listedFiles = append(listedFiles, files[child.index])
+		}
+	}
+
+	return pageOf(options, listedFolders, listedFiles)
+}
+
+// selectChildren filters the children of the kind, orders them and keeps the fetchLimit first ones after the position
+func selectChildren(options fsmodel.ListOptions, kind fsmodel.ItemKind, children []listedChild) []listedChild {
+	var nameMatcher *regexp.Regexp
+	if options.NameGlob != "" {
+		nameMatcher = regexp.MustCompile(globRegexp(options.NameGlob))
+	}
+	after := listedAfter(options, kind)
+
+	selected := make([]listedChild, 0)
+	for _, child := range children {
+		if nameMatcher != nil && !nameMatcher.MatchString(child.name) {
+			continue
+		}
+		if options.Extension != "" && fileExtension(child.name) != options.Extension {
+			continue
+		}
+		if after != nil && compareListed(options, kind, child, positionChild(*after)) <= 0 {
+			continue
+		}
+		selected = append(selected, child)
+	}
+
+	sort.Slice(selected, func(i, j int) bool {
+		return compareListed(options, kind, selected[i], selected[j]) < 0
+	})
+	if limit := fetchLimit(options); limit > 0 && len(selected) > limit {
+		selected = selected[:limit]
+	}
+	return selected
+}
+
+// compareListed tells whether a child comes before (< 0) or after (> 0) another one of the same kind in the listing
+func compareListed(options fsmodel.ListOptions, kind fsmodel.ItemKind, child listedChild, other listedChild) int {
+	comparison := 0
+	keys, otherKeys := listKeys(options.Sort, kind, child), listKeys(options.Sort, kind, other)
+	for i := range keys {
+		if comparison = compareListKeys(keys[i], otherKeys[i]); comparison != 0 {
+			break
+		}
+	}
+	if comparison == 0 {
+		comparison = strings.Compare(child.id, other.id)
+	}
+
+	if options.Descending {
+		return -comparison
+	}
+	return comparison
+}
+
+// compareListKeys compares two values of the same key, strings or numbers
+func compareListKeys(key interface{}, other interface{}) int {
+	number, isNumber := key.(int64)
+	if !isNumber {
+		return strings.Compare(key.(string), other.(string))
+	}
+	switch otherNumber := other.(int64); {
+	case number < otherNumber:
+		return -1
+	case number > otherNumber:
+		return 1
+	}
+	return 0
+}
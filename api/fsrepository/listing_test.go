@@ -0,0 +1,66 @@
+package fsrepository
+
+import (
+	"regexp"
+	"sort"
+	"testing"
+)
+
+func TestNaturalSortKey(t *testing.T) {
+	names := []string{"photo 10", "Photo 9", "photo 009b", "photo 0", "photo", "photo 100", "a2z", "a10"}
+	sort.Slice(names, func(i, j int) bool {
+		return naturalSortKey(names[i]) < naturalSortKey(names[j])
+	})
+
+	expected := []string{"a2z", "a10", "photo", "photo 0", "Photo 9", "photo 009b", "photo 10", "photo 100"}
+	for i := range names {
+		if names[i] != expected[i] {
+			t.Fatalf("expected %q, got %q", expected, names)
+		}
+	}
+}
+
+func TestFileExtension(t *testing.T) {
+	for name, expected := range map[string]string{
+		"photo.JPG":      "jpg",
+		"archive.tar.gz": "gz",
+		"README":         "",
+		".bashrc":        "",
+		"trailing.":      "",
+	} {
+		if extension := fileExtension(name); extension != expected {
+			t.Fatalf("expected extension %q for %q, got %q", expected, name, extension)
+		}
+	}
+}
+
+func TestGlobRegexp(t *testing.T) {
+	matcher := regexp.MustCompile(globRegexp("photo ?.*(1)"))
+	for name, expected := range map[string]bool{
+		"photo 1.jpg(1)":  true,
+		"photo 1.(1)":     true,
+		"photo 10.jpg(1)": false,
+		"photo 1.jpg1":    false,
+		"Photo 1.jpg(1)":  false,
+	} {
+		if matcher.MatchString(name) != expected {
+			t.Fatalf("%q matches: %v, expected %v", name, !expected, expected)
+		}
+	}
+}
+
+func TestSortKeyPrefixRange(t *testing.T) {
+	for _, prefix := range []string{"report", "report 2", "Été", "a\U0010FFFF", "퟿", ""} {
+		lower, upper := sortKeyPrefixRange(prefix)
+		for _, name := range []string{prefix, prefix + " (1).txt", prefix + "3", prefix + "\U0010FFFF"} {
+			key := naturalSortKey(name)
+			if key < lower || (upper != nil && key >= *upper) {
+				t.Fatalf("the sort key %q of %q is outside of the range of %q: [%q, %v)", key, name, prefix, lower, upper)
+			}
+		}
+	}
+
+	if _, upper := sortKeyPrefixRange("\U0010FFFF"); upper != nil {
+		t.Fatalf("expected no upper bound, got %q", *upper)
+	}
+}
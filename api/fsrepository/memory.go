@@ -0,0 +1,979 @@
+package fsrepository
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/loisfa/remote-file-system/api/fsmodel"
+	"github.com/pkg/errors"
+)
+
+const (
+	memoryRootFolderName  = "Root folder"
+	memoryTrashFolderName = "Trash"
+)
+
+// MemoryFileSystemRepository keeps the file system in memory, for local development, demos and unit tests: nothing
+// survives a restart. It is safe for concurrent use.
+type MemoryFileSystemRepository struct {
+	store *memoryStore
+
+	inTransaction bool // the store is already locked by ExecuteInTransaction
+}
+
+type memoryStore struct {
+	lock sync.RWMutex
+
+	folders        map[string]fsmodel.Folder
+	files          map[string]fsmodel.File
+	orphanBlobKeys map[string]bool
+	trashEntries   map[string]fsmodel.TrashEntry    // by item id
+	versions       map[string][]fsmodel.FileVersion // by file id, the newest first
+
+	// undo the writes of the transaction in progress when it fails, nil outside of a transaction
+	rollbackLog []func()
+}
+
+// NewMemoryFileSystemRepository returns an empty file system: a root folder and its empty trash only
+func NewMemoryFileSystemRepository() MemoryFileSystemRepository {
+	return MemoryFileSystemRepository{
+		store: &memoryStore{
+			folders: map[string]fsmodel.Folder{
+				rootFolderID:  {Id: rootFolderID, Name: memoryRootFolderName},
+				trashFolderID: {Id: trashFolderID, Name: memoryTrashFolderName},
+			},
+			files:          make(map[string]fsmodel.File),
+			orphanBlobKeys: make(map[string]bool),
+			trashEntries:   make(map[string]fsmodel.TrashEntry),
+			versions:       make(map[string][]fsmodel.FileVersion),
+		},
+	}
+}
+
+// ExecuteInTransaction holds the lock of the whole store during the work: transactions are serialized, and the
+// checks need no lock of their own
+func (repo MemoryFileSystemRepository) ExecuteInTransaction(ctx context.Context, work func(repo IFileSystemRepository) error) error {
+	if repo.inTransaction {
+		return work(repo)
+	}
+
+	store := repo.store
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	store.rollbackLog = make([]func(), 0)
+	defer func() { store.rollbackLog = nil }()
+
+	err := work(MemoryFileSystemRepository{store: store, inTransaction: true})
+	if err == nil {
+		// the caller gave up meanwhile
+		err = ctx.Err()
+	}
+	if err != nil {
+		for i := len(store.rollbackLog) - 1; i >= 0; i-- {
+			store.rollbackLog[i]()
+		}
+	}
+	return err
+}
+
+func (repo MemoryFileSystemRepository) UpdateFolder(ctx context.Context, folderID string, folderName string) error {
+	defer repo.writeLock()()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	folder, found := repo.store.folders[folderID]
+	if !found {
+		return folderNotFoundError(folderID)
+	}
+	if folder.ParentId != nil {
+		if err := repo.store.errorIfNameConflict(*folder.ParentId, folderName, folderID, noItemID); err != nil {
+			return err
+		}
+	}
+
+	modifiedAt := currentTime()
+	folder.Name = folderName
+	folder.ModifiedAt = modifiedAt
+	repo.store.putFolder(folder)
+	if folder.ParentId != nil {
+		repo.store.touchFolder(*folder.ParentId, modifiedAt)
+	}
+	return nil
+}
+
+func (repo MemoryFileSystemRepository) UpdateFile(ctx context.Context, fileID string, fileName string, contentType string) error {
+	defer repo.writeLock()()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	file, found := repo.store.files[fileID]
+	if !found {
+		return fileNotFoundError(fileID)
+	}
+	if err := repo.store.errorIfNameConflict(file.ParentId, fileName, noItemID, fileID); err != nil {
+		return err
+	}
+
+	modifiedAt := currentTime()
+	if fileName != file.Name {
+		repo.store.touchFolder(file.ParentId, modifiedAt)
+	}
+	file.Name = fileName
+	if contentType != "" {
+		file.ContentType = contentType
+	}
+	file.ModifiedAt = modifiedAt
+	repo.store.putFile(file)
+	return nil
+}
+
+func (repo MemoryFileSystemRepository) MoveFolder(ctx context.Context, folderID string, destFolderID string, folderName string) error {
+	defer repo.writeLock()()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	folder, found := repo.store.folders[folderID]
+	if !found {
+		return folderNotFoundError(folderID)
+	}
+	if _, found := repo.store.folders[destFolderID]; !found {
+		return folderNotFoundError(destFolderID)
+	}
+	if folderID == destFolderID || repo.store.isFolderInside(destFolderID, folderID) {
+		return errors.WithMessage(
+			errors.New(FolderMovedInsideItself),
+			fmt.Sprintf("Folder %s cannot be moved inside folder %s", folderID, destFolderID))
+	}
+	if err := repo.store.errorIfNameConflict(destFolderID, folderName, folderID, noItemID); err != nil {
+		return err
+	}
+
+	modifiedAt := currentTime()
+	if folder.ParentId != nil {
+		repo.store.touchFolder(*folder.ParentId, modifiedAt)
+	}
+	repo.store.touchFolder(destFolderID, modifiedAt)
+	folder.Name = folderName
+	folder.ParentId = &destFolderID
+	repo.store.putFolder(folder)
+	return nil
+}
+
+func (repo MemoryFileSystemRepository) MoveFile(ctx context.Context, fileID string, destFolderID string, fileName string) error {
+	defer repo.writeLock()()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	file, found := repo.store.files[fileID]
+	if !found {
+		return fileNotFoundError(fileID)
+	}
+	if _, found := repo.store.folders[destFolderID]; !found {
+		return folderNotFoundError(destFolderID)
+	}
+	if err := repo.store.errorIfNameConflict(destFolderID, fileName, noItemID, fileID); err != nil {
+		return err
+	}
+
+	modifiedAt := currentTime()
+	repo.store.touchFolder(file.ParentId, modifiedAt)
+	repo.store.touchFolder(destFolderID, modifiedAt)
+	file.Name = fileName
+	file.ParentId = destFolderID
+	repo.store.putFile(file)
+	return nil
+}
+
+// LockFolderSubtree only counts the subtree: a transaction holds the lock of the whole store
+func (repo MemoryFileSystemRepository) LockFolderSubtree(ctx context.Context, folderID string) (*int, error) {
+	defer repo.readLock()()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if _, found := repo.store.folders[folderID]; !found {
+		return nil, folderNotFoundError(folderID)
+	}
+
+	count := 0
+	var countSubtree func(folderID string)
+	countSubtree = func(folderID string) {
+		count += 1 + len(repo.store.filesIn(folderID))
+		for _, folder := range repo.store.foldersIn(folderID) {
+			countSubtree(folder.Id)
+		}
+	}
+	countSubtree(folderID)
+	return &count, nil
+}
+
+func (repo MemoryFileSystemRepository) DeleteFolderAndContent(ctx context.Context, folderID string) error {
+	defer repo.writeLock()()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	folder, found := repo.store.folders[folderID]
+	if !found {
+		return folderNotFoundError(folderID)
+	}
+
+	deletedBlobKeys := make([]string, 0)
+	var deleteSubtree func(folderID string)
+	deleteSubtree = func(folderID string) {
+		for _, file := range repo.store.filesIn(folderID) {
+			deletedBlobKeys = append(deletedBlobKeys, repo.store.deleteFile(file.Id)...)
+		}
+		for _, folder := range repo.store.foldersIn(folderID) {
+			deleteSubtree(folder.Id)
+		}
+		repo.store.deleteFolder(folderID)
+	}
+	deleteSubtree(folderID)
+	if folder.ParentId != nil {
+		repo.store.touchFolder(*folder.ParentId, currentTime())
+	}
+
+	repo.store.recordOrphanBlobKeys(deletedBlobKeys)
+	return nil
+}
+
+func (repo MemoryFileSystemRepository) DeleteFile(ctx context.Context, fileID string) error {
+	defer repo.writeLock()()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	file, found := repo.store.files[fileID]
+	if !found {
+		return fileNotFoundError(fileID)
+	}
+
+	deletedBlobKeys := repo.store.deleteFile(fileID)
+	repo.store.touchFolder(file.ParentId, currentTime())
+	repo.store.recordOrphanBlobKeys(deletedBlobKeys)
+	return nil
+}
+
+func (repo MemoryFileSystemRepository) GetFile(ctx context.Context, fileID string) (*fsmodel.File, error) {
+	defer repo.readLock()()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	file, found := repo.store.files[fileID]
+	if !found {
+		return nil, fileNotFoundError(fileID)
+	}
+	return &file, nil
+}
+
+func (repo MemoryFileSystemRepository) ExistsFile(ctx context.Context, fileID string) (*bool, error) {
+	defer repo.readLock()()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	_, found := repo.store.files[fileID]
+	return &found, nil
+}
+
+func (repo MemoryFileSystemRepository) ExistsUntrashedFile(ctx context.Context, fileID string) (*bool, error) {
+	defer repo.readLock()()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	file, found := repo.store.files[fileID]
+	exists := found && !repo.store.isTrashed(file.ParentId)
+	return &exists, nil
+}
+
+func (repo MemoryFileSystemRepository) GetFilesIn(ctx context.Context, folderID string) (*[]fsmodel.File, error) {
+	defer repo.readLock()()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	files := repo.store.filesIn(folderID)
+	return &files, nil
+}
+
+func (repo MemoryFileSystemRepository) GetFolder(ctx context.Context, folderID string) (*fsmodel.Folder, error) {
+	defer repo.readLock()()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	folder, found := repo.store.folders[folderID]
+	if !found {
+		return nil, folderNotFoundError(folderID)
+	}
+	return copyFolder(folder), nil
+}
+
+func (repo MemoryFileSystemRepository) GetRootFolderID(ctx context.Context) (*string, error) {
+	defer repo.readLock()()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if _, found := repo.store.folders[rootFolderID]; !found {
+		return nil, folderNotFoundError(rootFolderID)
+	}
+	rootID := rootFolderID
+	return &rootID, nil
+}
+
+func (repo MemoryFileSystemRepository) IsRootFolder(ctx context.Context, folderID string) (*bool, error) {
+	defer repo.readLock()()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if _, found := repo.store.folders[folderID]; !found {
+		return nil, folderNotFoundError(folderID)
+	}
+	isRoot := folderID == rootFolderID
+	return &isRoot, nil
+}
+
+func (repo MemoryFileSystemRepository) IsFolderInside(ctx context.Context, folderID string, ancestorFolderID string) (*bool, error) {
+	defer repo.readLock()()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if _, found := repo.store.folders[folderID]; !found {
+		return nil, folderNotFoundError(folderID)
+	}
+	if _, found := repo.store.folders[ancestorFolderID]; !found {
+		return nil, folderNotFoundError(ancestorFolderID)
+	}
+	isInside := repo.store.isFolderInside(folderID, ancestorFolderID)
+	return &isInside, nil
+}
+
+func (repo MemoryFileSystemRepository) ExistsFolder(ctx context.Context, folderID string) (*bool, error) {
+	defer repo.readLock()()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	_, found := repo.store.folders[folderID]
+	return &found, nil
+}
+
+func (repo MemoryFileSystemRepository) ExistsUntrashedFolder(ctx context.Context, folderID string) (*bool, error) {
+	defer repo.readLock()()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	_, found := repo.store.folders[folderID]
+	exists := found && !repo.store.isTrashed(folderID)
+	return &exists, nil
+}
+
+func (repo MemoryFileSystemRepository) GetFoldersIn(ctx context.Context, folderID string) (*[]fsmodel.Folder, error) {
+	defer repo.readLock()()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	folders := repo.store.foldersIn(folderID)
+	return &folders, nil
+}
+
+// GetChildrenWithNamePrefix goes through every item, as the other reads of the memory repository do
+func (repo MemoryFileSystemRepository) GetChildrenWithNamePrefix(ctx context.Context, folderID string, namePrefix string) (*[]fsmodel.NamedChild, error) {
+	defer repo.readLock()()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	children := make([]fsmodel.NamedChild, 0)
+	for _, folder := range repo.store.folders {
+		if folder.ParentId != nil && *folder.ParentId == folderID && strings.HasPrefix(folder.Name, namePrefix) {
+			children = append(children, fsmodel.NamedChild{Kind: fsmodel.FolderKind, Id: folder.Id, Name: folder.Name})
+		}
+	}
+	for _, file := range repo.store.files {
+		if file.ParentId == folderID && strings.HasPrefix(file.Name, namePrefix) {
+			children = append(children, fsmodel.NamedChild{Kind: fsmodel.FileKind, Id: file.Id, Name: file.Name})
+		}
+	}
+	return &children, nil
+}
+
+func (repo MemoryFileSystemRepository) GetFolderIDByLegacyID(ctx context.Context, legacyID int) (*string, error) {
+	defer repo.readLock()()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	folderID, found := repo.store.folderIDByLegacyID(legacyID)
+	if !found {
+		return nil, legacyFolderNotFoundError(legacyID)
+	}
+	return &folderID, nil
+}
+
+func (repo MemoryFileSystemRepository) GetFileIDByLegacyID(ctx context.Context, legacyID int) (*string, error) {
+	defer repo.readLock()()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	fileID, found := repo.store.fileIDByLegacyID(legacyID)
+	if !found {
+		return nil, legacyFileNotFoundError(legacyID)
+	}
+	return &fileID, nil
+}
+
+func (repo MemoryFileSystemRepository) GetFolderContent(ctx context.Context, folderID string, options fsmodel.ListOptions) (*fsmodel.FolderContent, error) {
+	defer repo.readLock()()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	folder, found := repo.store.folders[folderID]
+	if !found {
+		return nil, folderNotFoundError(folderID)
+	}
+	folders, files, next := listInMemory(options, repo.store.foldersIn(folderID), repo.store.filesIn(folderID))
+	return &fsmodel.FolderContent{
+		Folder:      *copyFolder(folder),
+		Folders:     folders,
+		Files:       files,
+		Next:        next,
+		ChildCounts: repo.store.childCounts(folderID, folders),
+		Trashed:     repo.store.isTrashed(folderID),
+	}, nil
+}
+
+func (repo MemoryFileSystemRepository) CreateFile(ctx context.Context, fileName string, content fsmodel.FileContent, folderParentID string) (*string, error) {
+	defer repo.writeLock()()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if _, found := repo.store.folders[folderParentID]; !found {
+		return nil, folderNotFoundError(folderParentID)
+	}
+	if err := repo.store.errorIfNameConflict(folderParentID, fileName, noItemID, noItemID); err != nil {
+		return nil, err
+	}
+
+	createdAt := currentTime()
+	file := fsmodel.File{
+		Id:          newID(),
+		Name:        fileName,
+		ParentId:    folderParentID,
+		FileContent: content,
+		CreatedAt:   createdAt,
+		ModifiedAt:  createdAt,
+	}
+	repo.store.putFile(file)
+	repo.store.touchFolder(folderParentID, createdAt)
+	repo.store.removeOrphanBlobKey(content.BlobKey)
+	return &file.Id, nil
+}
+
+func (repo MemoryFileSystemRepository) CreateFolder(ctx context.Context, folderName string, folderParentID string) (*string, error) {
+	defer repo.writeLock()()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if _, found := repo.store.folders[folderParentID]; !found {
+		return nil, folderNotFoundError(folderParentID)
+	}
+	if err := repo.store.errorIfNameConflict(folderParentID, folderName, noItemID, noItemID); err != nil {
+		return nil, err
+	}
+
+	parentID := folderParentID
+	createdAt := currentTime()
+	folder := fsmodel.Folder{
+		Id:         newID(),
+		Name:       folderName,
+		ParentId:   &parentID,
+		CreatedAt:  createdAt,
+		ModifiedAt: createdAt,
+	}
+	repo.store.putFolder(folder)
+	repo.store.touchFolder(folderParentID, createdAt)
+	return &folder.Id, nil
+}
+
+func (repo MemoryFileSystemRepository) ImportFolder(ctx context.Context, folder fsmodel.Folder) error {
+	defer repo.writeLock()()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if folder.ParentId == nil {
+		return rootFolderImportedError(folder.Id)
+	}
+	_, idTaken := repo.store.folders[folder.Id]
+	_, legacyIDTaken := repo.store.folderIDByLegacyID(folder.LegacyId)
+	if idTaken || legacyIDTaken {
+		return idAlreadyExistsError(dbFolder, folder.Id, folder.LegacyId)
+	}
+	if _, found := repo.store.folders[*folder.ParentId]; !found {
+		return folderNotFoundError(*folder.ParentId)
+	}
+	if err := repo.store.errorIfNameConflict(*folder.ParentId, folder.Name, noItemID, noItemID); err != nil {
+		return err
+	}
+
+	repo.store.putFolder(*copyFolder(folder))
+	return nil
+}
+
+func (repo MemoryFileSystemRepository) ImportFile(ctx context.Context, file fsmodel.File) error {
+	defer repo.writeLock()()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	_, idTaken := repo.store.files[file.Id]
+	_, legacyIDTaken := repo.store.fileIDByLegacyID(file.LegacyId)
+	if idTaken || legacyIDTaken {
+		return idAlreadyExistsError(dbFile, file.Id, file.LegacyId)
+	}
+	if _, found := repo.store.folders[file.ParentId]; !found {
+		return folderNotFoundError(file.ParentId)
+	}
+	if err := repo.store.errorIfNameConflict(file.ParentId, file.Name, noItemID, noItemID); err != nil {
+		return err
+	}
+
+	repo.store.putFile(file)
+	repo.store.removeOrphanBlobKey(file.BlobKey)
+	return nil
+}
+
+func (repo MemoryFileSystemRepository) GetOrphanBlobKeys(ctx context.Context) (*[]string, error) {
+	defer repo.readLock()()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	blobKeys := make([]string, 0, len(repo.store.orphanBlobKeys))
+	for blobKey := range repo.store.orphanBlobKeys {
+		blobKeys = append(blobKeys, blobKey)
+	}
+	sort.Strings(blobKeys)
+	return &blobKeys, nil
+}
+
+func (repo MemoryFileSystemRepository) IsBlobReferenced(ctx context.Context, blobKey string) (*bool, error) {
+	defer repo.readLock()()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	referenced := repo.store.isBlobReferenced(blobKey)
+	return &referenced, nil
+}
+
+func (repo MemoryFileSystemRepository) RemoveOrphanBlobKey(ctx context.Context, blobKey string) error {
+	defer repo.writeLock()()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	repo.store.removeOrphanBlobKey(blobKey)
+	return nil
+}
+
+func (repo MemoryFileSystemRepository) GetTrashFolderID(ctx context.Context) (*string, error) {
+	defer repo.readLock()()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if _, found := repo.store.folders[trashFolderID]; !found {
+		return nil, folderNotFoundError(trashFolderID)
+	}
+	trashID := trashFolderID
+	return &trashID, nil
+}
+
+func (repo MemoryFileSystemRepository) CreateTrashEntry(ctx context.Context, entry fsmodel.TrashEntry) error {
+	entry.TrashedAt = currentTime()
+	return repo.ImportTrashEntry(ctx, entry)
+}
+
+func (repo MemoryFileSystemRepository) GetTrashEntries(ctx context.Context) (*[]fsmodel.TrashEntry, error) {
+	defer repo.readLock()()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	entries := make([]fsmodel.TrashEntry, 0, len(repo.store.trashEntries))
+	for _, entry := range repo.store.trashEntries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].TrashedAt.Equal(entries[j].TrashedAt) {
+			return entries[i].TrashedAt.After(entries[j].TrashedAt)
+		}
+		return entries[i].ItemId < entries[j].ItemId
+	})
+	return &entries, nil
+}
+
+func (repo MemoryFileSystemRepository) DeleteTrashEntry(ctx context.Context, itemID string) (*fsmodel.TrashEntry, error) {
+	defer repo.writeLock()()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	entry, found := repo.store.trashEntries[itemID]
+	if !found {
+		return nil, trashEntryNotFoundError(itemID)
+	}
+	delete(repo.store.trashEntries, itemID)
+	repo.store.onRollback(func() { repo.store.trashEntries[itemID] = entry })
+	return &entry, nil
+}
+
+func (repo MemoryFileSystemRepository) ImportTrashEntry(ctx context.Context, entry fsmodel.TrashEntry) error {
+	defer repo.writeLock()()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if _, found := repo.store.trashEntries[entry.ItemId]; found {
+		return trashEntryAlreadyExistsError(entry.ItemId)
+	}
+	repo.store.trashEntries[entry.ItemId] = entry
+	repo.store.onRollback(func() { delete(repo.store.trashEntries, entry.ItemId) })
+	return nil
+}
+
+func (repo MemoryFileSystemRepository) UpdateFileContent(ctx context.Context, fileID string, content fsmodel.FileContent) error {
+	defer repo.writeLock()()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	file, found := repo.store.files[fileID]
+	if !found {
+		return fileNotFoundError(fileID)
+	}
+
+	replacedBlobKey := file.BlobKey
+	file.FileContent = content
+	file.ModifiedAt = currentTime()
+	repo.store.putFile(file)
+	repo.store.removeOrphanBlobKey(content.BlobKey)
+	repo.store.recordOrphanBlobKeys([]string{replacedBlobKey})
+	return nil
+}
+
+func (repo MemoryFileSystemRepository) GetFileVersions(ctx context.Context, fileID string) (*[]fsmodel.FileVersion, error) {
+	defer repo.readLock()()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if _, found := repo.store.files[fileID]; !found {
+		return nil, fileNotFoundError(fileID)
+	}
+	versions := append(make([]fsmodel.FileVersion, 0), repo.store.versions[fileID]...)
+	return &versions, nil
+}
+
+func (repo MemoryFileSystemRepository) CreateFileVersion(ctx context.Context, fileID string, version fsmodel.FileVersion) error {
+	defer repo.writeLock()()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if _, found := repo.store.files[fileID]; !found {
+		return fileNotFoundError(fileID)
+	}
+	versions := make([]fsmodel.FileVersion, 0, len(repo.store.versions[fileID])+1)
+	for _, existing := range repo.store.versions[fileID] {
+		if existing.Number == version.Number {
+			return fileVersionAlreadyExistsError(fileID, version.Number)
+		}
+		versions = append(versions, existing)
+	}
+	versions = append(versions, version)
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Number > versions[j].Number })
+
+	repo.store.putVersions(fileID, versions)
+	repo.store.removeOrphanBlobKey(version.BlobKey)
+	return nil
+}
+
+func (repo MemoryFileSystemRepository) DeleteFileVersion(ctx context.Context, fileID string, number int) error {
+	defer repo.writeLock()()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	versions := make([]fsmodel.FileVersion, 0)
+	var deleted *fsmodel.FileVersion
+	for _, version := range repo.store.versions[fileID] {
+		if version.Number == number {
+			version := version
+			deleted = &version
+			continue
+		}
+		versions = append(versions, version)
+	}
+	if deleted == nil {
+		return fileVersionNotFoundError(fileID, number)
+	}
+
+	repo.store.putVersions(fileID, versions)
+	repo.store.recordOrphanBlobKeys([]string{deleted.BlobKey})
+	return nil
+}
+
+// readLock locks the store for a read, unless in a transaction, and returns the function which unlocks it
+func (repo MemoryFileSystemRepository) readLock() func() {
+	if repo.inTransaction {
+		return func() {}
+	}
+	repo.store.lock.RLock()
+	return repo.store.lock.RUnlock
+}
+
+// writeLock locks the store for a write, unless in a transaction, and returns the function which unlocks it
+func (repo MemoryFileSystemRepository) writeLock() func() {
+	if repo.inTransaction {
+		return func() {}
+	}
+	repo.store.lock.Lock()
+	return repo.store.lock.Unlock
+}
+
+// The functions of the store expect the caller to hold its lock
+
+// isTrashed tells whether the folder is the trash folder or one of its descendants
+func (store *memoryStore) isTrashed(folderID string) bool {
+	return folderID == trashFolderID || store.isFolderInside(folderID, trashFolderID)
+}
+
+func (store *memoryStore) foldersIn(folderID string) []fsmodel.Folder {
+	var folders []fsmodel.Folder
+	for _, folder := range store.folders {
+		if folder.ParentId != nil && *folder.ParentId == folderID {
+			folders = append(folders, *copyFolder(folder))
+		}
+	}
+	sort.Slice(folders, func(i, j int) bool { return folders[i].Id < folders[j].Id })
+	return folders
+}
+
+func (store *memoryStore) filesIn(folderID string) []fsmodel.File {
+	var files []fsmodel.File
+	for _, file := range store.files {
+		if file.ParentId == folderID {
+			files = append(files, file)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Id < files[j].Id })
+	return files
+}
+
+// childCounts counts the children of the folder and of the listed folders in a single pass over the items
+func (store *memoryStore) childCounts(folderID string, folders []fsmodel.Folder) map[string]fsmodel.ChildCounts {
+	counts := map[string]fsmodel.ChildCounts{folderID: {}}
+	for _, folder := range folders {
+		counts[folder.Id] = fsmodel.ChildCounts{}
+	}
+	for _, folder := range store.folders {
+		if folder.ParentId == nil {
+			continue
+		}
+		if count, counted := counts[*folder.ParentId]; counted {
+			count.Folders++
+			counts[*folder.ParentId] = count
+		}
+	}
+	for _, file := range store.files {
+		if count, counted := counts[file.ParentId]; counted {
+			count.Files++
+			counts[file.ParentId] = count
+		}
+	}
+	return counts
+}
+
+func (store *memoryStore) isFolderInside(folderID string, ancestorFolderID string) bool {
+	parentID := store.folders[folderID].ParentId
+	for parentID != nil {
+		if *parentID == ancestorFolderID {
+			return true
+		}
+		parentID = store.folders[*parentID].ParentId
+	}
+	return false
+}
+
+// errorIfNameConflict: the given folder and file (noItemID for none) are the ones being named, they do not conflict
+// with themselves
+func (store *memoryStore) errorIfNameConflict(folderID string, name string, excludedFolderID string, excludedFileID string) error {
+	for _, folder := range store.foldersIn(folderID) {
+		if folder.Name == name && folder.Id != excludedFolderID {
+			return nameAlreadyExistsError(folderID, name)
+		}
+	}
+	for _, file := range store.filesIn(folderID) {
+		if file.Name == name && file.Id != excludedFileID {
+			return nameAlreadyExistsError(folderID, name)
+		}
+	}
+	return nil
+}
+
+// folderIDByLegacyID and fileIDByLegacyID: 0 is no legacy id, it finds no item
+func (store *memoryStore) folderIDByLegacyID(legacyID int) (string, bool) {
+	for _, folder := range store.folders {
+		if legacyID != 0 && folder.LegacyId == legacyID {
+			return folder.Id, true
+		}
+	}
+	return "", false
+}
+
+func (store *memoryStore) fileIDByLegacyID(legacyID int) (string, bool) {
+	for _, file := range store.files {
+		if legacyID != 0 && file.LegacyId == legacyID {
+			return file.Id, true
+		}
+	}
+	return "", false
+}
+
+func (store *memoryStore) isBlobReferenced(blobKey string) bool {
+	for _, file := range store.files {
+		if file.BlobKey == blobKey {
+			return true
+		}
+	}
+	for _, versions := range store.versions {
+		for _, version := range versions {
+			if version.BlobKey == blobKey {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (store *memoryStore) recordOrphanBlobKeys(blobKeys []string) {
+	for _, blobKey := range blobKeys {
+		if blobKey != "" && !store.orphanBlobKeys[blobKey] && !store.isBlobReferenced(blobKey) {
+			store.orphanBlobKeys[blobKey] = true
+			store.onRollback(func() { delete(store.orphanBlobKeys, blobKey) })
+		}
+	}
+}
+
+func (store *memoryStore) removeOrphanBlobKey(blobKey string) {
+	if store.orphanBlobKeys[blobKey] {
+		delete(store.orphanBlobKeys, blobKey)
+		store.onRollback(func() { store.orphanBlobKeys[blobKey] = true })
+	}
+}
+
+func (store *memoryStore) putFolder(folder fsmodel.Folder) {
+	previous, existed := store.folders[folder.Id]
+	store.folders[folder.Id] = folder
+	store.onRollback(func() {
+		if existed {
+			store.folders[folder.Id] = previous
+		} else {
+			delete(store.folders, folder.Id)
+		}
+	})
+}
+
+// touchFolder records that a direct child of the folder was added, removed or renamed
+func (store *memoryStore) touchFolder(folderID string, modifiedAt time.Time) {
+	folder := store.folders[folderID]
+	folder.ModifiedAt = modifiedAt
+	store.putFolder(folder)
+}
+
+func (store *memoryStore) deleteFolder(folderID string) {
+	previous := store.folders[folderID]
+	delete(store.folders, folderID)
+	store.onRollback(func() { store.folders[folderID] = previous })
+}
+
+func (store *memoryStore) putFile(file fsmodel.File) {
+	previous, existed := store.files[file.Id]
+	store.files[file.Id] = file
+	store.onRollback(func() {
+		if existed {
+			store.files[file.Id] = previous
+		} else {
+			delete(store.files, file.Id)
+		}
+	})
+}
+
+// deleteFile deletes the file along with its versions, and returns the content keys they referenced
+func (store *memoryStore) deleteFile(fileID string) []string {
+	previous := store.files[fileID]
+	blobKeys := []string{previous.BlobKey}
+	for _, version := range store.versions[fileID] {
+		blobKeys = append(blobKeys, version.BlobKey)
+	}
+	store.putVersions(fileID, nil)
+	delete(store.files, fileID)
+	store.onRollback(func() { store.files[fileID] = previous })
+	return blobKeys
+}
+
+// putVersions replaces the versions of the file, the newest first
+func (store *memoryStore) putVersions(fileID string, versions []fsmodel.FileVersion) {
+	previous, existed := store.versions[fileID]
+	if len(versions) == 0 {
+		delete(store.versions, fileID)
+	} else {
+		store.versions[fileID] = versions
+	}
+	store.onRollback(func() {
+		if existed {
+			store.versions[fileID] = previous
+		} else {
+			delete(store.versions, fileID)
+		}
+	})
+}
+
+func (store *memoryStore) onRollback(undo func()) {
+	if store.rollbackLog != nil {
+		store.rollbackLog = append(store.rollbackLog, undo)
+	}
+}
+
+// copyFolder does not share the parent id of the stored folder with the caller
+func copyFolder(folder fsmodel.Folder) *fsmodel.Folder {
+	if folder.ParentId != nil {
+		parentID := *folder.ParentId
+		folder.ParentId = &parentID
+	}
+	return &folder
+}
@@ -0,0 +1,85 @@
+package fsrepository
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/loisfa/remote-file-system/api/fsmodel"
+	"github.com/pkg/errors"
+)
+
+func TestMemoryTransactionRollsBackOnError(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryFileSystemRepository()
+	folderID, err := repo.CreateFolder(ctx, "kept", rootFolderID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileID, err := repo.CreateFile(ctx, "file.txt", fsmodel.FileContent{BlobKey: "sha256-abc", Digest: "abc"}, *folderID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failure := errors.New("failure")
+	err = repo.ExecuteInTransaction(ctx, func(txRepo IFileSystemRepository) error {
+		if _, err := txRepo.CreateFolder(ctx, "created", rootFolderID); err != nil {
+			return err
+		}
+		if err := txRepo.UpdateFolder(ctx, *folderID, "renamed"); err != nil {
+			return err
+		}
+		if err := txRepo.DeleteFolderAndContent(ctx, *folderID); err != nil {
+			return err
+		}
+		return failure
+	})
+	if err != failure {
+		t.Fatalf("expected the error of the work, got %v", err)
+	}
+
+	folders, err := repo.GetFoldersIn(ctx, rootFolderID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*folders) != 1 || (*folders)[0].Name != "kept" {
+		t.Fatalf("the transaction was not rolled back: %+v", *folders)
+	}
+	if exists, _ := repo.ExistsFile(ctx, *fileID); !*exists {
+		t.Fatalf("the deleted file was not restored")
+	}
+	if orphans, _ := repo.GetOrphanBlobKeys(ctx); len(*orphans) != 0 {
+		t.Fatalf("the orphan blob keys were not rolled back: %v", *orphans)
+	}
+}
+
+func TestMemoryConcurrentCreationsKeepNamesUnique(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryFileSystemRepository()
+
+	var wg sync.WaitGroup
+	created := make(chan int, 100)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// 10 distinct names, each one requested 10 times
+			if _, err := repo.CreateFolder(ctx, fmt.Sprintf("folder %d", i%10), rootFolderID); err == nil {
+				created <- i
+			} else if errors.Cause(err).Error() != NameAlreadyExists {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(created)
+
+	if len(created) != 10 {
+		t.Fatalf("expected 10 folders created, got %d", len(created))
+	}
+	folders, _ := repo.GetFoldersIn(ctx, rootFolderID)
+	if len(*folders) != 10 {
+		t.Fatalf("expected 10 folders inside the root, got %d", len(*folders))
+	}
+}
@@ -0,0 +1,33 @@
+package fsrepository
+
+import (
+	"time"
+
+	"github.com/loisfa/remote-file-system/api/fsmodel"
+)
+
+// currentTime is the time recorded as the creation or the modification of an item, to the millisecond as the
+// databases keep it
+func currentTime() time.Time {
+	return time.Now().UTC().Truncate(time.Millisecond)
+}
+
+// unixMillis stores a time as milliseconds since the epoch, and the zero time, unknown, as nil
+func unixMillis(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func fromUnixMillis(millis int64) time.Time {
+	return time.Unix(0, millis*int64(time.Millisecond)).UTC()
+}
+
+// knownSize stores the unknown size as nil
+func knownSize(size int64) interface{} {
+	if size == fsmodel.UnknownSize {
+		return nil
+	}
+	return size
+}
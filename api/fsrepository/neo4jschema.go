@@ -0,0 +1,219 @@
+package fsrepository
+
+import (
+	"fmt"
+
+	"github.com/neo4j/neo4j-go-driver/v4/neo4j"
+	"github.com/pkg/errors"
+)
+
+// the data is migrated by batches of neo4jMigrationBatchSize items, each in its own transaction
+const neo4jMigrationBatchSize = 1000
+
+// neo4jMigration changes the graph with its statements, then migrates the data which Cypher alone cannot with
+// migrateData, nil when there is none
+type neo4jMigration struct {
+	statements  []string
+	migrateData func(session neo4j.Session) error
+}
+
+// neo4jMigrations are the successive versions of the graph schema: never change a released migration, append a new
+// one. The version of a database is the number of migrations applied, recorded on the SchemaVersion node.
+//
+// Neo4j cannot change the schema and the data in the same transaction: each statement runs in its own one. The
+// statements, and the data migrations, are idempotent instead, so that a migration which failed halfway, or which
+// several instances run at the same time, can be run again.
+var neo4jMigrations = []neo4jMigration{
+	// 1: what init_db_script.cypher used to set up by hand
+	{statements: []string{
+		`CREATE CONSTRAINT unique_file_id IF NOT EXISTS ON (file:File) ASSERT file.id IS UNIQUE`,
+		`CREATE CONSTRAINT unique_folder_id IF NOT EXISTS ON (folder:Folder) ASSERT folder.id IS UNIQUE`,
+		`CREATE CONSTRAINT constraint_unique_is_root IF NOT EXISTS ON (folder:Folder) ASSERT folder.is_root IS UNIQUE`,
+		`CREATE CONSTRAINT unique_orphan_blob_key IF NOT EXISTS ON (blob:OrphanBlob) ASSERT blob.blob_key IS UNIQUE`,
+		`CREATE CONSTRAINT unique_sequence_key IF NOT EXISTS ON (seq:Sequence) ASSERT seq.key IS UNIQUE`,
+		// a database set up by hand may miss the sequences but not the items: the sequences start after them
+		`OPTIONAL MATCH (file:File)
+		WITH coalesce(max(file.id), 0) AS lastFileID
+		MERGE (seq:Sequence {key: 'file_id_sequence'})
+			ON CREATE SET seq.value = lastFileID`,
+		`OPTIONAL MATCH (folder:Folder)
+		WITH coalesce(max(folder.id), 0) AS lastFolderID
+		MERGE (seq:Sequence {key: 'folder_id_sequence'})
+			ON CREATE SET seq.value = lastFolderID`,
+		`MERGE (root:Folder {is_root: true})
+			ON CREATE SET root.id = 0, root.name = 'Root folder'`,
+	}},
+	// 2: the keys ordering the children of a folder
+	{migrateData: setSortKeys},
+	// 3: the opaque ids, the integer ids kept as legacy ids. The ids are not given by the sequences anymore.
+	{
+		statements: []string{
+			`CREATE CONSTRAINT unique_folder_legacy_id IF NOT EXISTS ON (folder:Folder) ASSERT folder.legacy_id IS UNIQUE`,
+			`CREATE CONSTRAINT unique_file_legacy_id IF NOT EXISTS ON (file:File) ASSERT file.legacy_id IS UNIQUE`,
+			`MATCH (seq:Sequence) DELETE seq`,
+			`DROP CONSTRAINT unique_sequence_key IF EXISTS`,
+		},
+		migrateData: setOpaqueIDs,
+	},
+	// 4: the trash folder, without parent as the root folder, and the trash entries
+	{statements: []string{
+		`CREATE CONSTRAINT unique_trash_entry_item_id IF NOT EXISTS ON (entry:TrashEntry) ASSERT entry.item_id IS UNIQUE`,
+		fmt.Sprintf(`MERGE (trash:Folder {id: '%s'})
+			ON CREATE SET trash.name = '%s', trash.sort_name = '%s'`, trashFolderID, neo4jTrashFolderName, naturalSortKey(neo4jTrashFolderName)),
+	}},
+	// 5: the file versions, (:FileVersion)-[:VERSION_OF]->(:File)
+	{statements: []string{
+		`CREATE INDEX file_version_blob_key IF NOT EXISTS FOR (version:FileVersion) ON (version.blob_key)`,
+	}},
+}
+
+// setSortKeys derives the sort keys of the items which have none from their name, a batch at a time
+func setSortKeys(session neo4j.Session) error {
+	for {
+		items, err := session.ReadTransaction(func(tx neo4j.Transaction) (interface{}, error) {
+			result, err := tx.Run(`MATCH (item)
+				WHERE (item:Folder OR item:File) AND item.sort_name IS NULL
+				RETURN id(item) AS nodeID, item.name AS name, item:File AS isFile
+				LIMIT $batchSize`, map[string]interface{}{"batchSize": neo4jMigrationBatchSize})
+			if err != nil {
+				return nil, err
+			}
+
+			items := make([]interface{}, 0)
+			for result.Next() {
+				record := result.Record()
+				name, _ := record.Values[1].(string)
+				var extension interface{} // folders have none
+				if record.Values[2].(bool) {
+					extension = fileExtension(name)
+				}
+				items = append(items, map[string]interface{}{
+					"nodeID":    record.Values[0],
+					"sortName":  naturalSortKey(name),
+					"extension": extension,
+				})
+			}
+			return items, result.Err()
+		})
+		if err != nil {
+			return err
+		}
+		if len(items.([]interface{})) == 0 {
+			return nil
+		}
+
+		err = runNeo4JStatement(session, `UNWIND $items AS item
+			MATCH (node) WHERE id(node) = item.nodeID
+			SET node.sort_name = item.sortName, node.extension = item.extension`,
+			map[string]interface{}{"items": items})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// setOpaqueIDs gives the items which still have an integer id a new id, a batch at a time, and the root folder the id
+// of every root folder
+func setOpaqueIDs(session neo4j.Session) error {
+	for {
+		items, err := session.ReadTransaction(func(tx neo4j.Transaction) (interface{}, error) {
+			result, err := tx.Run(`MATCH (item)
+				WHERE (item:Folder OR item:File) AND item.id = toInteger(item.id)
+				RETURN id(item) AS nodeID, item.id AS legacyID, coalesce(item.is_root, false) AS isRoot
+				LIMIT $batchSize`, map[string]interface{}{"batchSize": neo4jMigrationBatchSize})
+			if err != nil {
+				return nil, err
+			}
+
+			items := make([]interface{}, 0)
+			for result.Next() {
+				record := result.Record()
+				var itemID, legacyID interface{} = newID(), record.Values[1]
+				if record.Values[2].(bool) {
+					itemID, legacyID = rootFolderID, nil
+				}
+				items = append(items, map[string]interface{}{
+					"nodeID":   record.Values[0],
+					"id":       itemID,
+					"legacyID": legacyID,
+				})
+			}
+			return items, result.Err()
+		})
+		if err != nil {
+			return err
+		}
+		if len(items.([]interface{})) == 0 {
+			return nil
+		}
+
+		err = runNeo4JStatement(session, `UNWIND $items AS item
+			MATCH (node) WHERE id(node) = item.nodeID
+			SET node.id = item.id, node.legacy_id = item.legacyID`,
+			map[string]interface{}{"items": items})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// migrateNeo4J brings the schema of the database to the last version
+func migrateNeo4J(driver neo4j.Driver) error {
+	session := driver.NewSession(neo4j.SessionConfig{})
+	defer session.Close()
+
+	version, err := session.ReadTransaction(func(tx neo4j.Transaction) (interface{}, error) {
+		result, err := tx.Run(`OPTIONAL MATCH (schema:SchemaVersion)
+			RETURN coalesce(max(schema.version), 0) AS version`, map[string]interface{}{})
+		if err != nil {
+			return nil, err
+		}
+		record, err := result.Single()
+		if err != nil {
+			return nil, err
+		}
+		version, found := record.Get("version")
+		if !found {
+			return nil, errors.New("Could not find 'version' in schema version response")
+		}
+		return int(version.(int64)), nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for v := version.(int); v < len(neo4jMigrations); v++ {
+		migration := neo4jMigrations[v]
+		for _, statement := range migration.statements {
+			if err := runNeo4JStatement(session, statement, map[string]interface{}{}); err != nil {
+				return errors.WithMessage(err, fmt.Sprintf("Could not migrate the graph schema to version %d", v+1))
+			}
+		}
+		if migration.migrateData != nil {
+			if err := migration.migrateData(session); err != nil {
+				return errors.WithMessage(err, fmt.Sprintf("Could not migrate the data to version %d", v+1))
+			}
+		}
+
+		// a version is never lowered by an instance which migrated more slowly
+		err := runNeo4JStatement(session, `MERGE (schema:SchemaVersion {key: 'schema'})
+			SET schema.version = CASE WHEN schema.version > $version THEN schema.version ELSE $version END`,
+			map[string]interface{}{"version": v + 1})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Migrated the graph schema to version %d\n", v+1)
+	}
+	return nil
+}
+
+func runNeo4JStatement(session neo4j.Session, statement string, statementMap map[string]interface{}) error {
+	_, err := session.WriteTransaction(func(tx neo4j.Transaction) (interface{}, error) {
+		result, err := tx.Run(statement, statementMap)
+		if err != nil {
+			return nil, err
+		}
+		return result.Consume()
+	})
+	return err
+}
@@ -7,18 +7,22 @@ package fsrepository
 // defer driver.Close()
 
 import (
-	"errors"
+	"context"
 	"fmt"
-	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/loisfa/remote-file-system/api/fsconfig"
 	"github.com/loisfa/remote-file-system/api/fsmodel"
 	"github.com/neo4j/neo4j-go-driver/v4/neo4j"
 	"github.com/neo4j/neo4j-go-driver/v4/neo4j/dbtype"
+	"github.com/pkg/errors"
 )
 
 // Item can be a folder or a file
 type createdItem struct {
-	Id int
+	Id string
 }
 
 const (
@@ -33,72 +37,397 @@ const (
 	defaultUser     = "neo4j"
 	defaultPassword = "password"
 
-	dbId     = "id"
-	dbName   = "name"
-	dbPath   = "path"
-	dbFolder = "folder"
-	dbFile   = "file"
-	dbExists = "exists"
+	dbId       = "id"
+	dbLegacyId = "legacy_id"
+	dbName     = "name"
+	dbPath     = "path" // legacy: files uploaded before the blob store was introduced only have a path
+	dbBlobKey  = "blob_key"
+	dbDigest   = "digest"
+	dbSize     = "size"
+	dbType     = "content_type"
+	dbCreated  = "created_at" // milliseconds since the epoch, as the modification
+	dbModified = "modified_at"
+	dbFolder   = "folder"
+	dbFile     = "file"
+	dbExists   = "exists"
+
+	dbReferenced = "referenced"
+	dbParentID   = "parentID"
+	dbConflicts  = "conflicts"
+	dbLocked     = "locked"
+	dbFolders    = "folders"
+	dbFiles      = "files"
+	dbCounts     = "childCounts"
+	dbTrashed    = "trashed"
+
+	dbItemID    = "item_id"
+	dbKind      = "kind"
+	dbParent    = "parent_id"
+	dbTrashedAt = "trashed_at"
+	dbNumber    = "number"
+	dbVersion   = "version"
+
+	noItemID = ""
+
+	neo4jTrashFolderName = "Trash"
 )
 
+const (
+	ItemNotFound            = "Item not found"             // a folder or file the operation needs does not exist
+	NameAlreadyExists       = "Name already exists"        // a write would give an item the name of another item of the same folder
+	FolderMovedInsideItself = "Folder moved inside itself" // the destination is the folder itself or one of its descendants
+	IdAlreadyExists         = "Id already exists"          // an imported item has the id, or the legacy id, of another item
+)
+
+// Every implementation must pass the contract tests of the repositorytest package. In short:
+// - the root folder and its trash folder always exist, and are the only folders without parent
+// - names are unique inside a folder, files and folders included: the writes which name an item
+// (UpdateFolder, UpdateFile, MoveFolder, MoveFile, CreateFile, CreateFolder) fail with NameAlreadyExists otherwise
+// - the operations on a single folder or file fail with ItemNotFound when it does not exist, the listings of a
+// missing folder are empty
+// - the operations fail with the error of their context once it is cancelled or past its deadline, and what they
+// wrote is rolled back
+// - the writes which add, remove or rename a direct child of a folder set its ModifiedAt, the imports excepted: they
+// keep the timestamps of the imported items
+// - the trash entries are records of their own: creating or deleting one moves no item, the caller moves the item
+// inside or outside the trash folder. There is a single entry per item, the most recently trashed listed first.
+// - the file versions belong to their file, the file deletions delete them. Their content is referenced as the
+// content of the files is: it becomes orphan once neither a file nor a version references it.
 type IFileSystemRepository interface {
-	UpdateFolder(folderID int, folderName string) error
-	MoveFolder(folderID int, destFolderID int) error
-	MoveFile(fileID int, destFolderID int) error
-	DeleteFolderAndContent(folderID int) error
-	DeleteFile(folderID int) error
-	GetFile(fileID int) (*fsmodel.File, error)
-	ExistsFile(fileID int) (*bool, error)
-	GetFilesIn(folderID int) (*[]fsmodel.File, error)
-	GetFolder(folderID int) (*fsmodel.Folder, error)
-	GetRootFolderID() (*int, error)
-	IsRootFolder(folderID int) (*bool, error)
-	ExistsFolder(folderID int) (*bool, error)
-	GetFoldersIn(folderID int) (*[]fsmodel.Folder, error)
-	CreateFile(fileName string, filePath string, folderParentID int) (*int, error)
-	CreateFolder(folderName string, folderParentID int) (*int, error)
+	UpdateFolder(ctx context.Context, folderID string, folderName string) error
+	UpdateFile(ctx context.Context, fileID string, fileName string, contentType string) error      // the content type is kept when empty
+	MoveFolder(ctx context.Context, folderID string, destFolderID string, folderName string) error // the folder is renamed as it is moved
+	MoveFile(ctx context.Context, fileID string, destFolderID string, fileName string) error       // the file is renamed as it is moved
+	DeleteFolderAndContent(ctx context.Context, folderID string) error
+	// LockFolderSubtree locks the folder and its descendants, files included, until the end of the transaction: nothing
+	// can be created inside the subtree, moved out of it or deleted meanwhile. Returns how many items the subtree holds.
+	LockFolderSubtree(ctx context.Context, folderID string) (*int, error)
+	DeleteFile(ctx context.Context, folderID string) error
+	GetFile(ctx context.Context, fileID string) (*fsmodel.File, error)
+	ExistsFile(ctx context.Context, fileID string) (*bool, error)
+	GetFilesIn(ctx context.Context, folderID string) (*[]fsmodel.File, error)
+	GetFolder(ctx context.Context, folderID string) (*fsmodel.Folder, error)
+	GetRootFolderID(ctx context.Context) (*string, error)
+	IsRootFolder(ctx context.Context, folderID string) (*bool, error)
+	IsFolderInside(ctx context.Context, folderID string, ancestorFolderID string) (*bool, error) // whether the folder is a descendant (at any depth) of the ancestor
+	ExistsFolder(ctx context.Context, folderID string) (*bool, error)
+	// ExistsUntrashedFolder and ExistsUntrashedFile tell whether the item exists outside of the trash folder, in a single
+	// read. Inside a transaction they lock the item only, as ExistsFolder and ExistsFile do, not its ancestors.
+	ExistsUntrashedFolder(ctx context.Context, folderID string) (*bool, error)
+	ExistsUntrashedFile(ctx context.Context, fileID string) (*bool, error)
+	GetFoldersIn(ctx context.Context, folderID string) (*[]fsmodel.Folder, error)
+	// GetChildrenWithNamePrefix finds the direct children, folders and files, whose name starts with the prefix (case
+	// sensitive) without reading the other children
+	GetChildrenWithNamePrefix(ctx context.Context, folderID string, namePrefix string) (*[]fsmodel.NamedChild, error)
+	// GetFolderIDByLegacyID and GetFileIDByLegacyID find the item which had the integer id before the opaque ids,
+	// failing with ItemNotFound when none had
+	GetFolderIDByLegacyID(ctx context.Context, legacyID int) (*string, error)
+	GetFileIDByLegacyID(ctx context.Context, legacyID int) (*string, error)
+	// GetFolderContent reads the folder along with a page of its direct children, selected and ordered as the
+	// options tell, at once
+	GetFolderContent(ctx context.Context, folderID string, options fsmodel.ListOptions) (*fsmodel.FolderContent, error)
+	CreateFile(ctx context.Context, fileName string, content fsmodel.FileContent, folderParentID string) (*string, error)
+	CreateFolder(ctx context.Context, folderName string, folderParentID string) (*string, error)
+	GetOrphanBlobKeys(ctx context.Context) (*[]string, error)            // content of deleted files, which no file references anymore
+	IsBlobReferenced(ctx context.Context, blobKey string) (*bool, error) // whether a file or a file version still references the content
+	RemoveOrphanBlobKey(ctx context.Context, blobKey string) error       // to be called once the content has been deleted
+	GetTrashFolderID(ctx context.Context) (*string, error)
+	CreateTrashEntry(ctx context.Context, entry fsmodel.TrashEntry) error // trashed now: the TrashedAt of the entry is ignored
+	GetTrashEntries(ctx context.Context) (*[]fsmodel.TrashEntry, error)
+	// DeleteTrashEntry deletes the entry of the item and returns it, failing with ItemNotFound when there is none: of
+	// two transactions deleting the same entry, a single one succeeds
+	DeleteTrashEntry(ctx context.Context, itemID string) (*fsmodel.TrashEntry, error)
+
+	UpdateFileContent(ctx context.Context, fileID string, content fsmodel.FileContent) error // the file is modified, its folder is not
+	GetFileVersions(ctx context.Context, fileID string) (*[]fsmodel.FileVersion, error)      // the newest first
+	// CreateFileVersion records the version as it is, creation time included, failing with IdAlreadyExists when the
+	// file already has a version of that number
+	CreateFileVersion(ctx context.Context, fileID string, version fsmodel.FileVersion) error
+	DeleteFileVersion(ctx context.Context, fileID string, number int) error
+
+	// ExecuteInTransaction runs the work as a single transaction, committed when the work returns no error.
+	// The repository given to the work runs its operations in that transaction, and its checks lock what they
+	// checked until the end of the transaction: the existence checks the item, IsFolderInside the ancestors of the
+	// folder. The work may be retried on transient errors, such as deadlocks between transactions.
+	ExecuteInTransaction(ctx context.Context, work func(repo IFileSystemRepository) error) error
+}
+
+// IImportableRepository is implemented by the repositories which can receive the items of another repository as they
+// are, ids and legacy ids included, to migrate the data between backends. The imports check the parent and the name as
+// the creations do, and fail with IdAlreadyExists when the id or the legacy id is taken.
+type IImportableRepository interface {
+	IFileSystemRepository
+	ImportFolder(ctx context.Context, folder fsmodel.Folder) error // the parent must be imported first
+	ImportFile(ctx context.Context, file fsmodel.File) error
+	ImportTrashEntry(ctx context.Context, entry fsmodel.TrashEntry) error // the item must be imported first, inside the trash folder
 }
 
 type Neo4JFileSystemRepository struct {
 	driver neo4j.Driver
+	tx     neo4j.Transaction // set inside ExecuteInTransaction
 }
 
+// NewNeo4JFileSystemRepository connects to the database configured by the NEO4J_* environment variables, and
+// migrates its schema
 func NewNeo4JFileSystemRepository() Neo4JFileSystemRepository {
+	driver := initDriver()
+	if err := migrateNeo4J(driver); err != nil {
+		panic(err)
+	}
+
 	return Neo4JFileSystemRepository{
-		driver: initDriver(),
+		driver: driver,
 	}
 }
 
-func (repo Neo4JFileSystemRepository) UpdateFolder(folderID int, folderName string) error {
-	query, queryMap := updateFolderQuery(folderID, folderName)
-	return executeUpdateQuery(repo.driver)(query, queryMap)
+func (repo Neo4JFileSystemRepository) ExecuteInTransaction(ctx context.Context, work func(repo IFileSystemRepository) error) error {
+	_, err := repo.writeTransaction(ctx)(func(tx neo4j.Transaction) (interface{}, error) {
+		return nil, work(Neo4JFileSystemRepository{driver: repo.driver, tx: tx})
+	})
+	return err
 }
 
-func (repo Neo4JFileSystemRepository) MoveFolder(folderID int, destFolderID int) error {
-	query, queryMap := moveFolderQuery(folderID, destFolderID)
-	return executeUpdateQuery(repo.driver)(query, queryMap)
+// readTransaction runs the work in its own transaction, or in the one of ExecuteInTransaction
+func (repo Neo4JFileSystemRepository) readTransaction(ctx context.Context) func(neo4j.TransactionWork) (interface{}, error) {
+	return func(work neo4j.TransactionWork) (interface{}, error) {
+		return repo.runTransaction(ctx, work, false)
+	}
 }
 
-func (repo Neo4JFileSystemRepository) MoveFile(fileID int, destFolderID int) error {
-	query, queryMap := moveFileQuery(fileID, destFolderID)
-	return executeUpdateQuery(repo.driver)(query, queryMap)
+// writeTransaction runs the work in its own transaction, or in the one of ExecuteInTransaction
+func (repo Neo4JFileSystemRepository) writeTransaction(ctx context.Context) func(neo4j.TransactionWork) (interface{}, error) {
+	return func(work neo4j.TransactionWork) (interface{}, error) {
+		return repo.runTransaction(ctx, work, true)
+	}
 }
 
-func (repo Neo4JFileSystemRepository) DeleteFolderAndContent(folderID int) error {
-	query, queryMap := deleteFolderAndContentQuery(folderID)
-	return executeUpdateQuery(repo.driver)(query, queryMap)
-}
+// runTransaction binds the transaction to the context. The driver knows nothing of contexts: the deadline becomes
+// the timeout of the transaction, enforced by the database, and the cancellation is checked before each statement
+// and before each retry.
+func (repo Neo4JFileSystemRepository) runTransaction(ctx context.Context, work neo4j.TransactionWork, write bool) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if repo.tx != nil {
+		return work(repo.tx)
+	}
 
-func (repo Neo4JFileSystemRepository) DeleteFile(folderID int) error {
-	query, queryMap := deleteFileQuery(folderID)
-	return executeUpdateQuery(repo.driver)(query, queryMap)
-}
+	var configurers []func(*neo4j.TransactionConfig)
+	if deadline, ok := ctx.Deadline(); ok {
+		configurers = append(configurers, neo4j.WithTxTimeout(time.Until(deadline)))
+	}
 
-func (repo Neo4JFileSystemRepository) GetFile(fileID int) (*fsmodel.File, error) {
+	// Sessions are short-lived, cheap to create and NOT thread safe. Typically create one or more sessions
+	// per request in your web application. Make sure to call Close on the session when done.
+	// For multi-database support, set sessionConfig.DatabaseName to requested database
 	session := repo.driver.NewSession(neo4j.SessionConfig{})
 	defer session.Close()
 
-	result, err := session.ReadTransaction(func(tx neo4j.Transaction) (interface{}, error) {
+	contextWork := func(tx neo4j.Transaction) (interface{}, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		result, err := work(contextTransaction{Transaction: tx, ctx: ctx})
+		if err == nil {
+			// the caller gave up meanwhile: roll back
+			err = ctx.Err()
+		}
+		return result, err
+	}
+
+	var result interface{}
+	var err error
+	if write {
+		result, err = session.WriteTransaction(contextWork, configurers...)
+	} else {
+		result, err = session.ReadTransaction(contextWork, configurers...)
+	}
+	return result, contextErrorOr(ctx, err)
+}
+
+// contextTransaction fails the statements run once its context is done, which rolls the transaction back
+type contextTransaction struct {
+	neo4j.Transaction
+	ctx context.Context
+}
+
+func (tx contextTransaction) Run(cypher string, params map[string]interface{}) (neo4j.Result, error) {
+	if err := tx.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return tx.Transaction.Run(cypher, params)
+}
+
+func (repo Neo4JFileSystemRepository) UpdateFolder(ctx context.Context, folderID string, folderName string) error {
+	_, err := repo.writeTransaction(ctx)(func(tx neo4j.Transaction) (interface{}, error) {
+		if err := errorIfNotFound(tx, folderNotFoundError(folderID))(existsFolderByIDQuery(folderID, true)); err != nil {
+			return nil, err
+		}
+		if err := errorIfNameConflict(tx)(nameConflictsAmongSiblingsQuery("Folder", folderID, folderName)); err != nil {
+			return nil, err
+		}
+
+		query, queryMap := updateFolderQuery(folderID, folderName)
+		return updateItem(query, queryMap)(tx)
+	})
+	return err
+}
+
+func (repo Neo4JFileSystemRepository) UpdateFile(ctx context.Context, fileID string, fileName string, contentType string) error {
+	_, err := repo.writeTransaction(ctx)(func(tx neo4j.Transaction) (interface{}, error) {
+		if err := errorIfNotFound(tx, fileNotFoundError(fileID))(existsFileByIDQuery(fileID, true)); err != nil {
+			return nil, err
+		}
+		if err := errorIfNameConflict(tx)(nameConflictsAmongSiblingsQuery("File", fileID, fileName)); err != nil {
+			return nil, err
+		}
+
+		query, queryMap := updateFileQuery(fileID, fileName, contentType)
+		return updateItem(query, queryMap)(tx)
+	})
+	return err
+}
+
+func (repo Neo4JFileSystemRepository) MoveFolder(ctx context.Context, folderID string, destFolderID string, folderName string) error {
+	_, err := repo.writeTransaction(ctx)(func(tx neo4j.Transaction) (interface{}, error) {
+		if err := errorIfNotFound(tx, folderNotFoundError(folderID))(existsFolderByIDQuery(folderID, true)); err != nil {
+			return nil, err
+		}
+		if err := errorIfNotFound(tx, folderNotFoundError(destFolderID))(existsFolderByIDQuery(destFolderID, true)); err != nil {
+			return nil, err
+		}
+
+		isInsideQuery, isInsideQueryMap, mapResultToIsInsideFn := isFolderInsideQuery(destFolderID, folderID)
+		isInsideResult, err := tx.Run(isInsideQuery, isInsideQueryMap)
+		if err != nil {
+			return nil, err
+		}
+		isInside, err := mapResultToIsInsideFn(isInsideResult)
+		if err != nil {
+			return nil, err
+		}
+		if folderID == destFolderID || *isInside {
+			return nil, errors.WithMessage(
+				errors.New(FolderMovedInsideItself),
+				fmt.Sprintf("Folder %s cannot be moved inside folder %s", folderID, destFolderID))
+		}
+
+		if err := errorIfNameConflict(tx)(nameConflictsInFolderQuery(destFolderID, folderName, folderID, noItemID)); err != nil {
+			return nil, err
+		}
+
+		query, queryMap := moveFolderQuery(folderID, destFolderID, folderName)
+		return updateItem(query, queryMap)(tx)
+	})
+	return err
+}
+
+func (repo Neo4JFileSystemRepository) MoveFile(ctx context.Context, fileID string, destFolderID string, fileName string) error {
+	_, err := repo.writeTransaction(ctx)(func(tx neo4j.Transaction) (interface{}, error) {
+		if err := errorIfNotFound(tx, fileNotFoundError(fileID))(existsFileByIDQuery(fileID, true)); err != nil {
+			return nil, err
+		}
+		if err := errorIfNotFound(tx, folderNotFoundError(destFolderID))(existsFolderByIDQuery(destFolderID, true)); err != nil {
+			return nil, err
+		}
+		if err := errorIfNameConflict(tx)(nameConflictsInFolderQuery(destFolderID, fileName, noItemID, fileID)); err != nil {
+			return nil, err
+		}
+
+		query, queryMap := moveFileQuery(fileID, destFolderID, fileName)
+		return updateItem(query, queryMap)(tx)
+	})
+	return err
+}
+
+func (repo Neo4JFileSystemRepository) DeleteFolderAndContent(ctx context.Context, folderID string) error {
+	_, err := repo.writeTransaction(ctx)(func(tx neo4j.Transaction) (interface{}, error) {
+		if err := errorIfNotFound(tx, folderNotFoundError(folderID))(existsFolderByIDQuery(folderID, true)); err != nil {
+			return nil, err
+		}
+
+		// nothing can be created inside the subtree or moved out of it until it is deleted
+		lockQuery, lockQueryMap := lockSubtreeQuery(folderID)
+		if _, err := lockUntilStable(tx, lockQuery, lockQueryMap); err != nil {
+			return nil, err
+		}
+
+		query, queryMap := deleteFolderAndContentQuery(folderID)
+		return updateItem(query, queryMap)(tx)
+	})
+	return err
+}
+
+func (repo Neo4JFileSystemRepository) LockFolderSubtree(ctx context.Context, folderID string) (*int, error) {
+	result, err := repo.writeTransaction(ctx)(func(tx neo4j.Transaction) (interface{}, error) {
+		if err := errorIfNotFound(tx, folderNotFoundError(folderID))(existsFolderByIDQuery(folderID, true)); err != nil {
+			return nil, err
+		}
+
+		lockQuery, lockQueryMap := lockSubtreeQuery(folderID)
+		return lockUntilStable(tx, lockQuery, lockQueryMap)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	count := result.(int)
+	return &count, nil
+}
+
+func (repo Neo4JFileSystemRepository) DeleteFile(ctx context.Context, fileID string) error {
+	_, err := repo.writeTransaction(ctx)(func(tx neo4j.Transaction) (interface{}, error) {
+		if err := errorIfNotFound(tx, fileNotFoundError(fileID))(existsFileByIDQuery(fileID, true)); err != nil {
+			return nil, err
+		}
+
+		query, queryMap := deleteFileQuery(fileID)
+		return updateItem(query, queryMap)(tx)
+	})
+	return err
+}
+
+func (repo Neo4JFileSystemRepository) GetOrphanBlobKeys(ctx context.Context) (*[]string, error) {
+	result, err := repo.readTransaction(ctx)(func(tx neo4j.Transaction) (interface{}, error) {
+		query, queryMap, mapResultToBlobKeysFn := getOrphanBlobKeysQuery()
+		result, err := tx.Run(query, queryMap)
+		if err != nil {
+			return nil, err
+		}
+		return mapResultToBlobKeysFn(result)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return result.(*[]string), nil
+}
+
+func (repo Neo4JFileSystemRepository) IsBlobReferenced(ctx context.Context, blobKey string) (*bool, error) {
+	result, err := repo.readTransaction(ctx)(func(tx neo4j.Transaction) (interface{}, error) {
+		query, queryMap, mapResultToReferencedFn := isBlobReferencedQuery(blobKey)
+		result, err := tx.Run(query, queryMap)
+		if err != nil {
+			return nil, err
+		}
+		return mapResultToReferencedFn(result)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return result.(*bool), nil
+}
+
+func (repo Neo4JFileSystemRepository) RemoveOrphanBlobKey(ctx context.Context, blobKey string) error {
+	query, queryMap := removeOrphanBlobKeyQuery(blobKey)
+	return executeUpdateQuery(repo.writeTransaction(ctx))(query, queryMap)
+}
+
+func (repo Neo4JFileSystemRepository) GetFile(ctx context.Context, fileID string) (*fsmodel.File, error) {
+	result, err := repo.readTransaction(ctx)(func(tx neo4j.Transaction) (interface{}, error) {
 		query, queryMap, mapResultToFileFn := getFileByIDQuery(fileID)
 		result, err := tx.Run(query, queryMap)
 		if err != nil {
@@ -114,12 +443,9 @@ func (repo Neo4JFileSystemRepository) GetFile(fileID int) (*fsmodel.File, error)
 	return result.(*fsmodel.File), nil
 }
 
-func (repo Neo4JFileSystemRepository) ExistsFile(fileID int) (*bool, error) {
-	session := repo.driver.NewSession(neo4j.SessionConfig{})
-	defer session.Close()
-
-	result, err := session.ReadTransaction(func(tx neo4j.Transaction) (interface{}, error) {
-		query, queryMap, mapResultToExistFn := existsFileByIDQuery(fileID)
+func (repo Neo4JFileSystemRepository) ExistsFile(ctx context.Context, fileID string) (*bool, error) {
+	result, err := repo.readTransaction(ctx)(func(tx neo4j.Transaction) (interface{}, error) {
+		query, queryMap, mapResultToExistFn := existsFileByIDQuery(fileID, repo.tx != nil)
 		result, err := tx.Run(query, queryMap)
 		if err != nil {
 			return nil, err
@@ -134,11 +460,8 @@ func (repo Neo4JFileSystemRepository) ExistsFile(fileID int) (*bool, error) {
 	return result.(*bool), nil
 }
 
-func (repo Neo4JFileSystemRepository) GetFilesIn(folderID int) (*[]fsmodel.File, error) {
-	session := repo.driver.NewSession(neo4j.SessionConfig{})
-	defer session.Close()
-
-	result, err := session.ReadTransaction(func(tx neo4j.Transaction) (interface{}, error) {
+func (repo Neo4JFileSystemRepository) GetFilesIn(ctx context.Context, folderID string) (*[]fsmodel.File, error) {
+	result, err := repo.readTransaction(ctx)(func(tx neo4j.Transaction) (interface{}, error) {
 		query, queryMap, mapResultToFilesFn := getFilesInFolderQuery(folderID)
 		result, err := tx.Run(query, queryMap)
 		if err != nil {
@@ -154,11 +477,8 @@ func (repo Neo4JFileSystemRepository) GetFilesIn(folderID int) (*[]fsmodel.File,
 	return result.(*[]fsmodel.File), nil
 }
 
-func (repo Neo4JFileSystemRepository) GetFolder(folderID int) (*fsmodel.Folder, error) {
-	session := repo.driver.NewSession(neo4j.SessionConfig{})
-	defer session.Close()
-
-	result, err := session.ReadTransaction(func(tx neo4j.Transaction) (interface{}, error) {
+func (repo Neo4JFileSystemRepository) GetFolder(ctx context.Context, folderID string) (*fsmodel.Folder, error) {
+	result, err := repo.readTransaction(ctx)(func(tx neo4j.Transaction) (interface{}, error) {
 		query, queryMap, mapResultToFolderFn := getFolderByIDQuery(folderID)
 		result, err := tx.Run(query, queryMap)
 		if err != nil {
@@ -174,11 +494,8 @@ func (repo Neo4JFileSystemRepository) GetFolder(folderID int) (*fsmodel.Folder,
 	return result.(*fsmodel.Folder), nil
 }
 
-func (repo Neo4JFileSystemRepository) GetRootFolderID() (*int, error) {
-	session := repo.driver.NewSession(neo4j.SessionConfig{})
-	defer session.Close()
-
-	result, err := session.ReadTransaction(func(tx neo4j.Transaction) (interface{}, error) {
+func (repo Neo4JFileSystemRepository) GetRootFolderID(ctx context.Context) (*string, error) {
+	result, err := repo.readTransaction(ctx)(func(tx neo4j.Transaction) (interface{}, error) {
 		query, queryMap, mapResultToFolderIDFn := getRootFolderIDQuery()
 		result, err := tx.Run(query, queryMap)
 		if err != nil {
@@ -191,14 +508,11 @@ func (repo Neo4JFileSystemRepository) GetRootFolderID() (*int, error) {
 		return nil, err
 	}
 
-	return result.(*int), nil
+	return result.(*string), nil
 }
 
-func (repo Neo4JFileSystemRepository) IsRootFolder(folderID int) (*bool, error) {
-	session := repo.driver.NewSession(neo4j.SessionConfig{})
-	defer session.Close()
-
-	result, err := session.ReadTransaction(func(tx neo4j.Transaction) (interface{}, error) {
+func (repo Neo4JFileSystemRepository) IsRootFolder(ctx context.Context, folderID string) (*bool, error) {
+	result, err := repo.readTransaction(ctx)(func(tx neo4j.Transaction) (interface{}, error) {
 		query, queryMap, mapResultToIsRootFolderFn := isRootFolderQuery(folderID)
 		result, err := tx.Run(query, queryMap)
 		if err != nil {
@@ -214,17 +528,22 @@ func (repo Neo4JFileSystemRepository) IsRootFolder(folderID int) (*bool, error)
 	return result.(*bool), nil
 }
 
-func (repo Neo4JFileSystemRepository) ExistsFolder(folderID int) (*bool, error) {
-	session := repo.driver.NewSession(neo4j.SessionConfig{})
-	defer session.Close()
+func (repo Neo4JFileSystemRepository) IsFolderInside(ctx context.Context, folderID string, ancestorFolderID string) (*bool, error) {
+	result, err := repo.readTransaction(ctx)(func(tx neo4j.Transaction) (interface{}, error) {
+		if repo.tx != nil {
+			// the ancestors cannot be moved until the end of the transaction
+			lockQuery, lockQueryMap := lockAncestorsQuery(folderID)
+			if _, err := lockUntilStable(tx, lockQuery, lockQueryMap); err != nil {
+				return nil, err
+			}
+		}
 
-	result, err := session.ReadTransaction(func(tx neo4j.Transaction) (interface{}, error) {
-		query, queryMap, mapResultToExistFn := existsFolderByIDQuery(folderID)
+		query, queryMap, mapResultToIsInsideFn := isFolderInsideQuery(folderID, ancestorFolderID)
 		result, err := tx.Run(query, queryMap)
 		if err != nil {
 			return nil, err
 		}
-		return mapResultToExistFn(result)
+		return mapResultToIsInsideFn(result)
 	})
 
 	if err != nil {
@@ -234,255 +553,1216 @@ func (repo Neo4JFileSystemRepository) ExistsFolder(folderID int) (*bool, error)
 	return result.(*bool), nil
 }
 
-func (repo Neo4JFileSystemRepository) GetFoldersIn(folderID int) (*[]fsmodel.Folder, error) {
-	session := repo.driver.NewSession(neo4j.SessionConfig{})
-	defer session.Close()
+func (repo Neo4JFileSystemRepository) ExistsUntrashedFolder(ctx context.Context, folderID string) (*bool, error) {
+	return repo.existsUntrashed(ctx, "Folder", folderID)
+}
 
-	result, err := session.ReadTransaction(func(tx neo4j.Transaction) (interface{}, error) {
-		query, queryMap, mapResultToFoldersFn := getFoldersInFolderQuery(folderID)
+func (repo Neo4JFileSystemRepository) ExistsUntrashedFile(ctx context.Context, fileID string) (*bool, error) {
+	return repo.existsUntrashed(ctx, "File", fileID)
+}
+
+func (repo Neo4JFileSystemRepository) existsUntrashed(ctx context.Context, label string, itemID string) (*bool, error) {
+	result, err := repo.readTransaction(ctx)(func(tx neo4j.Transaction) (interface{}, error) {
+		query, queryMap, mapResultToExistFn := existsUntrashedQuery(label, itemID, repo.tx != nil)
 		result, err := tx.Run(query, queryMap)
 		if err != nil {
 			return nil, err
 		}
-		return mapResultToFoldersFn(result)
+		return mapResultToExistFn(result)
 	})
 
 	if err != nil {
 		return nil, err
 	}
 
-	return result.(*[]fsmodel.Folder), nil
-}
-
-func (repo Neo4JFileSystemRepository) CreateFile(fileName string, filePath string, folderParentID int) (*int, error) {
-	query, queryMap := createNewFileWithParentQuery(fileName, filePath, folderParentID)
-	return executeCreateQuery(repo.driver)(query, queryMap)
-}
-
-func (repo Neo4JFileSystemRepository) CreateFolder(folderName string, folderParentID int) (*int, error) {
-	query, queryMap := createNewFolderWithParentQuery(folderName, folderParentID)
-	return executeCreateQuery(repo.driver)(query, queryMap)
+	return result.(*bool), nil
 }
 
-// InitDriver returns a valid driver
-// handles driver lifetime based on your application lifetime requirements  driver's lifetime is usually
-// bound by the application lifetime, which usually implies one driver instance per application
-func initDriver() neo4j.Driver {
-	host := os.Getenv(NEO4J_HOST)
-	if len(host) == 0 {
-		fmt.Printf("Could not find envirnment variable %s. Fallback to default '%s'\n", NEO4J_HOST, defaultHost)
-		host = defaultHost
-	}
-
-	port := os.Getenv(NEO4J_PORT)
-	if len(port) == 0 {
-		fmt.Printf("Could not find envirnment variable %s. Fallback to default '%s'\n", NEO4J_PORT, defaultPort)
-		port = defaultPort
-	}
-
-	username := os.Getenv(NEO4J_USER)
-	if len(username) == 0 {
-		fmt.Printf("Could not find envirnment variable %s. Fallback to default '%s'\n", NEO4J_USER, defaultUser)
-		username = defaultUser
-	}
+func (repo Neo4JFileSystemRepository) ExistsFolder(ctx context.Context, folderID string) (*bool, error) {
+	result, err := repo.readTransaction(ctx)(func(tx neo4j.Transaction) (interface{}, error) {
+		query, queryMap, mapResultToExistFn := existsFolderByIDQuery(folderID, repo.tx != nil)
+		result, err := tx.Run(query, queryMap)
+		if err != nil {
+			return nil, err
+		}
+		return mapResultToExistFn(result)
+	})
 
-	password := os.Getenv(NEO4J_PASSWORD)
-	if len(password) == 0 {
-		fmt.Printf("Could not find envirnment variable %s. Fallback to default ******\n", NEO4J_PASSWORD)
-		password = defaultPassword
+	if err != nil {
+		return nil, err
 	}
 
-	uri := fmt.Sprintf("neo4j://%s:%s", host, port)
+	return result.(*bool), nil
+}
 
-	// Neo4j 4.0, defaults to no TLS therefore use bolt:// or neo4j://
-	// Neo4j 3.5, defaults to self-signed certificates, TLS on, therefore use bolt+ssc:// or neo4j+ssc://
-	driver, err := neo4j.NewDriver(uri, neo4j.BasicAuth(username, password, ""))
-	if err != nil {
-		panic(err)
-	}
+func (repo Neo4JFileSystemRepository) GetFoldersIn(ctx context.Context, folderID string) (*[]fsmodel.Folder, error) {
+	result, err := repo.readTransaction(ctx)(func(tx neo4j.Transaction) (interface{}, error) {
+		query, queryMap, mapResultToFoldersFn := getFoldersInFolderQuery(folderID)
+		result, err := tx.Run(query, queryMap)
+		if err != nil {
+			return nil, err
+		}
+		return mapResultToFoldersFn(result)
+	})
 
-	err = driver.VerifyConnectivity()
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	return driver
+	return result.(*[]fsmodel.Folder), nil
 }
 
-func executeCreateQuery(driver neo4j.Driver) func(string, map[string]interface{}) (*int, error) {
-	return func(query string, queryMap map[string]interface{}) (*int, error) {
-		// Sessions are short-lived, cheap to create and NOT thread safe. Typically create one or more sessions
-		// per request in your web application. Make sure to call Close on the session when done.
-		// For multi-database support, set sessionConfig.DatabaseName to requested database
-		// Session config will default to write mode, if only reads are to be used configure session for
-		// read mode.
-		session := driver.NewSession(neo4j.SessionConfig{})
-		defer session.Close()
-
-		result, err := session.WriteTransaction(createItem(query, queryMap))
+func (repo Neo4JFileSystemRepository) GetChildrenWithNamePrefix(ctx context.Context, folderID string, namePrefix string) (*[]fsmodel.NamedChild, error) {
+	result, err := repo.readTransaction(ctx)(func(tx neo4j.Transaction) (interface{}, error) {
+		query, queryMap, mapResultToNamedChildrenFn := getChildrenWithNamePrefixQuery(folderID, namePrefix)
+		result, err := tx.Run(query, queryMap)
 		if err != nil {
 			return nil, err
 		}
+		return mapResultToNamedChildrenFn(result)
+	})
 
-		item := result.(*createdItem)
-		return &item.Id, nil
+	if err != nil {
+		return nil, err
 	}
+
+	return result.(*[]fsmodel.NamedChild), nil
 }
 
-func createItem(query string, queryMap map[string]interface{}) func(tx neo4j.Transaction) (interface{}, error) {
-	return func(tx neo4j.Transaction) (interface{}, error) {
+func (repo Neo4JFileSystemRepository) GetFolderIDByLegacyID(ctx context.Context, legacyID int) (*string, error) {
+	result, err := repo.readTransaction(ctx)(func(tx neo4j.Transaction) (interface{}, error) {
+		query, queryMap, mapResultToIDFn := getIDByLegacyIDQuery("Folder", legacyID, legacyFolderNotFoundError(legacyID))
 		result, err := tx.Run(query, queryMap)
-		// In face of driver native errors, make sure to return them directly.
-		// Depending on the error, the driver may try to execute the function again.
 		if err != nil {
 			return nil, err
 		}
+		return mapResultToIDFn(result)
+	})
 
-		record, err := result.Single()
+	if err != nil {
+		return nil, err
+	}
+
+	return result.(*string), nil
+}
+
+func (repo Neo4JFileSystemRepository) GetFileIDByLegacyID(ctx context.Context, legacyID int) (*string, error) {
+	result, err := repo.readTransaction(ctx)(func(tx neo4j.Transaction) (interface{}, error) {
+		query, queryMap, mapResultToIDFn := getIDByLegacyIDQuery("File", legacyID, legacyFileNotFoundError(legacyID))
+		result, err := tx.Run(query, queryMap)
 		if err != nil {
 			return nil, err
 		}
+		return mapResultToIDFn(result)
+	})
 
-		// You can also retrieve values by name, with e.g. `id, found := record.Get("n.id")`
-		return &createdItem{
-			Id: int(record.Values[0].(int64)),
-		}, nil
+	if err != nil {
+		return nil, err
 	}
+
+	return result.(*string), nil
 }
 
-func updateItem(query string, queryMap map[string]interface{}) func(tx neo4j.Transaction) (interface{}, error) {
-	return func(tx neo4j.Transaction) (interface{}, error) {
-		_, err := tx.Run(query, queryMap)
-		// In face of driver native errors, make sure to return them directly.
-		// Depending on the error, the driver may try to execute the function again.
+func (repo Neo4JFileSystemRepository) GetFolderContent(ctx context.Context, folderID string, options fsmodel.ListOptions) (*fsmodel.FolderContent, error) {
+	result, err := repo.readTransaction(ctx)(func(tx neo4j.Transaction) (interface{}, error) {
+		query, queryMap, mapResultToFolderContentFn := getFolderContentQuery(folderID, options)
+		result, err := tx.Run(query, queryMap)
 		if err != nil {
 			return nil, err
 		}
+		return mapResultToFolderContentFn(result)
+	})
 
-		return nil, nil
+	if err != nil {
+		return nil, err
 	}
-}
 
-func executeUpdateQuery(driver neo4j.Driver) func(string, map[string]interface{}) error {
-	return func(query string, queryMap map[string]interface{}) error {
-		// Sessions are short-lived, cheap to create and NOT thread safe. Typically create one or more sessions
-		// per request in your web application. Make sure to call Close on the session when done.
-		// For multi-database support, set sessionConfig.DatabaseName to requested database
-		// Session config will default to write mode, if only reads are to be used configure session for
-		// read mode.
-		session := driver.NewSession(neo4j.SessionConfig{})
-		defer session.Close()
-
-		_, err := session.WriteTransaction(updateItem(query, queryMap))
-		return err
-	}
+	return result.(*fsmodel.FolderContent), nil
 }
 
-func getFileByIDQuery(fileID int) (string, map[string]interface{}, func(result neo4j.Result) (*fsmodel.File, error)) {
-	return `MATCH (file:File{id: $fileID})
-		RETURN file`,
-		map[string]interface{}{
-			"fileID": fileID,
-		},
-		func(result neo4j.Result) (*fsmodel.File, error) {
-			record, err := result.Single()
-			if err != nil {
-				return nil, err
-			}
-
-			return mapRecordToFile(record)
-		}
+func (repo Neo4JFileSystemRepository) CreateFile(ctx context.Context, fileName string, content fsmodel.FileContent, folderParentID string) (*string, error) {
+	query, queryMap := createNewFileWithParentQuery(fileName, content, folderParentID)
+	return executeCreateQueryInFolder(repo.writeTransaction(ctx))(folderParentID, fileName, query, queryMap)
 }
 
-func existsFileByIDQuery(fileID int) (string, map[string]interface{}, func(result neo4j.Result) (*bool, error)) {
+func (repo Neo4JFileSystemRepository) CreateFolder(ctx context.Context, folderName string, folderParentID string) (*string, error) {
+	query, queryMap := createNewFolderWithParentQuery(folderName, folderParentID)
+	return executeCreateQueryInFolder(repo.writeTransaction(ctx))(folderParentID, folderName, query, queryMap)
+}
+
+func (repo Neo4JFileSystemRepository) ImportFolder(ctx context.Context, folder fsmodel.Folder) error {
+	if folder.ParentId == nil {
+		return rootFolderImportedError(folder.Id)
+	}
+	query, queryMap := importFolderWithParentQuery(folder)
+	return executeImportQueryInFolder(repo.writeTransaction(ctx))(*folder.ParentId, folder.Name, query, queryMap, func(tx neo4j.Transaction) error {
+		return errorIfFound(tx, idAlreadyExistsError(dbFolder, folder.Id, folder.LegacyId))(existsByIDOrLegacyIDQuery("Folder", folder.Id, folder.LegacyId))
+	})
+}
+
+func (repo Neo4JFileSystemRepository) ImportFile(ctx context.Context, file fsmodel.File) error {
+	query, queryMap := importFileWithParentQuery(file)
+	return executeImportQueryInFolder(repo.writeTransaction(ctx))(file.ParentId, file.Name, query, queryMap, func(tx neo4j.Transaction) error {
+		return errorIfFound(tx, idAlreadyExistsError(dbFile, file.Id, file.LegacyId))(existsByIDOrLegacyIDQuery("File", file.Id, file.LegacyId))
+	})
+}
+
+func (repo Neo4JFileSystemRepository) GetTrashFolderID(ctx context.Context) (*string, error) {
+	result, err := repo.readTransaction(ctx)(func(tx neo4j.Transaction) (interface{}, error) {
+		query, queryMap, mapResultToFolderIDFn := getTrashFolderIDQuery()
+		result, err := tx.Run(query, queryMap)
+		if err != nil {
+			return nil, err
+		}
+		return mapResultToFolderIDFn(result)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return result.(*string), nil
+}
+
+func (repo Neo4JFileSystemRepository) CreateTrashEntry(ctx context.Context, entry fsmodel.TrashEntry) error {
+	entry.TrashedAt = currentTime()
+	return repo.ImportTrashEntry(ctx, entry)
+}
+
+func (repo Neo4JFileSystemRepository) GetTrashEntries(ctx context.Context) (*[]fsmodel.TrashEntry, error) {
+	result, err := repo.readTransaction(ctx)(func(tx neo4j.Transaction) (interface{}, error) {
+		query, queryMap, mapResultToTrashEntriesFn := getTrashEntriesQuery()
+		result, err := tx.Run(query, queryMap)
+		if err != nil {
+			return nil, err
+		}
+		return mapResultToTrashEntriesFn(result)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return result.(*[]fsmodel.TrashEntry), nil
+}
+
+func (repo Neo4JFileSystemRepository) DeleteTrashEntry(ctx context.Context, itemID string) (*fsmodel.TrashEntry, error) {
+	result, err := repo.writeTransaction(ctx)(func(tx neo4j.Transaction) (interface{}, error) {
+		// the entry is deleted as it is read: of two transactions, the second one finds no entry once the first commits
+		query, queryMap, mapResultToTrashEntryFn := deleteTrashEntryQuery(itemID)
+		result, err := tx.Run(query, queryMap)
+		if err != nil {
+			return nil, err
+		}
+		return mapResultToTrashEntryFn(result)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return result.(*fsmodel.TrashEntry), nil
+}
+
+func (repo Neo4JFileSystemRepository) ImportTrashEntry(ctx context.Context, entry fsmodel.TrashEntry) error {
+	_, err := repo.writeTransaction(ctx)(func(tx neo4j.Transaction) (interface{}, error) {
+		if err := errorIfFound(tx, trashEntryAlreadyExistsError(entry.ItemId))(existsTrashEntryQuery(entry.ItemId)); err != nil {
+			return nil, err
+		}
+
+		query, queryMap := createTrashEntryQuery(entry)
+		return updateItem(query, queryMap)(tx)
+	})
+	return err
+}
+
+func (repo Neo4JFileSystemRepository) UpdateFileContent(ctx context.Context, fileID string, content fsmodel.FileContent) error {
+	_, err := repo.writeTransaction(ctx)(func(tx neo4j.Transaction) (interface{}, error) {
+		if err := errorIfNotFound(tx, fileNotFoundError(fileID))(existsFileByIDQuery(fileID, true)); err != nil {
+			return nil, err
+		}
+
+		query, queryMap := updateFileContentQuery(fileID, content)
+		return updateItem(query, queryMap)(tx)
+	})
+	return err
+}
+
+func (repo Neo4JFileSystemRepository) GetFileVersions(ctx context.Context, fileID string) (*[]fsmodel.FileVersion, error) {
+	result, err := repo.readTransaction(ctx)(func(tx neo4j.Transaction) (interface{}, error) {
+		if err := errorIfNotFound(tx, fileNotFoundError(fileID))(existsFileByIDQuery(fileID, false)); err != nil {
+			return nil, err
+		}
+
+		query, queryMap, mapResultToFileVersionsFn := getFileVersionsQuery(fileID)
+		result, err := tx.Run(query, queryMap)
+		if err != nil {
+			return nil, err
+		}
+		return mapResultToFileVersionsFn(result)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return result.(*[]fsmodel.FileVersion), nil
+}
+
+func (repo Neo4JFileSystemRepository) CreateFileVersion(ctx context.Context, fileID string, version fsmodel.FileVersion) error {
+	_, err := repo.writeTransaction(ctx)(func(tx neo4j.Transaction) (interface{}, error) {
+		if err := errorIfNotFound(tx, fileNotFoundError(fileID))(existsFileByIDQuery(fileID, true)); err != nil {
+			return nil, err
+		}
+		if err := errorIfFound(tx, fileVersionAlreadyExistsError(fileID, version.Number))(existsFileVersionQuery(fileID, version.Number)); err != nil {
+			return nil, err
+		}
+
+		query, queryMap := createFileVersionQuery(fileID, version)
+		return updateItem(query, queryMap)(tx)
+	})
+	return err
+}
+
+func (repo Neo4JFileSystemRepository) DeleteFileVersion(ctx context.Context, fileID string, number int) error {
+	_, err := repo.writeTransaction(ctx)(func(tx neo4j.Transaction) (interface{}, error) {
+		if err := errorIfNotFound(tx, fileNotFoundError(fileID))(existsFileByIDQuery(fileID, true)); err != nil {
+			return nil, err
+		}
+		if err := errorIfNotFound(tx, fileVersionNotFoundError(fileID, number))(existsFileVersionQuery(fileID, number)); err != nil {
+			return nil, err
+		}
+
+		query, queryMap := deleteFileVersionQuery(fileID, number)
+		return updateItem(query, queryMap)(tx)
+	})
+	return err
+}
+
+// InitDriver returns a valid driver
+// handles driver lifetime based on your application lifetime requirements  driver's lifetime is usually
+// bound by the application lifetime, which usually implies one driver instance per application
+func initDriver() neo4j.Driver {
+	host := fsconfig.GetEnvOrDefault(NEO4J_HOST, defaultHost)
+	port := fsconfig.GetEnvOrDefault(NEO4J_PORT, defaultPort)
+	username := fsconfig.GetEnvOrDefault(NEO4J_USER, defaultUser)
+	password := fsconfig.GetSecretEnvOrDefault(NEO4J_PASSWORD, defaultPassword)
+
+	uri := fmt.Sprintf("neo4j://%s:%s", host, port)
+
+	// Neo4j 4.0, defaults to no TLS therefore use bolt:// or neo4j://
+	// Neo4j 3.5, defaults to self-signed certificates, TLS on, therefore use bolt+ssc:// or neo4j+ssc://
+	driver, err := neo4j.NewDriver(uri, neo4j.BasicAuth(username, password, ""))
+	if err != nil {
+		panic(err)
+	}
+
+	err = driver.VerifyConnectivity()
+	if err != nil {
+		panic(err)
+	}
+
+	return driver
+}
+
+func executeCreateQuery(writeTransaction func(neo4j.TransactionWork) (interface{}, error)) func(string, map[string]interface{}) (*string, error) {
+	return func(query string, queryMap map[string]interface{}) (*string, error) {
+		result, err := writeTransaction(createItem(query, queryMap))
+		if err != nil {
+			return nil, err
+		}
+
+		item := result.(*createdItem)
+		return &item.Id, nil
+	}
+}
+
+func createItem(query string, queryMap map[string]interface{}) func(tx neo4j.Transaction) (interface{}, error) {
+	return func(tx neo4j.Transaction) (interface{}, error) {
+		result, err := tx.Run(query, queryMap)
+		// In face of driver native errors, make sure to return them directly.
+		// Depending on the error, the driver may try to execute the function again.
+		if err != nil {
+			return nil, err
+		}
+
+		record, err := result.Single()
+		if err != nil {
+			return nil, err
+		}
+
+		// You can also retrieve values by name, with e.g. `id, found := record.Get("n.id")`
+		return &createdItem{
+			Id: record.Values[0].(string),
+		}, nil
+	}
+}
+
+func updateItem(query string, queryMap map[string]interface{}) func(tx neo4j.Transaction) (interface{}, error) {
+	return func(tx neo4j.Transaction) (interface{}, error) {
+		_, err := tx.Run(query, queryMap)
+		// In face of driver native errors, make sure to return them directly.
+		// Depending on the error, the driver may try to execute the function again.
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, nil
+	}
+}
+
+func executeUpdateQuery(writeTransaction func(neo4j.TransactionWork) (interface{}, error)) func(string, map[string]interface{}) error {
+	return func(query string, queryMap map[string]interface{}) error {
+		_, err := writeTransaction(updateItem(query, queryMap))
+		return err
+	}
+}
+
+// executeCreateQueryInFolder runs the checks on the parent folder and the creation in the same write transaction
+func executeCreateQueryInFolder(writeTransaction func(neo4j.TransactionWork) (interface{}, error)) func(string, string, string, map[string]interface{}) (*string, error) {
+	return func(folderParentID string, name string, query string, queryMap map[string]interface{}) (*string, error) {
+		result, err := writeTransaction(func(tx neo4j.Transaction) (interface{}, error) {
+			if err := errorIfNotFound(tx, folderNotFoundError(folderParentID))(existsFolderByIDQuery(folderParentID, true)); err != nil {
+				return nil, err
+			}
+			if err := errorIfNameConflict(tx)(nameConflictsInFolderQuery(folderParentID, name, noItemID, noItemID)); err != nil {
+				return nil, err
+			}
+			return createItem(query, queryMap)(tx)
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		item := result.(*createdItem)
+		return &item.Id, nil
+	}
+}
+
+// executeImportQueryInFolder runs the checks of executeCreateQueryInFolder, the one of the imported id, and the import
+// in the same write transaction
+func executeImportQueryInFolder(writeTransaction func(neo4j.TransactionWork) (interface{}, error)) func(string, string, string, map[string]interface{}, func(neo4j.Transaction) error) error {
+	return func(folderParentID string, name string, query string, queryMap map[string]interface{}, errorIfIDTaken func(neo4j.Transaction) error) error {
+		_, err := writeTransaction(func(tx neo4j.Transaction) (interface{}, error) {
+			if err := errorIfIDTaken(tx); err != nil {
+				return nil, err
+			}
+			if err := errorIfNotFound(tx, folderNotFoundError(folderParentID))(existsFolderByIDQuery(folderParentID, true)); err != nil {
+				return nil, err
+			}
+			if err := errorIfNameConflict(tx)(nameConflictsInFolderQuery(folderParentID, name, noItemID, noItemID)); err != nil {
+				return nil, err
+			}
+			return updateItem(query, queryMap)(tx)
+		})
+		return err
+	}
+}
+
+// errorIfFound runs the exists query in the transaction, and returns foundErr when the item exists
+func errorIfFound(tx neo4j.Transaction, foundErr error) func(string, map[string]interface{}, func(neo4j.Result) (*bool, error)) error {
+	return func(existsQuery string, existsQueryMap map[string]interface{}, mapResultToExistFn func(neo4j.Result) (*bool, error)) error {
+		result, err := tx.Run(existsQuery, existsQueryMap)
+		if err != nil {
+			return err
+		}
+
+		exists, err := mapResultToExistFn(result)
+		if err != nil {
+			return err
+		}
+		if *exists {
+			return foundErr
+		}
+		return nil
+	}
+}
+
+// errorIfNotFound runs the exists query in the transaction, and returns notFoundErr when the item does not exist
+func errorIfNotFound(tx neo4j.Transaction, notFoundErr error) func(string, map[string]interface{}, func(neo4j.Result) (*bool, error)) error {
+	return func(existsQuery string, existsQueryMap map[string]interface{}, mapResultToExistFn func(neo4j.Result) (*bool, error)) error {
+		result, err := tx.Run(existsQuery, existsQueryMap)
+		if err != nil {
+			return err
+		}
+
+		exists, err := mapResultToExistFn(result)
+		if err != nil {
+			return err
+		}
+		if !*exists {
+			return notFoundErr
+		}
+		return nil
+	}
+}
+
+// singleRecord is result.Single, except that no record at all means that the item was not found
+func singleRecord(result neo4j.Result, notFoundErr error) (*neo4j.Record, error) {
+	if !result.Next() {
+		if err := result.Err(); err != nil {
+			return nil, err
+		}
+		return nil, notFoundErr
+	}
+
+	record := result.Record()
+	if result.Next() {
+		return nil, errors.New("Expected a single record, got more")
+	}
+	return record, result.Err()
+}
+
+// lockUntilStable runs the lock query, which locks nodes and returns their internal ids, until no new node shows up.
+// A locked node cannot be moved by another transaction, so the set of nodes found is then complete. Returns how many
+// nodes were locked.
+func lockUntilStable(tx neo4j.Transaction, lockQuery string, lockQueryMap map[string]interface{}) (int, error) {
+	locked := make(map[int64]bool)
+	for {
+		result, err := tx.Run(lockQuery, lockQueryMap)
+		if err != nil {
+			return 0, err
+		}
+
+		record, err := result.Single()
+		if err != nil {
+			return 0, err
+		}
+
+		nodeIDs, found := record.Get(dbLocked)
+		if !found {
+			return 0, errors.New("Could not find 'locked' in lock response")
+		}
+
+		newNodes := false
+		for _, nodeID := range nodeIDs.([]interface{}) {
+			if !locked[nodeID.(int64)] {
+				locked[nodeID.(int64)] = true
+				newNodes = true
+			}
+		}
+		if !newNodes {
+			return len(locked), nil
+		}
+	}
+}
+
+func errorIfNameConflict(tx neo4j.Transaction) func(string, map[string]interface{}) error {
+	return func(nameCheckQuery string, nameCheckQueryMap map[string]interface{}) error {
+		result, err := tx.Run(nameCheckQuery, nameCheckQueryMap)
+		if err != nil {
+			return err
+		}
+
+		record, err := result.Single()
+		if err != nil {
+			return err
+		}
+
+		conflicts, found := record.Get(dbConflicts)
+		if !found {
+			return errors.New("Could not find 'conflicts' in name conflicts response")
+		}
+		if conflicts.(int64) > 0 {
+			return errors.New(NameAlreadyExists)
+		}
+		return nil
+	}
+}
+
+func getFileByIDQuery(fileID string) (string, map[string]interface{}, func(result neo4j.Result) (*fsmodel.File, error)) {
+	return `MATCH (file:File{id: $fileID})
+		OPTIONAL MATCH (file)-[:IS_INSIDE]->(parent:Folder)
+		RETURN file, parent.id AS parentID`,
+		map[string]interface{}{
+			"fileID": fileID,
+		},
+		func(result neo4j.Result) (*fsmodel.File, error) {
+			record, err := singleRecord(result, fileNotFoundError(fileID))
+			if err != nil {
+				return nil, err
+			}
+
+			return mapRecordToFile(record)
+		}
+}
+
+func existsFileByIDQuery(fileID string, lock bool) (string, map[string]interface{}, func(result neo4j.Result) (*bool, error)) {
 	return `OPTIONAL MATCH (file:File{id: $fileID})
+		` + lockClause("file", lock) + `
 		RETURN file IS NOT NULL AS exists`,
 		map[string]interface{}{
-			"fileID": fileID,
-		},
-		func(result neo4j.Result) (*bool, error) {
-			record, err := result.Single()
-			if err != nil {
-				return nil, err
-			}
+			"fileID": fileID,
+		},
+		func(result neo4j.Result) (*bool, error) {
+			record, err := result.Single()
+			if err != nil {
+				return nil, err
+			}
+
+			exists, found := record.Get(dbExists)
+			if !found {
+				return nil, errors.New("Could not find 'exists' in file exists response")
+			}
+
+			e := exists.(bool)
+			return &e, nil
+		}
+}
+
+func getFolderByIDQuery(folderID string) (string, map[string]interface{}, func(result neo4j.Result) (*fsmodel.Folder, error)) {
+	return `MATCH (folder:Folder{id: $folderID})
+		OPTIONAL MATCH (folder)-[:IS_INSIDE]->(parent:Folder)
+		RETURN folder, parent.id AS parentID`,
+		map[string]interface{}{
+			"folderID": folderID,
+		},
+		func(result neo4j.Result) (*fsmodel.Folder, error) {
+			record, err := singleRecord(result, folderNotFoundError(folderID))
+			if err != nil {
+				return nil, err
+			}
+
+			return mapRecordToFolder(record)
+		}
+}
+
+func getRootFolderIDQuery() (string, map[string]interface{}, func(result neo4j.Result) (*string, error)) {
+	return `MATCH (root:Folder {is_root: true})
+		RETURN root as folder`,
+		make(map[string]interface{}),
+		func(result neo4j.Result) (*string, error) {
+			record, err := singleRecord(result, errors.WithMessage(errors.New(ItemNotFound), "No root folder"))
+			if err != nil {
+				return nil, err
+			}
+			return mapRecordToFolderID(record)
+		}
+}
+
+// isRootFolderQuery:
+// It assumes you already have checked whether the folder exists or not
+func isRootFolderQuery(folderID string) (string, map[string]interface{}, func(result neo4j.Result) (*bool, error)) {
+	return `MATCH (folder:Folder {id: $folderID})
+		RETURN exists(folder.is_root) and folder.is_root = true`,
+		map[string]interface{}{
+			"folderID": folderID},
+		func(result neo4j.Result) (*bool, error) {
+			record, err := singleRecord(result, folderNotFoundError(folderID))
+			if err != nil {
+				return nil, err
+			}
+			isRoot := record.Values[0].(bool)
+			return &isRoot, nil
+		}
+}
+
+// isFolderInsideQuery:
+// It assumes you already have checked whether both folders exist or not
+func isFolderInsideQuery(folderID string, ancestorFolderID string) (string, map[string]interface{}, func(result neo4j.Result) (*bool, error)) {
+	return `MATCH (folder:Folder {id: $folderID})
+		MATCH (ancestor:Folder {id: $ancestorFolderID})
+		RETURN exists((folder)-[:IS_INSIDE *1..]->(ancestor))`,
+		map[string]interface{}{
+			"folderID":         folderID,
+			"ancestorFolderID": ancestorFolderID,
+		},
+		func(result neo4j.Result) (*bool, error) {
+			record, err := singleRecord(result, errors.WithMessage(errors.New(ItemNotFound), fmt.Sprintf("No folder with id %s or %s", folderID, ancestorFolderID)))
+			if err != nil {
+				return nil, err
+			}
+			isInside := record.Values[0].(bool)
+			return &isInside, nil
+		}
+}
+
+func existsFolderByIDQuery(folderID string, lock bool) (string, map[string]interface{}, func(result neo4j.Result) (*bool, error)) {
+	return `OPTIONAL MATCH (folder:Folder{id: $folderID})
+		` + lockClause("folder", lock) + `
+		RETURN folder IS NOT NULL AS exists`,
+		map[string]interface{}{
+			"folderID": folderID,
+		},
+		func(result neo4j.Result) (*bool, error) {
+			record, err := result.Single()
+			if err != nil {
+				return nil, err
+			}
+
+			exists, found := record.Get(dbExists)
+			if !found {
+				return nil, errors.New("Could not find 'exists' in folder exists response")
+			}
+
+			e := exists.(bool)
+			return &e, nil
+		}
+}
+
+// existsUntrashedQuery locks the item only: the ancestors are read, as the trashed flag of getFolderContentQuery is
+func existsUntrashedQuery(label string, itemID string, lock bool) (string, map[string]interface{}, func(result neo4j.Result) (*bool, error)) {
+	return fmt.Sprintf(`OPTIONAL MATCH (item:%s {id: $itemID})
+		`+lockClause("item", lock)+`
+		RETURN item IS NOT NULL AND NOT exists((item)-[:IS_INSIDE *0..]->(:Folder {id: $trashFolderID})) AS exists`, label),
+		map[string]interface{}{
+			"itemID":        itemID,
+			"trashFolderID": trashFolderID,
+		},
+		func(result neo4j.Result) (*bool, error) {
+			record, err := result.Single()
+			if err != nil {
+				return nil, err
+			}
+
+			exists, found := record.Get(dbExists)
+			if !found {
+				return nil, errors.New("Could not find 'exists' in untrashed exists response")
+			}
+
+			e := exists.(bool)
+			return &e, nil
+		}
+}
+
+func existsByIDOrLegacyIDQuery(label string, itemID string, legacyID int) (string, map[string]interface{}, func(result neo4j.Result) (*bool, error)) {
+	return fmt.Sprintf(`OPTIONAL MATCH (byID:%[1]s {id: $itemID})
+		OPTIONAL MATCH (byLegacyID:%[1]s {legacy_id: $legacyID})
+		RETURN byID IS NOT NULL OR byLegacyID IS NOT NULL AS exists`, label),
+		map[string]interface{}{
+			"itemID":   itemID,
+			"legacyID": neo4jLegacyID(legacyID),
+		},
+		func(result neo4j.Result) (*bool, error) {
+			record, err := result.Single()
+			if err != nil {
+				return nil, err
+			}
+
+			exists, found := record.Get(dbExists)
+			if !found {
+				return nil, errors.New("Could not find 'exists' in id exists response")
+			}
+
+			e := exists.(bool)
+			return &e, nil
+		}
+}
+
+func getIDByLegacyIDQuery(label string, legacyID int, notFoundErr error) (string, map[string]interface{}, func(result neo4j.Result) (*string, error)) {
+	return fmt.Sprintf(`MATCH (item:%s {legacy_id: $legacyID})
+		RETURN item.id AS id`, label),
+		map[string]interface{}{
+			"legacyID": neo4jLegacyID(legacyID),
+		},
+		func(result neo4j.Result) (*string, error) {
+			record, err := singleRecord(result, notFoundErr)
+			if err != nil {
+				return nil, err
+			}
+			id := record.Values[0].(string)
+			return &id, nil
+		}
+}
+
+func getFilesInFolderQuery(folderID string) (string, map[string]interface{}, func(result neo4j.Result) (*[]fsmodel.File, error)) {
+	return `MATCH (parentFolder:Folder{id: $folderID})
+	MATCH (file:File)-[:IS_INSIDE]->(parentFolder)
+	RETURN file, parentFolder.id AS parentID`,
+		map[string]interface{}{
+			"folderID": folderID,
+		}, mapResultToFiles
+}
+
+func getFoldersInFolderQuery(folderID string) (string, map[string]interface{}, func(result neo4j.Result) (*[]fsmodel.Folder, error)) {
+	return `MATCH (parentFolder:Folder{id: $folderID})
+	MATCH (folder:Folder)-[:IS_INSIDE]->(parentFolder)
+	RETURN folder, parentFolder.id AS parentID`,
+		map[string]interface{}{
+			"folderID": folderID,
+		},
+		mapResultToFolders
+}
+
+// getChildrenWithNamePrefixQuery only returns the names, ids and kinds of the children it finds
+func getChildrenWithNamePrefixQuery(folderID string, namePrefix string) (string, map[string]interface{}, func(result neo4j.Result) (*[]fsmodel.NamedChild, error)) {
+	return `MATCH (parentFolder:Folder {id: $folderID})
+	MATCH (child)-[:IS_INSIDE]->(parentFolder)
+	WHERE (child:Folder OR child:File) AND child.name STARTS WITH $namePrefix
+	RETURN CASE WHEN child:Folder THEN $folderKind ELSE $fileKind END AS kind, child.id AS id, child.name AS name`,
+		map[string]interface{}{
+			"folderID":   folderID,
+			"namePrefix": namePrefix,
+			"folderKind": string(fsmodel.FolderKind),
+			"fileKind":   string(fsmodel.FileKind),
+		},
+		mapResultToNamedChildren
+}
+
+// getFolderContentQuery returns the folder and a page of its children as a single record
+func getFolderContentQuery(folderID string, options fsmodel.ListOptions) (string, map[string]interface{}, func(result neo4j.Result) (*fsmodel.FolderContent, error)) {
+	queryMap := map[string]interface{}{
+		"folderID":      folderID,
+		"trashFolderID": trashFolderID,
+		"nameRegex":     globRegexp(options.NameGlob),
+		"extension":     options.Extension,
+		"limit":         fetchLimit(options),
+	}
+	foldersQuery := listChildrenQuery(fsmodel.FolderKind, listsFolders(options), options, queryMap, "folder, parent, trashed")
+	filesQuery := listChildrenQuery(fsmodel.FileKind, listsFiles(options), options, queryMap, "folder, parent, trashed, folders")
+
+	return `MATCH (folder:Folder {id: $folderID})
+	OPTIONAL MATCH (folder)-[:IS_INSIDE]->(parent:Folder)
+	WITH folder, parent, exists((folder)-[:IS_INSIDE *0..]->(:Folder {id: $trashFolderID})) AS trashed
+	` + foldersQuery + `
+	WITH folder, parent, trashed, collect(child) AS folders
+	` + filesQuery + `
+	RETURN folder, parent.id AS parentID, trashed, folders, collect(child) AS files,
+		[counted IN [folder] + folders | {
+			id: counted.id,
+			folders: size([(counted)<-[:IS_INSIDE]-(item:Folder) | item]),
+			files: size([(counted)<-[:IS_INSIDE]-(item:File) | item])
+		}] AS childCounts`,
+		queryMap,
+		func(result neo4j.Result) (*fsmodel.FolderContent, error) {
+			record, err := singleRecord(result, folderNotFoundError(folderID))
+			if err != nil {
+				return nil, err
+			}
+
+			content, err := mapRecordToFolderContent(record)
+			if err != nil {
+				return nil, err
+			}
+			content.Folders, content.Files, content.Next = pageOf(options, content.Folders, content.Files)
+			return content, nil
+		}
+}
+
+// listChildrenQuery matches the children of the kind as the options tell, one per row and in order, next to the
+// carried columns. The parameters of the position are added to the query map.
+func listChildrenQuery(kind fsmodel.ItemKind, listed bool, options fsmodel.ListOptions, queryMap map[string]interface{}, carried string) string {
+	conditions := []string{"true"}
+	if !listed {
+		conditions = []string{"false"}
+	}
+	if options.NameGlob != "" {
+		conditions = append(conditions, "child.name =~ $nameRegex")
+	}
+	if options.Extension != "" {
+		conditions = append(conditions, "child.extension = $extension")
+	}
+
+	expressions := make([]string, 0)
+	for _, name := range listKeyNames(options.Sort, kind) {
+		expressions = append(expressions, listKeyExpression("child.", name))
+	}
+	expressions = append(expressions, "child."+dbId)
+	comparison, direction := ">", "ASC"
+	if options.Descending {
+		comparison, direction = "<", "DESC"
+	}
+	if after := listedAfter(options, kind); after != nil {
+		params := make([]string, 0, len(expressions))
+		for i, key := range listKeys(options.Sort, kind, positionChild(*after)) {
+			queryMap[fmt.Sprintf("afterKey%d", i)] = key
+			params = append(params, fmt.Sprintf("$afterKey%d", i))
+		}
+		queryMap["afterID"] = after.Id
+		params = append(params, "$afterID")
+		conditions = append(conditions, keysetCondition(expressions, params, comparison))
+	}
+
+	orderBy := make([]string, 0, len(expressions))
+	for _, expression := range expressions {
+		orderBy = append(orderBy, expression+" "+direction)
+	}
+	limit := ""
+	if fetchLimit(options) > 0 {
+		limit = " LIMIT $limit"
+	}
+
+	label := "Folder"
+	if kind == fsmodel.FileKind {
+		label = "File"
+	}
+	return fmt.Sprintf(`OPTIONAL MATCH (child:%s)-[:IS_INSIDE]->(folder)
+	WHERE %s
+	WITH %s, child ORDER BY %s%s`,
+		label, strings.Join(conditions, " AND "), carried, strings.Join(orderBy, ", "), limit)
+}
+
+// keysetCondition selects the nodes which come after the values of the parameters, compared expression by
+// expression: k1 > $p1 OR (k1 = $p1 AND (k2 > $p2 OR ...))
+func keysetCondition(expressions []string, params []string, comparison string) string {
+	condition := fmt.Sprintf("%s %s %s", expressions[len(expressions)-1], comparison, params[len(params)-1])
+	for i := len(expressions) - 2; i >= 0; i-- {
+		condition = fmt.Sprintf("%[1]s %[2]s %[3]s OR (%[1]s = %[3]s AND (%[4]s))",
+			expressions[i], comparison, params[i], condition)
+	}
+	return "(" + condition + ")"
+}
+
+func createNewFileWithParentQuery(fileName string, content fsmodel.FileContent, parentFolderID string) (string, map[string]interface{}) {
+	return `MATCH (parentFolder:Folder{id: $parentFolderID})
+	CREATE (file:File { id: $fileID, name: $fileName, sort_name: $sortName, extension: $extension, blob_key: $blobKey, digest: $digest,
+		size: $size, content_type: $contentType, created_at: $createdAt, modified_at: $createdAt})
+	CREATE (file)-[:IS_INSIDE]->(parentFolder)
+	SET parentFolder.modified_at = $createdAt
+	WITH file
+	OPTIONAL MATCH (orphan:OrphanBlob {blob_key: $blobKey})
+	DELETE orphan
+	RETURN file.id AS fileID`,
+		map[string]interface{}{
+			"fileID":         newID(),
+			"fileName":       fileName,
+			"sortName":       naturalSortKey(fileName),
+			"extension":      fileExtension(fileName),
+			"blobKey":        content.BlobKey,
+			"digest":         content.Digest,
+			"size":           knownSize(content.Size),
+			"contentType":    content.ContentType,
+			"createdAt":      unixMillis(currentTime()),
+			"parentFolderID": parentFolderID,
+		}
+}
+
+// importFileWithParentQuery keeps the id and the legacy id of the file
+func importFileWithParentQuery(file fsmodel.File) (string, map[string]interface{}) {
+	return `MATCH (parentFolder:Folder{id: $parentFolderID})
+	CREATE (file:File { id: $fileID, legacy_id: $legacyID, name: $fileName, sort_name: $sortName, extension: $extension, blob_key: $blobKey, digest: $digest,
+		size: $size, content_type: $contentType, created_at: $createdAt, modified_at: $modifiedAt})
+	CREATE (file)-[:IS_INSIDE]->(parentFolder)
+	WITH file
+	OPTIONAL MATCH (orphan:OrphanBlob {blob_key: $blobKey})
+	DELETE orphan`,
+		map[string]interface{}{
+			"fileID":         file.Id,
+			"legacyID":       neo4jLegacyID(file.LegacyId),
+			"fileName":       file.Name,
+			"sortName":       naturalSortKey(file.Name),
+			"extension":      fileExtension(file.Name),
+			"blobKey":        file.BlobKey,
+			"digest":         file.Digest,
+			"size":           knownSize(file.Size),
+			"contentType":    file.ContentType,
+			"createdAt":      unixMillis(file.CreatedAt),
+			"modifiedAt":     unixMillis(file.ModifiedAt),
+			"parentFolderID": file.ParentId,
+		}
+}
+
+func createNewFolderWithParentQuery(folderName string, parentFolderID string) (string, map[string]interface{}) {
+	return `MATCH (parentFolder:Folder{id: $parentFolderID})
+	CREATE (folder:Folder { id: $folderID, name: $folderName, sort_name: $sortName, created_at: $createdAt, modified_at: $createdAt})
+	CREATE (folder)-[:IS_INSIDE]->(parentFolder)
+	SET parentFolder.modified_at = $createdAt
+	RETURN folder.id AS folderID`,
+		map[string]interface{}{
+			"folderID":       newID(),
+			"folderName":     folderName,
+			"sortName":       naturalSortKey(folderName),
+			"createdAt":      unixMillis(currentTime()),
+			"parentFolderID": parentFolderID,
+		}
+}
+
+// importFolderWithParentQuery keeps the id and the legacy id of the folder
+func importFolderWithParentQuery(folder fsmodel.Folder) (string, map[string]interface{}) {
+	return `MATCH (parentFolder:Folder{id: $parentFolderID})
+	CREATE (folder:Folder { id: $folderID, legacy_id: $legacyID, name: $folderName, sort_name: $sortName,
+		created_at: $createdAt, modified_at: $modifiedAt})
+	CREATE (folder)-[:IS_INSIDE]->(parentFolder)`,
+		map[string]interface{}{
+			"folderID":       folder.Id,
+			"legacyID":       neo4jLegacyID(folder.LegacyId),
+			"folderName":     folder.Name,
+			"sortName":       naturalSortKey(folder.Name),
+			"createdAt":      unixMillis(folder.CreatedAt),
+			"modifiedAt":     unixMillis(folder.ModifiedAt),
+			"parentFolderID": *folder.ParentId,
+		}
+}
+
+// Neo4j has no explicit lock: writing on a node takes its write lock until the end of the transaction
+func lockClause(variable string, lock bool) string {
+	if !lock {
+		return ""
+	}
+	return fmt.Sprintf("SET %[1]s._lock = true REMOVE %[1]s._lock", variable)
+}
+
+func lockAncestorsQuery(folderID string) (string, map[string]interface{}) {
+	return `OPTIONAL MATCH (folder:Folder {id: $folderID})-[:IS_INSIDE *0..]->(ancestor:Folder)
+	` + lockClause("ancestor", true) + `
+	RETURN collect(DISTINCT id(ancestor)) AS locked`,
+		map[string]interface{}{
+			"folderID": folderID,
+		}
+}
+
+func lockSubtreeQuery(folderID string) (string, map[string]interface{}) {
+	return `OPTIONAL MATCH (item)-[:IS_INSIDE *0..]->(folder:Folder {id: $folderID})
+	` + lockClause("item", true) + `
+	RETURN collect(DISTINCT id(item)) AS locked`,
+		map[string]interface{}{
+			"folderID": folderID,
+		}
+}
+
+// The name check queries lock the folder where the name is going to be used, by writing on it. Concurrent writes
+// of names into the same folder are then serialized until the end of the transaction.
+// A missing folder is no conflict: the write which follows the check matches nothing either.
+
+// nameConflictsInFolderQuery counts the items named name inside the folder, except the given folder and file
+// (noItemID for none) which are the ones being named
+func nameConflictsInFolderQuery(folderID string, name string, excludedFolderID string, excludedFileID string) (string, map[string]interface{}) {
+	return `OPTIONAL MATCH (folder:Folder {id: $folderID})
+	SET folder._lock = true
+	REMOVE folder._lock
+	WITH folder
+	OPTIONAL MATCH (item)-[:IS_INSIDE]->(folder)
+	WHERE item.name = $name
+		AND ((item:Folder AND item.id <> $excludedFolderID) OR (item:File AND item.id <> $excludedFileID))
+	RETURN count(item) AS conflicts`,
+		map[string]interface{}{
+			"folderID":         folderID,
+			"name":             name,
+			"excludedFolderID": excludedFolderID,
+			"excludedFileID":   excludedFileID,
+		}
+}
 
-			exists, found := record.Get(dbExists)
-			if !found {
-				return nil, errors.New("Could not find 'exists' in file exists response")
-			}
+// nameConflictsAmongSiblingsQuery counts the other items named name inside the folder of the item with the label
+func nameConflictsAmongSiblingsQuery(label string, itemID string, name string) (string, map[string]interface{}) {
+	return fmt.Sprintf(`OPTIONAL MATCH (named:%s {id: $itemID})
+	OPTIONAL MATCH (named)-[:IS_INSIDE]->(parent:Folder)
+	SET parent._lock = true
+	REMOVE parent._lock
+	WITH named, parent
+	OPTIONAL MATCH (item)-[:IS_INSIDE]->(parent)
+	WHERE item.name = $name
+		AND (item:Folder OR item:File)
+		AND item <> named
+	RETURN count(item) AS conflicts`, label),
+		map[string]interface{}{
+			"itemID": itemID,
+			"name":   name,
+		}
+}
 
-			e := exists.(bool)
-			return &e, nil
+// The writes below set modified_at on the folders whose direct children they add, remove or rename. SET on the null
+// of an OPTIONAL MATCH which found nothing, such as the parent of the root folder, does nothing.
+
+func updateFolderQuery(folderID string, folderName string) (string, map[string]interface{}) {
+	return `MATCH (folder:Folder {id: $folderID})
+	OPTIONAL MATCH (folder)-[:IS_INSIDE]->(parent:Folder)
+	SET folder.name = $folderName, folder.sort_name = $sortName, folder.modified_at = $modifiedAt, parent.modified_at = $modifiedAt`,
+		map[string]interface{}{
+			"folderID":   folderID,
+			"folderName": folderName,
+			"sortName":   naturalSortKey(folderName),
+			"modifiedAt": unixMillis(currentTime()),
 		}
 }
 
-func getFolderByIDQuery(folderID int) (string, map[string]interface{}, func(result neo4j.Result) (*fsmodel.Folder, error)) {
-	return `MATCH (folder:Folder{id: $folderID})
-		RETURN folder`,
+// updateFileQuery only modifies the parent when the file is renamed
+func updateFileQuery(fileID string, fileName string, contentType string) (string, map[string]interface{}) {
+	return `MATCH (file:File {id: $fileID})
+	OPTIONAL MATCH (file)-[:IS_INSIDE]->(parent:Folder)
+	SET parent.modified_at = CASE WHEN file.name <> $fileName THEN $modifiedAt ELSE parent.modified_at END
+	SET file.name = $fileName, file.sort_name = $sortName, file.extension = $extension,
+		file.content_type = CASE WHEN $contentType = '' THEN file.content_type ELSE $contentType END,
+		file.modified_at = $modifiedAt`,
 		map[string]interface{}{
-			"folderID": folderID,
-		},
-		func(result neo4j.Result) (*fsmodel.Folder, error) {
-			record, err := result.Single()
-			if err != nil {
-				return nil, err
-			}
+			"fileID":      fileID,
+			"fileName":    fileName,
+			"sortName":    naturalSortKey(fileName),
+			"extension":   fileExtension(fileName),
+			"contentType": contentType,
+			"modifiedAt":  unixMillis(currentTime()),
+		}
+}
 
-			return mapRecordToFolder(record)
+func moveFolderQuery(folderID string, destFolderID string, folderName string) (string, map[string]interface{}) {
+	return `MATCH (folder:Folder {id: $folderID})
+	MATCH (dest:Folder {id: $destFolderID})
+	WHERE folder <> dest AND NOT exists((dest)-[:IS_INSIDE *1..]->(folder))
+	OPTIONAL MATCH (folder)-[rel:IS_INSIDE]->(source:Folder)
+	SET source.modified_at = $modifiedAt
+	DELETE rel
+	CREATE (folder)-[:IS_INSIDE]->(dest)
+	SET folder.name = $folderName, folder.sort_name = $sortName, dest.modified_at = $modifiedAt`,
+		map[string]interface{}{
+			"folderID":     folderID,
+			"destFolderID": destFolderID,
+			"folderName":   folderName,
+			"sortName":     naturalSortKey(folderName),
+			"modifiedAt":   unixMillis(currentTime()),
+		}
+}
+
+func moveFileQuery(fileID string, destFolderID string, fileName string) (string, map[string]interface{}) {
+	return `MATCH (file:File {id: $fileID})
+	MATCH (dest:Folder{id: $destFolderID})
+	OPTIONAL MATCH (file)-[rel:IS_INSIDE]->(source:Folder)
+	SET source.modified_at = $modifiedAt
+	DELETE rel
+	CREATE (file)-[:IS_INSIDE]->(dest)
+	SET file.name = $fileName, file.sort_name = $sortName, file.extension = $extension, dest.modified_at = $modifiedAt`,
+		map[string]interface{}{
+			"fileID":       fileID,
+			"destFolderID": destFolderID,
+			"fileName":     fileName,
+			"sortName":     naturalSortKey(fileName),
+			"extension":    fileExtension(fileName),
+			"modifiedAt":   unixMillis(currentTime()),
+		}
+}
+
+// The content of the deleted files is recorded as orphan in the same transaction when no other file references it,
+// so that it is deleted from the blob store later on even if the API stops right after the graph delete.
+// Legacy files only have a path, whose base name is their blob key.
+const recordOrphanBlobKeysQuery = `UNWIND blobKeys AS blobKey
+	WITH DISTINCT blobKey
+	WHERE blobKey IS NOT NULL
+	OPTIONAL MATCH (other:File {blob_key: blobKey})
+	WITH blobKey, count(other) AS references
+	OPTIONAL MATCH (version:FileVersion {blob_key: blobKey})
+	WITH blobKey, references + count(version) AS references
+	WHERE references = 0
+	MERGE (:OrphanBlob {blob_key: blobKey})`
+
+func deleteFolderAndContentQuery(folderID string) (string, map[string]interface{}) {
+	return `MATCH (folder:Folder {id: $folderID})
+	OPTIONAL MATCH (folder)-[:IS_INSIDE]->(parent:Folder)
+	SET parent.modified_at = $modifiedAt
+	WITH folder
+	OPTIONAL MATCH (item)-[:IS_INSIDE *1..]->(folder)
+	WITH folder, collect(DISTINCT item) AS items
+	OPTIONAL MATCH (version:FileVersion)-[:VERSION_OF]->(versioned:File)
+	WHERE versioned IN items
+	WITH folder, items, collect(version) AS versions
+	WITH folder, items, versions,
+		[i IN items WHERE i:File | coalesce(i.blob_key, last(split(i.path, '/')))] + [v IN versions | v.blob_key] AS blobKeys
+	FOREACH (v IN versions | DETACH DELETE v)
+	FOREACH (i IN items | DETACH DELETE i)
+	DETACH DELETE folder
+	WITH blobKeys
+	` + recordOrphanBlobKeysQuery,
+		map[string]interface{}{
+			"folderID":   folderID,
+			"modifiedAt": unixMillis(currentTime()),
 		}
 }
 
-// createRootFolderIfNotExistsQuery:
-// use only if required since it increments the folder_id_sequence even if no root folder to create
-func createRootFolderIfNotExistsQuery() (string, map[string]interface{}) {
-	return `MATCH (seq:Sequence {key:'folder_id_sequence'})
-		CALL apoc.atomic.add(seq, 'value', 1, 5)
-		YIELD newValue as folder_id
-		
-		MERGE (root:Folder {is_root: true})
-			ON CREATE
-        		SET root.id = folder_id
-        		SET root.is_root = true
-        		SET root.name = 'Root folder'
-		RETURN root.id as folderID`,
-		make(map[string]interface{})
+func deleteFileQuery(fileID string) (string, map[string]interface{}) {
+	return `MATCH (file:File {id: $fileID})
+	OPTIONAL MATCH (file)-[:IS_INSIDE]->(parent:Folder)
+	SET parent.modified_at = $modifiedAt
+	WITH DISTINCT file
+	OPTIONAL MATCH (version:FileVersion)-[:VERSION_OF]->(file)
+	WITH file, collect(version) AS versions
+	WITH file, versions, [coalesce(file.blob_key, last(split(file.path, '/')))] + [v IN versions | v.blob_key] AS blobKeys
+	FOREACH (v IN versions | DETACH DELETE v)
+	DETACH DELETE file
+	WITH blobKeys
+	` + recordOrphanBlobKeysQuery,
+		map[string]interface{}{
+			"fileID":     fileID,
+			"modifiedAt": unixMillis(currentTime()),
+		}
 }
 
-func getRootFolderIDQuery() (string, map[string]interface{}, func(result neo4j.Result) (*int, error)) {
-	return `MATCH (root:Folder {is_root: true})
-		RETURN root as folder`,
+func getOrphanBlobKeysQuery() (string, map[string]interface{}, func(result neo4j.Result) (*[]string, error)) {
+	return `MATCH (orphan:OrphanBlob)
+	RETURN orphan.blob_key AS blobKey`,
 		make(map[string]interface{}),
-		func(result neo4j.Result) (*int, error) {
-			record, err := result.Single()
-			if err != nil {
-				return nil, err
+		func(result neo4j.Result) (*[]string, error) {
+			blobKeys := make([]string, 0)
+			for result.Next() {
+				blobKeys = append(blobKeys, result.Record().Values[0].(string))
 			}
-			return mapRecordToFolderID(record)
+			return &blobKeys, result.Err()
 		}
 }
 
-// isRootFolderQuery:
-// It assumes you already have checked whether the folder exists or not
-func isRootFolderQuery(folderID int) (string, map[string]interface{}, func(result neo4j.Result) (*bool, error)) {
-	return `MATCH (folder:Folder {id: $folderID})
-		RETURN exists(folder.is_root) and folder.is_root = true`,
+// isBlobReferencedQuery also counts the legacy files, which reference their content by path: see mapFilePropsToBlobKey
+func isBlobReferencedQuery(blobKey string) (string, map[string]interface{}, func(result neo4j.Result) (*bool, error)) {
+	return `OPTIONAL MATCH (file:File)
+	WHERE file.blob_key = $blobKey OR (file.blob_key IS NULL AND (file.path = $blobKey OR file.path ENDS WITH $pathSuffix))
+	WITH count(file) AS files
+	OPTIONAL MATCH (version:FileVersion {blob_key: $blobKey})
+	RETURN files + count(version) > 0 AS referenced`,
 		map[string]interface{}{
-			"folderID": folderID},
+			"blobKey":    blobKey,
+			"pathSuffix": "/" + blobKey,
+		},
 		func(result neo4j.Result) (*bool, error) {
 			record, err := result.Single()
 			if err != nil {
 				return nil, err
 			}
-			isRoot := record.Values[0].(bool)
-			return &isRoot, nil
+
+			referenced, found := record.Get(dbReferenced)
+			if !found {
+				return nil, errors.New("Could not find 'referenced' in blob referenced response")
+			}
+
+			r := referenced.(bool)
+			return &r, nil
 		}
 }
 
-func existsFolderByIDQuery(folderID int) (string, map[string]interface{}, func(result neo4j.Result) (*bool, error)) {
-	return `OPTIONAL MATCH (folder:Folder{id: $folderID})
-		RETURN folder IS NOT NULL AS exists`,
+// updateFileContentQuery records the content replaced as orphan when nothing references it anymore. A legacy file
+// loses its path: its content is referenced by blob key from now on.
+func updateFileContentQuery(fileID string, content fsmodel.FileContent) (string, map[string]interface{}) {
+	return `MATCH (file:File {id: $fileID})
+	WITH file, [coalesce(file.blob_key, last(split(file.path, '/')))] AS blobKeys
+	SET file.blob_key = $blobKey, file.digest = $digest, file.size = $size, file.content_type = $contentType,
+		file.modified_at = $modifiedAt
+	REMOVE file.path
+	WITH blobKeys
+	OPTIONAL MATCH (orphan:OrphanBlob {blob_key: $blobKey})
+	DELETE orphan
+	WITH DISTINCT blobKeys
+	` + recordOrphanBlobKeysQuery,
 		map[string]interface{}{
-			"folderID": folderID,
+			"fileID":      fileID,
+			"blobKey":     content.BlobKey,
+			"digest":      content.Digest,
+			"size":        knownSize(content.Size),
+			"contentType": content.ContentType,
+			"modifiedAt":  unixMillis(currentTime()),
+		}
+}
+
+func getFileVersionsQuery(fileID string) (string, map[string]interface{}, func(result neo4j.Result) (*[]fsmodel.FileVersion, error)) {
+	return `MATCH (version:FileVersion)-[:VERSION_OF]->(:File {id: $fileID})
+	RETURN version
+	ORDER BY version.number DESC`,
+		map[string]interface{}{
+			"fileID": fileID,
+		},
+		func(result neo4j.Result) (*[]fsmodel.FileVersion, error) {
+			versions := make([]fsmodel.FileVersion, 0)
+			for result.Next() {
+				version, err := mapRecordToFileVersion(result.Record())
+				if err != nil {
+					return nil, err
+				}
+				versions = append(versions, *version)
+			}
+			return &versions, result.Err()
+		}
+}
+
+func existsFileVersionQuery(fileID string, number int) (string, map[string]interface{}, func(result neo4j.Result) (*bool, error)) {
+	return `OPTIONAL MATCH (version:FileVersion {number: $number})-[:VERSION_OF]->(:File {id: $fileID})
+	RETURN version IS NOT NULL AS exists`,
+		map[string]interface{}{
+			"fileID": fileID,
+			"number": number,
 		},
 		func(result neo4j.Result) (*bool, error) {
 			record, err := result.Single()
@@ -492,7 +1772,7 @@ func existsFolderByIDQuery(folderID int) (string, map[string]interface{}, func(r
 
 			exists, found := record.Get(dbExists)
 			if !found {
-				return nil, errors.New("Could not find 'exists' in folder exists response")
+				return nil, errors.New("Could not find 'exists' in file version exists response")
 			}
 
 			e := exists.(bool)
@@ -500,103 +1780,124 @@ func existsFolderByIDQuery(folderID int) (string, map[string]interface{}, func(r
 		}
 }
 
-func getFilesInFolderQuery(folderID int) (string, map[string]interface{}, func(result neo4j.Result) (*[]fsmodel.File, error)) {
-	return `MATCH (parentFolder:Folder{id: $folderID})
-	MATCH (file:File)-[:IS_INSIDE]->(parentFolder)
-	RETURN file`,
-		map[string]interface{}{
-			"folderID": folderID,
-		}, mapResultToFiles
-}
-
-func getFoldersInFolderQuery(folderID int) (string, map[string]interface{}, func(result neo4j.Result) (*[]fsmodel.Folder, error)) {
-	return `MATCH (parentFolder:Folder{id: $folderID})
-	MATCH (folder:Folder)-[:IS_INSIDE]->(parentFolder)
-	RETURN folder`,
+func createFileVersionQuery(fileID string, version fsmodel.FileVersion) (string, map[string]interface{}) {
+	return `MATCH (file:File {id: $fileID})
+	CREATE (:FileVersion {number: $number, blob_key: $blobKey, digest: $digest, size: $size, content_type: $contentType,
+		created_at: $createdAt})-[:VERSION_OF]->(file)
+	WITH file
+	OPTIONAL MATCH (orphan:OrphanBlob {blob_key: $blobKey})
+	DELETE orphan`,
 		map[string]interface{}{
-			"folderID": folderID,
-		},
-		mapResultToFolders
+			"fileID":      fileID,
+			"number":      version.Number,
+			"blobKey":     version.BlobKey,
+			"digest":      version.Digest,
+			"size":        knownSize(version.Size),
+			"contentType": version.ContentType,
+			"createdAt":   unixMillis(version.CreatedAt),
+		}
 }
 
-func createNewFileWithParentQuery(fileName string, filePath string, parentFolderID int) (string, map[string]interface{}) {
-	return `MATCH (parentFolder:Folder{id: $parentFolderID})
-	MATCH (seq:Sequence {key:'file_id_sequence'})
-	CALL apoc.atomic.add(seq, 'value', 1, 5)
-	YIELD newValue as file_id
-	CREATE (file:File { id: file_id, name: $fileName, path: $filePath})
-	CREATE (file)-[:IS_INSIDE]->(parentFolder)
-	RETURN file.id AS fileID`,
+func deleteFileVersionQuery(fileID string, number int) (string, map[string]interface{}) {
+	return `MATCH (version:FileVersion {number: $number})-[:VERSION_OF]->(:File {id: $fileID})
+	WITH version, [version.blob_key] AS blobKeys
+	DETACH DELETE version
+	WITH blobKeys
+	` + recordOrphanBlobKeysQuery,
 		map[string]interface{}{
-			"fileName":       fileName,
-			"filePath":       filePath,
-			"parentFolderID": parentFolderID,
+			"fileID": fileID,
+			"number": number,
 		}
 }
 
-func createNewFolderWithParentQuery(folderName string, parentFolderID int) (string, map[string]interface{}) {
-	return `MATCH (parentFolder:Folder{id: $parentFolderID})
-	MATCH (seq:Sequence {key:'folder_id_sequence'})
-	CALL apoc.atomic.add(seq, 'value', 1, 5)
-	YIELD newValue as folder_id
-	CREATE (folder:Folder { id: folder_id, name: $folderName})
-	CREATE (folder)-[:IS_INSIDE]->(parentFolder)
-	RETURN folder.id AS folderID`,
+func getTrashFolderIDQuery() (string, map[string]interface{}, func(result neo4j.Result) (*string, error)) {
+	return `MATCH (trash:Folder {id: $trashFolderID})
+		RETURN trash as folder`,
 		map[string]interface{}{
-			"folderName":     folderName,
-			"parentFolderID": parentFolderID,
+			"trashFolderID": trashFolderID,
+		},
+		func(result neo4j.Result) (*string, error) {
+			record, err := singleRecord(result, errors.WithMessage(errors.New(ItemNotFound), "No trash folder"))
+			if err != nil {
+				return nil, err
+			}
+			return mapRecordToFolderID(record)
 		}
 }
 
-func updateFolderQuery(folderID int, folderName string) (string, map[string]interface{}) {
-	return `MATCH (folder:Folder {id: $folderID})
-	SET folder.name = $folderName`,
-		map[string]interface{}{
-			"folderID":   folderID,
-			"folderName": folderName,
+func getTrashEntriesQuery() (string, map[string]interface{}, func(result neo4j.Result) (*[]fsmodel.TrashEntry, error)) {
+	return `MATCH (entry:TrashEntry)
+	RETURN entry
+	ORDER BY entry.trashed_at DESC, entry.item_id`,
+		make(map[string]interface{}),
+		func(result neo4j.Result) (*[]fsmodel.TrashEntry, error) {
+			entries := make([]fsmodel.TrashEntry, 0)
+			for result.Next() {
+				entry, err := mapRecordToTrashEntry(result.Record())
+				if err != nil {
+					return nil, err
+				}
+				entries = append(entries, *entry)
+			}
+			return &entries, result.Err()
 		}
 }
 
-func moveFolderQuery(folderID int, destFolderID int) (string, map[string]interface{}) {
-	return `MATCH (folder:Folder {id: $folderID})
-	MATCH (dest:Folder {id: $destFolderID})
-	OPTIONAL MATCH (folder)-[rel:IS_INSIDE]->()
-	DELETE rel
-	CREATE (folder)-[:IS_INSIDE]->(dest)`,
+func existsTrashEntryQuery(itemID string) (string, map[string]interface{}, func(result neo4j.Result) (*bool, error)) {
+	return `OPTIONAL MATCH (entry:TrashEntry {item_id: $itemID})
+	RETURN entry IS NOT NULL AS exists`,
 		map[string]interface{}{
-			"folderID":     folderID,
-			"destFolderID": destFolderID,
+			"itemID": itemID,
+		},
+		func(result neo4j.Result) (*bool, error) {
+			record, err := result.Single()
+			if err != nil {
+				return nil, err
+			}
+
+			exists, found := record.Get(dbExists)
+			if !found {
+				return nil, errors.New("Could not find 'exists' in trash entry exists response")
+			}
+
+			e := exists.(bool)
+			return &e, nil
 		}
 }
 
-func moveFileQuery(fileID int, destFolderID int) (string, map[string]interface{}) {
-	return `MATCH (file:File {id: $fileID})
-	MATCH (dest:Folder{id: $destFolderID})
-	OPTIONAL MATCH (file)-[rel:IS_INSIDE]->()
-	DELETE rel
-	CREATE (file)-[:IS_INSIDE]->(dest)`,
+func createTrashEntryQuery(entry fsmodel.TrashEntry) (string, map[string]interface{}) {
+	return `CREATE (:TrashEntry {item_id: $itemID, kind: $kind, name: $name, parent_id: $parentID, trashed_at: $trashedAt})`,
 		map[string]interface{}{
-			"fileID":       fileID,
-			"destFolderID": destFolderID,
+			"itemID":    entry.ItemId,
+			"kind":      string(entry.Kind),
+			"name":      entry.Name,
+			"parentID":  entry.ParentId,
+			"trashedAt": unixMillis(entry.TrashedAt),
 		}
 }
 
-// TODO: Return the ids of all the deleted items?
-func deleteFolderAndContentQuery(folderID int) (string, map[string]interface{}) {
-	return `OPTIONAL MATCH (f)-[:IS_INSIDE *1..]->(Folder {id: $folderID})
-	OPTIONAL MATCH (folder:Folder {id: $folderID})
-	DETACH DELETE f, folder`,
+func deleteTrashEntryQuery(itemID string) (string, map[string]interface{}, func(result neo4j.Result) (*fsmodel.TrashEntry, error)) {
+	return `MATCH (entry:TrashEntry {item_id: $itemID})
+	WITH entry, properties(entry) AS props
+	DELETE entry
+	RETURN props AS entry`,
 		map[string]interface{}{
-			"folderID": folderID,
+			"itemID": itemID,
+		},
+		func(result neo4j.Result) (*fsmodel.TrashEntry, error) {
+			record, err := singleRecord(result, trashEntryNotFoundError(itemID))
+			if err != nil {
+				return nil, err
+			}
+			return mapRecordToTrashEntry(record)
 		}
 }
 
-// TODO Return the ids of all the deleted items?
-func deleteFileQuery(fileID int) (string, map[string]interface{}) {
-	return `MATCH (file:File {id: $fileID})
-	DETACH DELETE file`,
+func removeOrphanBlobKeyQuery(blobKey string) (string, map[string]interface{}) {
+	return `MATCH (orphan:OrphanBlob {blob_key: $blobKey})
+	DELETE orphan`,
 		map[string]interface{}{
-			"fileID": fileID,
+			"blobKey": blobKey,
 		}
 }
 
@@ -605,7 +1906,17 @@ func mapRecordToFile(record *neo4j.Record) (*fsmodel.File, error) {
 	if !found {
 		return nil, errors.New("Could not find 'file' inside the File record")
 	}
-	fileProps := (file.(dbtype.Node)).Props
+
+	parentID := ""
+	if dbParent, found := record.Get(dbParentID); found && dbParent != nil {
+		parentID = dbParent.(string)
+	}
+
+	return mapNodeToFile(file.(dbtype.Node), parentID)
+}
+
+func mapNodeToFile(file dbtype.Node, parentID string) (*fsmodel.File, error) {
+	fileProps := file.Props
 
 	id, found := fileProps[dbId]
 	if !found {
@@ -615,18 +1926,54 @@ func mapRecordToFile(record *neo4j.Record) (*fsmodel.File, error) {
 	if !found {
 		return nil, errors.New("Could not retrieve 'name' of the file result")
 	}
-	path, found := fileProps[dbPath]
+	blobKey, err := mapFilePropsToBlobKey(fileProps)
+	if err != nil {
+		return nil, err
+	}
+	digest, _ := fileProps[dbDigest].(string) // legacy files have no digest, nor the other metadata
+	legacyID, _ := fileProps[dbLegacyId].(int64)
+	contentType, _ := fileProps[dbType].(string)
+	size, found := fileProps[dbSize].(int64)
 	if !found {
-		return nil, errors.New("Could not retrieve 'name' of the file result")
+		size = fsmodel.UnknownSize
 	}
 
 	return &fsmodel.File{
-		Id:   int(id.(int64)),
-		Name: name.(string),
-		Path: path.(string),
+		Id:       id.(string),
+		LegacyId: int(legacyID),
+		Name:     name.(string),
+		ParentId: parentID,
+		FileContent: fsmodel.FileContent{
+			BlobKey:     blobKey,
+			Digest:      digest,
+			Size:        size,
+			ContentType: contentType,
+		},
+		CreatedAt:  neo4jTime(fileProps[dbCreated]),
+		ModifiedAt: neo4jTime(fileProps[dbModified]),
 	}, nil
 }
 
+// neo4jTime reads the milliseconds of a property, the zero time when the node has none
+func neo4jTime(millis interface{}) time.Time {
+	if millis, ok := millis.(int64); ok {
+		return fromUnixMillis(millis)
+	}
+	return time.Time{}
+}
+
+func mapFilePropsToBlobKey(fileProps map[string]interface{}) (string, error) {
+	if blobKey, found := fileProps[dbBlobKey]; found {
+		return blobKey.(string), nil
+	}
+
+	// legacy files were all written inside the default blob store directory
+	if path, found := fileProps[dbPath]; found {
+		return filepath.Base(path.(string)), nil
+	}
+	return "", errors.New("Could not retrieve 'blob_key' of the file result")
+}
+
 func mapResultToFiles(result neo4j.Result) (*[]fsmodel.File, error) {
 	var files []fsmodel.File
 	for result.Next() == true {
@@ -642,13 +1989,35 @@ func mapResultToFiles(result neo4j.Result) (*[]fsmodel.File, error) {
 	return &files, nil
 }
 
+func mapResultToNamedChildren(result neo4j.Result) (*[]fsmodel.NamedChild, error) {
+	children := make([]fsmodel.NamedChild, 0)
+	for result.Next() {
+		record := result.Record()
+		kind, _ := record.Get(dbKind)
+		id, _ := record.Get(dbId)
+		name, _ := record.Get(dbName)
+		children = append(children, fsmodel.NamedChild{Kind: fsmodel.ItemKind(kind.(string)), Id: id.(string), Name: name.(string)})
+	}
+	return &children, result.Err()
+}
+
 func mapRecordToFolder(record *neo4j.Record) (*fsmodel.Folder, error) {
 	folder, found := record.Get(dbFolder)
 	if !found {
 		return nil, errors.New("Could not find 'file' inside the Folder record")
 	}
 
-	folderProps := (folder.(dbtype.Node)).Props
+	var parentID *string
+	if dbParent, found := record.Get(dbParentID); found && dbParent != nil {
+		id := dbParent.(string)
+		parentID = &id
+	}
+
+	return mapNodeToFolder(folder.(dbtype.Node), parentID)
+}
+
+func mapNodeToFolder(folder dbtype.Node, parentID *string) (*fsmodel.Folder, error) {
+	folderProps := folder.Props
 	id, found := folderProps[dbId]
 	if !found {
 		return nil, errors.New("Could not retrieve 'id' of the Folder record")
@@ -657,14 +2026,68 @@ func mapRecordToFolder(record *neo4j.Record) (*fsmodel.Folder, error) {
 	if !found {
 		return nil, errors.New("Could not retrieve 'name' of the Folder record")
 	}
+	legacyID, _ := folderProps[dbLegacyId].(int64)
 
 	return &fsmodel.Folder{
-		Id:   int(id.(int64)),
-		Name: name.(string),
+		Id:         id.(string),
+		LegacyId:   int(legacyID),
+		Name:       name.(string),
+		ParentId:   parentID,
+		CreatedAt:  neo4jTime(folderProps[dbCreated]),
+		ModifiedAt: neo4jTime(folderProps[dbModified]),
+	}, nil
+}
+
+func mapRecordToFolderContent(record *neo4j.Record) (*fsmodel.FolderContent, error) {
+	folder, err := mapRecordToFolder(record)
+	if err != nil {
+		return nil, err
+	}
+
+	dbFolders, _ := record.Get(dbFolders)
+	folders := make([]fsmodel.Folder, 0)
+	for _, dbSubFolder := range dbFolders.([]interface{}) {
+		subFolder, err := mapNodeToFolder(dbSubFolder.(dbtype.Node), &folder.Id)
+		if err != nil {
+			return nil, err
+		}
+		folders = append(folders, *subFolder)
+	}
+
+	dbFiles, _ := record.Get(dbFiles)
+	files := make([]fsmodel.File, 0)
+	for _, dbFile := range dbFiles.([]interface{}) {
+		file, err := mapNodeToFile(dbFile.(dbtype.Node), folder.Id)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, *file)
+	}
+
+	dbChildCounts, _ := record.Get(dbCounts)
+	trashed, _ := record.Get(dbTrashed)
+	return &fsmodel.FolderContent{
+		Folder:      *folder,
+		Folders:     folders,
+		Files:       files,
+		ChildCounts: mapChildCounts(dbChildCounts.([]interface{})),
+		Trashed:     trashed.(bool),
 	}, nil
 }
 
-func mapRecordToFolderID(record *neo4j.Record) (*int, error) {
+func mapChildCounts(dbChildCounts []interface{}) map[string]fsmodel.ChildCounts {
+	childCounts := make(map[string]fsmodel.ChildCounts)
+	for _, dbChildCount := range dbChildCounts {
+		counts := dbChildCount.(map[string]interface{})
+		childCounts[counts[dbId].(string)] = fsmodel.ChildCounts{
+			Folders: int(counts[dbFolders].(int64)),
+			Files:   int(counts[dbFiles].(int64)),
+		}
+	}
+	return childCounts
+}
+
+func mapRecordToFolderID(record *neo4j.Record) (*string, error) {
 	folder, err := mapRecordToFolder(record)
 	if err != nil {
 		return nil, err
@@ -688,3 +2111,111 @@ func mapResultToFolders(result neo4j.Result) (*[]fsmodel.Folder, error) {
 
 	return &folders, nil
 }
+
+// neo4jLegacyID sets no property when there is no legacy id: the legacy ids are unique, the missing properties are not
+func neo4jLegacyID(legacyID int) interface{} {
+	if legacyID == 0 {
+		return nil
+	}
+	return legacyID
+}
+
+func mapRecordToFileVersion(record *neo4j.Record) (*fsmodel.FileVersion, error) {
+	version, found := record.Get(dbVersion)
+	if !found {
+		return nil, errors.New("Could not find 'version' inside the FileVersion record")
+	}
+
+	versionProps := version.(dbtype.Node).Props
+	number, found := versionProps[dbNumber].(int64)
+	if !found {
+		return nil, errors.New("Could not retrieve 'number' of the file version result")
+	}
+	size, found := versionProps[dbSize].(int64)
+	if !found {
+		size = fsmodel.UnknownSize
+	}
+	blobKey, _ := versionProps[dbBlobKey].(string)
+	digest, _ := versionProps[dbDigest].(string)
+	contentType, _ := versionProps[dbType].(string)
+	return &fsmodel.FileVersion{
+		Number:      int(number),
+		FileContent: fsmodel.FileContent{BlobKey: blobKey, Digest: digest, Size: size, ContentType: contentType},
+		CreatedAt:   neo4jTime(versionProps[dbCreated]),
+	}, nil
+}
+
+// mapRecordToTrashEntry reads the entry either as a node or as the map of its properties
+func mapRecordToTrashEntry(record *neo4j.Record) (*fsmodel.TrashEntry, error) {
+	entry, found := record.Get("entry")
+	if !found {
+		return nil, errors.New("Could not find 'entry' inside the TrashEntry record")
+	}
+
+	entryProps, isMap := entry.(map[string]interface{})
+	if !isMap {
+		entryProps = entry.(dbtype.Node).Props
+	}
+	return &fsmodel.TrashEntry{
+		ItemId:    entryProps[dbItemID].(string),
+		Kind:      fsmodel.ItemKind(entryProps[dbKind].(string)),
+		Name:      entryProps[dbName].(string),
+		ParentId:  entryProps[dbParent].(string),
+		TrashedAt: neo4jTime(entryProps[dbTrashedAt]),
+	}, nil
+}
+
+func folderNotFoundError(folderID string) error {
+	return errors.WithMessage(errors.New(ItemNotFound), fmt.Sprintf("No folder with id %s", folderID))
+}
+
+func fileNotFoundError(fileID string) error {
+	return errors.WithMessage(errors.New(ItemNotFound), fmt.Sprintf("No file with id %s", fileID))
+}
+
+func idAlreadyExistsError(kind string, id string, legacyID int) error {
+	return errors.WithMessage(errors.New(IdAlreadyExists), fmt.Sprintf("A %s with id %s or legacy id %d already exists", kind, id, legacyID))
+}
+
+func legacyFolderNotFoundError(legacyID int) error {
+	return errors.WithMessage(errors.New(ItemNotFound), fmt.Sprintf("No folder with legacy id %d", legacyID))
+}
+
+func legacyFileNotFoundError(legacyID int) error {
+	return errors.WithMessage(errors.New(ItemNotFound), fmt.Sprintf("No file with legacy id %d", legacyID))
+}
+
+// contextErrorOr returns the error of the context once it is done: the error of the operation is then a consequence
+// of it, such as a query interrupted or a transaction timed out by the database
+func contextErrorOr(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// rootFolderImportedError: every repository has its own root folder, the import of a folder without parent would
+// give it a second one
+func rootFolderImportedError(folderID string) error {
+	return errors.New(fmt.Sprintf("Folder %s has no parent: a root folder cannot be imported", folderID))
+}
+
+func nameAlreadyExistsError(folderID string, name string) error {
+	return errors.WithMessage(errors.New(NameAlreadyExists), fmt.Sprintf("An item named %s already exists inside folder %s", name, folderID))
+}
+
+func fileVersionNotFoundError(fileID string, number int) error {
+	return errors.WithMessage(errors.New(ItemNotFound), fmt.Sprintf("No version %d of file %s", number, fileID))
+}
+
+func fileVersionAlreadyExistsError(fileID string, number int) error {
+	return errors.WithMessage(errors.New(IdAlreadyExists), fmt.Sprintf("The file %s already has a version %d", fileID, number))
+}
+
+func trashEntryNotFoundError(itemID string) error {
+	return errors.WithMessage(errors.New(ItemNotFound), fmt.Sprintf("No trash entry for item %s", itemID))
+}
+
+func trashEntryAlreadyExistsError(itemID string) error {
+	return errors.WithMessage(errors.New(IdAlreadyExists), fmt.Sprintf("A trash entry for item %s already exists", itemID))
+}
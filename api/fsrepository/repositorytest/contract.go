@@ -0,0 +1,1570 @@
+// Package repositorytest holds the contract tests that every implementation of fsrepository.IFileSystemRepository
+// must pass.
+package repositorytest
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/loisfa/remote-file-system/api/fsmodel"
+	"github.com/loisfa/remote-file-system/api/fsrepository"
+	"github.com/pkg/errors"
+)
+
+// unknownID is the id of no folder and no file
+const unknownID = "ffffffff-ffff-7fff-bfff-ffffffffffff"
+
+// RunContractTests runs every contract test as a subtest. newRepository must return a repository holding the root
+// folder only. The import tests are skipped when the repository does not implement
+// fsrepository.IImportableRepository.
+func RunContractTests(t *testing.T, newRepository func(t *testing.T) fsrepository.IFileSystemRepository) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, repo fsrepository.IFileSystemRepository)
+	}{
+		{"RootFolder", testRootFolder},
+		{"CreateFolder", testCreateFolder},
+		{"CreateFile", testCreateFile},
+		{"ListsDirectChildrenOnly", testListsDirectChildrenOnly},
+		{"GetFolderContent", testGetFolderContent},
+		{"GetChildrenWithNamePrefix", testGetChildrenWithNamePrefix},
+		{"ListOrder", testListOrder},
+		{"ListFilters", testListFilters},
+		{"ListPages", testListPages},
+		{"ListBySizeAndModified", testListBySizeAndModified},
+		{"FolderModifiedAt", testFolderModifiedAt},
+		{"UpdateFolder", testUpdateFolder},
+		{"UpdateFile", testUpdateFile},
+		{"MoveFolder", testMoveFolder},
+		{"MoveFolderInsideItself", testMoveFolderInsideItself},
+		{"MoveFile", testMoveFile},
+		{"IsFolderInside", testIsFolderInside},
+		{"NameConflicts", testNameConflicts},
+		{"DeleteFolderAndContent", testDeleteFolderAndContent},
+		{"LockFolderSubtree", testLockFolderSubtree},
+		{"DeleteFile", testDeleteFile},
+		{"OrphanBlobKeys", testOrphanBlobKeys},
+		{"NotFound", testNotFound},
+		{"Transactions", testTransactions},
+		{"Context", testContext},
+		{"Import", testImport},
+		{"LegacyIDs", testLegacyIDs},
+		{"TrashFolder", testTrashFolder},
+		{"TrashEntries", testTrashEntries},
+		{"UpdateFileContent", testUpdateFileContent},
+		{"FileVersions", testFileVersions},
+		{"DeleteFileVersions", testDeleteFileVersions},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			test.test(t, newRepository(t))
+		})
+	}
+}
+
+func testRootFolder(t *testing.T, repo fsrepository.IFileSystemRepository) {
+	ctx := context.Background()
+	rootID := rootFolderID(t, repo)
+
+	isRoot, err := repo.IsRootFolder(ctx, rootID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !*isRoot {
+		t.Fatalf("folder %s is not the root folder", rootID)
+	}
+
+	root, err := repo.GetFolder(ctx, rootID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every repository gives its root folder the same id, so that the trees of two repositories line up
+	if root.Id != rootID || root.Id != "00000000-0000-0000-0000-000000000000" || root.LegacyId != 0 || root.ParentId != nil {
+		t.Fatalf("unexpected root folder %+v", *root)
+	}
+
+	assertFolderNames(t, repo, rootID)
+	assertFileNames(t, repo, rootID)
+
+	folderID := createFolder(t, repo, "folder", rootID)
+	if isRoot, err := repo.IsRootFolder(ctx, folderID); err != nil || *isRoot {
+		t.Fatalf("folder %s should not be the root folder (error: %v)", folderID, err)
+	}
+}
+
+func testCreateFolder(t *testing.T, repo fsrepository.IFileSystemRepository) {
+	ctx := context.Background()
+	rootID := rootFolderID(t, repo)
+
+	createdAfter := time.Now().Truncate(time.Millisecond)
+	folderID := createFolder(t, repo, "photos", rootID)
+	otherFolderID := createFolder(t, repo, "music", rootID)
+	if folderID == otherFolderID {
+		t.Fatalf("two folders got the same id %s", folderID)
+	}
+
+	folder, err := repo.GetFolder(ctx, folderID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if folder.Id != folderID || folder.LegacyId != 0 || folder.Name != "photos" || folder.ParentId == nil || *folder.ParentId != rootID {
+		t.Fatalf("unexpected folder %+v", *folder)
+	}
+	if folder.CreatedAt.Before(createdAfter) || folder.CreatedAt.After(time.Now()) || !folder.ModifiedAt.Equal(folder.CreatedAt) {
+		t.Fatalf("unexpected timestamps of the folder created after %v: %+v", createdAfter, *folder)
+	}
+	assertExistsFolder(t, repo, folderID, true)
+	assertFolderNames(t, repo, rootID, "music", "photos")
+}
+
+func testCreateFile(t *testing.T, repo fsrepository.IFileSystemRepository) {
+	ctx := context.Background()
+	rootID := rootFolderID(t, repo)
+	folderID := createFolder(t, repo, "documents", rootID)
+
+	content := fsmodel.FileContent{BlobKey: "sha256-0123", Digest: "0123", Size: 42, ContentType: "text/plain; charset=utf-8"}
+	createdAfter := time.Now().Truncate(time.Millisecond)
+	fileID := createFile(t, repo, "report.txt", content, folderID)
+	otherFileID := createFile(t, repo, "summary.txt", content, folderID)
+	if fileID == otherFileID {
+		t.Fatalf("two files got the same id %s", fileID)
+	}
+
+	file, err := repo.GetFile(ctx, fileID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.Id != fileID || file.LegacyId != 0 || file.Name != "report.txt" || file.ParentId != folderID || file.FileContent != content {
+		t.Fatalf("unexpected file %+v", *file)
+	}
+	if file.CreatedAt.Before(createdAfter) || file.CreatedAt.After(time.Now()) || !file.ModifiedAt.Equal(file.CreatedAt) {
+		t.Fatalf("unexpected timestamps of the file created after %v: %+v", createdAfter, *file)
+	}
+	assertExistsFile(t, repo, fileID, true)
+	assertFileNames(t, repo, folderID, "report.txt", "summary.txt")
+	assertFileNames(t, repo, rootID)
+
+	referenced, err := repo.IsBlobReferenced(ctx, content.BlobKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !*referenced {
+		t.Fatalf("the content of the file should be referenced")
+	}
+}
+
+func testListsDirectChildrenOnly(t *testing.T, repo fsrepository.IFileSystemRepository) {
+	ctx := context.Background()
+	rootID := rootFolderID(t, repo)
+	folderID := createFolder(t, repo, "folder", rootID)
+	subFolderID := createFolder(t, repo, "sub folder", folderID)
+	createFolder(t, repo, "sub sub folder", subFolderID)
+	createFile(t, repo, "file.txt", newContent("a"), folderID)
+	createFile(t, repo, "sub file.txt", newContent("b"), subFolderID)
+
+	assertFolderNames(t, repo, rootID, "folder")
+	assertFileNames(t, repo, rootID)
+	assertFolderNames(t, repo, folderID, "sub folder")
+	assertFileNames(t, repo, folderID, "file.txt")
+
+	files, err := repo.GetFilesIn(ctx, folderID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (*files)[0].ParentId != folderID {
+		t.Fatalf("the listed file has the wrong parent %s", (*files)[0].ParentId)
+	}
+	folders, err := repo.GetFoldersIn(ctx, folderID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (*folders)[0].ParentId == nil || *(*folders)[0].ParentId != folderID {
+		t.Fatalf("the listed folder has the wrong parent %v", (*folders)[0].ParentId)
+	}
+}
+
+func testGetChildrenWithNamePrefix(t *testing.T, repo fsrepository.IFileSystemRepository) {
+	rootID := rootFolderID(t, repo)
+	folderID := createFolder(t, repo, "folder", rootID)
+	otherFolderID := createFolder(t, repo, "other folder", rootID)
+	reportID := createFolder(t, repo, "report", folderID)
+	createFolder(t, repo, "report 2", folderID)
+	createFolder(t, repo, "Reports", folderID)
+	reportFileID := createFile(t, repo, "report.txt", newContent("a"), folderID)
+	createFile(t, repo, "report (1).txt", newContent("b"), folderID)
+	createFile(t, repo, "report 23.txt", newContent("c"), folderID)
+	createFile(t, repo, "repor.txt", newContent("d"), folderID)
+	createFile(t, repo, "été.txt", newContent("e"), folderID)
+	createFile(t, repo, "report.txt", newContent("f"), otherFolderID)
+
+	children := assertChildrenWithNamePrefix(t, repo, folderID, "report", "report", "report 2", "report.txt", "report (1).txt", "report 23.txt")
+	for _, child := range children {
+		if child.Name == "report" && (child.Kind != fsmodel.FolderKind || child.Id != reportID) ||
+			child.Name == "report.txt" && (child.Kind != fsmodel.FileKind || child.Id != reportFileID) {
+			t.Fatalf("unexpected child %+v", child)
+		}
+	}
+	// the names may carry on the number the prefix ends with
+	assertChildrenWithNamePrefix(t, repo, folderID, "report 2", "report 2", "report 23.txt")
+	assertChildrenWithNamePrefix(t, repo, folderID, "report (", "report (1).txt")
+	assertChildrenWithNamePrefix(t, repo, folderID, "ét", "été.txt")
+	assertChildrenWithNamePrefix(t, repo, folderID, "",
+		"report", "report 2", "Reports", "report.txt", "report (1).txt", "report 23.txt", "repor.txt", "été.txt")
+	assertChildrenWithNamePrefix(t, repo, folderID, "reports")
+	assertChildrenWithNamePrefix(t, repo, reportID, "report")
+}
+
+func testGetFolderContent(t *testing.T, repo fsrepository.IFileSystemRepository) {
+	ctx := context.Background()
+	rootID := rootFolderID(t, repo)
+	folderID := createFolder(t, repo, "folder", rootID)
+	subFolderID := createFolder(t, repo, "sub folder", folderID)
+	createFolder(t, repo, "sub sub folder", subFolderID)
+	fileID := createFile(t, repo, "file.txt", newContent("a"), folderID)
+	createFile(t, repo, "sub file.txt", newContent("b"), subFolderID)
+
+	content, err := repo.GetFolderContent(ctx, folderID, fsmodel.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content.Folder.Id != folderID || content.Folder.Name != "folder" || content.Folder.ParentId == nil || *content.Folder.ParentId != rootID {
+		t.Fatalf("unexpected folder %+v", content.Folder)
+	}
+	if len(content.Folders) != 1 || content.Folders[0].Id != subFolderID || content.Folders[0].Name != "sub folder" ||
+		content.Folders[0].ParentId == nil || *content.Folders[0].ParentId != folderID {
+		t.Fatalf("unexpected folders %+v", content.Folders)
+	}
+	file, err := repo.GetFile(ctx, fileID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(content.Files) != 1 || content.Files[0] != *file {
+		t.Fatalf("unexpected files %+v", content.Files)
+	}
+	// the counts are the ones of the direct children
+	if content.ChildCounts[folderID] != (fsmodel.ChildCounts{Folders: 1, Files: 1}) ||
+		content.ChildCounts[subFolderID] != (fsmodel.ChildCounts{Folders: 1, Files: 1}) {
+		t.Fatalf("unexpected child counts %+v", content.ChildCounts)
+	}
+
+	root, err := repo.GetFolderContent(ctx, rootID, fsmodel.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.Folder.ParentId != nil || len(root.Folders) != 1 || len(root.Files) != 0 || root.Next != nil {
+		t.Fatalf("unexpected root folder content %+v", *root)
+	}
+	if root.ChildCounts[rootID] != (fsmodel.ChildCounts{Folders: 1}) || root.ChildCounts[folderID] != (fsmodel.ChildCounts{Folders: 1, Files: 1}) {
+		t.Fatalf("unexpected child counts of the root folder content %+v", root.ChildCounts)
+	}
+
+	// all the children are counted, not only the ones of the page
+	createFile(t, repo, "other file.txt", newContent("c"), folderID)
+	page, err := repo.GetFolderContent(ctx, folderID, fsmodel.ListOptions{Kind: fsmodel.FileKind, Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Files) != 1 || page.ChildCounts[folderID] != (fsmodel.ChildCounts{Folders: 1, Files: 2}) {
+		t.Fatalf("unexpected page %+v", *page)
+	}
+
+	_, err = repo.GetFolderContent(ctx, unknownID, fsmodel.ListOptions{})
+	assertErrorCode(t, err, fsrepository.ItemNotFound)
+}
+
+func testListOrder(t *testing.T, repo fsrepository.IFileSystemRepository) {
+	ctx := context.Background()
+	folderID := createFolder(t, repo, "folder", rootFolderID(t, repo))
+	createFolder(t, repo, "b 10", folderID)
+	createFolder(t, repo, "B 2", folderID)
+	renamedID := createFolder(t, repo, "a", folderID)
+	createFile(t, repo, "photo 10.jpg", newContent("a"), folderID)
+	createFile(t, repo, "photo 2.png", newContent("b"), folderID)
+	createFile(t, repo, "notes.TXT", newContent("c"), folderID)
+	createFile(t, repo, "README", newContent("d"), folderID)
+
+	assertListedNames(t, repo, folderID, fsmodel.ListOptions{},
+		"a", "B 2", "b 10", "notes.TXT", "photo 2.png", "photo 10.jpg", "README")
+	assertListedNames(t, repo, folderID, fsmodel.ListOptions{Sort: fsmodel.SortByName, Descending: true},
+		"b 10", "B 2", "a", "README", "photo 10.jpg", "photo 2.png", "notes.TXT")
+	assertListedNames(t, repo, folderID, fsmodel.ListOptions{Sort: fsmodel.SortByType},
+		"a", "B 2", "b 10", "README", "photo 10.jpg", "photo 2.png", "notes.TXT")
+	assertListedNames(t, repo, folderID, fsmodel.ListOptions{Sort: fsmodel.SortByType, Descending: true},
+		"b 10", "B 2", "a", "notes.TXT", "photo 2.png", "photo 10.jpg", "README")
+
+	// the order follows the renamed and moved children
+	if err := repo.UpdateFolder(ctx, renamedID, "c"); err != nil {
+		t.Fatal(err)
+	}
+	movedID := createFile(t, repo, "moved.txt", newContent("e"), rootFolderID(t, repo))
+	if err := repo.MoveFile(ctx, movedID, folderID, "photo 3.gif"); err != nil {
+		t.Fatal(err)
+	}
+	assertListedNames(t, repo, folderID, fsmodel.ListOptions{},
+		"B 2", "b 10", "c", "notes.TXT", "photo 2.png", "photo 3.gif", "photo 10.jpg", "README")
+	assertListedNames(t, repo, folderID, fsmodel.ListOptions{Sort: fsmodel.SortByType},
+		"B 2", "b 10", "c", "README", "photo 3.gif", "photo 10.jpg", "photo 2.png", "notes.TXT")
+}
+
+func testListFilters(t *testing.T, repo fsrepository.IFileSystemRepository) {
+	folderID := createFolder(t, repo, "folder", rootFolderID(t, repo))
+	createFolder(t, repo, "photos", folderID)
+	createFolder(t, repo, "archive.txt", folderID)
+	createFile(t, repo, "photo 1.jpg", newContent("a"), folderID)
+	createFile(t, repo, "photo 2.JPG", newContent("b"), folderID)
+	createFile(t, repo, "notes.txt", newContent("c"), folderID)
+	createFile(t, repo, "100%_[x].txt", newContent("d"), folderID)
+	createFile(t, repo, "100ab[x].txt", newContent("e"), folderID)
+	createFile(t, repo, "100ab\\x.txt", newContent("f"), folderID)
+
+	assertListedNames(t, repo, folderID, fsmodel.ListOptions{Kind: fsmodel.FolderKind}, "archive.txt", "photos")
+	assertListedNames(t, repo, folderID, fsmodel.ListOptions{Kind: fsmodel.FileKind},
+		"100%_[x].txt", "100ab[x].txt", "100ab\\x.txt", "notes.txt", "photo 1.jpg", "photo 2.JPG")
+
+	// the extension matches whatever its case, and never a folder
+	assertListedNames(t, repo, folderID, fsmodel.ListOptions{Extension: "jpg"}, "photo 1.jpg", "photo 2.JPG")
+	assertListedNames(t, repo, folderID, fsmodel.ListOptions{Extension: "jpg", Kind: fsmodel.FolderKind})
+
+	// the name glob matches the whole name, case sensitive, and the folders too
+	assertListedNames(t, repo, folderID, fsmodel.ListOptions{NameGlob: "photo*"}, "photos", "photo 1.jpg", "photo 2.JPG")
+	assertListedNames(t, repo, folderID, fsmodel.ListOptions{NameGlob: "*.jpg"}, "photo 1.jpg")
+	assertListedNames(t, repo, folderID, fsmodel.ListOptions{NameGlob: "photo ?.*"}, "photo 1.jpg", "photo 2.JPG")
+	assertListedNames(t, repo, folderID, fsmodel.ListOptions{NameGlob: "notes"})
+	assertListedNames(t, repo, folderID, fsmodel.ListOptions{NameGlob: "*.txt", Kind: fsmodel.FolderKind}, "archive.txt")
+
+	// any other character of the glob stands for itself
+	assertListedNames(t, repo, folderID, fsmodel.ListOptions{NameGlob: "100%_[x]*"}, "100%_[x].txt")
+	assertListedNames(t, repo, folderID, fsmodel.ListOptions{NameGlob: "*\\x*"}, "100ab\\x.txt")
+
+	assertListedNames(t, repo, folderID, fsmodel.ListOptions{NameGlob: "*.txt", Extension: "txt", Sort: fsmodel.SortByType, Descending: true},
+		"notes.txt", "100ab\\x.txt", "100ab[x].txt", "100%_[x].txt")
+}
+
+func testListPages(t *testing.T, repo fsrepository.IFileSystemRepository) {
+	ctx := context.Background()
+	folderID := createFolder(t, repo, "folder", rootFolderID(t, repo))
+	firstFolderID := createFolder(t, repo, "folder 1", folderID)
+	createFolder(t, repo, "folder 2", folderID)
+	lastFolderID := createFolder(t, repo, "folder 3", folderID)
+	createFile(t, repo, "file 1", newContent("a"), folderID)
+	deletedID := createFile(t, repo, "file 2", newContent("b"), folderID)
+	createFile(t, repo, "file 3", newContent("c"), folderID)
+
+	// the pages go through the folders, then the files
+	options := fsmodel.ListOptions{Limit: 2}
+	options.After = assertListedPage(t, repo, folderID, options, true, "folder 1", "folder 2")
+	options.After = assertListedPage(t, repo, folderID, options, true, "folder 3", "file 1")
+	// the page follows its position even though the child at it was deleted
+	if err := repo.DeleteFile(ctx, deletedID); err != nil {
+		t.Fatal(err)
+	}
+	options.After = &fsmodel.ListPosition{Kind: fsmodel.FileKind, Name: "file 2", Id: deletedID}
+	assertListedPage(t, repo, folderID, options, false, "file 3")
+
+	// a page which ends with the last folder is followed by the files
+	options = fsmodel.ListOptions{Limit: 3}
+	options.After = assertListedPage(t, repo, folderID, options, true, "folder 1", "folder 2", "folder 3")
+	if *options.After != (fsmodel.ListPosition{Kind: fsmodel.FolderKind, Name: "folder 3", Id: lastFolderID}) {
+		t.Fatalf("unexpected position %+v", *options.After)
+	}
+	assertListedPage(t, repo, folderID, options, false, "file 1", "file 3")
+
+	options = fsmodel.ListOptions{Limit: 2, Descending: true}
+	options.After = assertListedPage(t, repo, folderID, options, true, "folder 3", "folder 2")
+	options.After = assertListedPage(t, repo, folderID, options, true, "folder 1", "file 3")
+	assertListedPage(t, repo, folderID, options, false, "file 1")
+
+	// the children with the same sort key are paged through by id, whatever the order they were created in
+	sameKeyID := createFolder(t, repo, "Folder 1", folderID)
+	firstName, secondName, secondID := "folder 1", "Folder 1", sameKeyID
+	if sameKeyID < firstFolderID {
+		firstName, secondName, secondID = "Folder 1", "folder 1", firstFolderID
+	}
+	options = fsmodel.ListOptions{Limit: 1, Kind: fsmodel.FolderKind}
+	options.After = assertListedPage(t, repo, folderID, options, true, firstName)
+	options.After = assertListedPage(t, repo, folderID, options, true, secondName)
+	if options.After.Id != secondID {
+		t.Fatalf("unexpected position %+v", *options.After)
+	}
+	options.After = assertListedPage(t, repo, folderID, options, true, "folder 2")
+	assertListedPage(t, repo, folderID, options, false, "folder 3")
+}
+
+func testListBySizeAndModified(t *testing.T, repo fsrepository.IFileSystemRepository) {
+	ctx := context.Background()
+	folderID := createFolder(t, repo, "folder", rootFolderID(t, repo))
+	createFolder(t, repo, "b", folderID)
+	nextMillisecond()
+	createFolder(t, repo, "a", folderID)
+	renamedID := createFile(t, repo, "small.txt", newContent("x"), folderID)
+	nextMillisecond()
+	createFile(t, repo, "big.txt", newContent("xyz"), folderID)
+	nextMillisecond()
+	createFile(t, repo, "medium.txt", newContent("xy"), folderID)
+	nextMillisecond()
+	createFile(t, repo, "same.txt", newContent("y"), folderID)
+	// renaming modifies the file
+	nextMillisecond()
+	if err := repo.UpdateFile(ctx, renamedID, "tiny.txt", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// the folders have no size: by name
+	assertListedNames(t, repo, folderID, fsmodel.ListOptions{Sort: fsmodel.SortBySize},
+		"a", "b", "same.txt", "tiny.txt", "medium.txt", "big.txt")
+	assertListedNames(t, repo, folderID, fsmodel.ListOptions{Sort: fsmodel.SortBySize, Descending: true},
+		"b", "a", "big.txt", "medium.txt", "tiny.txt", "same.txt")
+	assertListedNames(t, repo, folderID, fsmodel.ListOptions{Sort: fsmodel.SortByModified},
+		"b", "a", "big.txt", "medium.txt", "same.txt", "tiny.txt")
+	assertListedNames(t, repo, folderID, fsmodel.ListOptions{Sort: fsmodel.SortByModified, Descending: true},
+		"a", "b", "tiny.txt", "same.txt", "medium.txt", "big.txt")
+
+	// the positions carry the size or the modification the page resumes from
+	options := fsmodel.ListOptions{Sort: fsmodel.SortBySize, Limit: 2}
+	options.After = assertListedPage(t, repo, folderID, options, true, "a", "b")
+	options.After = assertListedPage(t, repo, folderID, options, true, "same.txt", "tiny.txt")
+	if options.After.Size != 1 || !options.After.ModifiedAt.IsZero() {
+		t.Fatalf("unexpected position %+v", *options.After)
+	}
+	assertListedPage(t, repo, folderID, options, false, "medium.txt", "big.txt")
+
+	options = fsmodel.ListOptions{Sort: fsmodel.SortByModified, Descending: true, Limit: 3}
+	options.After = assertListedPage(t, repo, folderID, options, true, "a", "b", "tiny.txt")
+	renamed, err := repo.GetFile(ctx, renamedID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options.After.Size != 0 || !options.After.ModifiedAt.Equal(renamed.ModifiedAt) {
+		t.Fatalf("unexpected position %+v", *options.After)
+	}
+	assertListedPage(t, repo, folderID, options, false, "same.txt", "medium.txt", "big.txt")
+}
+
+func testFolderModifiedAt(t *testing.T, repo fsrepository.IFileSystemRepository) {
+	ctx := context.Background()
+	rootID := rootFolderID(t, repo)
+	folderID := createFolder(t, repo, "folder", rootID)
+	otherFolderID := createFolder(t, repo, "other folder", rootID)
+	subFolderID := createFolder(t, repo, "sub folder", folderID)
+	fileID := createFile(t, repo, "file.txt", newContent("a"), folderID)
+
+	since := nextMillisecond()
+	createFile(t, repo, "created.txt", newContent("b"), otherFolderID)
+	assertModifiedSince(t, repo, otherFolderID, since, true)
+	assertModifiedSince(t, repo, folderID, since, false)
+	assertModifiedSince(t, repo, rootID, since, false)
+
+	// renaming a folder modifies it and its parent
+	since = nextMillisecond()
+	if err := repo.UpdateFolder(ctx, subFolderID, "renamed"); err != nil {
+		t.Fatal(err)
+	}
+	assertModifiedSince(t, repo, subFolderID, since, true)
+	assertModifiedSince(t, repo, folderID, since, true)
+	assertModifiedSince(t, repo, rootID, since, false)
+
+	// a move modifies both parents, not the moved item
+	since = nextMillisecond()
+	if err := repo.MoveFolder(ctx, subFolderID, otherFolderID, "moved"); err != nil {
+		t.Fatal(err)
+	}
+	assertModifiedSince(t, repo, folderID, since, true)
+	assertModifiedSince(t, repo, otherFolderID, since, true)
+	assertModifiedSince(t, repo, subFolderID, since, false)
+	assertModifiedSince(t, repo, rootID, since, false)
+
+	since = nextMillisecond()
+	if err := repo.MoveFile(ctx, fileID, otherFolderID, "moved.txt"); err != nil {
+		t.Fatal(err)
+	}
+	assertModifiedSince(t, repo, folderID, since, true)
+	assertModifiedSince(t, repo, otherFolderID, since, true)
+
+	since = nextMillisecond()
+	if err := repo.DeleteFile(ctx, fileID); err != nil {
+		t.Fatal(err)
+	}
+	assertModifiedSince(t, repo, otherFolderID, since, true)
+	assertModifiedSince(t, repo, folderID, since, false)
+
+	since = nextMillisecond()
+	if err := repo.DeleteFolderAndContent(ctx, subFolderID); err != nil {
+		t.Fatal(err)
+	}
+	assertModifiedSince(t, repo, otherFolderID, since, true)
+	assertModifiedSince(t, repo, rootID, since, false)
+
+	since = nextMillisecond()
+	createFolder(t, repo, "created", folderID)
+	assertModifiedSince(t, repo, folderID, since, true)
+	assertModifiedSince(t, repo, rootID, since, false)
+}
+
+func testUpdateFolder(t *testing.T, repo fsrepository.IFileSystemRepository) {
+	ctx := context.Background()
+	rootID := rootFolderID(t, repo)
+	folderID := createFolder(t, repo, "old name", rootID)
+
+	if err := repo.UpdateFolder(ctx, folderID, "new name"); err != nil {
+		t.Fatal(err)
+	}
+	assertFolderNames(t, repo, rootID, "new name")
+
+	// renaming a folder to its own name is no conflict
+	if err := repo.UpdateFolder(ctx, folderID, "new name"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.UpdateFolder(ctx, rootID, "new root name"); err != nil {
+		t.Fatal(err)
+	}
+	root, err := repo.GetFolder(ctx, rootID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.Name != "new root name" {
+		t.Fatalf("the root folder was not renamed: %q", root.Name)
+	}
+}
+
+func testUpdateFile(t *testing.T, repo fsrepository.IFileSystemRepository) {
+	ctx := context.Background()
+	rootID := rootFolderID(t, repo)
+	folderID := createFolder(t, repo, "documents", rootID)
+	createFolder(t, repo, "drafts", folderID)
+	fileID := createFile(t, repo, "report.txt", newContent("a"), folderID)
+	createFile(t, repo, "summary.txt", newContent("b"), folderID)
+	created, err := repo.GetFile(ctx, fileID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// renaming modifies the file and its folder, and keeps the content type when none is given
+	since := nextMillisecond()
+	if err := repo.UpdateFile(ctx, fileID, "report.md", ""); err != nil {
+		t.Fatal(err)
+	}
+	file, err := repo.GetFile(ctx, fileID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.Name != "report.md" || file.ParentId != folderID || file.FileContent != created.FileContent ||
+		!file.CreatedAt.Equal(created.CreatedAt) || file.ModifiedAt.Before(since) {
+		t.Fatalf("unexpected renamed file %+v", *file)
+	}
+	assertModifiedSince(t, repo, folderID, since, true)
+	assertFileNames(t, repo, folderID, "report.md", "summary.txt")
+	// the listings follow the new name and extension
+	assertListedNames(t, repo, folderID, fsmodel.ListOptions{Extension: "md"}, "report.md")
+
+	// replacing the content type only modifies the file
+	since = nextMillisecond()
+	if err := repo.UpdateFile(ctx, fileID, "report.md", "text/markdown"); err != nil {
+		t.Fatal(err)
+	}
+	file, err = repo.GetFile(ctx, fileID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.Name != "report.md" || file.ContentType != "text/markdown" || file.BlobKey != created.BlobKey || file.ModifiedAt.Before(since) {
+		t.Fatalf("unexpected updated file %+v", *file)
+	}
+	assertModifiedSince(t, repo, folderID, since, false)
+
+	assertErrorCode(t, repo.UpdateFile(ctx, fileID, "summary.txt", ""), fsrepository.NameAlreadyExists)
+	assertErrorCode(t, repo.UpdateFile(ctx, fileID, "drafts", ""), fsrepository.NameAlreadyExists)
+	assertFileNames(t, repo, folderID, "report.md", "summary.txt")
+}
+
+func testMoveFolder(t *testing.T, repo fsrepository.IFileSystemRepository) {
+	ctx := context.Background()
+	rootID := rootFolderID(t, repo)
+	sourceID := createFolder(t, repo, "source", rootID)
+	destID := createFolder(t, repo, "dest", rootID)
+	movedID := createFolder(t, repo, "moved", sourceID)
+	childID := createFolder(t, repo, "child", movedID)
+	createFile(t, repo, "file.txt", newContent("a"), movedID)
+
+	if err := repo.MoveFolder(ctx, movedID, destID, "renamed"); err != nil {
+		t.Fatal(err)
+	}
+
+	assertFolderNames(t, repo, sourceID)
+	assertFolderNames(t, repo, destID, "renamed")
+	moved, err := repo.GetFolder(ctx, movedID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved.ParentId == nil || *moved.ParentId != destID {
+		t.Fatalf("the moved folder has the wrong parent %v", moved.ParentId)
+	}
+
+	// the content follows the folder
+	assertFolderNames(t, repo, movedID, "child")
+	assertFileNames(t, repo, movedID, "file.txt")
+	assertIsFolderInside(t, repo, childID, destID, true)
+	assertIsFolderInside(t, repo, childID, sourceID, false)
+
+	// moving a folder into its own parent is no conflict
+	if err := repo.MoveFolder(ctx, movedID, destID, "renamed"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testMoveFolderInsideItself(t *testing.T, repo fsrepository.IFileSystemRepository) {
+	ctx := context.Background()
+	rootID := rootFolderID(t, repo)
+	folderID := createFolder(t, repo, "folder", rootID)
+	childID := createFolder(t, repo, "child", folderID)
+	grandChildID := createFolder(t, repo, "grand child", childID)
+
+	for _, destID := range []string{folderID, childID, grandChildID} {
+		err := repo.MoveFolder(ctx, folderID, destID, "folder")
+		assertErrorCode(t, err, fsrepository.FolderMovedInsideItself)
+	}
+
+	folder, err := repo.GetFolder(ctx, folderID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if folder.ParentId == nil || *folder.ParentId != rootID {
+		t.Fatalf("the folder should not have moved, parent %v", folder.ParentId)
+	}
+	assertIsFolderInside(t, repo, grandChildID, folderID, true)
+}
+
+func testMoveFile(t *testing.T, repo fsrepository.IFileSystemRepository) {
+	ctx := context.Background()
+	rootID := rootFolderID(t, repo)
+	destID := createFolder(t, repo, "dest", rootID)
+	content := newContent("a")
+	fileID := createFile(t, repo, "file.txt", content, rootID)
+
+	if err := repo.MoveFile(ctx, fileID, destID, "renamed.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	assertFileNames(t, repo, rootID)
+	assertFileNames(t, repo, destID, "renamed.txt")
+	file, err := repo.GetFile(ctx, fileID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.ParentId != destID || file.FileContent != content {
+		t.Fatalf("unexpected moved file %+v", *file)
+	}
+
+	// moving a file into its own parent is no conflict
+	if err := repo.MoveFile(ctx, fileID, destID, "renamed.txt"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testIsFolderInside(t *testing.T, repo fsrepository.IFileSystemRepository) {
+	rootID := rootFolderID(t, repo)
+	folderID := createFolder(t, repo, "folder", rootID)
+	childID := createFolder(t, repo, "child", folderID)
+	grandChildID := createFolder(t, repo, "grand child", childID)
+	siblingID := createFolder(t, repo, "sibling", rootID)
+
+	assertIsFolderInside(t, repo, childID, folderID, true)
+	assertIsFolderInside(t, repo, grandChildID, folderID, true)
+	assertIsFolderInside(t, repo, grandChildID, rootID, true)
+	assertIsFolderInside(t, repo, folderID, childID, false)
+	assertIsFolderInside(t, repo, folderID, folderID, false)
+	assertIsFolderInside(t, repo, siblingID, folderID, false)
+	assertIsFolderInside(t, repo, rootID, folderID, false)
+}
+
+func testNameConflicts(t *testing.T, repo fsrepository.IFileSystemRepository) {
+	ctx := context.Background()
+	rootID := rootFolderID(t, repo)
+	folderID := createFolder(t, repo, "folder", rootID)
+	otherFolderID := createFolder(t, repo, "other folder", rootID)
+	fileID := createFile(t, repo, "file.txt", newContent("a"), rootID)
+
+	_, err := repo.CreateFolder(ctx, "folder", rootID)
+	assertErrorCode(t, err, fsrepository.NameAlreadyExists)
+	_, err = repo.CreateFolder(ctx, "file.txt", rootID)
+	assertErrorCode(t, err, fsrepository.NameAlreadyExists)
+	_, err = repo.CreateFile(ctx, "file.txt", newContent("b"), rootID)
+	assertErrorCode(t, err, fsrepository.NameAlreadyExists)
+	_, err = repo.CreateFile(ctx, "folder", newContent("b"), rootID)
+	assertErrorCode(t, err, fsrepository.NameAlreadyExists)
+	assertErrorCode(t, repo.UpdateFolder(ctx, otherFolderID, "folder"), fsrepository.NameAlreadyExists)
+	assertErrorCode(t, repo.UpdateFolder(ctx, otherFolderID, "file.txt"), fsrepository.NameAlreadyExists)
+
+	movedFolderID := createFolder(t, repo, "folder", otherFolderID)
+	assertErrorCode(t, repo.MoveFolder(ctx, movedFolderID, rootID, "folder"), fsrepository.NameAlreadyExists)
+	movedFileID := createFile(t, repo, "file.txt", newContent("b"), folderID)
+	assertErrorCode(t, repo.MoveFile(ctx, movedFileID, rootID, "file.txt"), fsrepository.NameAlreadyExists)
+
+	// nothing changed
+	assertFolderNames(t, repo, rootID, "folder", "other folder")
+	assertFileNames(t, repo, rootID, "file.txt")
+	if file, err := repo.GetFile(ctx, fileID); err != nil || file.FileContent != newContent("a") {
+		t.Fatalf("the conflicting file should not have changed: %+v (error: %v)", file, err)
+	}
+
+	// names are case sensitive, and only unique inside a folder
+	createFolder(t, repo, "Folder", rootID)
+	createFile(t, repo, "FILE.txt", newContent("c"), rootID)
+	createFolder(t, repo, "other folder", folderID)
+}
+
+func testDeleteFolderAndContent(t *testing.T, repo fsrepository.IFileSystemRepository) {
+	ctx := context.Background()
+	rootID := rootFolderID(t, repo)
+	folderID := createFolder(t, repo, "folder", rootID)
+	childID := createFolder(t, repo, "child", folderID)
+	siblingID := createFolder(t, repo, "sibling", rootID)
+	fileID := createFile(t, repo, "file.txt", newContent("deleted"), folderID)
+	childFileID := createFile(t, repo, "child file.txt", newContent("shared"), childID)
+	siblingFileID := createFile(t, repo, "sibling file.txt", newContent("shared"), siblingID)
+
+	if err := repo.DeleteFolderAndContent(ctx, folderID); err != nil {
+		t.Fatal(err)
+	}
+
+	assertExistsFolder(t, repo, folderID, false)
+	assertExistsFolder(t, repo, childID, false)
+	assertExistsFile(t, repo, fileID, false)
+	assertExistsFile(t, repo, childFileID, false)
+	assertExistsFolder(t, repo, siblingID, true)
+	assertExistsFile(t, repo, siblingFileID, true)
+	assertFolderNames(t, repo, rootID, "sibling")
+	assertFolderNames(t, repo, folderID)
+	assertFileNames(t, repo, childID)
+
+	// the content still referenced by the sibling file is not orphan
+	assertOrphanBlobKeys(t, repo, newContent("deleted").BlobKey)
+}
+
+func testLockFolderSubtree(t *testing.T, repo fsrepository.IFileSystemRepository) {
+	ctx := context.Background()
+	rootID := rootFolderID(t, repo)
+	folderID := createFolder(t, repo, "folder", rootID)
+	childID := createFolder(t, repo, "child", folderID)
+	siblingID := createFolder(t, repo, "sibling", rootID)
+	createFile(t, repo, "file.txt", newContent("file"), folderID)
+	createFile(t, repo, "child file.txt", newContent("child file"), childID)
+	createFile(t, repo, "sibling file.txt", newContent("sibling file"), siblingID)
+
+	err := repo.ExecuteInTransaction(ctx, func(txRepo fsrepository.IFileSystemRepository) error {
+		count, err := txRepo.LockFolderSubtree(ctx, folderID)
+		if err != nil {
+			return err
+		}
+		if *count != 4 {
+			t.Fatalf("expected the folder, its child and their files, got %d items", *count)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = repo.LockFolderSubtree(ctx, unknownID)
+	assertErrorCode(t, err, fsrepository.ItemNotFound)
+}
+
+func testDeleteFile(t *testing.T, repo fsrepository.IFileSystemRepository) {
+	ctx := context.Background()
+	rootID := rootFolderID(t, repo)
+	content := newContent("shared")
+	fileID := createFile(t, repo, "file.txt", content, rootID)
+	otherFileID := createFile(t, repo, "other file.txt", content, rootID)
+
+	if err := repo.DeleteFile(ctx, fileID); err != nil {
+		t.Fatal(err)
+	}
+	assertExistsFile(t, repo, fileID, false)
+	assertFileNames(t, repo, rootID, "other file.txt")
+	assertOrphanBlobKeys(t, repo)
+
+	if err := repo.DeleteFile(ctx, otherFileID); err != nil {
+		t.Fatal(err)
+	}
+	assertFileNames(t, repo, rootID)
+	assertOrphanBlobKeys(t, repo, content.BlobKey)
+
+	referenced, err := repo.IsBlobReferenced(ctx, content.BlobKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *referenced {
+		t.Fatalf("the content of the deleted files should not be referenced anymore")
+	}
+}
+
+func testOrphanBlobKeys(t *testing.T, repo fsrepository.IFileSystemRepository) {
+	ctx := context.Background()
+	rootID := rootFolderID(t, repo)
+	first := newContent("first")
+	second := newContent("second")
+	firstFileID := createFile(t, repo, "first.txt", first, rootID)
+	secondFileID := createFile(t, repo, "second.txt", second, rootID)
+
+	if err := repo.DeleteFile(ctx, firstFileID); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.DeleteFile(ctx, secondFileID); err != nil {
+		t.Fatal(err)
+	}
+	assertOrphanBlobKeys(t, repo, first.BlobKey, second.BlobKey)
+
+	// a file created with an orphan content makes it referenced again
+	createFile(t, repo, "first again.txt", first, rootID)
+	assertOrphanBlobKeys(t, repo, second.BlobKey)
+
+	if err := repo.RemoveOrphanBlobKey(ctx, second.BlobKey); err != nil {
+		t.Fatal(err)
+	}
+	assertOrphanBlobKeys(t, repo)
+
+	// removing an unknown orphan is no error
+	if err := repo.RemoveOrphanBlobKey(ctx, second.BlobKey); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testNotFound(t *testing.T, repo fsrepository.IFileSystemRepository) {
+	ctx := context.Background()
+	rootID := rootFolderID(t, repo)
+	folderID := createFolder(t, repo, "folder", rootID)
+	fileID := createFile(t, repo, "file.txt", newContent("a"), rootID)
+	missingFolderID := unknownID
+	missingFileID := unknownID
+
+	assertExistsFolder(t, repo, missingFolderID, false)
+	assertExistsFile(t, repo, missingFileID, false)
+	assertFolderNames(t, repo, missingFolderID)
+	assertFileNames(t, repo, missingFolderID)
+
+	_, err := repo.GetFolder(ctx, missingFolderID)
+	assertErrorCode(t, err, fsrepository.ItemNotFound)
+	_, err = repo.GetFile(ctx, missingFileID)
+	assertErrorCode(t, err, fsrepository.ItemNotFound)
+	_, err = repo.IsRootFolder(ctx, missingFolderID)
+	assertErrorCode(t, err, fsrepository.ItemNotFound)
+	_, err = repo.IsFolderInside(ctx, missingFolderID, rootID)
+	assertErrorCode(t, err, fsrepository.ItemNotFound)
+	_, err = repo.IsFolderInside(ctx, folderID, missingFolderID)
+	assertErrorCode(t, err, fsrepository.ItemNotFound)
+
+	_, err = repo.CreateFolder(ctx, "new folder", missingFolderID)
+	assertErrorCode(t, err, fsrepository.ItemNotFound)
+	_, err = repo.CreateFile(ctx, "new file.txt", newContent("b"), missingFolderID)
+	assertErrorCode(t, err, fsrepository.ItemNotFound)
+	assertErrorCode(t, repo.UpdateFolder(ctx, missingFolderID, "new name"), fsrepository.ItemNotFound)
+	assertErrorCode(t, repo.UpdateFile(ctx, missingFileID, "new name.txt", ""), fsrepository.ItemNotFound)
+	assertErrorCode(t, repo.MoveFolder(ctx, missingFolderID, rootID, "moved"), fsrepository.ItemNotFound)
+	assertErrorCode(t, repo.MoveFolder(ctx, folderID, missingFolderID, "folder"), fsrepository.ItemNotFound)
+	assertErrorCode(t, repo.MoveFile(ctx, missingFileID, rootID, "moved.txt"), fsrepository.ItemNotFound)
+	assertErrorCode(t, repo.MoveFile(ctx, fileID, missingFolderID, "file.txt"), fsrepository.ItemNotFound)
+	assertErrorCode(t, repo.DeleteFolderAndContent(ctx, missingFolderID), fsrepository.ItemNotFound)
+	assertErrorCode(t, repo.DeleteFile(ctx, missingFileID), fsrepository.ItemNotFound)
+
+	// a file id is no folder id and the other way round
+	if folderID != fileID {
+		assertExistsFolder(t, repo, fileID, false)
+		assertExistsFile(t, repo, folderID, false)
+	}
+}
+
+func testTransactions(t *testing.T, repo fsrepository.IFileSystemRepository) {
+	ctx := context.Background()
+	rootID := rootFolderID(t, repo)
+	folderID := createFolder(t, repo, "folder", rootID)
+
+	err := repo.ExecuteInTransaction(ctx, func(txRepo fsrepository.IFileSystemRepository) error {
+		if _, err := txRepo.CreateFolder(ctx, "committed", rootID); err != nil {
+			return err
+		}
+		// the writes of the transaction are visible inside it
+		assertFolderNames(t, txRepo, rootID, "committed", "folder")
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertFolderNames(t, repo, rootID, "committed", "folder")
+
+	failure := errors.New("failure")
+	err = repo.ExecuteInTransaction(ctx, func(txRepo fsrepository.IFileSystemRepository) error {
+		if _, err := txRepo.CreateFolder(ctx, "rolled back", rootID); err != nil {
+			return err
+		}
+		if err := txRepo.DeleteFolderAndContent(ctx, folderID); err != nil {
+			return err
+		}
+		return failure
+	})
+	if errors.Cause(err) != failure {
+		t.Fatalf("expected the error of the work, got %v", err)
+	}
+	assertFolderNames(t, repo, rootID, "committed", "folder")
+	assertExistsFolder(t, repo, folderID, true)
+}
+
+func testContext(t *testing.T, repo fsrepository.IFileSystemRepository) {
+	rootID := rootFolderID(t, repo)
+
+	cancelledCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := repo.CreateFolder(cancelledCtx, "cancelled", rootID)
+	assertContextError(t, err, context.Canceled)
+	_, err = repo.GetFoldersIn(cancelledCtx, rootID)
+	assertContextError(t, err, context.Canceled)
+
+	expiredCtx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	_, err = repo.GetFolder(expiredCtx, rootID)
+	assertContextError(t, err, context.DeadlineExceeded)
+	err = repo.ExecuteInTransaction(expiredCtx, func(txRepo fsrepository.IFileSystemRepository) error {
+		_, err := txRepo.CreateFolder(expiredCtx, "expired", rootID)
+		return err
+	})
+	assertContextError(t, err, context.DeadlineExceeded)
+
+	// a transaction whose caller gives up is rolled back, even if its work succeeded
+	ctx, cancel := context.WithCancel(context.Background())
+	err = repo.ExecuteInTransaction(ctx, func(txRepo fsrepository.IFileSystemRepository) error {
+		if _, err := txRepo.CreateFolder(ctx, "given up", rootID); err != nil {
+			return err
+		}
+		cancel()
+		return nil
+	})
+	assertContextError(t, err, context.Canceled)
+	assertFolderNames(t, repo, rootID)
+}
+
+func testImport(t *testing.T, repo fsrepository.IFileSystemRepository) {
+	ctx := context.Background()
+	importable, ok := repo.(fsrepository.IImportableRepository)
+	if !ok {
+		t.Skip("the repository does not implement IImportableRepository")
+	}
+	rootID := rootFolderID(t, repo)
+	createdFolderID := createFolder(t, repo, "created", rootID)
+	content := newContent("imported")
+	deletedFileID := createFile(t, repo, "deleted.txt", content, rootID)
+	if err := repo.DeleteFile(ctx, deletedFileID); err != nil {
+		t.Fatal(err)
+	}
+
+	// the ids and the legacy ids are kept
+	folderID := "0190a6f2-4c1e-7b3a-9d2e-5f6a7b8c9d01"
+	fileID := "0190a6f2-4c1e-7b3a-9d2e-5f6a7b8c9d02"
+	createdAt := time.Date(2020, 1, 2, 3, 4, 5, 6000000, time.UTC)
+	importedFolder := fsmodel.Folder{Id: folderID, LegacyId: 7, Name: "imported", ParentId: &rootID,
+		CreatedAt: createdAt, ModifiedAt: createdAt.Add(time.Minute)}
+	if err := importable.ImportFolder(ctx, importedFolder); err != nil {
+		t.Fatal(err)
+	}
+	importedFile := fsmodel.File{Id: fileID, LegacyId: 7, Name: "imported.txt", ParentId: folderID, FileContent: content,
+		CreatedAt: createdAt, ModifiedAt: createdAt.Add(time.Hour)}
+	if err := importable.ImportFile(ctx, importedFile); err != nil {
+		t.Fatal(err)
+	}
+	// so are the metadata, unknown for the files stored before they were recorded
+	legacyFile := fsmodel.File{Id: "0190a6f2-4c1e-7b3a-9d2e-5f6a7b8c9d05", Name: "legacy.txt", ParentId: folderID,
+		FileContent: fsmodel.FileContent{BlobKey: "legacy.txt", Size: fsmodel.UnknownSize}}
+	if err := importable.ImportFile(ctx, legacyFile); err != nil {
+		t.Fatal(err)
+	}
+
+	folder, err := repo.GetFolder(ctx, folderID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// importing the files inside the folder does not modify it
+	if folder.LegacyId != 7 || folder.Name != "imported" || folder.ParentId == nil || *folder.ParentId != rootID ||
+		!folder.CreatedAt.Equal(importedFolder.CreatedAt) || !folder.ModifiedAt.Equal(importedFolder.ModifiedAt) {
+		t.Fatalf("unexpected imported folder %+v", *folder)
+	}
+	file, err := repo.GetFile(ctx, fileID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *file != importedFile {
+		t.Fatalf("unexpected imported file %+v", *file)
+	}
+	file, err = repo.GetFile(ctx, legacyFile.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *file != legacyFile {
+		t.Fatalf("unexpected imported legacy file %+v", *file)
+	}
+	assertFileNames(t, repo, folderID, "imported.txt", "legacy.txt")
+	// the imported file references the content again
+	assertOrphanBlobKeys(t, repo)
+
+	otherFolderID := "0190a6f2-4c1e-7b3a-9d2e-5f6a7b8c9d03"
+	otherFileID := "0190a6f2-4c1e-7b3a-9d2e-5f6a7b8c9d04"
+	assertErrorCode(t, importable.ImportFolder(ctx, fsmodel.Folder{Id: createdFolderID, Name: "taken", ParentId: &rootID}), fsrepository.IdAlreadyExists)
+	assertErrorCode(t, importable.ImportFile(ctx, fsmodel.File{Id: fileID, Name: "taken.txt", ParentId: rootID, FileContent: content}), fsrepository.IdAlreadyExists)
+	assertErrorCode(t, importable.ImportFolder(ctx, fsmodel.Folder{Id: otherFolderID, LegacyId: 7, Name: "taken", ParentId: &rootID}), fsrepository.IdAlreadyExists)
+	assertErrorCode(t, importable.ImportFile(ctx, fsmodel.File{Id: otherFileID, LegacyId: 7, Name: "taken.txt", ParentId: rootID, FileContent: content}), fsrepository.IdAlreadyExists)
+	assertErrorCode(t, importable.ImportFolder(ctx, fsmodel.Folder{Id: otherFolderID, Name: "imported.txt", ParentId: &folderID}), fsrepository.NameAlreadyExists)
+	assertErrorCode(t, importable.ImportFile(ctx, fsmodel.File{Id: otherFileID, Name: "created", ParentId: rootID, FileContent: content}), fsrepository.NameAlreadyExists)
+	missingFolderID := unknownID
+	assertErrorCode(t, importable.ImportFolder(ctx, fsmodel.Folder{Id: otherFolderID, Name: "orphan", ParentId: &missingFolderID}), fsrepository.ItemNotFound)
+	if err := importable.ImportFolder(ctx, fsmodel.Folder{Id: otherFolderID, Name: "second root"}); err == nil {
+		t.Fatal("a folder without parent was imported")
+	}
+	assertExistsFolder(t, repo, otherFolderID, false)
+}
+
+func testLegacyIDs(t *testing.T, repo fsrepository.IFileSystemRepository) {
+	ctx := context.Background()
+	rootID := rootFolderID(t, repo)
+	createdFolderID := createFolder(t, repo, "created", rootID)
+	createFile(t, repo, "created.txt", newContent("created"), createdFolderID)
+
+	// the items created since the opaque ids have no legacy id, 0 included: it was the root folder
+	_, err := repo.GetFolderIDByLegacyID(ctx, 0)
+	assertErrorCode(t, err, fsrepository.ItemNotFound)
+	_, err = repo.GetFileIDByLegacyID(ctx, 0)
+	assertErrorCode(t, err, fsrepository.ItemNotFound)
+	_, err = repo.GetFolderIDByLegacyID(ctx, 1)
+	assertErrorCode(t, err, fsrepository.ItemNotFound)
+
+	importable, ok := repo.(fsrepository.IImportableRepository)
+	if !ok {
+		t.Skip("the repository does not implement IImportableRepository: no item can have a legacy id")
+	}
+	folderID := "0190a6f2-4c1e-7b3a-9d2e-5f6a7b8c9d11"
+	fileID := "0190a6f2-4c1e-7b3a-9d2e-5f6a7b8c9d12"
+	if err := importable.ImportFolder(ctx, fsmodel.Folder{Id: folderID, LegacyId: 3, Name: "legacy", ParentId: &rootID}); err != nil {
+		t.Fatal(err)
+	}
+	if err := importable.ImportFile(ctx, fsmodel.File{Id: fileID, LegacyId: 3, Name: "legacy.txt", ParentId: folderID, FileContent: newContent("legacy")}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the folders and the files had their own sequences: the same legacy id may be a folder and a file
+	foundFolderID, err := repo.GetFolderIDByLegacyID(ctx, 3)
+	if err != nil || *foundFolderID != folderID {
+		t.Fatalf("expected folder %s for the legacy id 3, got %v (error: %v)", folderID, foundFolderID, err)
+	}
+	foundFileID, err := repo.GetFileIDByLegacyID(ctx, 3)
+	if err != nil || *foundFileID != fileID {
+		t.Fatalf("expected file %s for the legacy id 3, got %v (error: %v)", fileID, foundFileID, err)
+	}
+
+	// the legacy ids go along with the deleted items
+	if err := repo.DeleteFolderAndContent(ctx, folderID); err != nil {
+		t.Fatal(err)
+	}
+	_, err = repo.GetFolderIDByLegacyID(ctx, 3)
+	assertErrorCode(t, err, fsrepository.ItemNotFound)
+	_, err = repo.GetFileIDByLegacyID(ctx, 3)
+	assertErrorCode(t, err, fsrepository.ItemNotFound)
+	if err := importable.ImportFolder(ctx, fsmodel.Folder{Id: folderID, LegacyId: 3, Name: "legacy", ParentId: &rootID}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testTrashFolder(t *testing.T, repo fsrepository.IFileSystemRepository) {
+	ctx := context.Background()
+	rootID := rootFolderID(t, repo)
+	trashID, err := repo.GetTrashFolderID(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the trash folder has the same id in every repository too, and is not inside the root folder
+	trash, err := repo.GetFolder(ctx, *trashID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trash.Id != "00000000-0000-0000-0000-000000000001" || trash.ParentId != nil {
+		t.Fatalf("unexpected trash folder %+v", *trash)
+	}
+	if isRoot, err := repo.IsRootFolder(ctx, *trashID); err != nil || *isRoot {
+		t.Fatalf("the trash folder %s should not be the root folder (error: %v)", *trashID, err)
+	}
+	assertFolderNames(t, repo, rootID)
+
+	folderID := createFolder(t, repo, "folder", rootID)
+	if err := repo.MoveFolder(ctx, folderID, *trashID, folderID); err != nil {
+		t.Fatal(err)
+	}
+	assertIsFolderInside(t, repo, folderID, *trashID, true)
+	assertIsFolderInside(t, repo, folderID, rootID, false)
+
+	// the content of a folder tells whether it is trashed
+	subFolderID := createFolder(t, repo, "sub folder", folderID)
+	for id, trashed := range map[string]bool{*trashID: true, folderID: true, subFolderID: true, rootID: false} {
+		content, err := repo.GetFolderContent(ctx, id, fsmodel.ListOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if content.Trashed != trashed {
+			t.Fatalf("expected the content of folder %s to be trashed: %v", id, trashed)
+		}
+	}
+
+	// the existence checks outside of the trash, in a transaction as the service runs them
+	trashedFileID := createFile(t, repo, "trashed.txt", newContent("trashed"), subFolderID)
+	fileID := createFile(t, repo, "file.txt", newContent("file"), rootID)
+	err = repo.ExecuteInTransaction(ctx, func(txRepo fsrepository.IFileSystemRepository) error {
+		for id, expected := range map[string]bool{*trashID: false, folderID: false, subFolderID: false, rootID: true, unknownID: false} {
+			exists, err := txRepo.ExistsUntrashedFolder(ctx, id)
+			if err != nil {
+				return err
+			}
+			if *exists != expected {
+				t.Fatalf("folder %s exists outside of the trash: %v, expected %v", id, *exists, expected)
+			}
+		}
+		for id, expected := range map[string]bool{trashedFileID: false, fileID: true, unknownID: false} {
+			exists, err := txRepo.ExistsUntrashedFile(ctx, id)
+			if err != nil {
+				return err
+			}
+			if *exists != expected {
+				t.Fatalf("file %s exists outside of the trash: %v, expected %v", id, *exists, expected)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testTrashEntries(t *testing.T, repo fsrepository.IFileSystemRepository) {
+	ctx := context.Background()
+	rootID := rootFolderID(t, repo)
+	folderEntry := fsmodel.TrashEntry{ItemId: createFolder(t, repo, "folder", rootID), Kind: fsmodel.FolderKind, Name: "folder", ParentId: rootID}
+	fileEntry := fsmodel.TrashEntry{ItemId: createFile(t, repo, "file.txt", newContent("file"), rootID), Kind: fsmodel.FileKind, Name: "file.txt", ParentId: rootID}
+
+	since := nextMillisecond()
+	if err := repo.CreateTrashEntry(ctx, folderEntry); err != nil {
+		t.Fatal(err)
+	}
+	nextMillisecond()
+	if err := repo.CreateTrashEntry(ctx, fileEntry); err != nil {
+		t.Fatal(err)
+	}
+	assertErrorCode(t, repo.CreateTrashEntry(ctx, fileEntry), fsrepository.IdAlreadyExists)
+	// the entries are records of their own: the items stay where they are
+	assertFolderNames(t, repo, rootID, "folder")
+
+	entries, err := repo.GetTrashEntries(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*entries) != 2 || (*entries)[0].ItemId != fileEntry.ItemId || (*entries)[1].ItemId != folderEntry.ItemId {
+		t.Fatalf("expected the entries of %s then %s, got %+v", fileEntry.ItemId, folderEntry.ItemId, *entries)
+	}
+	if (*entries)[1].TrashedAt.Before(since) || !(*entries)[0].TrashedAt.After((*entries)[1].TrashedAt) {
+		t.Fatalf("unexpected trash times %+v", *entries)
+	}
+
+	deleted, err := repo.DeleteTrashEntry(ctx, folderEntry.ItemId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	folderEntry.TrashedAt = (*entries)[1].TrashedAt
+	if *deleted != folderEntry {
+		t.Fatalf("expected the deleted entry %+v, got %+v", folderEntry, *deleted)
+	}
+	_, err = repo.DeleteTrashEntry(ctx, folderEntry.ItemId)
+	assertErrorCode(t, err, fsrepository.ItemNotFound)
+
+	// a trash entry deleted in a transaction rolled back is kept
+	failure := errors.New("failure")
+	err = repo.ExecuteInTransaction(ctx, func(txRepo fsrepository.IFileSystemRepository) error {
+		if _, err := txRepo.DeleteTrashEntry(ctx, fileEntry.ItemId); err != nil {
+			return err
+		}
+		return failure
+	})
+	if errors.Cause(err) != failure {
+		t.Fatalf("expected the error of the work, got %v", err)
+	}
+	entries, err = repo.GetTrashEntries(ctx)
+	if err != nil || len(*entries) != 1 {
+		t.Fatalf("expected the entry of %s only, got %+v (error: %v)", fileEntry.ItemId, entries, err)
+	}
+
+	importable, ok := repo.(fsrepository.IImportableRepository)
+	if !ok {
+		t.Skip("the repository does not implement IImportableRepository")
+	}
+	// the imported entries keep their trash time
+	folderEntry.TrashedAt = time.Date(2020, 1, 2, 3, 4, 5, 6000000, time.UTC)
+	if err := importable.ImportTrashEntry(ctx, folderEntry); err != nil {
+		t.Fatal(err)
+	}
+	assertErrorCode(t, importable.ImportTrashEntry(ctx, fileEntry), fsrepository.IdAlreadyExists)
+	entries, err = repo.GetTrashEntries(ctx)
+	if err != nil || len(*entries) != 2 || (*entries)[1] != folderEntry {
+		t.Fatalf("expected the imported entry %+v last, got %+v (error: %v)", folderEntry, entries, err)
+	}
+}
+
+func testUpdateFileContent(t *testing.T, repo fsrepository.IFileSystemRepository) {
+	ctx := context.Background()
+	rootID := rootFolderID(t, repo)
+	folderID := createFolder(t, repo, "reports", rootID)
+	first := newContent("first")
+	second := newContent("second")
+	fileID := createFile(t, repo, "report.txt", first, folderID)
+	created, err := repo.GetFile(ctx, fileID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the file is modified, its folder is not: no child was added, removed or renamed
+	since := nextMillisecond()
+	if err := repo.UpdateFileContent(ctx, fileID, second); err != nil {
+		t.Fatal(err)
+	}
+	file, err := repo.GetFile(ctx, fileID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.Name != "report.txt" || file.FileContent != second || !file.CreatedAt.Equal(created.CreatedAt) || file.ModifiedAt.Before(since) {
+		t.Fatalf("unexpected updated file %+v", *file)
+	}
+	assertModifiedSince(t, repo, folderID, since, false)
+	// the replaced content is not referenced anymore
+	assertOrphanBlobKeys(t, repo, first.BlobKey)
+
+	// an orphan content referenced again is not orphan anymore
+	if err := repo.UpdateFileContent(ctx, fileID, first); err != nil {
+		t.Fatal(err)
+	}
+	assertOrphanBlobKeys(t, repo, second.BlobKey)
+
+	assertErrorCode(t, repo.UpdateFileContent(ctx, unknownID, second), fsrepository.ItemNotFound)
+}
+
+func testFileVersions(t *testing.T, repo fsrepository.IFileSystemRepository) {
+	ctx := context.Background()
+	rootID := rootFolderID(t, repo)
+	first := newContent("first")
+	second := newContent("second")
+	fileID := createFile(t, repo, "config.yaml", second, rootID)
+	otherFileID := createFile(t, repo, "other.yaml", first, rootID)
+
+	// a file whose content was never replaced has no version
+	assertFileVersions(t, repo, fileID)
+
+	// the versions keep their creation time
+	firstVersion := fsmodel.FileVersion{Number: 1, FileContent: first, CreatedAt: time.Date(2020, 1, 2, 3, 4, 5, 6000000, time.UTC)}
+	secondVersion := fsmodel.FileVersion{Number: 2, FileContent: second, CreatedAt: time.Date(2020, 1, 3, 3, 4, 5, 6000000, time.UTC)}
+	for _, version := range []fsmodel.FileVersion{secondVersion, firstVersion} {
+		if err := repo.CreateFileVersion(ctx, fileID, version); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assertErrorCode(t, repo.CreateFileVersion(ctx, fileID, secondVersion), fsrepository.IdAlreadyExists)
+	assertErrorCode(t, repo.CreateFileVersion(ctx, unknownID, firstVersion), fsrepository.ItemNotFound)
+	assertFileVersions(t, repo, fileID, secondVersion, firstVersion)
+	// the versions belong to their file
+	assertFileVersions(t, repo, otherFileID)
+
+	// the content of a version is referenced as long as the version is
+	if err := repo.DeleteFile(ctx, otherFileID); err != nil {
+		t.Fatal(err)
+	}
+	assertOrphanBlobKeys(t, repo)
+	if err := repo.DeleteFileVersion(ctx, fileID, firstVersion.Number); err != nil {
+		t.Fatal(err)
+	}
+	assertFileVersions(t, repo, fileID, secondVersion)
+	assertOrphanBlobKeys(t, repo, first.BlobKey)
+	assertErrorCode(t, repo.DeleteFileVersion(ctx, fileID, firstVersion.Number), fsrepository.ItemNotFound)
+
+	// an orphan content referenced again by a version is not orphan anymore
+	if err := repo.CreateFileVersion(ctx, fileID, firstVersion); err != nil {
+		t.Fatal(err)
+	}
+	assertOrphanBlobKeys(t, repo)
+	referenced, err := repo.IsBlobReferenced(ctx, first.BlobKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !*referenced {
+		t.Fatalf("the content of the version should be referenced")
+	}
+
+	// a version rolled back is not kept
+	failure := errors.New("failure")
+	err = repo.ExecuteInTransaction(ctx, func(txRepo fsrepository.IFileSystemRepository) error {
+		if err := txRepo.CreateFileVersion(ctx, fileID, fsmodel.FileVersion{Number: 3, FileContent: newContent("third")}); err != nil {
+			return err
+		}
+		return failure
+	})
+	if errors.Cause(err) != failure {
+		t.Fatalf("expected the error of the work, got %v", err)
+	}
+	assertFileVersions(t, repo, fileID, secondVersion, firstVersion)
+}
+
+func testDeleteFileVersions(t *testing.T, repo fsrepository.IFileSystemRepository) {
+	ctx := context.Background()
+	rootID := rootFolderID(t, repo)
+	folderID := createFolder(t, repo, "folder", rootID)
+	fileID := createFile(t, repo, "file.txt", newContent("file"), rootID)
+	childFileID := createFile(t, repo, "child file.txt", newContent("child file"), folderID)
+	versions := map[string]fsmodel.FileVersion{
+		fileID:      {Number: 1, FileContent: newContent("file version"), CreatedAt: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)},
+		childFileID: {Number: 1, FileContent: newContent("child file version"), CreatedAt: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)},
+	}
+	for id, version := range versions {
+		if err := repo.CreateFileVersion(ctx, id, version); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the versions are deleted along with their file, and their content becomes orphan
+	if err := repo.DeleteFile(ctx, fileID); err != nil {
+		t.Fatal(err)
+	}
+	_, err := repo.GetFileVersions(ctx, fileID)
+	assertErrorCode(t, err, fsrepository.ItemNotFound)
+	assertOrphanBlobKeys(t, repo, newContent("file").BlobKey, newContent("file version").BlobKey)
+
+	if err := repo.DeleteFolderAndContent(ctx, folderID); err != nil {
+		t.Fatal(err)
+	}
+	_, err = repo.GetFileVersions(ctx, childFileID)
+	assertErrorCode(t, err, fsrepository.ItemNotFound)
+	assertOrphanBlobKeys(t, repo, newContent("file").BlobKey, newContent("file version").BlobKey,
+		newContent("child file").BlobKey, newContent("child file version").BlobKey)
+}
+
+func rootFolderID(t *testing.T, repo fsrepository.IFileSystemRepository) string {
+	t.Helper()
+	ctx := context.Background()
+	rootID, err := repo.GetRootFolderID(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *rootID
+}
+
+func createFolder(t *testing.T, repo fsrepository.IFileSystemRepository, name string, parentID string) string {
+	t.Helper()
+	ctx := context.Background()
+	folderID, err := repo.CreateFolder(ctx, name, parentID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *folderID
+}
+
+func createFile(t *testing.T, repo fsrepository.IFileSystemRepository, name string, content fsmodel.FileContent, parentID string) string {
+	t.Helper()
+	ctx := context.Background()
+	fileID, err := repo.CreateFile(ctx, name, content, parentID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *fileID
+}
+
+// newContent returns a content reference, the repositories never read the content itself
+func newContent(digest string) fsmodel.FileContent {
+	return fsmodel.FileContent{BlobKey: "sha256-" + digest, Digest: digest, Size: int64(len(digest)), ContentType: "text/plain"}
+}
+
+// nextMillisecond waits until the current millisecond is over, and returns the next one: the writes which follow
+// happen after the previous ones, at the resolution of the timestamps
+func nextMillisecond() time.Time {
+	time.Sleep(time.Millisecond)
+	return time.Now().Truncate(time.Millisecond)
+}
+
+func assertModifiedSince(t *testing.T, repo fsrepository.IFileSystemRepository, folderID string, since time.Time, expected bool) {
+	t.Helper()
+	folder, err := repo.GetFolder(context.Background(), folderID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if modified := !folder.ModifiedAt.Before(since); modified != expected {
+		t.Fatalf("folder %s modified at %v, since %v: expected %v", folder.Name, folder.ModifiedAt, since, expected)
+	}
+}
+
+func assertErrorCode(t *testing.T, err error, code string) {
+	t.Helper()
+	if err == nil || errors.Cause(err).Error() != code {
+		t.Fatalf("expected error %q, got %v", code, err)
+	}
+}
+
+func assertContextError(t *testing.T, err error, expected error) {
+	t.Helper()
+	if errors.Cause(err) != expected {
+		t.Fatalf("expected error %v, got %v", expected, err)
+	}
+}
+
+func assertExistsFolder(t *testing.T, repo fsrepository.IFileSystemRepository, folderID string, expected bool) {
+	t.Helper()
+	ctx := context.Background()
+	exists, err := repo.ExistsFolder(ctx, folderID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *exists != expected {
+		t.Fatalf("folder %s exists: %v, expected %v", folderID, *exists, expected)
+	}
+}
+
+func assertExistsFile(t *testing.T, repo fsrepository.IFileSystemRepository, fileID string, expected bool) {
+	t.Helper()
+	ctx := context.Background()
+	exists, err := repo.ExistsFile(ctx, fileID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *exists != expected {
+		t.Fatalf("file %s exists: %v, expected %v", fileID, *exists, expected)
+	}
+}
+
+func assertIsFolderInside(t *testing.T, repo fsrepository.IFileSystemRepository, folderID string, ancestorFolderID string, expected bool) {
+	t.Helper()
+	ctx := context.Background()
+	isInside, err := repo.IsFolderInside(ctx, folderID, ancestorFolderID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *isInside != expected {
+		t.Fatalf("folder %s inside folder %s: %v, expected %v", folderID, ancestorFolderID, *isInside, expected)
+	}
+}
+
+func assertFolderNames(t *testing.T, repo fsrepository.IFileSystemRepository, folderID string, expected ...string) {
+	t.Helper()
+	ctx := context.Background()
+	folders, err := repo.GetFoldersIn(ctx, folderID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0)
+	for _, folder := range *folders {
+		names = append(names, folder.Name)
+	}
+	assertSameNames(t, names, expected)
+}
+
+func assertFileNames(t *testing.T, repo fsrepository.IFileSystemRepository, folderID string, expected ...string) {
+	t.Helper()
+	ctx := context.Background()
+	files, err := repo.GetFilesIn(ctx, folderID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0)
+	for _, file := range *files {
+		names = append(names, file.Name)
+	}
+	assertSameNames(t, names, expected)
+}
+
+// assertListedNames asserts the names of the children listed, in order, on a single page
+func assertListedNames(t *testing.T, repo fsrepository.IFileSystemRepository, folderID string, options fsmodel.ListOptions, expected ...string) {
+	t.Helper()
+	assertListedPage(t, repo, folderID, options, false, expected...)
+}
+
+// assertListedPage asserts the names of the children listed on the page, in order, and whether another page follows.
+// It returns the position of the next page.
+func assertListedPage(t *testing.T, repo fsrepository.IFileSystemRepository, folderID string, options fsmodel.ListOptions, hasNext bool, expected ...string) *fsmodel.ListPosition {
+	t.Helper()
+	ctx := context.Background()
+	content, err := repo.GetFolderContent(ctx, folderID, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0)
+	for _, folder := range content.Folders {
+		names = append(names, folder.Name)
+	}
+	for _, file := range content.Files {
+		names = append(names, file.Name)
+	}
+	if len(names) != len(expected) {
+		t.Fatalf("expected %q, got %q", expected, names)
+	}
+	for i := range names {
+		if names[i] != expected[i] {
+			t.Fatalf("expected %q, got %q", expected, names)
+		}
+	}
+	if (content.Next != nil) != hasNext {
+		t.Fatalf("next page %+v, expected one: %v", content.Next, hasNext)
+	}
+	return content.Next
+}
+
+func assertOrphanBlobKeys(t *testing.T, repo fsrepository.IFileSystemRepository, expected ...string) {
+	t.Helper()
+	ctx := context.Background()
+	blobKeys, err := repo.GetOrphanBlobKeys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSameNames(t, *blobKeys, expected)
+}
+
+func assertFileVersions(t *testing.T, repo fsrepository.IFileSystemRepository, fileID string, expected ...fsmodel.FileVersion) {
+	t.Helper()
+	ctx := context.Background()
+	versions, err := repo.GetFileVersions(ctx, fileID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*versions) != len(expected) {
+		t.Fatalf("expected the versions %+v, got %+v", expected, *versions)
+	}
+	for i, version := range *versions {
+		if version.Number != expected[i].Number || version.FileContent != expected[i].FileContent || !version.CreatedAt.Equal(expected[i].CreatedAt) {
+			t.Fatalf("expected the versions %+v, got %+v", expected, *versions)
+		}
+	}
+}
+
+func assertChildrenWithNamePrefix(t *testing.T, repo fsrepository.IFileSystemRepository, folderID string, namePrefix string, expected ...string) []fsmodel.NamedChild {
+	t.Helper()
+	children, err := repo.GetChildrenWithNamePrefix(context.Background(), folderID, namePrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0)
+	for _, child := range *children {
+		names = append(names, child.Name)
+	}
+	assertSameNames(t, names, expected)
+	return *children
+}
+
+func assertSameNames(t *testing.T, names []string, expected []string) {
+	t.Helper()
+	sort.Strings(names)
+	sort.Strings(expected)
+	if len(names) != len(expected) {
+		t.Fatalf("expected %q, got %q", expected, names)
+	}
+	for i := range names {
+		if names[i] != expected[i] {
+			t.Fatalf("expected %q, got %q", expected, names)
+		}
+	}
+}
@@ -0,0 +1,1278 @@
+package fsrepository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/loisfa/remote-file-system/api/fsmodel"
+	"github.com/pkg/errors"
+	_ "modernc.org/sqlite"
+)
+
+const (
+	// the retries of a transaction which failed on a deadlock or a serialization failure
+	maxSQLTransactionAttempts = 5
+
+	sqlTrashFolderName = "Trash"
+)
+
+// sqlDialect holds what differs between the supported databases. The queries are written with '?' placeholders.
+type sqlDialect struct {
+	driverName     string
+	idColumnType   string // auto incremented primary key, of the integer ids before the opaque ones
+	binaryTextType string // text compared byte by byte, as Go does: the sort keys must order alike everywhere
+
+	// appended to the queries which lock the rows they read until the end of the transaction, empty when the
+	// database serializes the transactions anyway
+	forUpdate string
+	// run before the migrations, so that concurrent instances do not migrate the same database at the same time
+	lockSchemaVersion string
+
+	rebind           func(query string) string
+	isTransientError func(err error) bool
+	// the operator, and its pattern, matching the names against the name glob of the list options
+	nameGlob func(glob string) (operator string, pattern string)
+}
+
+// SQLite is meant for single node setups: the repository keeps a single connection, which serializes the
+// transactions
+var sqliteDialect = sqlDialect{
+	driverName:        "sqlite",
+	idColumnType:      "INTEGER PRIMARY KEY AUTOINCREMENT",
+	binaryTextType:    "TEXT", // BINARY collation by default
+	forUpdate:         "",
+	lockSchemaVersion: "",
+	rebind:            func(query string) string { return query },
+	isTransientError:  func(err error) bool { return false },
+	nameGlob: func(glob string) (string, string) {
+		// GLOB is case sensitive, and its wildcards are the ones of the list options, [ aside
+		return "GLOB", strings.ReplaceAll(glob, "[", "[[]")
+	},
+}
+
+var postgresDialect = sqlDialect{
+	driverName:        "postgres",
+	idColumnType:      "SERIAL PRIMARY KEY",
+	binaryTextType:    `TEXT COLLATE "C"`,
+	forUpdate:         " FOR UPDATE",
+	lockSchemaVersion: "LOCK TABLE schema_version IN EXCLUSIVE MODE",
+	rebind:            numberedPlaceholders,
+	isTransientError: func(err error) bool {
+		pqErr, ok := errors.Cause(err).(*pq.Error)
+		// serialization_failure, deadlock_detected
+		return ok && (pqErr.Code == "40001" || pqErr.Code == "40P01")
+	},
+	nameGlob: func(glob string) (string, string) {
+		pattern := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`, "*", "%", "?", "_").Replace(glob)
+		return "LIKE", pattern
+	},
+}
+
+// SQLFileSystemRepository stores the file system in a SQL database: SQLite or PostgreSQL. The subtrees are walked
+// with recursive queries, the folders only know their parent.
+type SQLFileSystemRepository struct {
+	db      *sql.DB
+	dialect sqlDialect
+	tx      *sql.Tx         // set inside ExecuteInTransaction
+	ctx     context.Context // the one of the operation, or of the transaction, in progress
+}
+
+// NewSQLiteFileSystemRepository opens (or creates) the SQLite database stored in the file, and migrates its schema
+func NewSQLiteFileSystemRepository(file string) (SQLFileSystemRepository, error) {
+	db, err := sql.Open(sqliteDialect.driverName, fmt.Sprintf("file:%s?_pragma=foreign_keys(1)", file))
+	if err != nil {
+		return SQLFileSystemRepository{}, err
+	}
+	db.SetMaxOpenConns(1)
+	return newSQLFileSystemRepository(db, sqliteDialect)
+}
+
+// NewPostgresFileSystemRepository connects to the PostgreSQL database, and migrates its schema
+func NewPostgresFileSystemRepository(dataSourceName string) (SQLFileSystemRepository, error) {
+	db, err := sql.Open(postgresDialect.driverName, dataSourceName)
+	if err != nil {
+		return SQLFileSystemRepository{}, err
+	}
+	return newSQLFileSystemRepository(db, postgresDialect)
+}
+
+func newSQLFileSystemRepository(db *sql.DB, dialect sqlDialect) (SQLFileSystemRepository, error) {
+	repo := SQLFileSystemRepository{db: db, dialect: dialect}
+	if err := repo.migrate(); err != nil {
+		db.Close()
+		return SQLFileSystemRepository{}, errors.WithMessage(err, "Could not migrate the schema of the database")
+	}
+	return repo, nil
+}
+
+// Close releases the connections to the database
+func (repo SQLFileSystemRepository) Close() error {
+	return repo.db.Close()
+}
+
+func (repo SQLFileSystemRepository) ExecuteInTransaction(ctx context.Context, work func(repo IFileSystemRepository) error) error {
+	return repo.inTransaction(ctx, func(repo SQLFileSystemRepository) error {
+		return work(repo)
+	})
+}
+
+// inTransaction runs the work in its own transaction, or in the one of ExecuteInTransaction
+func (repo SQLFileSystemRepository) inTransaction(ctx context.Context, work func(repo SQLFileSystemRepository) error) error {
+	if repo.tx != nil {
+		return work(repo.withContext(ctx))
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := repo.runTransaction(ctx, work)
+		if err == nil || !repo.dialect.isTransientError(err) || attempt == maxSQLTransactionAttempts {
+			return err
+		}
+	}
+}
+
+func (repo SQLFileSystemRepository) runTransaction(ctx context.Context, work func(repo SQLFileSystemRepository) error) error {
+	tx, err := repo.db.BeginTx(ctx, nil)
+	if err != nil {
+		return contextErrorOr(ctx, err)
+	}
+
+	err = work(SQLFileSystemRepository{db: repo.db, dialect: repo.dialect, tx: tx, ctx: ctx})
+	if err == nil {
+		// the caller gave up meanwhile: roll back
+		err = ctx.Err()
+	}
+	if err != nil {
+		tx.Rollback()
+		return contextErrorOr(ctx, err)
+	}
+	return contextErrorOr(ctx, tx.Commit())
+}
+
+func (repo SQLFileSystemRepository) UpdateFolder(ctx context.Context, folderID string, folderName string) error {
+	return repo.inTransaction(ctx, func(repo SQLFileSystemRepository) error {
+		folder, err := repo.getFolder(folderID, true)
+		if err != nil {
+			return err
+		}
+		modifiedAt := currentTime()
+		if folder.ParentId != nil {
+			if err := repo.errorIfNameConflict(*folder.ParentId, folderName, folderID, noItemID); err != nil {
+				return err
+			}
+			if err := repo.touchFolders(modifiedAt, *folder.ParentId); err != nil {
+				return err
+			}
+		}
+
+		return repo.exec(`UPDATE folders SET name = ?, sort_name = ?, modified_at = ? WHERE id = ?`,
+			folderName, naturalSortKey(folderName), unixMillis(modifiedAt), folderID)
+	})
+}
+
+func (repo SQLFileSystemRepository) UpdateFile(ctx context.Context, fileID string, fileName string, contentType string) error {
+	return repo.inTransaction(ctx, func(repo SQLFileSystemRepository) error {
+		file, err := repo.getFile(fileID, true)
+		if err != nil {
+			return err
+		}
+		if err := repo.errorIfNameConflict(file.ParentId, fileName, noItemID, fileID); err != nil {
+			return err
+		}
+
+		modifiedAt := currentTime()
+		if fileName != file.Name {
+			if err := repo.touchFolders(modifiedAt, file.ParentId); err != nil {
+				return err
+			}
+		}
+		if contentType == "" {
+			contentType = file.ContentType
+		}
+		return repo.exec(`UPDATE files SET name = ?, sort_name = ?, extension = ?, content_type = ?, modified_at = ? WHERE id = ?`,
+			fileName, naturalSortKey(fileName), fileExtension(fileName), contentType, unixMillis(modifiedAt), fileID)
+	})
+}
+
+func (repo SQLFileSystemRepository) MoveFolder(ctx context.Context, folderID string, destFolderID string, folderName string) error {
+	return repo.inTransaction(ctx, func(repo SQLFileSystemRepository) error {
+		folder, err := repo.getFolder(folderID, true)
+		if err != nil {
+			return err
+		}
+		if err := repo.errorIfFolderNotFound(destFolderID); err != nil {
+			return err
+		}
+
+		ancestorIDs, err := repo.lockAncestors(destFolderID)
+		if err != nil {
+			return err
+		}
+		if folderID == destFolderID || ancestorIDs[folderID] {
+			return errors.WithMessage(
+				errors.New(FolderMovedInsideItself),
+				fmt.Sprintf("Folder %s cannot be moved inside folder %s", folderID, destFolderID))
+		}
+
+		if err := repo.errorIfNameConflict(destFolderID, folderName, folderID, noItemID); err != nil {
+			return err
+		}
+		touchedIDs := []string{destFolderID}
+		if folder.ParentId != nil {
+			touchedIDs = append(touchedIDs, *folder.ParentId)
+		}
+		if err := repo.touchFolders(currentTime(), touchedIDs...); err != nil {
+			return err
+		}
+
+		return repo.exec(`UPDATE folders SET name = ?, sort_name = ?, parent_id = ? WHERE id = ?`,
+			folderName, naturalSortKey(folderName), destFolderID, folderID)
+	})
+}
+
+func (repo SQLFileSystemRepository) MoveFile(ctx context.Context, fileID string, destFolderID string, fileName string) error {
+	return repo.inTransaction(ctx, func(repo SQLFileSystemRepository) error {
+		file, err := repo.getFile(fileID, true)
+		if err != nil {
+			return err
+		}
+		if err := repo.errorIfNameConflict(destFolderID, fileName, noItemID, fileID); err != nil {
+			return err
+		}
+		if err := repo.touchFolders(currentTime(), file.ParentId, destFolderID); err != nil {
+			return err
+		}
+
+		return repo.exec(`UPDATE files SET name = ?, sort_name = ?, extension = ?, folder_id = ? WHERE id = ?`,
+			fileName, naturalSortKey(fileName), fileExtension(fileName), destFolderID, fileID)
+	})
+}
+
+// subtreeQuery selects the ids of the folder and of its descendants
+const subtreeQuery = `WITH RECURSIVE subtree (id) AS (
+		SELECT id FROM folders WHERE id = ?
+		UNION
+		SELECT folder.id FROM folders folder JOIN subtree ON folder.parent_id = subtree.id
+	)`
+
+// subtreeBlobsQuery also selects the content keys referenced by the files of the subtree and by their versions
+const subtreeBlobsQuery = subtreeQuery + `, subtree_blobs (blob_key) AS (
+		SELECT blob_key FROM files WHERE folder_id IN (SELECT id FROM subtree)
+		UNION
+		SELECT version.blob_key FROM file_versions version JOIN files file ON file.id = version.file_id
+		WHERE file.folder_id IN (SELECT id FROM subtree)
+	)`
+
+func (repo SQLFileSystemRepository) LockFolderSubtree(ctx context.Context, folderID string) (*int, error) {
+	repo = repo.withContext(ctx)
+	if _, err := repo.getFolder(folderID, true); err != nil {
+		return nil, err
+	}
+
+	// a row cannot be locked along with the rows of another table by a single query
+	folderIDs, err := repo.lockUntilStable(subtreeQuery+`
+		SELECT id FROM folders WHERE id IN (SELECT id FROM subtree)`, folderID)
+	if err != nil {
+		return nil, err
+	}
+	fileIDs, err := repo.lockUntilStable(subtreeQuery+`
+		SELECT id FROM files WHERE folder_id IN (SELECT id FROM subtree)`, folderID)
+	if err != nil {
+		return nil, err
+	}
+
+	count := len(folderIDs) + len(fileIDs)
+	return &count, nil
+}
+
+func (repo SQLFileSystemRepository) DeleteFolderAndContent(ctx context.Context, folderID string) error {
+	return repo.inTransaction(ctx, func(repo SQLFileSystemRepository) error {
+		folder, err := repo.getFolder(folderID, true)
+		if err != nil {
+			return err
+		}
+
+		// nothing can be created inside the subtree or moved out of it until it is deleted
+		if _, err := repo.lockUntilStable(subtreeQuery+`
+			SELECT id FROM folders WHERE id IN (SELECT id FROM subtree)`, folderID); err != nil {
+			return err
+		}
+		// the other files with the same content cannot be deleted meanwhile, else none may record it as orphan
+		if _, err := repo.lockUntilStable(subtreeBlobsQuery+`
+			SELECT id FROM files WHERE blob_key IN (SELECT blob_key FROM subtree_blobs)
+				OR id IN (SELECT file_id FROM file_versions WHERE blob_key IN (SELECT blob_key FROM subtree_blobs))`, folderID); err != nil {
+			return err
+		}
+
+		if err := repo.exec(subtreeBlobsQuery+`
+			INSERT INTO orphan_blobs (blob_key)
+			SELECT candidate.blob_key FROM subtree_blobs candidate
+			WHERE candidate.blob_key <> ''
+				AND NOT EXISTS (
+					SELECT 1 FROM files other
+					WHERE other.blob_key = candidate.blob_key AND other.folder_id NOT IN (SELECT id FROM subtree))
+				AND NOT EXISTS (
+					SELECT 1 FROM file_versions version JOIN files other ON other.id = version.file_id
+					WHERE version.blob_key = candidate.blob_key AND other.folder_id NOT IN (SELECT id FROM subtree))
+			ON CONFLICT (blob_key) DO NOTHING`, folderID); err != nil {
+			return err
+		}
+		if err := repo.exec(subtreeQuery+`
+			DELETE FROM file_versions WHERE file_id IN (SELECT id FROM files WHERE folder_id IN (SELECT id FROM subtree))`, folderID); err != nil {
+			return err
+		}
+		if err := repo.exec(subtreeQuery+`
+			DELETE FROM files WHERE folder_id IN (SELECT id FROM subtree)`, folderID); err != nil {
+			return err
+		}
+		if folder.ParentId != nil {
+			if err := repo.touchFolders(currentTime(), *folder.ParentId); err != nil {
+				return err
+			}
+		}
+		return repo.exec(subtreeQuery+`
+			DELETE FROM folders WHERE id IN (SELECT id FROM subtree)`, folderID)
+	})
+}
+
+func (repo SQLFileSystemRepository) DeleteFile(ctx context.Context, fileID string) error {
+	return repo.inTransaction(ctx, func(repo SQLFileSystemRepository) error {
+		file, err := repo.getFile(fileID, true)
+		if err != nil {
+			return err
+		}
+
+		versions, err := repo.getFileVersions(fileID)
+		if err != nil {
+			return err
+		}
+		deletedBlobKeys := []string{file.BlobKey}
+		for _, version := range *versions {
+			deletedBlobKeys = append(deletedBlobKeys, version.BlobKey)
+		}
+		if err := repo.lockBlobReferences(deletedBlobKeys); err != nil {
+			return err
+		}
+
+		if err := repo.exec(`DELETE FROM file_versions WHERE file_id = ?`, fileID); err != nil {
+			return err
+		}
+		if err := repo.exec(`DELETE FROM files WHERE id = ?`, fileID); err != nil {
+			return err
+		}
+		if err := repo.touchFolders(currentTime(), file.ParentId); err != nil {
+			return err
+		}
+		return repo.recordOrphanBlobKeys(deletedBlobKeys)
+	})
+}
+
+func (repo SQLFileSystemRepository) GetOrphanBlobKeys(ctx context.Context) (*[]string, error) {
+	rows, err := repo.withContext(ctx).query(`SELECT blob_key FROM orphan_blobs ORDER BY blob_key`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	blobKeys := make([]string, 0)
+	for rows.Next() {
+		var blobKey string
+		if err := rows.Scan(&blobKey); err != nil {
+			return nil, err
+		}
+		blobKeys = append(blobKeys, blobKey)
+	}
+	return &blobKeys, rows.Err()
+}
+
+func (repo SQLFileSystemRepository) IsBlobReferenced(ctx context.Context, blobKey string) (*bool, error) {
+	var referenced bool
+	err := repo.withContext(ctx).queryRow(`SELECT EXISTS (SELECT 1 FROM files WHERE blob_key = ?)
+		OR EXISTS (SELECT 1 FROM file_versions WHERE blob_key = ?)`, blobKey, blobKey).Scan(&referenced)
+	if err != nil {
+		return nil, err
+	}
+	return &referenced, nil
+}
+
+func (repo SQLFileSystemRepository) RemoveOrphanBlobKey(ctx context.Context, blobKey string) error {
+	return repo.withContext(ctx).exec(`DELETE FROM orphan_blobs WHERE blob_key = ?`, blobKey)
+}
+
+func (repo SQLFileSystemRepository) GetFile(ctx context.Context, fileID string) (*fsmodel.File, error) {
+	return repo.withContext(ctx).getFile(fileID, false)
+}
+
+func (repo SQLFileSystemRepository) ExistsFile(ctx context.Context, fileID string) (*bool, error) {
+	return repo.withContext(ctx).exists(`SELECT id FROM files WHERE id = ?`, fileID)
+}
+
+func (repo SQLFileSystemRepository) ExistsUntrashedFile(ctx context.Context, fileID string) (*bool, error) {
+	repo = repo.withContext(ctx)
+	var folderID string
+	err := repo.queryRow(`SELECT folder_id FROM files WHERE id = ?`+repo.lockClause(repo.tx != nil), fileID).Scan(&folderID)
+	if err == sql.ErrNoRows {
+		exists := false
+		return &exists, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	trashed, err := repo.isTrashed(folderID)
+	if err != nil {
+		return nil, err
+	}
+	exists := !trashed
+	return &exists, nil
+}
+
+func (repo SQLFileSystemRepository) GetFilesIn(ctx context.Context, folderID string) (*[]fsmodel.File, error) {
+	return repo.withContext(ctx).getFilesIn(folderID)
+}
+
+func (repo SQLFileSystemRepository) getFilesIn(folderID string) (*[]fsmodel.File, error) {
+	rows, err := repo.query(`SELECT `+sqlFileColumns+` FROM files WHERE folder_id = ? ORDER BY id`, folderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	files := make([]fsmodel.File, 0)
+	for rows.Next() {
+		file, err := scanFile(rows)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, *file)
+	}
+	return &files, rows.Err()
+}
+
+func (repo SQLFileSystemRepository) GetChildrenWithNamePrefix(ctx context.Context, folderID string, namePrefix string) (*[]fsmodel.NamedChild, error) {
+	return repo.withContext(ctx).getChildrenWithNamePrefix(folderID, namePrefix)
+}
+
+// getChildrenWithNamePrefix scans the range of sort keys the names starting with the prefix have, then keeps these
+// names only
+func (repo SQLFileSystemRepository) getChildrenWithNamePrefix(folderID string, namePrefix string) (*[]fsmodel.NamedChild, error) {
+	lower, upper := sortKeyPrefixRange(namePrefix)
+	keyRange := `sort_name >= ?`
+	args := []interface{}{folderID, lower}
+	if upper != nil {
+		keyRange += ` AND sort_name < ?`
+		args = append(args, *upper)
+	}
+
+	rows, err := repo.query(fmt.Sprintf(`SELECT '%s', id, name FROM folders WHERE parent_id = ? AND %s
+		UNION ALL SELECT '%s', id, name FROM files WHERE folder_id = ? AND %s`, fsmodel.FolderKind, keyRange, fsmodel.FileKind, keyRange),
+		append(args, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	children := make([]fsmodel.NamedChild, 0)
+	for rows.Next() {
+		var child fsmodel.NamedChild
+		if err := rows.Scan(&child.Kind, &child.Id, &child.Name); err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(child.Name, namePrefix) {
+			children = append(children, child)
+		}
+	}
+	return &children, rows.Err()
+}
+
+func (repo SQLFileSystemRepository) GetFolder(ctx context.Context, folderID string) (*fsmodel.Folder, error) {
+	return repo.withContext(ctx).getFolder(folderID, false)
+}
+
+func (repo SQLFileSystemRepository) GetRootFolderID(ctx context.Context) (*string, error) {
+	var rootFolderID string
+	err := repo.withContext(ctx).queryRow(`SELECT id FROM folders WHERE is_root`).Scan(&rootFolderID)
+	if err == sql.ErrNoRows {
+		return nil, errors.WithMessage(errors.New(ItemNotFound), "Could not find the root folder")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rootFolderID, nil
+}
+
+func (repo SQLFileSystemRepository) IsRootFolder(ctx context.Context, folderID string) (*bool, error) {
+	var isRoot bool
+	err := repo.withContext(ctx).queryRow(`SELECT is_root FROM folders WHERE id = ?`, folderID).Scan(&isRoot)
+	if err == sql.ErrNoRows {
+		return nil, folderNotFoundError(folderID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &isRoot, nil
+}
+
+func (repo SQLFileSystemRepository) IsFolderInside(ctx context.Context, folderID string, ancestorFolderID string) (*bool, error) {
+	repo = repo.withContext(ctx)
+	if err := repo.errorIfFolderNotFound(folderID); err != nil {
+		return nil, err
+	}
+	if err := repo.errorIfFolderNotFound(ancestorFolderID); err != nil {
+		return nil, err
+	}
+
+	ancestorIDs, err := repo.lockAncestors(folderID)
+	if err != nil {
+		return nil, err
+	}
+	isInside := ancestorIDs[ancestorFolderID]
+	return &isInside, nil
+}
+
+func (repo SQLFileSystemRepository) ExistsFolder(ctx context.Context, folderID string) (*bool, error) {
+	return repo.withContext(ctx).exists(`SELECT id FROM folders WHERE id = ?`, folderID)
+}
+
+func (repo SQLFileSystemRepository) ExistsUntrashedFolder(ctx context.Context, folderID string) (*bool, error) {
+	repo = repo.withContext(ctx)
+	exists, err := repo.exists(`SELECT id FROM folders WHERE id = ?`, folderID)
+	if err != nil || !*exists {
+		return exists, err
+	}
+	trashed, err := repo.isTrashed(folderID)
+	if err != nil {
+		return nil, err
+	}
+	*exists = !trashed
+	return exists, nil
+}
+
+func (repo SQLFileSystemRepository) GetFoldersIn(ctx context.Context, folderID string) (*[]fsmodel.Folder, error) {
+	return repo.withContext(ctx).getFoldersIn(folderID)
+}
+
+func (repo SQLFileSystemRepository) GetFolderIDByLegacyID(ctx context.Context, legacyID int) (*string, error) {
+	var folderID string
+	err := repo.withContext(ctx).queryRow(`SELECT id FROM folders WHERE legacy_id = ?`, legacyID).Scan(&folderID)
+	if err == sql.ErrNoRows {
+		return nil, legacyFolderNotFoundError(legacyID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &folderID, nil
+}
+
+func (repo SQLFileSystemRepository) GetFileIDByLegacyID(ctx context.Context, legacyID int) (*string, error) {
+	var fileID string
+	err := repo.withContext(ctx).queryRow(`SELECT id FROM files WHERE legacy_id = ?`, legacyID).Scan(&fileID)
+	if err == sql.ErrNoRows {
+		return nil, legacyFileNotFoundError(legacyID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &fileID, nil
+}
+
+func (repo SQLFileSystemRepository) getFoldersIn(folderID string) (*[]fsmodel.Folder, error) {
+	rows, err := repo.query(`SELECT `+sqlFolderColumns+` FROM folders WHERE parent_id = ? ORDER BY id`, folderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	folders := make([]fsmodel.Folder, 0)
+	for rows.Next() {
+		folder, err := scanFolder(rows)
+		if err != nil {
+			return nil, err
+		}
+		folders = append(folders, *folder)
+	}
+	return &folders, rows.Err()
+}
+
+// GetFolderContent reads the folder and its children inside a single transaction, so that they are consistent
+func (repo SQLFileSystemRepository) GetFolderContent(ctx context.Context, folderID string, options fsmodel.ListOptions) (*fsmodel.FolderContent, error) {
+	var content fsmodel.FolderContent
+	err := repo.inTransaction(ctx, func(repo SQLFileSystemRepository) error {
+		folder, err := repo.getFolder(folderID, false)
+		if err != nil {
+			return err
+		}
+		var counts fsmodel.ChildCounts
+		if err := repo.queryRow(`SELECT (SELECT count(*) FROM folders WHERE parent_id = ?), (SELECT count(*) FROM files WHERE folder_id = ?)`,
+			folderID, folderID).Scan(&counts.Folders, &counts.Files); err != nil {
+			return err
+		}
+		content.ChildCounts = map[string]fsmodel.ChildCounts{folderID: counts}
+		if content.Trashed, err = repo.isTrashed(folderID); err != nil {
+			return err
+		}
+
+		folders := make([]fsmodel.Folder, 0)
+		if listsFolders(options) {
+			if folders, err = repo.listFolders(folderID, options, content.ChildCounts); err != nil {
+				return err
+			}
+		}
+		files := make([]fsmodel.File, 0)
+		// the folders may fill the page already
+		if listsFiles(options) && (options.Limit == 0 || len(folders) <= options.Limit) {
+			if files, err = repo.listFiles(folderID, options); err != nil {
+				return err
+			}
+		}
+
+		content.Folder = *folder
+		content.Folders, content.Files, content.Next = pageOf(options, folders, files)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &content, nil
+}
+
+// isTrashed tells whether the folder is the trash folder or one of its descendants
+func (repo SQLFileSystemRepository) isTrashed(folderID string) (bool, error) {
+	var trashed bool
+	err := repo.queryRow(`WITH RECURSIVE ancestors (id) AS (
+			SELECT id FROM folders WHERE id = ?
+			UNION
+			SELECT folder.parent_id FROM folders folder JOIN ancestors ON folder.id = ancestors.id
+			WHERE folder.parent_id IS NOT NULL
+		)
+		SELECT count(*) > 0 FROM ancestors WHERE id = ?`, folderID, trashFolderID).Scan(&trashed)
+	return trashed, err
+}
+
+// listFolders adds the child counts of the listed folders to the given ones
+func (repo SQLFileSystemRepository) listFolders(folderID string, options fsmodel.ListOptions, childCounts map[string]fsmodel.ChildCounts) ([]fsmodel.Folder, error) {
+	query, args := repo.listQuery(`SELECT `+sqlFolderColumns+`,
+			(SELECT count(*) FROM folders child WHERE child.parent_id = folders.id),
+			(SELECT count(*) FROM files child WHERE child.folder_id = folders.id)
+		FROM folders WHERE parent_id = ?`, folderID, fsmodel.FolderKind, options)
+	rows, err := repo.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	folders := make([]fsmodel.Folder, 0)
+	for rows.Next() {
+		var counts fsmodel.ChildCounts
+		folder, err := scanFolder(rows, &counts.Folders, &counts.Files)
+		if err != nil {
+			return nil, err
+		}
+		childCounts[folder.Id] = counts
+		folders = append(folders, *folder)
+	}
+	return folders, rows.Err()
+}
+
+func (repo SQLFileSystemRepository) listFiles(folderID string, options fsmodel.ListOptions) ([]fsmodel.File, error) {
+	query, args := repo.listQuery(`SELECT `+sqlFileColumns+` FROM files WHERE folder_id = ?`, folderID, fsmodel.FileKind, options)
+	rows, err := repo.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	files := make([]fsmodel.File, 0)
+	for rows.Next() {
+		file, err := scanFile(rows)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, *file)
+	}
+	return files, rows.Err()
+}
+
+// listQuery completes the query selecting the children of the kind with the filters, the position and the order of
+// the options, and returns it with its arguments
+func (repo SQLFileSystemRepository) listQuery(query string, folderID string, kind fsmodel.ItemKind, options fsmodel.ListOptions) (string, []interface{}) {
+	args := []interface{}{folderID}
+	if options.NameGlob != "" {
+		operator, pattern := repo.dialect.nameGlob(options.NameGlob)
+		query += fmt.Sprintf(" AND name %s ?", operator)
+		args = append(args, pattern)
+	}
+	if options.Extension != "" {
+		query += " AND extension = ?"
+		args = append(args, options.Extension)
+	}
+
+	columns := make([]string, 0)
+	for _, name := range listKeyNames(options.Sort, kind) {
+		columns = append(columns, listKeyExpression("", name))
+	}
+	columns = append(columns, dbId)
+	comparison, direction := ">", "ASC"
+	if options.Descending {
+		comparison, direction = "<", "DESC"
+	}
+	if after := listedAfter(options, kind); after != nil {
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+		query += fmt.Sprintf(" AND (%s) %s (%s)", strings.Join(columns, ", "), comparison, placeholders)
+		for _, key := range listKeys(options.Sort, kind, positionChild(*after)) {
+			args = append(args, key)
+		}
+		args = append(args, after.Id)
+	}
+
+	orderBy := make([]string, 0, len(columns))
+	for _, column := range columns {
+		orderBy = append(orderBy, column+" "+direction)
+	}
+	query += " ORDER BY " + strings.Join(orderBy, ", ")
+	if limit := fetchLimit(options); limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit)
+	}
+	return query, args
+}
+
+func (repo SQLFileSystemRepository) CreateFile(ctx context.Context, fileName string, content fsmodel.FileContent, folderParentID string) (*string, error) {
+	var fileID string
+	err := repo.inTransaction(ctx, func(repo SQLFileSystemRepository) error {
+		if err := repo.errorIfNameConflict(folderParentID, fileName, noItemID, noItemID); err != nil {
+			return err
+		}
+
+		fileID = newID()
+		createdAt := currentTime()
+		if err := repo.exec(`INSERT INTO files (id, name, sort_name, extension, folder_id, blob_key, digest, size, content_type, created_at, modified_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			fileID, fileName, naturalSortKey(fileName), fileExtension(fileName), folderParentID, content.BlobKey, content.Digest,
+			knownSize(content.Size), content.ContentType, unixMillis(createdAt), unixMillis(createdAt)); err != nil {
+			return err
+		}
+		if err := repo.touchFolders(createdAt, folderParentID); err != nil {
+			return err
+		}
+		return repo.exec(`DELETE FROM orphan_blobs WHERE blob_key = ?`, content.BlobKey)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &fileID, nil
+}
+
+func (repo SQLFileSystemRepository) CreateFolder(ctx context.Context, folderName string, folderParentID string) (*string, error) {
+	var folderID string
+	err := repo.inTransaction(ctx, func(repo SQLFileSystemRepository) error {
+		if err := repo.errorIfNameConflict(folderParentID, folderName, noItemID, noItemID); err != nil {
+			return err
+		}
+
+		folderID = newID()
+		createdAt := currentTime()
+		if err := repo.exec(`INSERT INTO folders (id, name, sort_name, parent_id, created_at, modified_at) VALUES (?, ?, ?, ?, ?, ?)`,
+			folderID, folderName, naturalSortKey(folderName), folderParentID, unixMillis(createdAt), unixMillis(createdAt)); err != nil {
+			return err
+		}
+		return repo.touchFolders(createdAt, folderParentID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &folderID, nil
+}
+
+func (repo SQLFileSystemRepository) ImportFolder(ctx context.Context, folder fsmodel.Folder) error {
+	if folder.ParentId == nil {
+		return rootFolderImportedError(folder.Id)
+	}
+	return repo.inTransaction(ctx, func(repo SQLFileSystemRepository) error {
+		exists, err := repo.exists(`SELECT id FROM folders WHERE id = ? OR legacy_id = ?`, folder.Id, sqlLegacyID(folder.LegacyId))
+		if err != nil {
+			return err
+		}
+		if *exists {
+			return idAlreadyExistsError(dbFolder, folder.Id, folder.LegacyId)
+		}
+		if err := repo.errorIfNameConflict(*folder.ParentId, folder.Name, noItemID, noItemID); err != nil {
+			return err
+		}
+
+		return repo.exec(`INSERT INTO folders (id, legacy_id, name, sort_name, parent_id, created_at, modified_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			folder.Id, sqlLegacyID(folder.LegacyId), folder.Name, naturalSortKey(folder.Name), *folder.ParentId,
+			unixMillis(folder.CreatedAt), unixMillis(folder.ModifiedAt))
+	})
+}
+
+func (repo SQLFileSystemRepository) ImportFile(ctx context.Context, file fsmodel.File) error {
+	return repo.inTransaction(ctx, func(repo SQLFileSystemRepository) error {
+		exists, err := repo.exists(`SELECT id FROM files WHERE id = ? OR legacy_id = ?`, file.Id, sqlLegacyID(file.LegacyId))
+		if err != nil {
+			return err
+		}
+		if *exists {
+			return idAlreadyExistsError(dbFile, file.Id, file.LegacyId)
+		}
+		if err := repo.errorIfNameConflict(file.ParentId, file.Name, noItemID, noItemID); err != nil {
+			return err
+		}
+
+		if err := repo.exec(`INSERT INTO files (id, legacy_id, name, sort_name, extension, folder_id, blob_key, digest, size, content_type, created_at, modified_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			file.Id, sqlLegacyID(file.LegacyId), file.Name, naturalSortKey(file.Name), fileExtension(file.Name), file.ParentId, file.BlobKey, file.Digest,
+			knownSize(file.Size), file.ContentType, unixMillis(file.CreatedAt), unixMillis(file.ModifiedAt)); err != nil {
+			return err
+		}
+		return repo.exec(`DELETE FROM orphan_blobs WHERE blob_key = ?`, file.BlobKey)
+	})
+}
+
+// GetTrashFolderID does not lock the trash folder: every transaction which trashes or restores an item reads it
+func (repo SQLFileSystemRepository) GetTrashFolderID(ctx context.Context) (*string, error) {
+	folder, err := repo.withContext(ctx).getFolder(trashFolderID, false)
+	if err != nil {
+		return nil, err
+	}
+	return &folder.Id, nil
+}
+
+func (repo SQLFileSystemRepository) CreateTrashEntry(ctx context.Context, entry fsmodel.TrashEntry) error {
+	entry.TrashedAt = currentTime()
+	return repo.ImportTrashEntry(ctx, entry)
+}
+
+func (repo SQLFileSystemRepository) GetTrashEntries(ctx context.Context) (*[]fsmodel.TrashEntry, error) {
+	rows, err := repo.withContext(ctx).query(`SELECT ` + sqlTrashEntryColumns + ` FROM trash_entries ORDER BY trashed_at DESC, item_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]fsmodel.TrashEntry, 0)
+	for rows.Next() {
+		entry, err := scanTrashEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, *entry)
+	}
+	return &entries, rows.Err()
+}
+
+func (repo SQLFileSystemRepository) DeleteTrashEntry(ctx context.Context, itemID string) (*fsmodel.TrashEntry, error) {
+	var entry *fsmodel.TrashEntry
+	err := repo.inTransaction(ctx, func(repo SQLFileSystemRepository) error {
+		var err error
+		entry, err = scanTrashEntry(repo.queryRow(`SELECT `+sqlTrashEntryColumns+` FROM trash_entries WHERE item_id = ?`+repo.lockClause(true), itemID))
+		if err == sql.ErrNoRows {
+			return trashEntryNotFoundError(itemID)
+		}
+		if err != nil {
+			return err
+		}
+		return repo.exec(`DELETE FROM trash_entries WHERE item_id = ?`, itemID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func (repo SQLFileSystemRepository) ImportTrashEntry(ctx context.Context, entry fsmodel.TrashEntry) error {
+	return repo.inTransaction(ctx, func(repo SQLFileSystemRepository) error {
+		exists, err := repo.exists(`SELECT item_id FROM trash_entries WHERE item_id = ?`, entry.ItemId)
+		if err != nil {
+			return err
+		}
+		if *exists {
+			return trashEntryAlreadyExistsError(entry.ItemId)
+		}
+		return repo.exec(`INSERT INTO trash_entries (item_id, kind, name, parent_id, trashed_at) VALUES (?, ?, ?, ?, ?)`,
+			entry.ItemId, string(entry.Kind), entry.Name, entry.ParentId, unixMillis(entry.TrashedAt))
+	})
+}
+
+func (repo SQLFileSystemRepository) UpdateFileContent(ctx context.Context, fileID string, content fsmodel.FileContent) error {
+	return repo.inTransaction(ctx, func(repo SQLFileSystemRepository) error {
+		file, err := repo.getFile(fileID, true)
+		if err != nil {
+			return err
+		}
+		if err := repo.lockBlobReferences([]string{file.BlobKey}); err != nil {
+			return err
+		}
+
+		if err := repo.exec(`UPDATE files SET blob_key = ?, digest = ?, size = ?, content_type = ?, modified_at = ? WHERE id = ?`,
+			content.BlobKey, content.Digest, knownSize(content.Size), content.ContentType, unixMillis(currentTime()), fileID); err != nil {
+			return err
+		}
+		if err := repo.exec(`DELETE FROM orphan_blobs WHERE blob_key = ?`, content.BlobKey); err != nil {
+			return err
+		}
+		return repo.recordOrphanBlobKeys([]string{file.BlobKey})
+	})
+}
+
+func (repo SQLFileSystemRepository) GetFileVersions(ctx context.Context, fileID string) (*[]fsmodel.FileVersion, error) {
+	repo = repo.withContext(ctx)
+	if _, err := repo.getFile(fileID, false); err != nil {
+		return nil, err
+	}
+	return repo.getFileVersions(fileID)
+}
+
+func (repo SQLFileSystemRepository) CreateFileVersion(ctx context.Context, fileID string, version fsmodel.FileVersion) error {
+	return repo.inTransaction(ctx, func(repo SQLFileSystemRepository) error {
+		if _, err := repo.getFile(fileID, true); err != nil {
+			return err
+		}
+		exists, err := repo.exists(`SELECT file_id FROM file_versions WHERE file_id = ? AND number = ?`, fileID, version.Number)
+		if err != nil {
+			return err
+		}
+		if *exists {
+			return fileVersionAlreadyExistsError(fileID, version.Number)
+		}
+
+		if err := repo.exec(`INSERT INTO file_versions (file_id, number, blob_key, digest, size, content_type, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			fileID, version.Number, version.BlobKey, version.Digest, knownSize(version.Size), version.ContentType, unixMillis(version.CreatedAt)); err != nil {
+			return err
+		}
+		return repo.exec(`DELETE FROM orphan_blobs WHERE blob_key = ?`, version.BlobKey)
+	})
+}
+
+func (repo SQLFileSystemRepository) DeleteFileVersion(ctx context.Context, fileID string, number int) error {
+	return repo.inTransaction(ctx, func(repo SQLFileSystemRepository) error {
+		if _, err := repo.getFile(fileID, true); err != nil {
+			return err
+		}
+		var blobKey string
+		err := repo.queryRow(`SELECT blob_key FROM file_versions WHERE file_id = ? AND number = ?`, fileID, number).Scan(&blobKey)
+		if err == sql.ErrNoRows {
+			return fileVersionNotFoundError(fileID, number)
+		}
+		if err != nil {
+			return err
+		}
+		if err := repo.lockBlobReferences([]string{blobKey}); err != nil {
+			return err
+		}
+
+		if err := repo.exec(`DELETE FROM file_versions WHERE file_id = ? AND number = ?`, fileID, number); err != nil {
+			return err
+		}
+		return repo.recordOrphanBlobKeys([]string{blobKey})
+	})
+}
+
+// getFileVersions reads the versions of the file, the newest first
+func (repo SQLFileSystemRepository) getFileVersions(fileID string) (*[]fsmodel.FileVersion, error) {
+	rows, err := repo.query(`SELECT `+sqlFileVersionColumns+` FROM file_versions WHERE file_id = ? ORDER BY number DESC`, fileID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := make([]fsmodel.FileVersion, 0)
+	for rows.Next() {
+		version, err := scanFileVersion(rows)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, *version)
+	}
+	return &versions, rows.Err()
+}
+
+// lockBlobReferences locks the files which reference one of the contents, by themselves or by one of their versions:
+// the other references cannot be deleted meanwhile, else none may record the content as orphan
+func (repo SQLFileSystemRepository) lockBlobReferences(blobKeys []string) error {
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(blobKeys)), ", ")
+	args := make([]interface{}, 0, 2*len(blobKeys))
+	for i := 0; i < 2; i++ {
+		for _, blobKey := range blobKeys {
+			args = append(args, blobKey)
+		}
+	}
+	_, err := repo.lockUntilStable(`SELECT id FROM files WHERE blob_key IN (`+placeholders+`)
+		OR id IN (SELECT file_id FROM file_versions WHERE blob_key IN (`+placeholders+`))`, args...)
+	return err
+}
+
+// recordOrphanBlobKeys records the contents which neither a file nor a file version references anymore
+func (repo SQLFileSystemRepository) recordOrphanBlobKeys(blobKeys []string) error {
+	for _, blobKey := range blobKeys {
+		if blobKey == "" {
+			continue
+		}
+		if err := repo.exec(`INSERT INTO orphan_blobs (blob_key)
+			SELECT CAST(? AS TEXT) WHERE NOT EXISTS (SELECT 1 FROM files WHERE blob_key = ?)
+				AND NOT EXISTS (SELECT 1 FROM file_versions WHERE blob_key = ?)
+			ON CONFLICT (blob_key) DO NOTHING`, blobKey, blobKey, blobKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getFolder locks the folder until the end of the transaction when asked to
+func (repo SQLFileSystemRepository) getFolder(folderID string, lock bool) (*fsmodel.Folder, error) {
+	folder, err := scanFolder(repo.queryRow(`SELECT `+sqlFolderColumns+` FROM folders WHERE id = ?`+repo.lockClause(lock), folderID))
+	if err == sql.ErrNoRows {
+		return nil, folderNotFoundError(folderID)
+	}
+	return folder, err
+}
+
+// getFile locks the file until the end of the transaction when asked to
+func (repo SQLFileSystemRepository) getFile(fileID string, lock bool) (*fsmodel.File, error) {
+	file, err := scanFile(repo.queryRow(`SELECT `+sqlFileColumns+` FROM files WHERE id = ?`+repo.lockClause(lock), fileID))
+	if err == sql.ErrNoRows {
+		return nil, fileNotFoundError(fileID)
+	}
+	return file, err
+}
+
+// exists locks the item found inside a transaction
+func (repo SQLFileSystemRepository) exists(query string, args ...interface{}) (*bool, error) {
+	var id string
+	err := repo.queryRow(query+repo.lockClause(repo.tx != nil), args...).Scan(&id)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	exists := err == nil
+	return &exists, nil
+}
+
+// touchFolders records that a direct child of the folders was added, removed or renamed
+func (repo SQLFileSystemRepository) touchFolders(modifiedAt time.Time, folderIDs ...string) error {
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(folderIDs)), ", ")
+	args := []interface{}{unixMillis(modifiedAt)}
+	for _, folderID := range folderIDs {
+		args = append(args, folderID)
+	}
+	return repo.exec(`UPDATE folders SET modified_at = ? WHERE id IN (`+placeholders+`)`, args...)
+}
+
+func (repo SQLFileSystemRepository) errorIfFolderNotFound(folderID string) error {
+	_, err := repo.getFolder(folderID, repo.tx != nil)
+	return err
+}
+
+// errorIfNameConflict locks the folder, so that no other item gets the name until the end of the transaction. The
+// given folder and file (noItemID for none) are the ones being named, they do not conflict with themselves.
+func (repo SQLFileSystemRepository) errorIfNameConflict(folderID string, name string, excludedFolderID string, excludedFileID string) error {
+	if _, err := repo.getFolder(folderID, true); err != nil {
+		return err
+	}
+
+	var conflicts int
+	err := repo.queryRow(`SELECT
+			(SELECT count(*) FROM folders WHERE parent_id = ? AND name = ? AND id <> ?) +
+			(SELECT count(*) FROM files WHERE folder_id = ? AND name = ? AND id <> ?)`,
+		folderID, name, excludedFolderID, folderID, name, excludedFileID).Scan(&conflicts)
+	if err != nil {
+		return err
+	}
+	if conflicts > 0 {
+		return nameAlreadyExistsError(folderID, name)
+	}
+	return nil
+}
+
+// lockAncestors returns the ancestors of the folder, which cannot be moved until the end of the transaction if any
+func (repo SQLFileSystemRepository) lockAncestors(folderID string) (map[string]bool, error) {
+	return repo.lockUntilStable(`WITH RECURSIVE ancestors (id) AS (
+			SELECT parent_id FROM folders WHERE id = ? AND parent_id IS NOT NULL
+			UNION
+			SELECT folder.parent_id FROM folders folder JOIN ancestors ON folder.id = ancestors.id
+			WHERE folder.parent_id IS NOT NULL
+		)
+		SELECT id FROM folders WHERE id IN (SELECT id FROM ancestors)`, folderID)
+}
+
+// lockUntilStable runs the query, which selects ids, with the lock clause until it selects no new id. The rows
+// selected may have changed while waiting for their locks: a folder moved inside a subtree which was being locked.
+// Outside of a transaction the locks would be released straight away: the query runs once, without lock clause.
+func (repo SQLFileSystemRepository) lockUntilStable(query string, args ...interface{}) (map[string]bool, error) {
+	lockClause := repo.lockClause(repo.tx != nil)
+	lockedIDs := make(map[string]bool)
+	for {
+		rows, err := repo.query(query+lockClause, args...)
+		if err != nil {
+			return nil, err
+		}
+
+		stable := true
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if !lockedIDs[id] {
+				lockedIDs[id] = true
+				stable = false
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+
+		// without lock clause nothing was waited for
+		if stable || lockClause == "" {
+			return lockedIDs, nil
+		}
+	}
+}
+
+func (repo SQLFileSystemRepository) lockClause(lock bool) string {
+	if !lock {
+		return ""
+	}
+	return repo.dialect.forUpdate
+}
+
+// withContext runs the operations of the returned repository in the context
+func (repo SQLFileSystemRepository) withContext(ctx context.Context) SQLFileSystemRepository {
+	repo.ctx = ctx
+	return repo
+}
+
+func (repo SQLFileSystemRepository) exec(query string, args ...interface{}) error {
+	var err error
+	if repo.tx != nil {
+		_, err = repo.tx.ExecContext(repo.ctx, repo.dialect.rebind(query), args...)
+	} else {
+		_, err = repo.db.ExecContext(repo.ctx, repo.dialect.rebind(query), args...)
+	}
+	return contextErrorOr(repo.ctx, err)
+}
+
+func (repo SQLFileSystemRepository) query(query string, args ...interface{}) (*sql.Rows, error) {
+	if repo.tx != nil {
+		return repo.tx.QueryContext(repo.ctx, repo.dialect.rebind(query), args...)
+	}
+	return repo.db.QueryContext(repo.ctx, repo.dialect.rebind(query), args...)
+}
+
+func (repo SQLFileSystemRepository) queryRow(query string, args ...interface{}) *sql.Row {
+	if repo.tx != nil {
+		return repo.tx.QueryRowContext(repo.ctx, repo.dialect.rebind(query), args...)
+	}
+	return repo.db.QueryRowContext(repo.ctx, repo.dialect.rebind(query), args...)
+}
+
+// rowScanner is either *sql.Row or *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// the columns read by scanFolder and scanFile
+const (
+	sqlFolderColumns = "id, legacy_id, name, parent_id, created_at, modified_at"
+	sqlFileColumns   = "id, legacy_id, name, folder_id, blob_key, digest, size, content_type, created_at, modified_at"
+
+	sqlTrashEntryColumns  = "item_id, kind, name, parent_id, trashed_at"
+	sqlFileVersionColumns = "number, blob_key, digest, size, content_type, created_at"
+)
+
+// scanFolder also scans the columns selected after sqlFolderColumns into extra
+func scanFolder(row rowScanner, extra ...interface{}) (*fsmodel.Folder, error) {
+	var folder fsmodel.Folder
+	var legacyID, createdAt, modifiedAt sql.NullInt64
+	var parentID sql.NullString
+	dest := append([]interface{}{&folder.Id, &legacyID, &folder.Name, &parentID, &createdAt, &modifiedAt}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	folder.LegacyId = int(legacyID.Int64)
+	if parentID.Valid {
+		folder.ParentId = &parentID.String
+	}
+	if createdAt.Valid {
+		folder.CreatedAt = fromUnixMillis(createdAt.Int64)
+	}
+	if modifiedAt.Valid {
+		folder.ModifiedAt = fromUnixMillis(modifiedAt.Int64)
+	}
+	return &folder, nil
+}
+
+func scanFile(row rowScanner) (*fsmodel.File, error) {
+	var file fsmodel.File
+	var legacyID, size, createdAt, modifiedAt sql.NullInt64
+	if err := row.Scan(&file.Id, &legacyID, &file.Name, &file.ParentId, &file.BlobKey, &file.Digest,
+		&size, &file.ContentType, &createdAt, &modifiedAt); err != nil {
+		return nil, err
+	}
+	file.LegacyId = int(legacyID.Int64)
+	file.Size = fsmodel.UnknownSize
+	if size.Valid {
+		file.Size = size.Int64
+	}
+	if createdAt.Valid {
+		file.CreatedAt = fromUnixMillis(createdAt.Int64)
+	}
+	if modifiedAt.Valid {
+		file.ModifiedAt = fromUnixMillis(modifiedAt.Int64)
+	}
+	return &file, nil
+}
+
+func scanFileVersion(row rowScanner) (*fsmodel.FileVersion, error) {
+	var version fsmodel.FileVersion
+	var size, createdAt sql.NullInt64
+	if err := row.Scan(&version.Number, &version.BlobKey, &version.Digest, &size, &version.ContentType, &createdAt); err != nil {
+		return nil, err
+	}
+	version.Size = fsmodel.UnknownSize
+	if size.Valid {
+		version.Size = size.Int64
+	}
+	if createdAt.Valid {
+		version.CreatedAt = fromUnixMillis(createdAt.Int64)
+	}
+	return &version, nil
+}
+
+func scanTrashEntry(row rowScanner) (*fsmodel.TrashEntry, error) {
+	var entry fsmodel.TrashEntry
+	var kind string
+	var trashedAt int64
+	if err := row.Scan(&entry.ItemId, &kind, &entry.Name, &entry.ParentId, &trashedAt); err != nil {
+		return nil, err
+	}
+	entry.Kind = fsmodel.ItemKind(kind)
+	entry.TrashedAt = fromUnixMillis(trashedAt)
+	return &entry, nil
+}
+
+// sqlLegacyID stores no legacy id as NULL: the legacy ids are unique, the NULLs are not
+func sqlLegacyID(legacyID int) interface{} {
+	if legacyID == 0 {
+		return nil
+	}
+	return legacyID
+}
+
+// numberedPlaceholders replaces the '?' placeholders by the '$1', '$2'... of PostgreSQL
+func numberedPlaceholders(query string) string {
+	var builder strings.Builder
+	n := 0
+	for _, char := range query {
+		if char == '?' {
+			n++
+			builder.WriteString(fmt.Sprintf("$%d", n))
+			continue
+		}
+		builder.WriteRune(char)
+	}
+	return builder.String()
+}
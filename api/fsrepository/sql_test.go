@@ -0,0 +1,189 @@
+package fsrepository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/loisfa/remote-file-system/api/fsmodel"
+)
+
+func TestSQLiteDatabaseIsMigratedOnce(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "sqlite-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "test.db")
+
+	repo, err := NewSQLiteFileSystemRepository(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	folderID, err := repo.CreateFolder(ctx, "kept", rootFolderID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo.Close()
+
+	// the schema is up to date on restart, and the content survives it
+	repo, err = NewSQLiteFileSystemRepository(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.Close()
+
+	folder, err := repo.GetFolder(ctx, *folderID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if folder.Name != "kept" {
+		t.Fatalf("unexpected folder %+v", *folder)
+	}
+
+	var versions int
+	if err := repo.withContext(ctx).queryRow(`SELECT count(*) FROM schema_version`).Scan(&versions); err != nil {
+		t.Fatal(err)
+	}
+	if versions != len(sqlMigrations) {
+		t.Fatalf("expected %d schema versions, got %d", len(sqlMigrations), versions)
+	}
+}
+
+func TestSQLiteSortKeysAreMigrated(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "sqlite-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "test.db")
+
+	// a database at version 1, holding items without sort keys
+	db, err := sql.Open(sqliteDialect.driverName, fmt.Sprintf("file:%s?_pragma=foreign_keys(1)", file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	statements := append(sqlMigrations[0].statements(sqliteDialect),
+		`CREATE TABLE schema_version (version INTEGER NOT NULL)`,
+		`INSERT INTO schema_version (version) VALUES (1)`,
+		`INSERT INTO folders (id, name, parent_id) VALUES (1, 'photo 10', 0), (2, 'Photo 9', 0)`,
+		`INSERT INTO files (id, name, folder_id, blob_key, digest) VALUES (1, 'b.TXT', 0, 'b', 'b'), (2, 'a.png', 0, 'a', 'a')`)
+	for _, statement := range statements {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	repo, err := NewSQLiteFileSystemRepository(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.Close()
+
+	content, err := repo.GetFolderContent(ctx, rootFolderID, fsmodel.ListOptions{Sort: fsmodel.SortByType, Descending: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(content.Folders) != 2 || content.Folders[0].LegacyId != 1 || content.Folders[1].LegacyId != 2 {
+		t.Fatalf("unexpected folders %+v", content.Folders)
+	}
+	if len(content.Files) != 2 || content.Files[0].LegacyId != 1 || content.Files[1].LegacyId != 2 {
+		t.Fatalf("unexpected files %+v", content.Files)
+	}
+}
+
+func TestSQLiteIntegerIDsAreMigrated(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "sqlite-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "test.db")
+
+	// a database at version 2, holding a file inside a sub folder
+	db, err := sql.Open(sqliteDialect.driverName, fmt.Sprintf("file:%s?_pragma=foreign_keys(1)", file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	statements := append(sqlMigrations[0].statements(sqliteDialect), sqlMigrations[1].statements(sqliteDialect)...)
+	statements = append(statements,
+		`CREATE TABLE schema_version (version INTEGER NOT NULL)`,
+		`INSERT INTO schema_version (version) VALUES (1), (2)`,
+		`INSERT INTO folders (id, name, sort_name, parent_id) VALUES (4, 'photos', 'photos', 0), (9, 'cats', 'cats', 4)`,
+		`INSERT INTO files (id, name, sort_name, extension, folder_id, blob_key, digest) VALUES (3, 'cat.jpg', 'cat.jpg', 'jpg', 9, 'sha256-abc', 'abc')`)
+	for _, statement := range statements {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	repo, err := NewSQLiteFileSystemRepository(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.Close()
+
+	if rootID, err := repo.GetRootFolderID(ctx); err != nil || *rootID != rootFolderID {
+		t.Fatalf("unexpected root folder %v (error: %v)", rootID, err)
+	}
+	photosID, err := repo.GetFolderIDByLegacyID(ctx, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	catsID, err := repo.GetFolderIDByLegacyID(ctx, 9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cats, err := repo.GetFolder(ctx, *catsID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cats.LegacyId != 9 || cats.Name != "cats" || cats.ParentId == nil || *cats.ParentId != *photosID ||
+		!cats.CreatedAt.IsZero() || !cats.ModifiedAt.IsZero() {
+		t.Fatalf("unexpected migrated folder %+v", *cats)
+	}
+	fileID, err := repo.GetFileIDByLegacyID(ctx, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	catFile, err := repo.GetFile(ctx, *fileID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if catFile.LegacyId != 3 || catFile.Name != "cat.jpg" || catFile.ParentId != *catsID || catFile.BlobKey != "sha256-abc" {
+		t.Fatalf("unexpected migrated file %+v", *catFile)
+	}
+	// its metadata were never recorded
+	if catFile.Size != fsmodel.UnknownSize || catFile.ContentType != "" || !catFile.CreatedAt.IsZero() {
+		t.Fatalf("unexpected metadata of the migrated file %+v", *catFile)
+	}
+
+	// the migrated tables keep their constraints and the listing indexes
+	_, err = repo.CreateFolder(ctx, "cats", *photosID)
+	if err == nil {
+		t.Fatal("a second folder named cats was created")
+	}
+	var indexes int
+	if err := repo.withContext(ctx).queryRow(`SELECT count(*) FROM sqlite_master WHERE type = 'index' AND name LIKE '%_sort_name'`).Scan(&indexes); err != nil {
+		t.Fatal(err)
+	}
+	if indexes != 2 {
+		t.Fatalf("expected the 2 sort name indexes, got %d", indexes)
+	}
+}
+
+func TestNumberedPlaceholders(t *testing.T) {
+	query := numberedPlaceholders(`SELECT id FROM files WHERE folder_id = ? AND name = ?`)
+	if query != `SELECT id FROM files WHERE folder_id = $1 AND name = $2` {
+		t.Fatalf("unexpected query %s", query)
+	}
+}
@@ -0,0 +1,335 @@
+package fsrepository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// sqlMigration changes the schema with its statements, then migrates the data which SQL alone cannot with
+// migrateData, nil when there is none
+type sqlMigration struct {
+	statements  func(dialect sqlDialect) []string
+	migrateData func(repo SQLFileSystemRepository) error
+}
+
+// sqlMigrations are the successive versions of the schema: never change a released migration, append a new one.
+// The version of a database is the number of migrations applied, recorded inside the schema_version table.
+var sqlMigrations = []sqlMigration{
+	// 1: folders, files, orphan contents and the root folder
+	{statements: func(dialect sqlDialect) []string {
+		return []string{
+			fmt.Sprintf(`CREATE TABLE folders (
+				id %s,
+				name TEXT NOT NULL,
+				parent_id INTEGER REFERENCES folders (id),
+				is_root BOOLEAN NOT NULL DEFAULT FALSE
+			)`, dialect.idColumnType),
+			`CREATE INDEX folders_parent_id ON folders (parent_id)`,
+			fmt.Sprintf(`CREATE TABLE files (
+				id %s,
+				name TEXT NOT NULL,
+				folder_id INTEGER NOT NULL REFERENCES folders (id),
+				blob_key TEXT NOT NULL,
+				digest TEXT NOT NULL
+			)`, dialect.idColumnType),
+			`CREATE INDEX files_folder_id ON files (folder_id)`,
+			`CREATE INDEX files_blob_key ON files (blob_key)`,
+			`CREATE TABLE orphan_blobs (
+				blob_key TEXT PRIMARY KEY
+			)`,
+			`INSERT INTO folders (id, name, is_root) VALUES (0, 'Root folder', TRUE)`,
+		}
+	}},
+	// 2: the keys ordering the children of a folder
+	{
+		statements: func(dialect sqlDialect) []string {
+			return []string{
+				fmt.Sprintf(`ALTER TABLE folders ADD COLUMN sort_name %s NOT NULL DEFAULT ''`, dialect.binaryTextType),
+				fmt.Sprintf(`ALTER TABLE files ADD COLUMN sort_name %s NOT NULL DEFAULT ''`, dialect.binaryTextType),
+				fmt.Sprintf(`ALTER TABLE files ADD COLUMN extension %s NOT NULL DEFAULT ''`, dialect.binaryTextType),
+				`CREATE INDEX folders_parent_id_sort_name ON folders (parent_id, sort_name, id)`,
+				`CREATE INDEX files_folder_id_sort_name ON files (folder_id, sort_name, id)`,
+				`CREATE INDEX files_folder_id_extension ON files (folder_id, extension, sort_name, id)`,
+			}
+		},
+		migrateData: func(repo SQLFileSystemRepository) error {
+			return repo.updateSortKeys()
+		},
+	},
+	// 3: the opaque ids, the integer ids kept as legacy ids. The types of the primary keys cannot be altered in SQLite:
+	// the tables are copied, then replace the former ones.
+	{
+		statements: func(dialect sqlDialect) []string {
+			return []string{
+				fmt.Sprintf(`CREATE TABLE folders_v3 (
+					id %[1]s PRIMARY KEY,
+					legacy_id INTEGER UNIQUE,
+					name TEXT NOT NULL,
+					sort_name %[1]s NOT NULL DEFAULT '',
+					parent_id %[1]s REFERENCES folders_v3 (id),
+					is_root BOOLEAN NOT NULL DEFAULT FALSE
+				)`, dialect.binaryTextType),
+				fmt.Sprintf(`CREATE TABLE files_v3 (
+					id %[1]s PRIMARY KEY,
+					legacy_id INTEGER UNIQUE,
+					name TEXT NOT NULL,
+					sort_name %[1]s NOT NULL DEFAULT '',
+					extension %[1]s NOT NULL DEFAULT '',
+					folder_id %[1]s NOT NULL REFERENCES folders_v3 (id),
+					blob_key TEXT NOT NULL,
+					digest TEXT NOT NULL
+				)`, dialect.binaryTextType),
+			}
+		},
+		migrateData: func(repo SQLFileSystemRepository) error {
+			if err := repo.copyWithOpaqueIDs(); err != nil {
+				return err
+			}
+			for _, statement := range []string{
+				`DROP TABLE files`,
+				`DROP TABLE folders`,
+				`ALTER TABLE folders_v3 RENAME TO folders`,
+				`ALTER TABLE files_v3 RENAME TO files`,
+				`CREATE INDEX folders_parent_id_sort_name ON folders (parent_id, sort_name, id)`,
+				`CREATE INDEX files_folder_id_sort_name ON files (folder_id, sort_name, id)`,
+				`CREATE INDEX files_folder_id_extension ON files (folder_id, extension, sort_name, id)`,
+				`CREATE INDEX files_blob_key ON files (blob_key)`,
+			} {
+				if err := repo.exec(statement); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	// 4: the metadata of the files, unknown (NULL) for the existing ones
+	{statements: func(dialect sqlDialect) []string {
+		return []string{
+			`ALTER TABLE files ADD COLUMN size BIGINT`,
+			`ALTER TABLE files ADD COLUMN content_type TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE files ADD COLUMN created_at BIGINT`,
+			`ALTER TABLE files ADD COLUMN modified_at BIGINT`,
+		}
+	}},
+	// 5: the timestamps of the folders, unknown (NULL) for the existing ones
+	{statements: func(dialect sqlDialect) []string {
+		return []string{
+			`ALTER TABLE folders ADD COLUMN created_at BIGINT`,
+			`ALTER TABLE folders ADD COLUMN modified_at BIGINT`,
+		}
+	}},
+	// 6: the trash folder, without parent as the root folder, and the trash entries. The folder an item was deleted
+	// from may be deleted in turn: the entries do not reference it.
+	{
+		statements: func(dialect sqlDialect) []string {
+			return []string{
+				fmt.Sprintf(`CREATE TABLE trash_entries (
+					item_id %[1]s PRIMARY KEY,
+					kind TEXT NOT NULL,
+					name TEXT NOT NULL,
+					parent_id %[1]s NOT NULL,
+					trashed_at BIGINT NOT NULL
+				)`, dialect.binaryTextType),
+				`CREATE INDEX trash_entries_trashed_at ON trash_entries (trashed_at)`,
+			}
+		},
+		migrateData: func(repo SQLFileSystemRepository) error {
+			return repo.exec(`INSERT INTO folders (id, name, sort_name) VALUES (?, ?, ?)`,
+				trashFolderID, sqlTrashFolderName, naturalSortKey(sqlTrashFolderName))
+		},
+	},
+	// 7: the file versions
+	{statements: func(dialect sqlDialect) []string {
+		return []string{
+			fmt.Sprintf(`CREATE TABLE file_versions (
+				file_id %s NOT NULL REFERENCES files (id),
+				number INTEGER NOT NULL,
+				blob_key TEXT NOT NULL,
+				digest TEXT NOT NULL,
+				size BIGINT,
+				content_type TEXT NOT NULL DEFAULT '',
+				created_at BIGINT,
+				PRIMARY KEY (file_id, number)
+			)`, dialect.binaryTextType),
+			`CREATE INDEX file_versions_blob_key ON file_versions (blob_key)`,
+		}
+	}},
+	// 8: the keys ordering the children by size and by modification, the unknown ones as listKeyExpression orders them
+	{statements: func(dialect sqlDialect) []string {
+		return []string{
+			`CREATE INDEX files_folder_id_size ON files (folder_id, (coalesce(size, -1)), sort_name, id)`,
+			`CREATE INDEX files_folder_id_modified_at ON files (folder_id, (coalesce(modified_at, 0)), sort_name, id)`,
+			`CREATE INDEX folders_parent_id_modified_at ON folders (parent_id, (coalesce(modified_at, 0)), sort_name, id)`,
+		}
+	}},
+}
+
+// updateSortKeys derives the sort keys of every item from its name
+func (repo SQLFileSystemRepository) updateSortKeys() error {
+	for _, table := range []string{"folders", "files"} {
+		names, err := repo.namesByID(table)
+		if err != nil {
+			return err
+		}
+		for id, name := range names {
+			if table == "folders" {
+				err = repo.exec(`UPDATE folders SET sort_name = ? WHERE id = ?`, naturalSortKey(name), id)
+			} else {
+				err = repo.exec(`UPDATE files SET sort_name = ?, extension = ? WHERE id = ?`, naturalSortKey(name), fileExtension(name), id)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// copyWithOpaqueIDs copies the items of version 2 into the tables of version 3, giving them new ids, and the root folder
+// the id of every root folder
+func (repo SQLFileSystemRepository) copyWithOpaqueIDs() error {
+	type folderV2 struct {
+		legacyID       int
+		name, sortName string
+		parentID       sql.NullInt64
+		isRoot         bool
+	}
+	folders := make([]folderV2, 0)
+	rows, err := repo.query(`SELECT id, name, sort_name, parent_id, is_root FROM folders ORDER BY id`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var folder folderV2
+		if err := rows.Scan(&folder.legacyID, &folder.name, &folder.sortName, &folder.parentID, &folder.isRoot); err != nil {
+			rows.Close()
+			return err
+		}
+		folders = append(folders, folder)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// the parents are set once every folder is inserted
+	folderIDs := make(map[int]string)
+	for _, folder := range folders {
+		if folder.isRoot {
+			folderIDs[folder.legacyID] = rootFolderID
+			err = repo.exec(`INSERT INTO folders_v3 (id, name, sort_name, is_root) VALUES (?, ?, ?, TRUE)`,
+				rootFolderID, folder.name, folder.sortName)
+		} else {
+			folderIDs[folder.legacyID] = newID()
+			err = repo.exec(`INSERT INTO folders_v3 (id, legacy_id, name, sort_name) VALUES (?, ?, ?, ?)`,
+				folderIDs[folder.legacyID], folder.legacyID, folder.name, folder.sortName)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for _, folder := range folders {
+		if !folder.parentID.Valid {
+			continue
+		}
+		if err := repo.exec(`UPDATE folders_v3 SET parent_id = ? WHERE id = ?`,
+			folderIDs[int(folder.parentID.Int64)], folderIDs[folder.legacyID]); err != nil {
+			return err
+		}
+	}
+
+	type fileV2 struct {
+		legacyID, folderID                         int
+		name, sortName, extension, blobKey, digest string
+	}
+	files := make([]fileV2, 0)
+	rows, err = repo.query(`SELECT id, name, sort_name, extension, folder_id, blob_key, digest FROM files ORDER BY id`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var file fileV2
+		if err := rows.Scan(&file.legacyID, &file.name, &file.sortName, &file.extension, &file.folderID, &file.blobKey, &file.digest); err != nil {
+			rows.Close()
+			return err
+		}
+		files = append(files, file)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if err := repo.exec(`INSERT INTO files_v3 (id, legacy_id, name, sort_name, extension, folder_id, blob_key, digest)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			newID(), file.legacyID, file.name, file.sortName, file.extension, folderIDs[file.folderID], file.blobKey, file.digest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (repo SQLFileSystemRepository) namesByID(table string) (map[int]string, error) {
+	rows, err := repo.query(fmt.Sprintf(`SELECT id, name FROM %s`, table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := make(map[int]string)
+	for rows.Next() {
+		var id int
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, err
+		}
+		names[id] = name
+	}
+	return names, rows.Err()
+}
+
+// migrate brings the schema of the database to the last version, each migration in its own transaction
+func (repo SQLFileSystemRepository) migrate() error {
+	ctx := context.Background()
+	if err := repo.withContext(ctx).exec(`CREATE TABLE IF NOT EXISTS schema_version (version INTEGER NOT NULL)`); err != nil {
+		return err
+	}
+
+	for {
+		migrated := false
+		err := repo.inTransaction(ctx, func(repo SQLFileSystemRepository) error {
+			if repo.dialect.lockSchemaVersion != "" {
+				if err := repo.exec(repo.dialect.lockSchemaVersion); err != nil {
+					return err
+				}
+			}
+
+			var version int
+			if err := repo.queryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version); err != nil {
+				return err
+			}
+			if version >= len(sqlMigrations) {
+				return nil
+			}
+
+			migration := sqlMigrations[version]
+			for _, statement := range migration.statements(repo.dialect) {
+				if err := repo.exec(statement); err != nil {
+					return err
+				}
+			}
+			if migration.migrateData != nil {
+				if err := migration.migrateData(repo); err != nil {
+					return err
+				}
+			}
+			migrated = true
+			return repo.exec(`INSERT INTO schema_version (version) VALUES (?)`, version+1)
+		})
+		if err != nil || !migrated {
+			return err
+		}
+	}
+}
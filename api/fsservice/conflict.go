@@ -0,0 +1,170 @@
+package fsservice
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/loisfa/remote-file-system/api/fsmodel"
+	"github.com/loisfa/remote-file-system/api/fsrepository"
+	"github.com/pkg/errors"
+)
+
+// ConflictPolicy tells what to do when an item is named like another item of the same folder
+type ConflictPolicy string
+
+const (
+	ConflictFail      ConflictPolicy = "fail"      // the operation fails with Conflict
+	ConflictRename    ConflictPolicy = "rename"    // the item gets a free name: "name (1).ext", "name (2).ext"...
+	ConflictOverwrite ConflictPolicy = "overwrite" // the other item is moved to the trash, provided it is of the same kind
+	ConflictVersion   ConflictPolicy = "version"   // the uploaded content becomes a new version of the file, see CreateFile
+
+	noItemID = ""
+
+	// the name resolved can be taken concurrently before the write: retry with a freshly resolved one
+	maxNameConflictAttempts = 5
+)
+
+// ParseConflictPolicy parses the policy given by a client, ConflictFail when none is given
+func ParseConflictPolicy(policy string) (ConflictPolicy, error) {
+	switch ConflictPolicy(policy) {
+	case "":
+		return ConflictFail, nil
+	case ConflictFail, ConflictRename, ConflictOverwrite, ConflictVersion:
+		return ConflictPolicy(policy), nil
+	default:
+		return "", errors.WithMessage(
+			errors.New(BadRequest),
+			fmt.Sprintf("Unknown conflict policy '%s', expected one of: %s, %s, %s, %s", policy, ConflictFail, ConflictRename, ConflictOverwrite, ConflictVersion))
+	}
+}
+
+// namedItem is the folder or file being named, noItemID when it is being created
+type namedItem struct {
+	isFolder bool
+	id       string
+	copiedID string // the item the created one is a copy of, which it cannot overwrite, noItemID when none
+}
+
+// writeWithConflictPolicy resolves the name the item gets inside the folder according to the policy, then writes it.
+// NB: an overwrite moves the other item to the trash before the write, in the transaction of the caller: both are undone
+// together.
+func (svc FileSystemService) writeWithConflictPolicy(ctx context.Context, folderID string, name string, item namedItem, policy ConflictPolicy, write func(name string) error) error {
+	if policy == ConflictVersion {
+		return errors.WithMessage(
+			errors.New(BadRequest),
+			fmt.Sprintf("The conflict policy '%s' only applies to the uploads of files.", ConflictVersion))
+	}
+
+	for attempt := 1; ; attempt++ {
+		resolvedName, err := svc.resolveName(ctx, folderID, name, item, policy)
+		if err != nil {
+			return err
+		}
+
+		err = write(resolvedName)
+		if err == nil || errors.Cause(err).Error() != fsrepository.NameAlreadyExists {
+			return err
+		}
+		if policy == ConflictFail || attempt == maxNameConflictAttempts {
+			return nameConflictError(folderID, resolvedName)
+		}
+	}
+}
+
+// resolveName only reads the children named as the numbered names start, not the whole folder
+func (svc FileSystemService) resolveName(ctx context.Context, folderID string, name string, item namedItem, policy ConflictPolicy) (string, error) {
+	baseName, _ := splitNumberableName(name, item.isFolder)
+	children, err := svc.repo.GetChildrenWithNamePrefix(ctx, folderID, baseName)
+	if err != nil {
+		return "", err
+	}
+
+	takenNames := make(map[string]namedItem)
+	for _, child := range *children {
+		isFolder := child.Kind == fsmodel.FolderKind
+		if isFolder != item.isFolder || child.Id != item.id {
+			takenNames[child.Name] = namedItem{isFolder: isFolder, id: child.Id}
+		}
+	}
+
+	conflictingItem, found := takenNames[name]
+	if !found {
+		return name, nil
+	}
+
+	switch policy {
+	case ConflictRename:
+		for n := 1; ; n++ {
+			candidate := numberedName(name, n, item.isFolder)
+			if _, found := takenNames[candidate]; !found {
+				return candidate, nil
+			}
+		}
+	case ConflictOverwrite:
+		if err := svc.overwrite(ctx, folderID, name, conflictingItem, item); err != nil {
+			return "", err
+		}
+		return name, nil
+	default:
+		return "", nameConflictError(folderID, name)
+	}
+}
+
+// overwrite moves the item named so to the trash, as a delete does
+func (svc FileSystemService) overwrite(ctx context.Context, folderID string, name string, overwrittenItem namedItem, item namedItem) error {
+	if overwrittenItem.isFolder != item.isFolder {
+		return errors.WithMessage(
+			errors.New(Conflict),
+			fmt.Sprintf("A file and a folder cannot overwrite each other (item %s inside folder %s).", overwrittenItem.id, folderID))
+	}
+	if overwrittenItem.id == item.copiedID {
+		return errors.WithMessage(
+			errors.New(Conflict),
+			fmt.Sprintf("The copy of the item %s cannot overwrite it (inside folder %s).", item.copiedID, folderID))
+	}
+	if !overwrittenItem.isFolder {
+		return svc.moveToTrash(ctx, fsmodel.TrashEntry{ItemId: overwrittenItem.id, Kind: fsmodel.FileKind, Name: name, ParentId: folderID})
+	}
+
+	// the folder, or the folder copied, would be trashed along with the folder it overwrites
+	for _, folderID := range []string{item.id, item.copiedID} {
+		if folderID == noItemID {
+			continue
+		}
+		isInside, err := svc.repo.IsFolderInside(ctx, folderID, overwrittenItem.id)
+		if err != nil {
+			return err
+		}
+		if *isInside {
+			return errors.WithMessage(
+				errors.New(Conflict),
+				fmt.Sprintf("The folder %s cannot overwrite the folder %s it is inside of.", folderID, overwrittenItem.id))
+		}
+	}
+	return svc.moveToTrash(ctx, fsmodel.TrashEntry{ItemId: overwrittenItem.id, Kind: fsmodel.FolderKind, Name: name, ParentId: folderID})
+}
+
+// numberedName inserts the number before the extension of a file name: "report (1).txt". Folder names and names
+// made of an extension only (".bashrc") are numbered as a whole.
+func numberedName(name string, n int, isFolder bool) string {
+	baseName, extension := splitNumberableName(name, isFolder)
+	return fmt.Sprintf("%s (%d)%s", baseName, n, extension)
+}
+
+// splitNumberableName splits the name where numberedName inserts the number: the numbered names all start with the
+// part before
+func splitNumberableName(name string, isFolder bool) (string, string) {
+	extension := ""
+	if !isFolder && filepath.Ext(name) != name {
+		extension = filepath.Ext(name)
+	}
+	return strings.TrimSuffix(name, extension), extension
+}
+
+func nameConflictError(folderID string, name string) error {
+	return errors.WithMessage(
+		errors.New(Conflict),
+		fmt.Sprintf("An item named %s already exists inside folder %s.", name, folderID))
+}
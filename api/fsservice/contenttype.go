@@ -0,0 +1,64 @@
+package fsservice
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// sniffLength is the number of first bytes http.DetectContentType considers
+const sniffLength = 512
+
+// contentSniffer keeps the first bytes of the content streamed through it, to detect its type once stored
+type contentSniffer struct {
+	reader io.Reader
+	head   []byte
+}
+
+func newContentSniffer(reader io.Reader) *contentSniffer {
+	return &contentSniffer{reader: reader, head: make([]byte, 0, sniffLength)}
+}
+
+func (sniffer *contentSniffer) Read(p []byte) (int, error) {
+	n, err := sniffer.reader.Read(p)
+	if missing := sniffLength - len(sniffer.head); missing > 0 {
+		if missing > n {
+			missing = n
+		}
+		sniffer.head = append(sniffer.head, p[:missing]...)
+	}
+	return n, err
+}
+
+func (sniffer *contentSniffer) contentType() string {
+	return http.DetectContentType(sniffer.head)
+}
+
+// errorIfInvalidContentType accepts the empty content type, which is no content type
+func errorIfInvalidContentType(contentType string) error {
+	if contentType == "" {
+		return nil
+	}
+	if _, _, err := mime.ParseMediaType(contentType); err != nil {
+		return errors.WithMessage(errors.New(BadRequest), fmt.Sprintf("Invalid content type '%s': %v", contentType, err))
+	}
+	return nil
+}
+
+// contentTypeOf prefers the type given by the extension of the file name when the content only tells it is text or
+// binary: the content of a CSV or a JSON file is detected as plain text
+func contentTypeOf(fileName string, detected string) string {
+	mediaType, _, _ := mime.ParseMediaType(detected)
+	if mediaType != "text/plain" && mediaType != "application/octet-stream" {
+		return detected
+	}
+	if byExtension := mime.TypeByExtension(strings.ToLower(filepath.Ext(fileName))); byExtension != "" {
+		return byExtension
+	}
+	return detected
+}
@@ -0,0 +1,133 @@
+package fsservice
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/loisfa/remote-file-system/api/fsmodel"
+	"github.com/pkg/errors"
+)
+
+const (
+	OrderAscending  = "asc"
+	OrderDescending = "desc"
+
+	MaxListLimit = 1000 // children of a folder on a single page
+)
+
+// ListParams are the listing options as given by a client, empty when not given
+type ListParams struct {
+	Sort   string // name (default), type, size or modified
+	Order  string // asc (default) or desc
+	Kind   string // file or folder, both when empty
+	Ext    string // extension of the files, with or without dot, case insensitive
+	Name   string // glob of the names: * for any characters, ? for a single one
+	Limit  string // number of children of the page, all of them when empty
+	Cursor string // where the page starts, as returned along with the previous page
+}
+
+// cursor is the position of the next page along with the order it belongs to: a page cannot be resumed in another
+// order
+type cursor struct {
+	Kind       fsmodel.ItemKind `json:"k"`
+	Name       string           `json:"n"`
+	Id         string           `json:"i"`
+	Size       int64            `json:"z,omitempty"`
+	ModifiedAt int64            `json:"m,omitempty"` // milliseconds since the epoch, 0 for the zero time
+	Sort       fsmodel.SortKey  `json:"s"`
+	Descending bool             `json:"d"`
+}
+
+// ParseListOptions parses the listing options given by a client, every child by name when none is given
+func ParseListOptions(params ListParams) (fsmodel.ListOptions, error) {
+	options := fsmodel.ListOptions{Sort: fsmodel.SortByName}
+
+	switch fsmodel.SortKey(params.Sort) {
+	case "":
+	case fsmodel.SortByName, fsmodel.SortByType, fsmodel.SortBySize, fsmodel.SortByModified:
+		options.Sort = fsmodel.SortKey(params.Sort)
+	default:
+		return fsmodel.ListOptions{}, badListParam(fmt.Sprintf("Unknown sort '%s', expected one of: %s, %s, %s, %s", params.Sort,
+			fsmodel.SortByName, fsmodel.SortByType, fsmodel.SortBySize, fsmodel.SortByModified))
+	}
+
+	switch params.Order {
+	case "", OrderAscending:
+	case OrderDescending:
+		options.Descending = true
+	default:
+		return fsmodel.ListOptions{}, badListParam(fmt.Sprintf("Unknown order '%s', expected one of: %s, %s", params.Order, OrderAscending, OrderDescending))
+	}
+
+	switch fsmodel.ItemKind(params.Kind) {
+	case "", fsmodel.FolderKind, fsmodel.FileKind:
+		options.Kind = fsmodel.ItemKind(params.Kind)
+	default:
+		return fsmodel.ListOptions{}, badListParam(fmt.Sprintf("Unknown kind '%s', expected one of: %s, %s", params.Kind, fsmodel.FileKind, fsmodel.FolderKind))
+	}
+
+	options.Extension = strings.ToLower(strings.TrimPrefix(params.Ext, "."))
+	options.NameGlob = params.Name
+
+	if params.Limit != "" {
+		limit, err := strconv.Atoi(params.Limit)
+		if err != nil || limit <= 0 || limit > MaxListLimit {
+			return fsmodel.ListOptions{}, badListParam(fmt.Sprintf("Invalid limit '%s', expected a number between 1 and %d", params.Limit, MaxListLimit))
+		}
+		options.Limit = limit
+	}
+
+	if params.Cursor != "" {
+		position, err := decodeCursor(params.Cursor, options)
+		if err != nil {
+			return fsmodel.ListOptions{}, err
+		}
+		options.After = position
+	}
+	return options, nil
+}
+
+// EncodeCursor returns the opaque cursor a client gives back to get the page starting after the position
+func EncodeCursor(options fsmodel.ListOptions, position fsmodel.ListPosition) string {
+	var modifiedAt int64
+	if !position.ModifiedAt.IsZero() {
+		modifiedAt = position.ModifiedAt.UnixNano() / int64(time.Millisecond)
+	}
+	cursorJSON, _ := json.Marshal(cursor{
+		Kind:       position.Kind,
+		Name:       position.Name,
+		Id:         position.Id,
+		Size:       position.Size,
+		ModifiedAt: modifiedAt,
+		Sort:       options.Sort,
+		Descending: options.Descending,
+	})
+	return base64.RawURLEncoding.EncodeToString(cursorJSON)
+}
+
+func decodeCursor(encoded string, options fsmodel.ListOptions) (*fsmodel.ListPosition, error) {
+	var decoded cursor
+	cursorJSON, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err == nil {
+		err = json.Unmarshal(cursorJSON, &decoded)
+	}
+	if err != nil || (decoded.Kind != fsmodel.FolderKind && decoded.Kind != fsmodel.FileKind) {
+		return nil, badListParam(fmt.Sprintf("Invalid cursor '%s'", encoded))
+	}
+	if decoded.Sort != options.Sort || decoded.Descending != options.Descending {
+		return nil, badListParam(fmt.Sprintf("The cursor '%s' belongs to another sort or order", encoded))
+	}
+	position := fsmodel.ListPosition{Kind: decoded.Kind, Name: decoded.Name, Id: decoded.Id, Size: decoded.Size}
+	if decoded.ModifiedAt != 0 {
+		position.ModifiedAt = time.Unix(0, decoded.ModifiedAt*int64(time.Millisecond)).UTC()
+	}
+	return &position, nil
+}
+
+func badListParam(message string) error {
+	return errors.WithMessage(errors.New(BadRequest), message)
+}
@@ -1,17 +1,27 @@
 package fsservice
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"time"
 
 	"github.com/loisfa/remote-file-system/api/fsmodel"
 	"github.com/loisfa/remote-file-system/api/fsrepository"
+	"github.com/loisfa/remote-file-system/api/fsstorage"
 	"github.com/pkg/errors"
 )
 
 const (
-	NotFound         = "Not found"
-	BadRequest       = "Bad Request" // go for bad request when not obvious which resource is not found (ex: params={folderID+destFolderID} => not obvious)
-	IllegalOperation = "Illegal operation"
+	NotFound           = "Not found"
+	BadRequest         = "Bad Request" // go for bad request when not obvious which resource is not found (ex: params={folderID+destFolderID} => not obvious)
+	IllegalOperation   = "Illegal operation"
+	Conflict           = "Conflict"            // the name is already used inside the folder, see ConflictPolicy
+	PreconditionFailed = "Precondition failed" // the content of the file is not the one the client expected
+
+	// MaxCopiedItems bounds the subtree of a folder copy, folder and files included, which is copied within a single
+	// write transaction
+	MaxCopiedItems = 5000
 )
 
 type CustomError struct {
@@ -23,173 +33,768 @@ func (error CustomError) Error() string {
 }
 
 type IFileSystemService interface {
-	GetRootFolderID() (*int, error)                                  // the function ensures it exists
-	GetFolder(folderID int) (*fsmodel.Folder, error)                 // the function ensures it exists
-	GetFile(fileID int) (*fsmodel.File, error)                       // the function ensures it exists
-	GetFoldersIn(folderID int) (*[]fsmodel.Folder, error)            // the function ensures it exists
-	GetFilesIn(folderID int) (*[]fsmodel.File, error)                // the function ensures it exists
-	CreateFolder(name string, parentID int) (*int, error)            // the function ensures the parent exists
-	CreateFile(name string, path string, parentID int) (*int, error) // the function ensures the parent exists
-	UpdateFolder(folderID int, name string) error                    // the function ensures it exists
-	MoveFolder(folderID int, destFolderID int) error                 // the function ensures it and parent exist
-	MoveFile(fileID int, destFolderID int) error                     // the function ensures it and parent exist
-	DeleteFolderAndContent(folderID int) error                       // the function ensures it exists
-	DeleteFile(fileID int) error                                     // the function ensures it exists
+	GetRootFolderID(ctx context.Context) (*string, error)                                                                              // the function ensures it exists
+	GetFolderIDByLegacyID(ctx context.Context, legacyID int) (*string, error)                                                          // the folder which had the integer id before the opaque ids, 0 being the root folder
+	GetFileIDByLegacyID(ctx context.Context, legacyID int) (*string, error)                                                            // the file which had the integer id before the opaque ids
+	GetFolder(ctx context.Context, folderID string) (*fsmodel.Folder, error)                                                           // the function ensures it exists
+	GetFile(ctx context.Context, fileID string) (*fsmodel.File, error)                                                                 // the function ensures it exists
+	GetFoldersIn(ctx context.Context, folderID string) (*[]fsmodel.Folder, error)                                                      // the function ensures it exists
+	GetFilesIn(ctx context.Context, folderID string) (*[]fsmodel.File, error)                                                          // the function ensures it exists
+	GetFolderContent(ctx context.Context, folderID string, options fsmodel.ListOptions) (*fsmodel.FolderContent, error)                // the folder and a page of its children, in a single read
+	CreateFolder(ctx context.Context, name string, parentID string, policy ConflictPolicy) (*string, error)                            // the function ensures the parent exists
+	StoreFileContent(ctx context.Context, content io.Reader) (*fsmodel.FileContent, error)                                             // the content is staged until a file is created with it
+	CreateFile(ctx context.Context, name string, content fsmodel.FileContent, parentID string, policy ConflictPolicy) (*string, error) // the function ensures the parent exists
+	UpdateFolder(ctx context.Context, folderID string, name string, policy ConflictPolicy) error                                       // the function ensures it exists
+	UpdateFile(ctx context.Context, fileID string, name string, contentType string, policy ConflictPolicy) error                       // the function ensures it exists, the content type is kept when empty
+	ReplaceFileContent(ctx context.Context, fileID string, content fsmodel.FileContent, ifMatch []string) error                        // the content becomes a new version of the file, nil ifMatch for no precondition
+	MoveFolder(ctx context.Context, folderID string, destFolderID string, policy ConflictPolicy) error                                 // the function ensures it and parent exist
+	MoveFile(ctx context.Context, fileID string, destFolderID string, policy ConflictPolicy) error                                     // the function ensures it and parent exist
+	CopyFolder(ctx context.Context, folderID string, destFolderID string, policy ConflictPolicy) (*string, error)                      // the copied files share the content of the original ones
+	CopyFile(ctx context.Context, fileID string, destFolderID string, policy ConflictPolicy) (*string, error)                          // the copy shares the content of the file
+	DeleteFolderAndContent(ctx context.Context, folderID string) error                                                                 // the function ensures it exists, and moves it to the trash
+	DeleteFile(ctx context.Context, fileID string) error                                                                               // the function ensures it exists, and moves it to the trash
+	PurgeDeletedContent(ctx context.Context) (int, error)                                                                              // deletes the content no file references anymore
+	PurgeStaleContent(ctx context.Context, stagedBefore time.Time) (int, error)                                                        // deletes the content staged before the time and never referenced
+	GetTrashEntries(ctx context.Context) (*[]fsmodel.TrashEntry, error)                                                                // the most recently trashed first
+	RestoreFromTrash(ctx context.Context, itemID string, policy ConflictPolicy) error                                                  // back inside the folder it was deleted from, the root folder when that one is gone
+	GetFileVersions(ctx context.Context, fileID string) (*[]fsmodel.FileVersion, error)                                                // the newest first, the current content being the newest
+	GetFileVersion(ctx context.Context, fileID string, number int) (*fsmodel.FileVersion, error)                                       // the function ensures it exists
+	RestoreFileVersion(ctx context.Context, fileID string, number int) error                                                           // the content of the version becomes the current one, as a new version
+	EmptyTrash(ctx context.Context) (int, error)                                                                                       // deletes the trashed items for good
+	PurgeTrash(ctx context.Context, trashedBefore time.Time) (int, error)                                                              // deletes for good the items trashed before the time
 }
 
 type FileSystemService struct {
-	repo fsrepository.IFileSystemRepository
+	repo     fsrepository.IFileSystemRepository
+	blobs    fsstorage.BlobStore
+	timeouts Timeouts
+
+	maxFileVersions int // the versions kept per file, 0 when unlimited
+	maxCopiedItems  int // see MaxCopiedItems
+
+	// locks the content keys: a content must not be deleted while a file starts referencing it. NB: only holds within a
+	// single API instance.
+	contentLocks *fsstorage.KeyLocks
 }
 
 // could use a builder pattern?
-func NewFileSystemService() FileSystemService {
+func NewFileSystemService(repo fsrepository.IFileSystemRepository, blobs fsstorage.BlobStore, timeouts Timeouts, maxFileVersions int) FileSystemService {
 	return FileSystemService{
-		repo: fsrepository.NewNeo4JFileSystemRepository(),
+		repo:            repo,
+		blobs:           blobs,
+		timeouts:        timeouts,
+		maxFileVersions: maxFileVersions,
+		maxCopiedItems:  MaxCopiedItems,
+		contentLocks:    &fsstorage.KeyLocks{},
 	}
 }
 
-func (svc FileSystemService) GetRootFolderID() (id *int, err error) {
-	return svc.repo.GetRootFolderID()
+func (svc FileSystemService) GetRootFolderID(ctx context.Context) (id *string, err error) {
+	ctx, cancel := svc.timeouts.read(ctx)
+	defer cancel()
+	return svc.repo.GetRootFolderID(ctx)
 }
 
-func (svc FileSystemService) GetFolder(folderID int) (*fsmodel.Folder, error) {
-	if err := svc.errorIfFolderNotFound(folderID); err != nil {
-		return nil, err
+func (svc FileSystemService) GetFolderIDByLegacyID(ctx context.Context, legacyID int) (*string, error) {
+	ctx, cancel := svc.timeouts.read(ctx)
+	defer cancel()
+
+	// the root folder kept no legacy id: it has the same id in every repository
+	if legacyID == 0 {
+		return svc.repo.GetRootFolderID(ctx)
 	}
+	folderID, err := svc.repo.GetFolderIDByLegacyID(ctx, legacyID)
+	if err != nil {
+		return nil, withCodeIfItemNotFound(err, NotFound, fmt.Sprintf("Could not find folder %d", legacyID))
+	}
+	return folderID, nil
+}
+
+func (svc FileSystemService) GetFileIDByLegacyID(ctx context.Context, legacyID int) (*string, error) {
+	ctx, cancel := svc.timeouts.read(ctx)
+	defer cancel()
 
-	folder, err := svc.repo.GetFolder(folderID)
+	fileID, err := svc.repo.GetFileIDByLegacyID(ctx, legacyID)
 	if err != nil {
+		return nil, withCodeIfItemNotFound(err, NotFound, fmt.Sprintf("Could not find file %d", legacyID))
+	}
+	return fileID, nil
+}
+
+func (svc FileSystemService) GetFolder(ctx context.Context, folderID string) (*fsmodel.Folder, error) {
+	ctx, cancel := svc.timeouts.read(ctx)
+	defer cancel()
+
+	if err := svc.errorIfFolderNotFound(ctx, folderID); err != nil {
 		return nil, err
 	}
-	if folder == nil {
-		// required since no tx mgmt => no guarantee folder was not deleted since previous check
-		return nil, errors.New(NotFound)
+
+	// the read is not in the transaction of the check: the folder may have been deleted in between
+	folder, err := svc.repo.GetFolder(ctx, folderID)
+	if err != nil {
+		return nil, withCodeIfItemNotFound(err, NotFound, fmt.Sprintf("Could not find folder %s.", folderID))
 	}
-	return folder, err
+	return folder, nil
 }
 
-func (svc FileSystemService) ExistsFolder(folderID int) (*bool, error) {
-	return svc.repo.ExistsFolder(folderID)
+// ExistsFolder does not find the trash folder, nor the folders inside it
+func (svc FileSystemService) ExistsFolder(ctx context.Context, folderID string) (*bool, error) {
+	ctx, cancel := svc.timeouts.read(ctx)
+	defer cancel()
+	return svc.repo.ExistsUntrashedFolder(ctx, folderID)
 }
 
-func (svc FileSystemService) ExistsFile(folderID int) (*bool, error) {
-	return svc.repo.ExistsFile(folderID)
+// ExistsFile does not find the files inside the trash folder
+func (svc FileSystemService) ExistsFile(ctx context.Context, fileID string) (*bool, error) {
+	ctx, cancel := svc.timeouts.read(ctx)
+	defer cancel()
+	return svc.repo.ExistsUntrashedFile(ctx, fileID)
 }
 
-func (svc FileSystemService) GetFile(fileID int) (*fsmodel.File, error) {
-	if exists, err := svc.repo.ExistsFile(fileID); err != nil || exists == nil || *exists == false {
-		return nil, errors.New(NotFound)
+func (svc FileSystemService) GetFile(ctx context.Context, fileID string) (*fsmodel.File, error) {
+	ctx, cancel := svc.timeouts.read(ctx)
+	defer cancel()
+
+	if err := svc.errorIfFileNotFound(ctx, fileID); err != nil {
+		return nil, err
 	}
 
-	file, err := svc.repo.GetFile(fileID)
+	// the read is not in the transaction of the check: the file may have been deleted in between
+	file, err := svc.repo.GetFile(ctx, fileID)
 	if err != nil {
+		return nil, withCodeIfItemNotFound(err, NotFound, fmt.Sprintf("Could not find file %s.", fileID))
+	}
+	return file, nil
+}
+
+func (svc FileSystemService) GetFoldersIn(ctx context.Context, folderID string) (*[]fsmodel.Folder, error) {
+	ctx, cancel := svc.timeouts.read(ctx)
+	defer cancel()
+
+	if err := svc.errorIfFolderNotFound(ctx, folderID); err != nil {
 		return nil, err
 	}
-	if file == nil {
-		// this check is required since no transaction mgmt implemented => no guarantee the file was not deleted since previous check
-		return nil, errors.New(NotFound)
+	return svc.repo.GetFoldersIn(ctx, folderID)
+}
+
+func (svc FileSystemService) GetFilesIn(ctx context.Context, folderID string) (*[]fsmodel.File, error) {
+	ctx, cancel := svc.timeouts.read(ctx)
+	defer cancel()
+
+	if err := svc.errorIfFolderNotFound(ctx, folderID); err != nil {
+		return nil, err
 	}
-	return file, err
+	return svc.repo.GetFilesIn(ctx, folderID)
 }
 
-// TODO could have a single database call to return at the same time: currentFolder, folders, files
-func (svc FileSystemService) GetFoldersIn(folderID int) (*[]fsmodel.Folder, error) {
-	if err := svc.errorIfFolderNotFound(folderID); err != nil {
-		return nil, errors.New(NotFound)
+func (svc FileSystemService) GetFolderContent(ctx context.Context, folderID string, options fsmodel.ListOptions) (*fsmodel.FolderContent, error) {
+	ctx, cancel := svc.timeouts.read(ctx)
+	defer cancel()
+
+	content, err := svc.repo.GetFolderContent(ctx, folderID, options)
+	if err != nil {
+		return nil, withCodeIfItemNotFound(err, NotFound, fmt.Sprintf("Could not find folder %s", folderID))
 	}
-	return svc.repo.GetFoldersIn(folderID)
+	if content.Trashed {
+		return nil, errors.WithMessage(errors.New(NotFound), fmt.Sprintf("Could not find folder %s", folderID))
+	}
+	return content, nil
 }
 
-func (svc FileSystemService) GetFilesIn(folderID int) (*[]fsmodel.File, error) {
-	if err := svc.errorIfFolderNotFound(folderID); err != nil {
-		return nil, errors.New(NotFound)
+// The writes run their checks and the write itself in a single transaction: what was checked cannot change before
+// the write, whatever the concurrent requests.
+
+func (svc FileSystemService) CreateFolder(ctx context.Context, name string, parentID string, policy ConflictPolicy) (*string, error) {
+	ctx, cancel := svc.timeouts.write(ctx)
+	defer cancel()
+
+	var folderID *string
+	err := svc.inTransaction(ctx, func(svc FileSystemService) error {
+		if err := svc.errorIfFolderNotFound(ctx, parentID); err != nil {
+			return withCodeIfNotFound(err, BadRequest,
+				fmt.Sprintf("Not found folder specified (id=%s) when trying to create folder named %s inside.", parentID, name))
+		}
+
+		return svc.writeWithConflictPolicy(ctx, parentID, name, namedItem{isFolder: true, id: noItemID}, policy, func(name string) error {
+			var err error
+			folderID, err = svc.repo.CreateFolder(ctx, name, parentID)
+			return err
+		})
+	})
+	return folderID, err
+}
+
+func (svc FileSystemService) StoreFileContent(ctx context.Context, content io.Reader) (*fsmodel.FileContent, error) {
+	sniffer := newContentSniffer(content)
+	blobInfo, err := fsstorage.StageContent(svc.blobs, sniffer)
+	if err != nil {
+		return nil, err
 	}
-	return svc.repo.GetFilesIn(folderID)
+	return &fsmodel.FileContent{
+		BlobKey:     blobInfo.Key,
+		Digest:      blobInfo.SHA256,
+		Size:        blobInfo.Size,
+		ContentType: sniffer.contentType(),
+	}, nil
 }
 
-func (svc FileSystemService) CreateFolder(name string, parentID int) (*int, error) {
-	if err := svc.errorIfFolderNotFound(parentID); err != nil {
-		return nil, errors.WithMessage(
-			errors.New(BadRequest),
-			fmt.Sprintf("Not found folder specified (id=%d) when trying to create folder named %s inside.", parentID, name))
+// CreateFile creates the file inside the folder. With ConflictVersion, the content becomes a new version of the file
+// of the same name when there is one: its id is returned.
+func (svc FileSystemService) CreateFile(ctx context.Context, name string, content fsmodel.FileContent, parentID string, policy ConflictPolicy) (*string, error) {
+	ctx, cancel := svc.timeouts.write(ctx)
+	defer cancel()
+
+	content.ContentType = contentTypeOf(name, content.ContentType)
+	var fileID *string
+	var evictedBlobKeys []string
+	err := svc.referenceContent(ctx, content, func(committedContent fsmodel.FileContent) error {
+		return svc.inTransaction(ctx, func(svc FileSystemService) error {
+			if err := svc.errorIfFolderNotFound(ctx, parentID); err != nil {
+				return withCodeIfNotFound(err, BadRequest,
+					fmt.Sprintf("Not found folder specified (id=%s) when trying to create file named %s inside.", parentID, name))
+			}
+
+			// the transaction may be retried: the policy of the first attempt is kept for the next ones
+			writePolicy := policy
+			if writePolicy == ConflictVersion {
+				versionedFile, err := svc.fileNamed(ctx, parentID, name)
+				if err != nil {
+					return err
+				}
+				if versionedFile != nil {
+					if err := svc.errorIfFileNotFound(ctx, versionedFile.Id); err != nil {
+						return err
+					}
+					fileID = &versionedFile.Id
+					var err error
+					evictedBlobKeys, err = svc.addFileVersion(ctx, versionedFile.Id, committedContent)
+					return err
+				}
+				writePolicy = ConflictFail
+			}
+
+			return svc.writeWithConflictPolicy(ctx, parentID, name, namedItem{isFolder: false, id: noItemID}, writePolicy, func(name string) error {
+				var err error
+				fileID, err = svc.repo.CreateFile(ctx, name, committedContent, parentID)
+				return err
+			})
+		})
+	})
+	if err == nil {
+		svc.purgeEvictedContent(ctx, evictedBlobKeys)
 	}
-	return svc.repo.CreateFolder(name, parentID)
+	return fileID, err
 }
 
-func (svc FileSystemService) CreateFile(name string, path string, parentID int) (*int, error) {
-	if err := svc.errorIfFolderNotFound(parentID); err != nil {
-		return nil, errors.WithMessage(
-			errors.New(BadRequest),
-			fmt.Sprintf("Not found folder specified (id=%d) when trying to create file named %s inside.", parentID, name))
+func (svc FileSystemService) UpdateFolder(ctx context.Context, folderID string, name string, policy ConflictPolicy) error {
+	ctx, cancel := svc.timeouts.write(ctx)
+	defer cancel()
+
+	err := svc.inTransaction(ctx, func(svc FileSystemService) error {
+		if err := svc.errorIfFolderNotFound(ctx, folderID); err != nil {
+			return err
+		}
+
+		folder, err := svc.repo.GetFolder(ctx, folderID)
+		if err != nil {
+			return err
+		}
+		if folder.ParentId == nil {
+			// the root folder has no sibling to conflict with
+			return svc.repo.UpdateFolder(ctx, folderID, name)
+		}
+		if err := svc.errorIfFolderNotFound(ctx, *folder.ParentId); err != nil {
+			return err
+		}
+
+		return svc.writeWithConflictPolicy(ctx, *folder.ParentId, name, namedItem{isFolder: true, id: folderID}, policy, func(name string) error {
+			return svc.repo.UpdateFolder(ctx, folderID, name)
+		})
+	})
+	return err
+}
+
+func (svc FileSystemService) UpdateFile(ctx context.Context, fileID string, name string, contentType string, policy ConflictPolicy) error {
+	if name == "" {
+		return errors.WithMessage(errors.New(BadRequest), fmt.Sprintf("The file %s cannot be renamed without name", fileID))
+	}
+	if err := errorIfInvalidContentType(contentType); err != nil {
+		return err
 	}
-	return svc.repo.CreateFile(name, path, parentID)
+
+	ctx, cancel := svc.timeouts.write(ctx)
+	defer cancel()
+
+	err := svc.inTransaction(ctx, func(svc FileSystemService) error {
+		if err := svc.errorIfFileNotFound(ctx, fileID); err != nil {
+			return err
+		}
+
+		file, err := svc.repo.GetFile(ctx, fileID)
+		if err != nil {
+			return err
+		}
+		if err := svc.errorIfFolderNotFound(ctx, file.ParentId); err != nil {
+			return err
+		}
+
+		return svc.writeWithConflictPolicy(ctx, file.ParentId, name, namedItem{isFolder: false, id: fileID}, policy, func(name string) error {
+			return svc.repo.UpdateFile(ctx, fileID, name, contentType)
+		})
+	})
+	return err
 }
 
-func (svc FileSystemService) UpdateFolder(folderID int, name string) error {
-	if err := svc.errorIfFolderNotFound(folderID); err != nil {
-		return errors.New(NotFound)
+// ReplaceFileContent makes the content the current one of the file, as a new version. When ifMatch is not nil, the
+// current content must have one of its digests, or the replacement fails with PreconditionFailed.
+func (svc FileSystemService) ReplaceFileContent(ctx context.Context, fileID string, content fsmodel.FileContent, ifMatch []string) error {
+	ctx, cancel := svc.timeouts.write(ctx)
+	defer cancel()
+
+	var evictedBlobKeys []string
+	err := svc.referenceContent(ctx, content, func(committedContent fsmodel.FileContent) error {
+		return svc.inTransaction(ctx, func(svc FileSystemService) error {
+			if err := svc.errorIfFileNotFound(ctx, fileID); err != nil {
+				return withCodeIfNotFound(err, NotFound, fmt.Sprintf("Could not find file %s to replace its content.", fileID))
+			}
+
+			file, err := svc.repo.GetFile(ctx, fileID)
+			if err != nil {
+				return err
+			}
+			if err := ErrorIfContentNotMatched(*file, ifMatch); err != nil {
+				return err
+			}
+
+			committedContent.ContentType = contentTypeOf(file.Name, committedContent.ContentType)
+			evictedBlobKeys, err = svc.addFileVersion(ctx, fileID, committedContent)
+			return err
+		})
+	})
+	if err == nil {
+		svc.purgeEvictedContent(ctx, evictedBlobKeys)
 	}
-	return svc.repo.UpdateFolder(folderID, name)
+	return err
 }
 
-func (svc FileSystemService) MoveFolder(folderID int, destFolderID int) error {
-	if err := svc.errorIfFolderNotFound(folderID); err != nil {
+// ErrorIfContentNotMatched fails with PreconditionFailed when ifMatch is not nil and the current content of the file
+// has none of its digests
+func ErrorIfContentNotMatched(file fsmodel.File, ifMatch []string) error {
+	if ifMatch != nil && !matchesDigest(file.Digest, ifMatch) {
 		return errors.WithMessage(
-			errors.New(BadRequest),
-			fmt.Sprintf("Could not find folder %d trying to be moved.", folderID))
+			errors.New(PreconditionFailed),
+			fmt.Sprintf("The content of file %s has the digest '%s', none of %v.", file.Id, file.Digest, ifMatch))
 	}
-	if err := svc.errorIfFolderNotFound(destFolderID); err != nil {
-		return errors.WithMessage(
-			errors.New(BadRequest),
-			fmt.Sprintf("Could not find destination folder %d where folder %d is trying to be moved.", destFolderID, folderID))
+	return nil
+}
+
+// matchesDigest tells whether the digest is one of the expected ones. A content without digest matches none.
+func matchesDigest(digest string, expectedDigests []string) bool {
+	for _, expectedDigest := range expectedDigests {
+		if digest != "" && digest == expectedDigest {
+			return true
+		}
 	}
+	return false
+}
 
-	if isRoot, err := svc.repo.IsRootFolder(folderID); err != nil || isRoot == nil || *isRoot == true {
-		return errors.WithMessage(
-			errors.New(IllegalOperation),
-			fmt.Sprintf("The root folder %d cannot be moved to any other folder. Attempted target folder %d.", destFolderID, folderID))
+func (svc FileSystemService) MoveFolder(ctx context.Context, folderID string, destFolderID string, policy ConflictPolicy) error {
+	ctx, cancel := svc.timeouts.write(ctx)
+	defer cancel()
+
+	err := svc.inTransaction(ctx, func(svc FileSystemService) error {
+		if err := svc.errorIfFolderNotFound(ctx, folderID); err != nil {
+			return withCodeIfNotFound(err, BadRequest,
+				fmt.Sprintf("Could not find folder %s trying to be moved.", folderID))
+		}
+		if err := svc.errorIfFolderNotFound(ctx, destFolderID); err != nil {
+			return withCodeIfNotFound(err, BadRequest,
+				fmt.Sprintf("Could not find destination folder %s where folder %s is trying to be moved.", destFolderID, folderID))
+		}
+
+		isRoot, err := svc.repo.IsRootFolder(ctx, folderID)
+		if err != nil {
+			return err
+		}
+		if *isRoot {
+			return errors.WithMessage(
+				errors.New(IllegalOperation),
+				fmt.Sprintf("The root folder %s cannot be moved to any other folder. Attempted target folder %s.", folderID, destFolderID))
+		}
+
+		// moving a folder inside itself would detach the whole subtree from the root
+		if folderID == destFolderID {
+			return errors.WithMessage(
+				errors.New(IllegalOperation),
+				fmt.Sprintf("The folder %s cannot be moved inside itself.", folderID))
+		}
+		isInside, err := svc.repo.IsFolderInside(ctx, destFolderID, folderID)
+		if err != nil {
+			return err
+		}
+		if *isInside {
+			return errors.WithMessage(
+				errors.New(IllegalOperation),
+				fmt.Sprintf("The folder %s cannot be moved inside its descendant folder %s.", folderID, destFolderID))
+		}
+
+		folder, err := svc.repo.GetFolder(ctx, folderID)
+		if err != nil {
+			return err
+		}
+		return svc.writeWithConflictPolicy(ctx, destFolderID, folder.Name, namedItem{isFolder: true, id: folderID}, policy, func(name string) error {
+			return svc.repo.MoveFolder(ctx, folderID, destFolderID, name)
+		})
+	})
+	return err
+}
+
+func (svc FileSystemService) MoveFile(ctx context.Context, fileID string, destFolderID string, policy ConflictPolicy) error {
+	ctx, cancel := svc.timeouts.write(ctx)
+	defer cancel()
+
+	err := svc.inTransaction(ctx, func(svc FileSystemService) error {
+		if err := svc.errorIfFileNotFound(ctx, fileID); err != nil {
+			return withCodeIfNotFound(err, BadRequest,
+				fmt.Sprintf("Could not find file %s trying to be moved.", fileID))
+		}
+		if err := svc.errorIfFolderNotFound(ctx, destFolderID); err != nil {
+			return withCodeIfNotFound(err, BadRequest,
+				fmt.Sprintf("Could not find destination folder %s where file %s is trying to be moved.", destFolderID, fileID))
+		}
+
+		file, err := svc.repo.GetFile(ctx, fileID)
+		if err != nil {
+			return err
+		}
+		return svc.writeWithConflictPolicy(ctx, destFolderID, file.Name, namedItem{isFolder: false, id: fileID}, policy, func(name string) error {
+			return svc.repo.MoveFile(ctx, fileID, destFolderID, name)
+		})
+	})
+	return err
+}
+
+// CopyFolder copies the folder and its whole subtree inside the destination folder. The copied files share the
+// content of the original ones.
+func (svc FileSystemService) CopyFolder(ctx context.Context, folderID string, destFolderID string, policy ConflictPolicy) (*string, error) {
+	ctx, cancel := svc.timeouts.write(ctx)
+	defer cancel()
+
+	var copyID *string
+	err := svc.inTransaction(ctx, func(svc FileSystemService) error {
+		if err := svc.errorIfFolderNotFound(ctx, folderID); err != nil {
+			return withCodeIfNotFound(err, BadRequest,
+				fmt.Sprintf("Could not find folder %s trying to be copied.", folderID))
+		}
+		if err := svc.errorIfFolderNotFound(ctx, destFolderID); err != nil {
+			return withCodeIfNotFound(err, BadRequest,
+				fmt.Sprintf("Could not find destination folder %s where folder %s is trying to be copied.", destFolderID, folderID))
+		}
+
+		// the copy would be copied in turn, endlessly. The root folder is always in this case.
+		if folderID == destFolderID {
+			return errors.WithMessage(
+				errors.New(IllegalOperation),
+				fmt.Sprintf("The folder %s cannot be copied inside itself.", folderID))
+		}
+		isInside, err := svc.repo.IsFolderInside(ctx, destFolderID, folderID)
+		if err != nil {
+			return err
+		}
+		if *isInside {
+			return errors.WithMessage(
+				errors.New(IllegalOperation),
+				fmt.Sprintf("The folder %s cannot be copied inside its descendant folder %s.", folderID, destFolderID))
+		}
+
+		// nothing can be added to the subtree, moved out of it or deleted while it is copied
+		count, err := svc.repo.LockFolderSubtree(ctx, folderID)
+		if err != nil {
+			return err
+		}
+		if *count > svc.maxCopiedItems {
+			return errors.WithMessage(
+				errors.New(IllegalOperation),
+				fmt.Sprintf("The folder %s holds %d items, a copy is limited to %d.", folderID, *count, svc.maxCopiedItems))
+		}
+
+		folder, err := svc.repo.GetFolder(ctx, folderID)
+		if err != nil {
+			return err
+		}
+		err = svc.writeWithConflictPolicy(ctx, destFolderID, folder.Name, namedItem{isFolder: true, id: noItemID, copiedID: folderID}, policy, func(name string) error {
+			var err error
+			copyID, err = svc.repo.CreateFolder(ctx, name, destFolderID)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		return svc.copyFolderContent(ctx, folderID, *copyID)
+	})
+	return copyID, err
+}
+
+// copyFolderContent copies the children of the folder inside the copy of the folder, which is empty. The subtree of the
+// folder must be locked: its files cannot be deleted meanwhile.
+func (svc FileSystemService) copyFolderContent(ctx context.Context, folderID string, copyID string) error {
+	files, err := svc.repo.GetFilesIn(ctx, folderID)
+	if err != nil {
+		return err
+	}
+	for _, file := range *files {
+		if _, err := svc.repo.CreateFile(ctx, file.Name, file.FileContent, copyID); err != nil {
+			return err
+		}
+	}
+
+	folders, err := svc.repo.GetFoldersIn(ctx, folderID)
+	if err != nil {
+		return err
+	}
+	for _, folder := range *folders {
+		folderCopyID, err := svc.repo.CreateFolder(ctx, folder.Name, copyID)
+		if err != nil {
+			return err
+		}
+		if err := svc.copyFolderContent(ctx, folder.Id, *folderCopyID); err != nil {
+			return err
+		}
 	}
-	return svc.repo.MoveFolder(folderID, destFolderID)
+	return nil
 }
 
-func (svc FileSystemService) MoveFile(fileID int, destFolderID int) error {
-	if err := svc.errorIfFileNotFound(fileID); err != nil {
-		return errors.WithMessage(
-			errors.New(BadRequest),
-			fmt.Sprintf("Could not find file %d trying to be moved.", fileID))
+// CopyFile copies the file inside the destination folder. The copy shares the content of the file.
+func (svc FileSystemService) CopyFile(ctx context.Context, fileID string, destFolderID string, policy ConflictPolicy) (*string, error) {
+	ctx, cancel := svc.timeouts.write(ctx)
+	defer cancel()
+
+	var copyID *string
+	err := svc.inTransaction(ctx, func(svc FileSystemService) error {
+		if err := svc.errorIfFileNotFound(ctx, fileID); err != nil {
+			return withCodeIfNotFound(err, BadRequest,
+				fmt.Sprintf("Could not find file %s trying to be copied.", fileID))
+		}
+		if err := svc.errorIfFolderNotFound(ctx, destFolderID); err != nil {
+			return withCodeIfNotFound(err, BadRequest,
+				fmt.Sprintf("Could not find destination folder %s where file %s is trying to be copied.", destFolderID, fileID))
+		}
+
+		file, err := svc.repo.GetFile(ctx, fileID)
+		if err != nil {
+			return err
+		}
+		return svc.writeWithConflictPolicy(ctx, destFolderID, file.Name, namedItem{isFolder: false, id: noItemID, copiedID: fileID}, policy, func(name string) error {
+			var err error
+			copyID, err = svc.copyFile(ctx, *file, destFolderID, name)
+			return err
+		})
+	})
+	return copyID, err
+}
+
+// copyFile creates the copy of the file, which references the same content. The file is locked until the end of the
+// transaction: it cannot be deleted meanwhile, so its content cannot be purged before the copy references it too.
+func (svc FileSystemService) copyFile(ctx context.Context, file fsmodel.File, destFolderID string, name string) (*string, error) {
+	if err := svc.errorIfFileNotFound(ctx, file.Id); err != nil {
+		return nil, err
 	}
-	if err := svc.errorIfFolderNotFound(destFolderID); err != nil {
-		return errors.WithMessage(
-			errors.New(BadRequest),
-			fmt.Sprintf("Could not find destination folder %d where file %d is trying to be moved.", destFolderID, fileID))
+	return svc.repo.CreateFile(ctx, name, file.FileContent, destFolderID)
+}
+
+// PurgeDeletedContent deletes from the blob store the content of the deleted files. A content which fails to be deleted
+// stays recorded as orphan in the repository, and is retried on the next purge.
+func (svc FileSystemService) PurgeDeletedContent(ctx context.Context) (int, error) {
+	ctx, cancel := svc.timeouts.write(ctx)
+	defer cancel()
+
+	blobKeys, err := svc.repo.GetOrphanBlobKeys(ctx)
+	if err != nil {
+		return 0, err
 	}
 
-	return svc.repo.MoveFile(fileID, destFolderID)
+	purged := 0
+	var firstErr error
+	for _, blobKey := range *blobKeys {
+		if err := svc.purgeOrphanBlob(ctx, blobKey); err != nil {
+			if firstErr == nil {
+				firstErr = errors.WithMessage(err, fmt.Sprintf("Could not delete the content with blob key %s", blobKey))
+			}
+			continue
+		}
+		purged++
+	}
+	return purged, firstErr
 }
 
-func (svc FileSystemService) DeleteFolderAndContent(folderID int) error {
-	if found, err := svc.repo.ExistsFolder(folderID); err != nil || found == nil || *found == false {
-		return errors.New(NotFound)
+func (svc FileSystemService) purgeOrphanBlob(ctx context.Context, blobKey string) error {
+	lock := svc.contentLocks.Of(blobKey)
+	lock.Lock()
+	defer lock.Unlock()
+
+	// a file may have been created with the same content since it was recorded as orphan
+	referenced, err := svc.repo.IsBlobReferenced(ctx, blobKey)
+	if err != nil {
+		return err
 	}
+	if !*referenced {
+		if err := svc.blobs.Delete(blobKey); err != nil {
+			return err
+		}
+	}
+	return svc.repo.RemoveOrphanBlobKey(ctx, blobKey)
+}
 
-	if isRoot, err := svc.repo.IsRootFolder(folderID); err != nil || isRoot == nil || *isRoot == true {
-		return errors.WithMessage(
-			errors.New(IllegalOperation),
-			fmt.Sprintf("Cannot delete root folder %d", folderID))
+// PurgeStaleContent deletes the staged content no file references, and the temporary files of the blob store, last
+// written before the time: a crash may leave them behind. The time must leave the ongoing writes enough margin.
+func (svc FileSystemService) PurgeStaleContent(ctx context.Context, stagedBefore time.Time) (int, error) {
+	ctx, cancel := svc.timeouts.write(ctx)
+	defer cancel()
+
+	blobs, err := svc.blobs.List()
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	var firstErr error
+	for _, blob := range blobs {
+		if fsstorage.IsContentKey(blob.Key) || !blob.ModTime.Before(stagedBefore) {
+			continue
+		}
+		deleted, err := svc.purgeStagedBlob(ctx, blob.Key)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = errors.WithMessage(err, fmt.Sprintf("Could not delete the staged content with blob key %s", blob.Key))
+			}
+			continue
+		}
+		if deleted {
+			purged++
+		}
+	}
+
+	tmpPurged, err := svc.blobs.PurgeTemporaryFiles(stagedBefore)
+	if err != nil && firstErr == nil {
+		firstErr = errors.WithMessage(err, "Could not delete the temporary files of the blob store")
+	}
+	return purged + tmpPurged, firstErr
+}
+
+func (svc FileSystemService) purgeStagedBlob(ctx context.Context, blobKey string) (bool, error) {
+	// the files created before the content keys reference the key they were uploaded with
+	referenced, err := svc.repo.IsBlobReferenced(ctx, blobKey)
+	if err != nil || *referenced {
+		return false, err
+	}
+	return true, svc.blobs.Delete(blobKey)
+}
+
+// purgeEvictedContent purges the content of the versions deleted beyond the limit, once their deletion is committed.
+// It cannot happen during the write itself, which may hold the lock of a content key.
+func (svc FileSystemService) purgeEvictedContent(ctx context.Context, blobKeys []string) {
+	for _, blobKey := range blobKeys {
+		// the write already succeeded: content which cannot be purged now will be on the next periodic purge
+		if err := svc.purgeOrphanBlob(ctx, blobKey); err != nil {
+			fmt.Println(err, fmt.Sprintf("Error when trying to purge the content with blob key %s.", blobKey))
+		}
 	}
+}
 
-	return svc.repo.DeleteFolderAndContent(folderID)
+func (svc FileSystemService) purgeDeletedContentAfterDelete(ctx context.Context) {
+	// the delete already succeeded: content which cannot be purged now will be on the next periodic purge
+	if _, err := svc.PurgeDeletedContent(ctx); err != nil {
+		fmt.Println(err, "Error when trying to purge the content of the deleted files.")
+	}
 }
 
-func (svc FileSystemService) DeleteFile(fileID int) error {
-	if found, err := svc.repo.ExistsFile(fileID); err != nil || found == nil || *found == false {
-		return errors.New("The file does not exist. It cannot be deleted.")
+// referenceContent moves the staged content to its content key, then calls reference, which makes a file point to
+// the content. The content key stays locked in between so that it cannot be purged meanwhile.
+func (svc FileSystemService) referenceContent(ctx context.Context, content fsmodel.FileContent, reference func(fsmodel.FileContent) error) error {
+	if content.Digest == "" {
+		return errors.WithMessage(errors.New(BadRequest), "The content to reference has no digest")
+	}
+
+	contentKey := fsstorage.ContentKey(content.Digest)
+	lock := svc.contentLocks.Of(contentKey)
+	lock.Lock()
+	defer lock.Unlock()
+
+	created := false
+	if content.BlobKey != contentKey {
+		var err error
+		if created, err = fsstorage.CommitContent(svc.blobs, content.BlobKey, contentKey); err != nil {
+			return err
+		}
 	}
-	return svc.repo.DeleteFile(fileID)
+
+	committedContent := content
+	committedContent.BlobKey = contentKey
+	err := reference(committedContent)
+	if err != nil && created {
+		// do not keep a content nobody references, unless the reference was made despite the error
+		if referenced, refErr := svc.repo.IsBlobReferenced(ctx, contentKey); refErr == nil && !*referenced {
+			svc.blobs.Delete(contentKey)
+		}
+	}
+	return err
 }
 
-func (svc FileSystemService) errorIfFileNotFound(fileID int) error {
-	exists, err := svc.ExistsFile(fileID)
+func (svc FileSystemService) DeleteFolderAndContent(ctx context.Context, folderID string) error {
+	ctx, cancel := svc.timeouts.write(ctx)
+	defer cancel()
+
+	return svc.inTransaction(ctx, func(svc FileSystemService) error {
+		if err := svc.errorIfFolderNotFound(ctx, folderID); err != nil {
+			return err
+		}
+
+		isRoot, err := svc.repo.IsRootFolder(ctx, folderID)
+		if err != nil {
+			return err
+		}
+		if *isRoot {
+			return errors.WithMessage(
+				errors.New(IllegalOperation),
+				fmt.Sprintf("Cannot delete root folder %s", folderID))
+		}
+
+		folder, err := svc.repo.GetFolder(ctx, folderID)
+		if err != nil {
+			return err
+		}
+		return svc.moveToTrash(ctx, fsmodel.TrashEntry{ItemId: folderID, Kind: fsmodel.FolderKind, Name: folder.Name, ParentId: *folder.ParentId})
+	})
+}
+
+func (svc FileSystemService) DeleteFile(ctx context.Context, fileID string) error {
+	ctx, cancel := svc.timeouts.write(ctx)
+	defer cancel()
+
+	return svc.inTransaction(ctx, func(svc FileSystemService) error {
+		if err := svc.errorIfFileNotFound(ctx, fileID); err != nil {
+			return withCodeIfNotFound(err, NotFound, "The file does not exist. It cannot be deleted.")
+		}
+
+		file, err := svc.repo.GetFile(ctx, fileID)
+		if err != nil {
+			return err
+		}
+		return svc.moveToTrash(ctx, fsmodel.TrashEntry{ItemId: fileID, Kind: fsmodel.FileKind, Name: file.Name, ParentId: file.ParentId})
+	})
+}
+
+// inTransaction runs the work with a service whose repository runs everything in a single transaction
+func (svc FileSystemService) inTransaction(ctx context.Context, work func(svc FileSystemService) error) error {
+	return svc.repo.ExecuteInTransaction(ctx, func(repo fsrepository.IFileSystemRepository) error {
+		txSvc := svc
+		txSvc.repo = repo
+		return work(txSvc)
+	})
+}
+
+func (svc FileSystemService) errorIfFileNotFound(ctx context.Context, fileID string) error {
+	exists, err := svc.ExistsFile(ctx, fileID)
 	if err != nil {
 		return err
 	}
@@ -199,8 +804,8 @@ func (svc FileSystemService) errorIfFileNotFound(fileID int) error {
 	return nil
 }
 
-func (svc FileSystemService) errorIfFolderNotFound(folderID int) error {
-	exists, err := svc.ExistsFolder(folderID)
+func (svc FileSystemService) errorIfFolderNotFound(ctx context.Context, folderID string) error {
+	exists, err := svc.ExistsFolder(ctx, folderID)
 	if err != nil {
 		return err
 	}
@@ -209,3 +814,20 @@ func (svc FileSystemService) errorIfFolderNotFound(folderID int) error {
 	}
 	return nil
 }
+
+// withCodeIfNotFound replaces NotFound by the code which suits the operation. Other errors are returned as is, so that
+// the repository can retry the transaction on its transient errors.
+func withCodeIfNotFound(err error, code string, message string) error {
+	if errors.Cause(err).Error() != NotFound {
+		return err
+	}
+	return errors.WithMessage(errors.New(code), message)
+}
+
+// withCodeIfItemNotFound replaces the ItemNotFound of the repository by the code which suits the operation
+func withCodeIfItemNotFound(err error, code string, message string) error {
+	if errors.Cause(err).Error() != fsrepository.ItemNotFound {
+		return err
+	}
+	return errors.WithMessage(errors.New(code), message)
+}
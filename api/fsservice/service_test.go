@@ -1,186 +1,1070 @@
 package fsservice
 
 import (
+	"context"
+	"io/ioutil"
+	"os"
 	"runtime/debug"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/loisfa/remote-file-system/api/fsmodel"
+	"github.com/loisfa/remote-file-system/api/fsrepository"
+	"github.com/loisfa/remote-file-system/api/fsstorage"
+	"github.com/pkg/errors"
 )
 
-func TestDbInit(t *testing.T) {
-	folderIdToFolderMap := GetDbFolderMap()
+// unknownID is a well formed id no item has
+const unknownID = "ffffffff-ffff-7fff-bfff-ffffffffffff"
 
-	if folderIdToFolderMap == nil {
-		t.Errorf("folderIdToFolderMap is nil")
+func newTestService(t *testing.T) (FileSystemService, fsstorage.LocalBlobStore) {
+	dir, err := ioutil.TempDir("", "service-test-")
+	if err != nil {
+		t.Fatal(err)
 	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
 
-	var rootFolder = folderIdToFolderMap[0]
-	var photosFolder = folderIdToFolderMap[1]
-	var summerPhotosFolder = folderIdToFolderMap[2]
-
-	if rootFolder != nil {
-		t.Errorf("folderIdToFolderMap is not nil before db init")
+	blobs, err := fsstorage.NewLocalBlobStore(dir)
+	if err != nil {
+		t.Fatal(err)
 	}
-	if photosFolder != nil {
-		t.Errorf("photosFolder is not nil before db init")
+	return NewFileSystemService(fsrepository.NewMemoryFileSystemRepository(), blobs, Timeouts{}, 0), blobs
+}
+
+func TestCreateFolder(t *testing.T) {
+	ctx := context.Background()
+	svc, _ := newTestService(t)
+	rootID := getRootFolderID(t, svc)
+
+	folderID, err := svc.CreateFolder(ctx, "New folder", rootID, ConflictFail)
+	assertNoError(t, err)
+
+	folder, err := svc.GetFolder(ctx, *folderID)
+	assertNoError(t, err)
+	assertEqual(t, folder.Id, *folderID)
+	assertEqual(t, folder.Name, "New folder")
+	assertEqual(t, *folder.ParentId, rootID)
+
+	_, err = svc.CreateFolder(ctx, "Inner folder", unknownID, ConflictFail)
+	assertErrorCode(t, err, BadRequest)
+}
+
+func TestCreateFolderWithConflictPolicy(t *testing.T) {
+	ctx := context.Background()
+	svc, _ := newTestService(t)
+	rootID := getRootFolderID(t, svc)
+	folderID, err := svc.CreateFolder(ctx, "Photos", rootID, ConflictFail)
+	assertNoError(t, err)
+
+	_, err = svc.CreateFolder(ctx, "Photos", rootID, ConflictFail)
+	assertErrorCode(t, err, Conflict)
+
+	renamedID, err := svc.CreateFolder(ctx, "Photos", rootID, ConflictRename)
+	assertNoError(t, err)
+	renamed, err := svc.GetFolder(ctx, *renamedID)
+	assertNoError(t, err)
+	assertEqual(t, renamed.Name, "Photos (1)")
+
+	overwritingID, err := svc.CreateFolder(ctx, "Photos", rootID, ConflictOverwrite)
+	assertNoError(t, err)
+	exists, err := svc.ExistsFolder(ctx, *folderID)
+	assertNoError(t, err)
+	assertEqual(t, *exists, false)
+	overwriting, err := svc.GetFolder(ctx, *overwritingID)
+	assertNoError(t, err)
+	assertEqual(t, overwriting.Name, "Photos")
+}
+
+func TestOverwriteFileWithFolderIsConflict(t *testing.T) {
+	ctx := context.Background()
+	svc, _ := newTestService(t)
+	rootID := getRootFolderID(t, svc)
+	createFile(t, svc, "notes", "some notes", rootID)
+
+	_, err := svc.CreateFolder(ctx, "notes", rootID, ConflictOverwrite)
+	assertErrorCode(t, err, Conflict)
+}
+
+func TestOverwriteMovesToTrash(t *testing.T) {
+	ctx := context.Background()
+	svc, blobs := newTestService(t)
+	rootID := getRootFolderID(t, svc)
+	fileID := createFile(t, svc, "notes.txt", "some notes", rootID)
+	file, err := svc.GetFile(ctx, fileID)
+	assertNoError(t, err)
+
+	content, err := svc.StoreFileContent(ctx, strings.NewReader("other notes"))
+	assertNoError(t, err)
+	_, err = svc.CreateFile(ctx, "notes.txt", *content, rootID, ConflictOverwrite)
+	assertNoError(t, err)
+
+	entries, err := svc.GetTrashEntries(ctx)
+	assertNoError(t, err)
+	assertEqual(t, len(*entries), 1)
+	assertEqual(t, (*entries)[0].ItemId, fileID)
+	assertEqual(t, (*entries)[0].Name, "notes.txt")
+	assertEqual(t, (*entries)[0].ParentId, rootID)
+	// the content is kept until the trash is purged
+	_, err = blobs.Stat(file.BlobKey)
+	assertNoError(t, err)
+
+	assertNoError(t, svc.RestoreFromTrash(ctx, fileID, ConflictRename))
+	restored, err := svc.GetFile(ctx, fileID)
+	assertNoError(t, err)
+	assertEqual(t, restored.Name, "notes (1).txt")
+}
+
+func TestGetFolderContent(t *testing.T) {
+	ctx := context.Background()
+	svc, _ := newTestService(t)
+	rootID := getRootFolderID(t, svc)
+	folderID, err := svc.CreateFolder(ctx, "Folder", rootID, ConflictFail)
+	assertNoError(t, err)
+	createFile(t, svc, "notes", "some notes", rootID)
+
+	content, err := svc.GetFolderContent(ctx, rootID, fsmodel.ListOptions{})
+	assertNoError(t, err)
+	assertEqual(t, content.Folder.Id, rootID)
+	assertEqual(t, len(content.Folders), 1)
+	assertEqual(t, content.Folders[0].Id, *folderID)
+	assertEqual(t, len(content.Files), 1)
+	assertEqual(t, content.Files[0].Name, "notes")
+
+	_, err = svc.GetFolderContent(ctx, unknownID, fsmodel.ListOptions{})
+	assertErrorCode(t, err, NotFound)
+}
+
+func TestParseListOptions(t *testing.T) {
+	options, err := ParseListOptions(ListParams{})
+	assertNoError(t, err)
+	assertEqual(t, options, fsmodel.ListOptions{Sort: fsmodel.SortByName})
+
+	options, err = ParseListOptions(ListParams{Sort: "type", Order: "desc", Kind: "file", Ext: ".JPG", Name: "photo*", Limit: "50"})
+	assertNoError(t, err)
+	assertEqual(t, options, fsmodel.ListOptions{Sort: fsmodel.SortByType, Descending: true, Kind: fsmodel.FileKind, Extension: "jpg", NameGlob: "photo*", Limit: 50})
+
+	for _, params := range []ListParams{
+		{Sort: "date"},
+		{Order: "up"},
+		{Kind: "link"},
+		{Limit: "0"},
+		{Limit: "1001"},
+		{Limit: "ten"},
+		{Cursor: "not a cursor"},
+	} {
+		_, err = ParseListOptions(params)
+		assertErrorCode(t, err, BadRequest)
 	}
-	if summerPhotosFolder != nil {
-		t.Errorf("summerPhotosFolder is not nil before db init")
+}
+
+func TestListPagesWithCursor(t *testing.T) {
+	ctx := context.Background()
+	svc, _ := newTestService(t)
+	rootID := getRootFolderID(t, svc)
+	createFile(t, svc, "file 10", "a", rootID)
+	createFile(t, svc, "file 9", "b", rootID)
+	createFile(t, svc, "file 1", "c", rootID)
+
+	params := ListParams{Order: "desc", Limit: "2"}
+	options, err := ParseListOptions(params)
+	assertNoError(t, err)
+	content, err := svc.GetFolderContent(ctx, rootID, options)
+	assertNoError(t, err)
+	assertEqual(t, len(content.Files), 2)
+	assertEqual(t, content.Files[1].Name, "file 9")
+
+	params.Cursor = EncodeCursor(options, *content.Next)
+	options, err = ParseListOptions(params)
+	assertNoError(t, err)
+	content, err = svc.GetFolderContent(ctx, rootID, options)
+	assertNoError(t, err)
+	assertEqual(t, len(content.Files), 1)
+	assertEqual(t, content.Files[0].Name, "file 1")
+	assertEqual(t, content.Next, (*fsmodel.ListPosition)(nil))
+
+	// a cursor cannot resume the pages in another order
+	params.Order = "asc"
+	_, err = ParseListOptions(params)
+	assertErrorCode(t, err, BadRequest)
+}
+
+func TestListPagesWithCursorByModification(t *testing.T) {
+	ctx := context.Background()
+	svc, _ := newTestService(t)
+	rootID := getRootFolderID(t, svc)
+	createFile(t, svc, "file 2", "a", rootID)
+	time.Sleep(time.Millisecond)
+	createFile(t, svc, "file 1", "b", rootID)
+
+	params := ListParams{Sort: "modified", Limit: "1"}
+	options, err := ParseListOptions(params)
+	assertNoError(t, err)
+	content, err := svc.GetFolderContent(ctx, rootID, options)
+	assertNoError(t, err)
+	assertEqual(t, content.Files[0].Name, "file 2")
+
+	// the cursor keeps the modification of the last child
+	params.Cursor = EncodeCursor(options, *content.Next)
+	options, err = ParseListOptions(params)
+	assertNoError(t, err)
+	assertEqual(t, *options.After, *content.Next)
+	content, err = svc.GetFolderContent(ctx, rootID, options)
+	assertNoError(t, err)
+	assertEqual(t, content.Files[0].Name, "file 1")
+	assertEqual(t, content.Next, (*fsmodel.ListPosition)(nil))
+}
+
+func TestUpdateFolder(t *testing.T) {
+	ctx := context.Background()
+	svc, _ := newTestService(t)
+	rootID := getRootFolderID(t, svc)
+	folderID, err := svc.CreateFolder(ctx, "New folder", rootID, ConflictFail)
+	assertNoError(t, err)
+
+	assertNoError(t, svc.UpdateFolder(ctx, *folderID, "New name for folder", ConflictFail))
+
+	folder, err := svc.GetFolder(ctx, *folderID)
+	assertNoError(t, err)
+	assertEqual(t, folder.Name, "New name for folder")
+}
+
+func TestUpdateFile(t *testing.T) {
+	ctx := context.Background()
+	svc, _ := newTestService(t)
+	rootID := getRootFolderID(t, svc)
+	createFile(t, svc, "report.md", "other report", rootID)
+	fileID := createFile(t, svc, "report.txt", "# Report", rootID)
+
+	assertErrorCode(t, svc.UpdateFile(ctx, fileID, "report.md", "", ConflictFail), Conflict)
+	assertNoError(t, svc.UpdateFile(ctx, fileID, "report.md", "text/markdown; charset=utf-8", ConflictRename))
+
+	file, err := svc.GetFile(ctx, fileID)
+	assertNoError(t, err)
+	assertEqual(t, file.Name, "report (1).md")
+	assertEqual(t, file.ContentType, "text/markdown; charset=utf-8")
+
+	assertErrorCode(t, svc.UpdateFile(ctx, fileID, "", "", ConflictFail), BadRequest)
+	assertErrorCode(t, svc.UpdateFile(ctx, fileID, "report.md", "not a type", ConflictFail), BadRequest)
+	assertErrorCode(t, svc.UpdateFile(ctx, unknownID, "report.md", "", ConflictFail), NotFound)
+}
+
+func TestMoveFolder(t *testing.T) {
+	ctx := context.Background()
+	svc, _ := newTestService(t)
+	rootID := getRootFolderID(t, svc)
+	folderID, err := svc.CreateFolder(ctx, "New folder", rootID, ConflictFail)
+	assertNoError(t, err)
+	targetFolderID, err := svc.CreateFolder(ctx, "Target folder", rootID, ConflictFail)
+	assertNoError(t, err)
+
+	assertNoError(t, svc.MoveFolder(ctx, *folderID, *targetFolderID, ConflictFail))
+
+	folder, err := svc.GetFolder(ctx, *folderID)
+	assertNoError(t, err)
+	assertEqual(t, folder.Name, "New folder")
+	assertEqual(t, *folder.ParentId, *targetFolderID)
+}
+
+func TestMoveFolderInsideItselfIsIllegal(t *testing.T) {
+	ctx := context.Background()
+	svc, _ := newTestService(t)
+	rootID := getRootFolderID(t, svc)
+	folderID, err := svc.CreateFolder(ctx, "Folder", rootID, ConflictFail)
+	assertNoError(t, err)
+	childID, err := svc.CreateFolder(ctx, "Child", *folderID, ConflictFail)
+	assertNoError(t, err)
+	grandChildID, err := svc.CreateFolder(ctx, "Grand child", *childID, ConflictFail)
+	assertNoError(t, err)
+
+	assertErrorCode(t, svc.MoveFolder(ctx, *folderID, *folderID, ConflictFail), IllegalOperation)
+	assertErrorCode(t, svc.MoveFolder(ctx, *folderID, *grandChildID, ConflictFail), IllegalOperation)
+	assertErrorCode(t, svc.MoveFolder(ctx, rootID, *folderID, ConflictFail), IllegalOperation)
+}
+
+func TestMoveFileWithConflictPolicy(t *testing.T) {
+	ctx := context.Background()
+	svc, _ := newTestService(t)
+	rootID := getRootFolderID(t, svc)
+	targetFolderID, err := svc.CreateFolder(ctx, "Target folder", rootID, ConflictFail)
+	assertNoError(t, err)
+	createFile(t, svc, "report.txt", "target report", *targetFolderID)
+	fileID := createFile(t, svc, "report.txt", "moved report", rootID)
+
+	assertErrorCode(t, svc.MoveFile(ctx, fileID, *targetFolderID, ConflictFail), Conflict)
+
+	assertNoError(t, svc.MoveFile(ctx, fileID, *targetFolderID, ConflictRename))
+	file, err := svc.GetFile(ctx, fileID)
+	assertNoError(t, err)
+	assertEqual(t, file.Name, "report (1).txt")
+	assertEqual(t, file.ParentId, *targetFolderID)
+}
+
+func TestCopyFolder(t *testing.T) {
+	ctx := context.Background()
+	svc, blobs := newTestService(t)
+	rootID := getRootFolderID(t, svc)
+	templateID, err := svc.CreateFolder(ctx, "Template", rootID, ConflictFail)
+	assertNoError(t, err)
+	docsID, err := svc.CreateFolder(ctx, "Docs", *templateID, ConflictFail)
+	assertNoError(t, err)
+	_, err = svc.CreateFolder(ctx, "Empty", *docsID, ConflictFail)
+	assertNoError(t, err)
+	readmeID := createFile(t, svc, "README.md", "read me", *templateID)
+	createFile(t, svc, "guide.txt", "guide", *docsID)
+	projectsID, err := svc.CreateFolder(ctx, "Projects", rootID, ConflictFail)
+	assertNoError(t, err)
+
+	copyID, err := svc.CopyFolder(ctx, *templateID, *projectsID, ConflictFail)
+	assertNoError(t, err)
+	copy, err := svc.GetFolder(ctx, *copyID)
+	assertNoError(t, err)
+	assertEqual(t, copy.Name, "Template")
+	assertEqual(t, *copy.ParentId, *projectsID)
+
+	files, err := svc.GetFilesIn(ctx, *copyID)
+	assertNoError(t, err)
+	assertEqual(t, len(*files), 1)
+	readme, err := svc.GetFile(ctx, readmeID)
+	assertNoError(t, err)
+	readmeCopy := (*files)[0]
+	if readmeCopy.Id == readme.Id {
+		t.Fatalf("the copy has the id of the file %s", readme.Id)
 	}
+	assertEqual(t, readmeCopy.Name, "README.md")
+	assertEqual(t, readmeCopy.FileContent, readme.FileContent)
+
+	folders, err := svc.GetFoldersIn(ctx, *copyID)
+	assertNoError(t, err)
+	assertEqual(t, len(*folders), 1)
+	assertEqual(t, (*folders)[0].Name, "Docs")
+	folders, err = svc.GetFoldersIn(ctx, (*folders)[0].Id)
+	assertNoError(t, err)
+	assertEqual(t, len(*folders), 1)
+	assertEqual(t, (*folders)[0].Name, "Empty")
+
+	// the copy keeps referencing the content once the original is deleted
+	assertNoError(t, svc.DeleteFolderAndContent(ctx, *templateID))
+	_, err = blobs.Stat(readme.BlobKey)
+	assertNoError(t, err)
+
+	_, err = svc.CopyFolder(ctx, *templateID, rootID, ConflictFail)
+	assertErrorCode(t, err, BadRequest)
+	_, err = svc.CopyFolder(ctx, *projectsID, unknownID, ConflictFail)
+	assertErrorCode(t, err, BadRequest)
+}
+
+func TestCopyFolderInsideItselfIsIllegal(t *testing.T) {
+	ctx := context.Background()
+	svc, _ := newTestService(t)
+	rootID := getRootFolderID(t, svc)
+	folderID, err := svc.CreateFolder(ctx, "Folder", rootID, ConflictFail)
+	assertNoError(t, err)
+	childID, err := svc.CreateFolder(ctx, "Child", *folderID, ConflictFail)
+	assertNoError(t, err)
+
+	_, err = svc.CopyFolder(ctx, *folderID, *folderID, ConflictFail)
+	assertErrorCode(t, err, IllegalOperation)
+	_, err = svc.CopyFolder(ctx, *folderID, *childID, ConflictFail)
+	assertErrorCode(t, err, IllegalOperation)
+	_, err = svc.CopyFolder(ctx, rootID, *folderID, ConflictFail)
+	assertErrorCode(t, err, IllegalOperation)
+}
+
+func TestCopyFolderTooLarge(t *testing.T) {
+	ctx := context.Background()
+	svc, _ := newTestService(t)
+	svc.maxCopiedItems = 2
+	rootID := getRootFolderID(t, svc)
+	folderID, err := svc.CreateFolder(ctx, "Folder", rootID, ConflictFail)
+	assertNoError(t, err)
+	createFile(t, svc, "file.txt", "some content", *folderID)
+
+	_, err = svc.CopyFolder(ctx, *folderID, rootID, ConflictRename)
+	assertNoError(t, err)
+
+	createFile(t, svc, "other file.txt", "other content", *folderID)
+	_, err = svc.CopyFolder(ctx, *folderID, rootID, ConflictRename)
+	assertErrorCode(t, err, IllegalOperation)
+	folders, err := svc.GetFoldersIn(ctx, rootID)
+	assertNoError(t, err)
+	assertEqual(t, len(*folders), 2)
+}
+
+func TestCopyWithConflictPolicy(t *testing.T) {
+	ctx := context.Background()
+	svc, _ := newTestService(t)
+	rootID := getRootFolderID(t, svc)
+	folderID, err := svc.CreateFolder(ctx, "Folder", rootID, ConflictFail)
+	assertNoError(t, err)
+	fileID := createFile(t, svc, "report.txt", "report", rootID)
+
+	_, err = svc.CopyFile(ctx, fileID, rootID, ConflictFail)
+	assertErrorCode(t, err, Conflict)
+	_, err = svc.CopyFolder(ctx, *folderID, rootID, ConflictFail)
+	assertErrorCode(t, err, Conflict)
 
-	InitDB()
+	fileCopyID, err := svc.CopyFile(ctx, fileID, rootID, ConflictRename)
+	assertNoError(t, err)
+	fileCopy, err := svc.GetFile(ctx, *fileCopyID)
+	assertNoError(t, err)
+	assertEqual(t, fileCopy.Name, "report (1).txt")
+	folderCopyID, err := svc.CopyFolder(ctx, *folderID, rootID, ConflictRename)
+	assertNoError(t, err)
+	folderCopy, err := svc.GetFolder(ctx, *folderCopyID)
+	assertNoError(t, err)
+	assertEqual(t, folderCopy.Name, "Folder (1)")
 
-	rootFolder = folderIdToFolderMap[0]
-	photosFolder = folderIdToFolderMap[1]
-	summerPhotosFolder = folderIdToFolderMap[2]
-	if rootFolder == nil {
-		t.Errorf("folderIdToFolderMap is nil after db init")
+	// the copies would delete what they copy
+	_, err = svc.CopyFile(ctx, fileID, rootID, ConflictOverwrite)
+	assertErrorCode(t, err, Conflict)
+	_, err = svc.CopyFolder(ctx, *folderID, rootID, ConflictOverwrite)
+	assertErrorCode(t, err, Conflict)
+	childID, err := svc.CreateFolder(ctx, "Folder", *folderID, ConflictFail)
+	assertNoError(t, err)
+	_, err = svc.CopyFolder(ctx, *childID, rootID, ConflictOverwrite)
+	assertErrorCode(t, err, Conflict)
+
+	overwrittenID := createFile(t, svc, "report.txt", "overwritten report", *folderID)
+	_, err = svc.CopyFile(ctx, fileID, *folderID, ConflictOverwrite)
+	assertNoError(t, err)
+	_, err = svc.GetFile(ctx, overwrittenID)
+	assertErrorCode(t, err, NotFound)
+}
+
+func TestDeleteFolderAndContent(t *testing.T) {
+	ctx := context.Background()
+	svc, _ := newTestService(t)
+	rootID := getRootFolderID(t, svc)
+	folderID, err := svc.CreateFolder(ctx, "New folder", rootID, ConflictFail)
+	assertNoError(t, err)
+	innerFolderID, err := svc.CreateFolder(ctx, "New inner folder", *folderID, ConflictFail)
+	assertNoError(t, err)
+	fileID := createFile(t, svc, "New file", "some content", *innerFolderID)
+
+	assertNoError(t, svc.DeleteFolderAndContent(ctx, *folderID))
+
+	_, err = svc.GetFolder(ctx, *folderID)
+	assertErrorCode(t, err, NotFound)
+	_, err = svc.GetFolder(ctx, *innerFolderID)
+	assertErrorCode(t, err, NotFound)
+	_, err = svc.GetFile(ctx, fileID)
+	assertErrorCode(t, err, NotFound)
+
+	assertErrorCode(t, svc.DeleteFolderAndContent(ctx, rootID), IllegalOperation)
+}
+
+func TestCreateFolderWhileParentDeleted(t *testing.T) {
+	ctx := context.Background()
+	svc, _ := newTestService(t)
+	rootID := getRootFolderID(t, svc)
+	folderID, err := svc.CreateFolder(ctx, "New folder", rootID, ConflictFail)
+	assertNoError(t, err)
+
+	// the folder is deleted between the check of the parent and the creation of the child: the delete has to wait
+	deleted := make(chan error, 1)
+	var startDelete sync.Once
+	interleavedSvc := svc
+	interleavedSvc.repo = &interleavingRepository{IFileSystemRepository: svc.repo, beforeCreateFolder: func() {
+		startDelete.Do(func() {
+			go func() { deleted <- svc.DeleteFolderAndContent(ctx, *folderID) }()
+			select {
+			case <-deleted:
+				t.Fatal("the parent was deleted after it was checked, before the child was created")
+			case <-time.After(20 * time.Millisecond):
+			}
+		})
+	}}
+	innerFolderID, err := interleavedSvc.CreateFolder(ctx, "New inner folder", *folderID, ConflictFail)
+	assertNoError(t, err)
+	assertNoError(t, <-deleted)
+
+	// the child is not left behind inside the deleted folder
+	_, err = svc.GetFolder(ctx, *innerFolderID)
+	assertErrorCode(t, err, NotFound)
+
+	// once the folder is deleted, the child cannot be created anymore
+	_, err = svc.CreateFolder(ctx, "Other inner folder", *folderID, ConflictFail)
+	assertErrorCode(t, err, BadRequest)
+}
+
+func TestCreateFileRecordsMetadata(t *testing.T) {
+	ctx := context.Background()
+	svc, _ := newTestService(t)
+	rootID := getRootFolderID(t, svc)
+
+	for _, upload := range []struct{ name, content, expectedType string }{
+		{"page.html", "<!DOCTYPE html><html></html>", "text/html; charset=utf-8"},
+		{"data.json", `{"some": "text"}`, "application/json"},
+		{"notes", "some notes", "text/plain; charset=utf-8"},
+		{"image.jpeg", "<!DOCTYPE html><html></html>", "text/html; charset=utf-8"}, // the content prevails over the extension
+	} {
+		file, err := svc.GetFile(ctx, createFile(t, svc, upload.name, upload.content, rootID))
+		assertNoError(t, err)
+		assertEqual(t, file.Size, int64(len(upload.content)))
+		assertEqual(t, file.ContentType, upload.expectedType)
+		if file.CreatedAt.IsZero() || file.ModifiedAt != file.CreatedAt {
+			t.Fatalf("unexpected timestamps %+v", *file)
+		}
 	}
-	if photosFolder == nil {
-		t.Errorf("photosFolder is nil after db init")
+}
+
+func TestEmptyTrashPurgesUnreferencedContent(t *testing.T) {
+	ctx := context.Background()
+	svc, blobs := newTestService(t)
+	rootID := getRootFolderID(t, svc)
+	fileID := createFile(t, svc, "file.txt", "shared content", rootID)
+	otherFileID := createFile(t, svc, "other file.txt", "shared content", rootID)
+	file, err := svc.GetFile(ctx, fileID)
+	assertNoError(t, err)
+
+	// the other file still references the content
+	assertNoError(t, svc.DeleteFile(ctx, fileID))
+	purged, err := svc.EmptyTrash(ctx)
+	assertNoError(t, err)
+	assertEqual(t, purged, 1)
+	_, err = blobs.Stat(file.BlobKey)
+	assertNoError(t, err)
+
+	// the trashed file still references the content
+	assertNoError(t, svc.DeleteFile(ctx, otherFileID))
+	_, err = blobs.Stat(file.BlobKey)
+	assertNoError(t, err)
+
+	_, err = svc.EmptyTrash(ctx)
+	assertNoError(t, err)
+	_, err = blobs.Stat(file.BlobKey)
+	assertErrorCode(t, err, fsstorage.BlobNotFound)
+
+	assertErrorCode(t, svc.DeleteFile(ctx, fileID), NotFound)
+}
+
+func TestPurgeRetriesFailedDelete(t *testing.T) {
+	ctx := context.Background()
+	svc, blobs := newTestService(t)
+	rootID := getRootFolderID(t, svc)
+	fileID := createFile(t, svc, "file.txt", "some content", rootID)
+	file, err := svc.GetFile(ctx, fileID)
+	assertNoError(t, err)
+	assertNoError(t, svc.DeleteFile(ctx, fileID))
+
+	svc.blobs = failingDeleteBlobStore{blobs}
+	_, err = svc.EmptyTrash(ctx)
+	assertNoError(t, err)
+	_, err = svc.PurgeDeletedContent(ctx)
+	if err == nil {
+		t.Fatal("expected the failed delete to be reported")
 	}
-	if summerPhotosFolder == nil {
-		t.Errorf("summerPhotosFolder is nil after db init")
+	orphans, err := svc.repo.GetOrphanBlobKeys(ctx)
+	assertNoError(t, err)
+	assertEqual(t, len(*orphans), 1)
+	assertEqual(t, (*orphans)[0], file.BlobKey)
+	_, err = blobs.Stat(file.BlobKey)
+	assertNoError(t, err)
+
+	svc.blobs = blobs
+	purged, err := svc.PurgeDeletedContent(ctx)
+	assertNoError(t, err)
+	assertEqual(t, purged, 1)
+	_, err = blobs.Stat(file.BlobKey)
+	assertErrorCode(t, err, fsstorage.BlobNotFound)
+	orphans, err = svc.repo.GetOrphanBlobKeys(ctx)
+	assertNoError(t, err)
+	assertEqual(t, len(*orphans), 0)
+}
+
+func TestPurgeStaleContent(t *testing.T) {
+	ctx := context.Background()
+	svc, blobs := newTestService(t)
+	rootID := getRootFolderID(t, svc)
+	fileID := createFile(t, svc, "file.txt", "committed content", rootID)
+	file, err := svc.GetFile(ctx, fileID)
+	assertNoError(t, err)
+	staged, err := svc.StoreFileContent(ctx, strings.NewReader("staged content"))
+	assertNoError(t, err)
+	// a file created before the content keys references the key it was uploaded with
+	legacy, err := svc.StoreFileContent(ctx, strings.NewReader("legacy content"))
+	assertNoError(t, err)
+	_, err = svc.repo.CreateFile(ctx, "legacy.txt", *legacy, rootID)
+	assertNoError(t, err)
+
+	purged, err := svc.PurgeStaleContent(ctx, time.Now().Add(-time.Hour))
+	assertNoError(t, err)
+	assertEqual(t, purged, 0)
+
+	purged, err = svc.PurgeStaleContent(ctx, time.Now().Add(time.Hour))
+	assertNoError(t, err)
+	assertEqual(t, purged, 1)
+	_, err = blobs.Stat(staged.BlobKey)
+	assertErrorCode(t, err, fsstorage.BlobNotFound)
+	_, err = blobs.Stat(legacy.BlobKey)
+	assertNoError(t, err)
+	_, err = blobs.Stat(file.BlobKey)
+	assertNoError(t, err)
+}
+
+func TestDeleteMovesToTrash(t *testing.T) {
+	ctx := context.Background()
+	svc, _ := newTestService(t)
+	rootID := getRootFolderID(t, svc)
+	folderID, err := svc.CreateFolder(ctx, "Photos", rootID, ConflictFail)
+	assertNoError(t, err)
+	fileID := createFile(t, svc, "notes.txt", "some notes", *folderID)
+
+	assertNoError(t, svc.DeleteFile(ctx, fileID))
+	assertNoError(t, svc.DeleteFolderAndContent(ctx, *folderID))
+
+	entries, err := svc.GetTrashEntries(ctx)
+	assertNoError(t, err)
+	assertEqual(t, len(*entries), 2)
+	for _, entry := range *entries {
+		switch entry.ItemId {
+		case fileID:
+			assertEqual(t, entry.Kind, fsmodel.FileKind)
+			assertEqual(t, entry.Name, "notes.txt")
+			assertEqual(t, entry.ParentId, *folderID)
+		case *folderID:
+			assertEqual(t, entry.Kind, fsmodel.FolderKind)
+			assertEqual(t, entry.Name, "Photos")
+			assertEqual(t, entry.ParentId, rootID)
+		default:
+			t.Fatalf("unexpected trash entry %+v", entry)
+		}
 	}
+
+	// the trashed items cannot be reached, nor be written to
+	folders, err := svc.GetFoldersIn(ctx, rootID)
+	assertNoError(t, err)
+	assertEqual(t, len(*folders), 0)
+	_, err = svc.GetFolderContent(ctx, *folderID, fsmodel.ListOptions{})
+	assertErrorCode(t, err, NotFound)
+	_, err = svc.CreateFolder(ctx, "Holidays", *folderID, ConflictFail)
+	assertErrorCode(t, err, BadRequest)
+	assertErrorCode(t, svc.DeleteFile(ctx, fileID), NotFound)
 }
 
-func TestDbCreateFolder(t *testing.T) {
-	InitDB()
+func TestRestoreFromTrash(t *testing.T) {
+	ctx := context.Background()
+	svc, _ := newTestService(t)
+	rootID := getRootFolderID(t, svc)
+	folderID, err := svc.CreateFolder(ctx, "Photos", rootID, ConflictFail)
+	assertNoError(t, err)
+	fileID := createFile(t, svc, "notes.txt", "some notes", *folderID)
+	assertNoError(t, svc.DeleteFolderAndContent(ctx, *folderID))
 
-	var newFolderId int = DBCreateFolder("New folder", 0)
+	assertNoError(t, svc.RestoreFromTrash(ctx, *folderID, ConflictFail))
+	folder, err := svc.GetFolder(ctx, *folderID)
+	assertNoError(t, err)
+	assertEqual(t, folder.Name, "Photos")
+	assertEqual(t, *folder.ParentId, rootID)
+	file, err := svc.GetFile(ctx, fileID)
+	assertNoError(t, err)
+	assertEqual(t, file.ParentId, *folderID)
 
-	folderIdToFolderMap := GetDbFolderMap()
+	assertErrorCode(t, svc.RestoreFromTrash(ctx, *folderID, ConflictFail), NotFound)
+}
+
+func TestRestoreFromTrashWithConflictPolicy(t *testing.T) {
+	ctx := context.Background()
+	svc, _ := newTestService(t)
+	rootID := getRootFolderID(t, svc)
+	fileID := createFile(t, svc, "notes.txt", "some notes", rootID)
+	assertNoError(t, svc.DeleteFile(ctx, fileID))
+	createFile(t, svc, "notes.txt", "other notes", rootID)
 
-	var createdFolder *DBFolder = folderIdToFolderMap[newFolderId]
+	// the entry stays in the trash when the restore fails
+	assertErrorCode(t, svc.RestoreFromTrash(ctx, fileID, ConflictFail), Conflict)
+	entries, err := svc.GetTrashEntries(ctx)
+	assertNoError(t, err)
+	assertEqual(t, len(*entries), 1)
 
-	assertEqual(t, createdFolder.Id, newFolderId)
-	assertEqual(t, createdFolder.Name, "New folder")
+	assertNoError(t, svc.RestoreFromTrash(ctx, fileID, ConflictRename))
+	file, err := svc.GetFile(ctx, fileID)
+	assertNoError(t, err)
+	assertEqual(t, file.Name, "notes (1).txt")
 }
 
-func TestDbUpdateFolder(t *testing.T) {
-	InitDB()
+func TestRestoreFromTrashWithoutParent(t *testing.T) {
+	ctx := context.Background()
+	svc, _ := newTestService(t)
+	rootID := getRootFolderID(t, svc)
+	folderID, err := svc.CreateFolder(ctx, "Photos", rootID, ConflictFail)
+	assertNoError(t, err)
+	fileID := createFile(t, svc, "beach.jpg", "a beach", *folderID)
+	assertNoError(t, svc.DeleteFile(ctx, fileID))
+	assertNoError(t, svc.DeleteFolderAndContent(ctx, *folderID))
 
-	var newFolderId int = DBCreateFolder("New folder", 0)
+	// the folder the file was deleted from is trashed too
+	assertNoError(t, svc.RestoreFromTrash(ctx, fileID, ConflictFail))
+	file, err := svc.GetFile(ctx, fileID)
+	assertNoError(t, err)
+	assertEqual(t, file.ParentId, rootID)
+	assertEqual(t, file.Name, "beach.jpg")
+}
 
-	folderIdToFolderMap := GetDbFolderMap()
+func TestPurgeTrash(t *testing.T) {
+	ctx := context.Background()
+	svc, _ := newTestService(t)
+	rootID := getRootFolderID(t, svc)
+	folderID, err := svc.CreateFolder(ctx, "Photos", rootID, ConflictFail)
+	assertNoError(t, err)
+	assertNoError(t, svc.DeleteFolderAndContent(ctx, *folderID))
+	entries, err := svc.GetTrashEntries(ctx)
+	assertNoError(t, err)
+	trashedAt := (*entries)[0].TrashedAt
 
-	var createdFolder *DBFolder = folderIdToFolderMap[newFolderId]
-	assertEqual(t, createdFolder.Id, newFolderId)
-	assertEqual(t, createdFolder.Name, "New folder")
+	purged, err := svc.PurgeTrash(ctx, trashedAt)
+	assertNoError(t, err)
+	assertEqual(t, purged, 0)
 
-	DBUpdateFolder(newFolderId, "New name for folder")
-	var updatedFolder *DBFolder = folderIdToFolderMap[newFolderId]
-	assertEqual(t, updatedFolder.Id, newFolderId)
-	assertEqual(t, updatedFolder.Name, "New name for folder")
+	purged, err = svc.PurgeTrash(ctx, trashedAt.Add(time.Millisecond))
+	assertNoError(t, err)
+	assertEqual(t, purged, 1)
+	assertErrorCode(t, svc.RestoreFromTrash(ctx, *folderID, ConflictFail), NotFound)
+	exists, err := svc.repo.ExistsFolder(ctx, *folderID)
+	assertNoError(t, err)
+	assertEqual(t, *exists, false)
 }
 
-func TestDbMoveFolder(t *testing.T) {
-	InitDB()
+func TestCreateFileAddsVersion(t *testing.T) {
+	ctx := context.Background()
+	svc, _ := newTestService(t)
+	rootID := getRootFolderID(t, svc)
+	fileID := createFile(t, svc, "config.txt", "first", rootID)
 
-	folderIdToFolderMap := GetDbFolderMap()
-	var rootFolder *DBFolder = folderIdToFolderMap[0]
+	versions, err := svc.GetFileVersions(ctx, fileID)
+	assertNoError(t, err)
+	assertEqual(t, len(*versions), 1)
+	assertEqual(t, (*versions)[0].Number, 1)
 
-	var newFolderId int = DBCreateFolder("New folder", rootFolder.Id)
+	versionedID := createFileVersion(t, svc, "config.txt", "second", rootID)
+	assertEqual(t, versionedID, fileID)
+	file, err := svc.GetFile(ctx, fileID)
+	assertNoError(t, err)
+	assertEqual(t, file.Size, int64(len("second")))
+	files, err := svc.GetFilesIn(ctx, rootID)
+	assertNoError(t, err)
+	assertEqual(t, len(*files), 1)
 
-	var createdFolder *DBFolder = folderIdToFolderMap[newFolderId]
-	assertEqual(t, createdFolder.Id, newFolderId)
-	assertEqual(t, createdFolder.Name, "New folder")
-	assertEqual(t, *createdFolder.ParentId, rootFolder.Id)
+	versions, err = svc.GetFileVersions(ctx, fileID)
+	assertNoError(t, err)
+	assertEqual(t, len(*versions), 2)
+	assertEqual(t, (*versions)[0].Number, 2)
+	assertEqual(t, (*versions)[0].Digest, file.Digest)
+	assertEqual(t, (*versions)[1].Size, int64(len("first")))
 
-	var targetFolder *DBFolder = folderIdToFolderMap[1]
-	assertNotNil(t, targetFolder)
+	// a new name creates a file, a folder is not versioned
+	otherID := createFileVersion(t, svc, "other.txt", "other", rootID)
+	if otherID == fileID {
+		t.Fatalf("expected a new file, got %s", otherID)
+	}
+	_, err = svc.CreateFolder(ctx, "folder", rootID, ConflictFail)
+	assertNoError(t, err)
+	content, err := svc.StoreFileContent(ctx, strings.NewReader("content"))
+	assertNoError(t, err)
+	_, err = svc.CreateFile(ctx, "folder", *content, rootID, ConflictVersion)
+	assertErrorCode(t, err, Conflict)
+}
+
+func TestCreateFileVersionWhenRetried(t *testing.T) {
+	svc, _ := newTestService(t)
+	rootID := getRootFolderID(t, svc)
+
+	// the file is created concurrently, while the first attempt of the transaction creates it as well
+	var fileID string
+	retryingSvc := svc
+	retryingSvc.repo = &retryingRepository{IFileSystemRepository: svc.repo, beforeRetry: func() {
+		fileID = createFile(t, svc, "config.txt", "first", rootID)
+	}}
+	versionedID := createFileVersion(t, retryingSvc, "config.txt", "second", rootID)
+	assertEqual(t, versionedID, fileID)
+	assertEqual(t, len(*versionsOf(t, svc, fileID)), 2)
+}
+
+func TestRestoreFileVersion(t *testing.T) {
+	ctx := context.Background()
+	svc, blobs := newTestService(t)
+	rootID := getRootFolderID(t, svc)
+	fileID := createFile(t, svc, "config.txt", "first", rootID)
+	createFileVersion(t, svc, "config.txt", "second", rootID)
+
+	firstVersion, err := svc.GetFileVersion(ctx, fileID, 1)
+	assertNoError(t, err)
+	assertNoError(t, svc.RestoreFileVersion(ctx, fileID, 1))
+	file, err := svc.GetFile(ctx, fileID)
+	assertNoError(t, err)
+	assertEqual(t, file.Digest, firstVersion.Digest)
+	_, err = blobs.Stat(file.BlobKey)
+	assertNoError(t, err)
+
+	versions, err := svc.GetFileVersions(ctx, fileID)
+	assertNoError(t, err)
+	assertEqual(t, len(*versions), 3)
+	assertEqual(t, (*versions)[0].Number, 3)
+
+	// restoring the current version changes nothing
+	assertNoError(t, svc.RestoreFileVersion(ctx, fileID, 3))
+	versions, err = svc.GetFileVersions(ctx, fileID)
+	assertNoError(t, err)
+	assertEqual(t, len(*versions), 3)
+
+	assertErrorCode(t, svc.RestoreFileVersion(ctx, fileID, 4), NotFound)
+	_, err = svc.GetFileVersion(ctx, fileID, 0)
+	assertErrorCode(t, err, NotFound)
+}
+
+func TestFileVersionsLimit(t *testing.T) {
+	ctx := context.Background()
+	svc, blobs := newTestService(t)
+	svc.maxFileVersions = 2
+	rootID := getRootFolderID(t, svc)
+	fileID := createFile(t, svc, "config.txt", "first", rootID)
+	firstFile, err := svc.GetFile(ctx, fileID)
+	assertNoError(t, err)
+
+	createFileVersion(t, svc, "config.txt", "second", rootID)
+	createFileVersion(t, svc, "config.txt", "third", rootID)
+
+	versions, err := svc.GetFileVersions(ctx, fileID)
+	assertNoError(t, err)
+	assertEqual(t, len(*versions), 2)
+	assertEqual(t, (*versions)[0].Number, 3)
+	assertEqual(t, (*versions)[1].Number, 2)
+
+	// the content of the deleted version is purged
+	_, err = blobs.Stat(firstFile.BlobKey)
+	assertErrorCode(t, err, fsstorage.BlobNotFound)
+
+	// the other deleted content is left to the periodic purge
+	otherFileID := createFile(t, svc, "other.txt", "other", rootID)
+	otherFile, err := svc.GetFile(ctx, otherFileID)
+	assertNoError(t, err)
+	assertNoError(t, svc.repo.DeleteFile(ctx, otherFileID))
+	createFileVersion(t, svc, "config.txt", "fourth", rootID)
+	_, err = blobs.Stat(otherFile.BlobKey)
+	assertNoError(t, err)
+}
+
+func TestReplaceFileContent(t *testing.T) {
+	ctx := context.Background()
+	svc, _ := newTestService(t)
+	rootID := getRootFolderID(t, svc)
+	fileID := createFile(t, svc, "report.json", "{}", rootID)
+	file, err := svc.GetFile(ctx, fileID)
+	assertNoError(t, err)
+
+	content, err := svc.StoreFileContent(ctx, strings.NewReader(`{"total": 12}`))
+	assertNoError(t, err)
+	assertNoError(t, svc.ReplaceFileContent(ctx, fileID, *content, nil))
+	replacedFile, err := svc.GetFile(ctx, fileID)
+	assertNoError(t, err)
+	assertEqual(t, replacedFile.Digest, content.Digest)
+	assertEqual(t, replacedFile.Size, int64(len(`{"total": 12}`)))
+	assertEqual(t, replacedFile.ContentType, "application/json")
+	assertEqual(t, replacedFile.CreatedAt, file.CreatedAt)
+	if replacedFile.ModifiedAt.Before(file.ModifiedAt) || replacedFile.ModifiedAt != (*versionsOf(t, svc, fileID))[0].CreatedAt {
+		t.Fatalf("unexpected modification time %+v", *replacedFile)
+	}
+	versions := versionsOf(t, svc, fileID)
+	assertEqual(t, len(*versions), 2)
+	assertEqual(t, (*versions)[1].Digest, file.Digest)
 
-	DBMoveFolder(newFolderId, &targetFolder.Id)
-	assertEqual(t, createdFolder.Id, newFolderId)
-	assertEqual(t, createdFolder.Name, "New folder")
-	assertEqual(t, *createdFolder.ParentId, targetFolder.Id)
+	content, err = svc.StoreFileContent(ctx, strings.NewReader(`{"total": 13}`))
+	assertNoError(t, err)
+	assertNoError(t, svc.ReplaceFileContent(ctx, fileID, *content, []string{"other digest", replacedFile.Digest}))
+	assertErrorCode(t, svc.ReplaceFileContent(ctx, "missing", *content, nil), NotFound)
 }
 
-func TestDbDeleteFolderAndContent(t *testing.T) {
-	InitDB()
+func TestReplaceFileContentPreconditionFailed(t *testing.T) {
+	ctx := context.Background()
+	svc, _ := newTestService(t)
+	rootID := getRootFolderID(t, svc)
+	fileID := createFile(t, svc, "report.txt", "first", rootID)
+	file, err := svc.GetFile(ctx, fileID)
+	assertNoError(t, err)
 
-	var newFolderId int = DBCreateFolder("New folder", 0)
-	var newInnerFolderId int = DBCreateFolder("New inner folder", newFolderId)
-	var newFileId int = DBCreateFile("New file", "./my-path/file1.txt", newFolderId)
+	content, err := svc.StoreFileContent(ctx, strings.NewReader("second"))
+	assertNoError(t, err)
+	assertErrorCode(t, svc.ReplaceFileContent(ctx, fileID, *content, []string{"other digest"}), PreconditionFailed)
+	// the staged content is not kept once the replacement failed
+	content, err = svc.StoreFileContent(ctx, strings.NewReader("second"))
+	assertNoError(t, err)
+	assertErrorCode(t, svc.ReplaceFileContent(ctx, fileID, *content, []string{}), PreconditionFailed)
 
-	folderIdToFolderMap := GetDbFolderMap()
-	fileIdToFileMap := GetDbFileMap()
+	unchangedFile, err := svc.GetFile(ctx, fileID)
+	assertNoError(t, err)
+	assertEqual(t, *unchangedFile, *file)
+	versions, err := svc.GetFileVersions(ctx, fileID)
+	assertNoError(t, err)
+	assertEqual(t, len(*versions), 1)
 
-	assertNotNil(t, folderIdToFolderMap[newFolderId])
-	assertNotNil(t, folderIdToFolderMap[newInnerFolderId])
-	assertNotNil(t, fileIdToFileMap[newFileId])
+	// the check the handlers make before receiving the content
+	assertNoError(t, ErrorIfContentNotMatched(*file, nil))
+	assertNoError(t, ErrorIfContentNotMatched(*file, []string{"other digest", file.Digest}))
+	assertErrorCode(t, ErrorIfContentNotMatched(*file, []string{"other digest"}), PreconditionFailed)
+}
 
-	DBDeleteFolderAndContent(newFolderId)
+func TestVersionPolicyOnlyAppliesToUploads(t *testing.T) {
+	ctx := context.Background()
+	svc, _ := newTestService(t)
+	rootID := getRootFolderID(t, svc)
+	folderID, err := svc.CreateFolder(ctx, "folder", rootID, ConflictFail)
+	assertNoError(t, err)
+	fileID := createFile(t, svc, "file.txt", "content", rootID)
 
-	assertNil(t, folderIdToFolderMap[newFolderId])
-	assertNil(t, folderIdToFolderMap[newInnerFolderId])
-	assertNil(t, fileIdToFileMap[newFileId])
+	_, err = svc.CreateFolder(ctx, "other folder", rootID, ConflictVersion)
+	assertErrorCode(t, err, BadRequest)
+	assertErrorCode(t, svc.MoveFile(ctx, fileID, *folderID, ConflictVersion), BadRequest)
+	_, err = svc.CopyFile(ctx, fileID, *folderID, ConflictVersion)
+	assertErrorCode(t, err, BadRequest)
 }
 
-func TestDbCreateFile(t *testing.T) {
-	InitDB()
+func TestWriteTimeout(t *testing.T) {
+	ctx := context.Background()
+	svc, _ := newTestService(t)
+	rootID := getRootFolderID(t, svc)
+	svc.timeouts = Timeouts{Write: time.Nanosecond}
+
+	_, err := svc.CreateFolder(ctx, "New folder", rootID, ConflictFail)
+	if errors.Cause(err) != context.DeadlineExceeded {
+		t.Fatalf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+	// the reads are not bound by the write timeout
+	folders, err := svc.GetFoldersIn(ctx, rootID)
+	assertNoError(t, err)
+	assertEqual(t, len(*folders), 0)
+}
 
-	var newFileId int = DBCreateFile("New file", "/my-path/file5.txt", 0)
+func TestGetIDByLegacyID(t *testing.T) {
+	ctx := context.Background()
+	repo := fsrepository.NewMemoryFileSystemRepository()
+	svc := NewFileSystemService(repo, nil, Timeouts{}, 0)
+	rootID := getRootFolderID(t, svc)
+	importedFolder := fsmodel.Folder{Id: "0190f4a2-5b3c-7d4e-8f60-000000000001", LegacyId: 12, Name: "Imported", ParentId: &rootID}
+	assertNoError(t, repo.ImportFolder(ctx, importedFolder))
 
-	fileIdToFileMap := GetDbFileMap()
+	folderID, err := svc.GetFolderIDByLegacyID(ctx, 12)
+	assertNoError(t, err)
+	assertEqual(t, *folderID, importedFolder.Id)
 
-	var createdFile *DBFile = fileIdToFileMap[newFileId]
+	folderID, err = svc.GetFolderIDByLegacyID(ctx, 0)
+	assertNoError(t, err)
+	assertEqual(t, *folderID, rootID)
 
-	assertEqual(t, createdFile.Id, newFileId)
-	assertEqual(t, createdFile.Name, "New file")
-	assertEqual(t, createdFile.Path, "/my-path/file5.txt")
+	_, err = svc.GetFolderIDByLegacyID(ctx, 13)
+	assertErrorCode(t, err, NotFound)
+	_, err = svc.GetFileIDByLegacyID(ctx, 12)
+	assertErrorCode(t, err, NotFound)
 }
 
-func TestDbDeleteFile(t *testing.T) {
-	InitDB()
+func TestCancelledRead(t *testing.T) {
+	svc, _ := newTestService(t)
+	rootID := getRootFolderID(t, svc)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
 
-	var newFileId int = DBCreateFile("New file", "/path/word.txt", 0)
+	_, err := svc.GetFolder(ctx, rootID)
+	if errors.Cause(err) != context.Canceled {
+		t.Fatalf("expected %v, got %v", context.Canceled, err)
+	}
+}
+
+func getRootFolderID(t *testing.T, svc FileSystemService) string {
+	ctx := context.Background()
+	rootID, err := svc.GetRootFolderID(ctx)
+	assertNoError(t, err)
+	return *rootID
+}
 
-	fileIdToFileMap := GetDbFileMap()
+func createFile(t *testing.T, svc FileSystemService, name string, content string, parentID string) string {
+	ctx := context.Background()
+	fileContent, err := svc.StoreFileContent(ctx, strings.NewReader(content))
+	assertNoError(t, err)
+	fileID, err := svc.CreateFile(ctx, name, *fileContent, parentID, ConflictFail)
+	assertNoError(t, err)
+	return *fileID
+}
 
-	var createdFile *DBFile = fileIdToFileMap[newFileId]
-	assertEqual(t, createdFile.Id, newFileId)
+func createFileVersion(t *testing.T, svc FileSystemService, name string, content string, parentID string) string {
+	ctx := context.Background()
+	fileContent, err := svc.StoreFileContent(ctx, strings.NewReader(content))
+	assertNoError(t, err)
+	fileID, err := svc.CreateFile(ctx, name, *fileContent, parentID, ConflictVersion)
+	assertNoError(t, err)
+	return *fileID
+}
 
-	DBDeleteFile(newFileId)
+func versionsOf(t *testing.T, svc FileSystemService, fileID string) *[]fsmodel.FileVersion {
+	versions, err := svc.GetFileVersions(context.Background(), fileID)
+	assertNoError(t, err)
+	return versions
+}
 
-	assertNil(t, fileIdToFileMap[newFileId])
+// retryingRepository rolls back the first transaction, then runs it again as Neo4j retries the transient failures
+type retryingRepository struct {
+	fsrepository.IFileSystemRepository
+	beforeRetry func()
+	retried     bool
 }
 
-func TestDbMoveFile(t *testing.T) {
-	InitDB()
+func (repo *retryingRepository) ExecuteInTransaction(ctx context.Context, work func(repo fsrepository.IFileSystemRepository) error) error {
+	if !repo.retried {
+		repo.retried = true
+		_ = repo.IFileSystemRepository.ExecuteInTransaction(ctx, func(txRepo fsrepository.IFileSystemRepository) error {
+			if err := work(txRepo); err != nil {
+				return err
+			}
+			return errors.New("transient failure")
+		})
+		repo.beforeRetry()
+	}
+	return repo.IFileSystemRepository.ExecuteInTransaction(ctx, work)
+}
 
-	var newFileId int = DBCreateFile("New file", "/path/word.txt", 0)
+// interleavingRepository calls beforeCreateFolder inside the transaction, right before the folder is created
+type interleavingRepository struct {
+	fsrepository.IFileSystemRepository
+	beforeCreateFolder func()
+}
 
-	fileIdToFileMap := GetDbFileMap()
-	folderIdToFolderMap := GetDbFolderMap()
+func (repo *interleavingRepository) ExecuteInTransaction(ctx context.Context, work func(repo fsrepository.IFileSystemRepository) error) error {
+	return repo.IFileSystemRepository.ExecuteInTransaction(ctx, func(txRepo fsrepository.IFileSystemRepository) error {
+		return work(&interleavingRepository{IFileSystemRepository: txRepo, beforeCreateFolder: repo.beforeCreateFolder})
+	})
+}
 
-	var createdFile *DBFile = fileIdToFileMap[newFileId]
-	assertEqual(t, createdFile.Id, newFileId)
-	assertEqual(t, createdFile.ParentId, 0)
+func (repo *interleavingRepository) CreateFolder(ctx context.Context, folderName string, parentID string) (*string, error) {
+	repo.beforeCreateFolder()
+	return repo.IFileSystemRepository.CreateFolder(ctx, folderName, parentID)
+}
 
-	var existingFolder *DBFolder = folderIdToFolderMap[1]
-	assertNotNil(t, existingFolder)
+// failingDeleteBlobStore fails every delete, as a blob store which is not reachable
+type failingDeleteBlobStore struct {
+	fsstorage.BlobStore
+}
 
-	DBMoveFile(newFileId, &existingFolder.Id)
-	assertEqual(t, createdFile.Id, newFileId)
-	assertEqual(t, createdFile.ParentId, existingFolder.Id)
+func (store failingDeleteBlobStore) Delete(key string) error {
+	return errors.New("unreachable blob store")
 }
 
 func assertEqual(t *testing.T, a interface{}, b interface{}) {
 	if a != b {
 		t.Log(string(debug.Stack()))
-		t.Fatalf("%s != %s", a, b)
+		t.Fatalf("%v != %v", a, b)
 	}
 }
 
-func assertNotNil(t *testing.T, a interface{}) {
-	if a == nil {
+func assertNoError(t *testing.T, err error) {
+	if err != nil {
 		t.Log(string(debug.Stack()))
-		t.Fatalf("%s == nil", a)
+		t.Fatalf("unexpected error: %v", err)
 	}
 }
 
-func assertNil(t *testing.T, a interface{}) {
-	if a == nil {
+func assertErrorCode(t *testing.T, err error, code string) {
+	if err == nil || errors.Cause(err).Error() != code {
 		t.Log(string(debug.Stack()))
-		t.Fatalf("%s != nil", a)
+		t.Fatalf("expected error %q, got %v", code, err)
 	}
 }
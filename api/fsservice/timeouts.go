@@ -0,0 +1,55 @@
+package fsservice
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/loisfa/remote-file-system/api/fsconfig"
+)
+
+const (
+	READ_TIMEOUT  = "READ_TIMEOUT"  // go duration, ex: 10s
+	WRITE_TIMEOUT = "WRITE_TIMEOUT" // go duration, ex: 30s
+
+	defaultReadTimeout  = 10 * time.Second
+	defaultWriteTimeout = 30 * time.Second
+)
+
+// Timeouts bound the time an operation of the service may take, on top of the deadline of the caller's context.
+// A zero timeout means no bound.
+type Timeouts struct {
+	Read  time.Duration
+	Write time.Duration
+}
+
+func NewTimeouts() Timeouts {
+	return Timeouts{
+		Read:  getTimeout(READ_TIMEOUT, defaultReadTimeout),
+		Write: getTimeout(WRITE_TIMEOUT, defaultWriteTimeout),
+	}
+}
+
+func getTimeout(envVar string, defaultTimeout time.Duration) time.Duration {
+	timeoutStr := fsconfig.GetEnvOrDefault(envVar, defaultTimeout.String())
+	timeout, err := time.ParseDuration(timeoutStr)
+	if err != nil || timeout < 0 {
+		panic(fmt.Sprintf("Invalid value '%s' for environment variable %s: expected a positive duration", timeoutStr, envVar))
+	}
+	return timeout
+}
+
+func (timeouts Timeouts) read(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withTimeout(ctx, timeouts.Read)
+}
+
+func (timeouts Timeouts) write(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withTimeout(ctx, timeouts.Write)
+}
+
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout == 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
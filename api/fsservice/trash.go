@@ -0,0 +1,127 @@
+package fsservice
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/loisfa/remote-file-system/api/fsmodel"
+	"github.com/loisfa/remote-file-system/api/fsrepository"
+	"github.com/pkg/errors"
+)
+
+// The deleted items are moved inside the trash folder, renamed by their id so that their names never conflict, and
+// recorded by a trash entry until they are restored or purged. The trash folder and what it holds are hidden: they
+// are not found outside of the trash operations.
+
+func (svc FileSystemService) GetTrashEntries(ctx context.Context) (*[]fsmodel.TrashEntry, error) {
+	ctx, cancel := svc.timeouts.read(ctx)
+	defer cancel()
+	return svc.repo.GetTrashEntries(ctx)
+}
+
+// RestoreFromTrash moves the item back inside the folder it was deleted from, or inside the root folder when that one
+// does not exist anymore or was trashed too. The item gets back its name, which may conflict with the items created
+// meanwhile: the policy resolves the conflict.
+func (svc FileSystemService) RestoreFromTrash(ctx context.Context, itemID string, policy ConflictPolicy) error {
+	ctx, cancel := svc.timeouts.write(ctx)
+	defer cancel()
+
+	err := svc.inTransaction(ctx, func(svc FileSystemService) error {
+		entry, err := svc.repo.DeleteTrashEntry(ctx, itemID)
+		if err != nil {
+			return withCodeIfItemNotFound(err, NotFound, fmt.Sprintf("Could not find item %s inside the trash.", itemID))
+		}
+
+		destFolderID := entry.ParentId
+		exists, err := svc.ExistsFolder(ctx, destFolderID)
+		if err != nil {
+			return err
+		}
+		if !*exists {
+			rootID, err := svc.repo.GetRootFolderID(ctx)
+			if err != nil {
+				return err
+			}
+			destFolderID = *rootID
+		}
+
+		item := namedItem{isFolder: entry.Kind == fsmodel.FolderKind, id: itemID}
+		return svc.writeWithConflictPolicy(ctx, destFolderID, entry.Name, item, policy, func(name string) error {
+			if item.isFolder {
+				return svc.repo.MoveFolder(ctx, itemID, destFolderID, name)
+			}
+			return svc.repo.MoveFile(ctx, itemID, destFolderID, name)
+		})
+	})
+	return err
+}
+
+// EmptyTrash deletes every trashed item for good, and returns how many were
+func (svc FileSystemService) EmptyTrash(ctx context.Context) (int, error) {
+	return svc.purgeTrash(ctx, func(entry fsmodel.TrashEntry) bool { return true })
+}
+
+// PurgeTrash deletes for good the items trashed before the time, and returns how many were
+func (svc FileSystemService) PurgeTrash(ctx context.Context, trashedBefore time.Time) (int, error) {
+	return svc.purgeTrash(ctx, func(entry fsmodel.TrashEntry) bool { return entry.TrashedAt.Before(trashedBefore) })
+}
+
+// purgeTrash purges each selected entry in its own transaction, so that a large trash does not hold its locks for
+// long. The entries restored or purged concurrently are skipped.
+func (svc FileSystemService) purgeTrash(ctx context.Context, selected func(fsmodel.TrashEntry) bool) (int, error) {
+	entries, err := svc.GetTrashEntries(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, entry := range *entries {
+		if !selected(entry) {
+			continue
+		}
+		if err := svc.purgeTrashEntry(ctx, entry.ItemId); err != nil {
+			if errors.Cause(err).Error() == fsrepository.ItemNotFound {
+				continue
+			}
+			return purged, err
+		}
+		purged++
+	}
+	if purged > 0 {
+		svc.purgeDeletedContentAfterDelete(ctx)
+	}
+	return purged, nil
+}
+
+func (svc FileSystemService) purgeTrashEntry(ctx context.Context, itemID string) error {
+	ctx, cancel := svc.timeouts.write(ctx)
+	defer cancel()
+
+	return svc.inTransaction(ctx, func(svc FileSystemService) error {
+		entry, err := svc.repo.DeleteTrashEntry(ctx, itemID)
+		if err != nil {
+			return err
+		}
+		if entry.Kind == fsmodel.FolderKind {
+			return svc.repo.DeleteFolderAndContent(ctx, itemID)
+		}
+		return svc.repo.DeleteFile(ctx, itemID)
+	})
+}
+
+// moveToTrash records the entry of the item, then moves the item inside the trash folder
+func (svc FileSystemService) moveToTrash(ctx context.Context, entry fsmodel.TrashEntry) error {
+	trashID, err := svc.repo.GetTrashFolderID(ctx)
+	if err != nil {
+		return err
+	}
+	if err := svc.repo.CreateTrashEntry(ctx, entry); err != nil {
+		return err
+	}
+
+	if entry.Kind == fsmodel.FolderKind {
+		return svc.repo.MoveFolder(ctx, entry.ItemId, *trashID, entry.ItemId)
+	}
+	return svc.repo.MoveFile(ctx, entry.ItemId, *trashID, entry.ItemId)
+}
@@ -0,0 +1,154 @@
+package fsservice
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/loisfa/remote-file-system/api/fsmodel"
+	"github.com/pkg/errors"
+)
+
+// The versions of a file are numbered from 1, the newest one being the current content of the file. A file whose
+// content was never replaced has no version recorded: its content is its version 1, recorded along with the second
+// one. Once a file has more versions than the limit, the oldest ones are deleted.
+
+// GetFileVersions lists the versions of the file, the newest first
+func (svc FileSystemService) GetFileVersions(ctx context.Context, fileID string) (*[]fsmodel.FileVersion, error) {
+	ctx, cancel := svc.timeouts.read(ctx)
+	defer cancel()
+
+	if err := svc.errorIfFileNotFound(ctx, fileID); err != nil {
+		return nil, err
+	}
+
+	file, err := svc.repo.GetFile(ctx, fileID)
+	if err != nil {
+		return nil, withCodeIfItemNotFound(err, NotFound, fmt.Sprintf("Could not find file %s.", fileID))
+	}
+	return svc.fileVersions(ctx, *file)
+}
+
+func (svc FileSystemService) GetFileVersion(ctx context.Context, fileID string, number int) (*fsmodel.FileVersion, error) {
+	versions, err := svc.GetFileVersions(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+	return findFileVersion(*versions, fileID, number)
+}
+
+// RestoreFileVersion makes the content of the version the current content of the file, as a new version: the versions
+// in between are kept. Restoring the current version changes nothing.
+func (svc FileSystemService) RestoreFileVersion(ctx context.Context, fileID string, number int) error {
+	versions, err := svc.GetFileVersions(ctx, fileID)
+	if err != nil {
+		return err
+	}
+	version, err := findFileVersion(*versions, fileID, number)
+	if err != nil {
+		return err
+	}
+	if (*versions)[0].Number == number {
+		return nil
+	}
+
+	ctx, cancel := svc.timeouts.write(ctx)
+	defer cancel()
+
+	var evictedBlobKeys []string
+	err = svc.referenceContent(ctx, version.FileContent, func(committedContent fsmodel.FileContent) error {
+		return svc.inTransaction(ctx, func(svc FileSystemService) error {
+			if err := svc.errorIfFileNotFound(ctx, fileID); err != nil {
+				return err
+			}
+			var err error
+			evictedBlobKeys, err = svc.addFileVersion(ctx, fileID, committedContent)
+			return err
+		})
+	})
+	if err == nil {
+		svc.purgeEvictedContent(ctx, evictedBlobKeys)
+	}
+	return err
+}
+
+// addFileVersion replaces the content of the file by a new version, then deletes the versions beyond the limit, whose
+// content keys it returns. To be called inside a transaction which checked the file exists.
+func (svc FileSystemService) addFileVersion(ctx context.Context, fileID string, content fsmodel.FileContent) ([]string, error) {
+	file, err := svc.repo.GetFile(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+	versions, err := svc.repo.GetFileVersions(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+	if len(*versions) == 0 {
+		firstVersion := fsmodel.FileVersion{Number: 1, FileContent: file.FileContent, CreatedAt: file.CreatedAt}
+		if err := svc.repo.CreateFileVersion(ctx, fileID, firstVersion); err != nil {
+			return nil, err
+		}
+		versions = &[]fsmodel.FileVersion{firstVersion}
+	}
+
+	if err := svc.repo.UpdateFileContent(ctx, fileID, content); err != nil {
+		return nil, err
+	}
+	updatedFile, err := svc.repo.GetFile(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+	newVersion := fsmodel.FileVersion{Number: (*versions)[0].Number + 1, FileContent: content, CreatedAt: updatedFile.ModifiedAt}
+	if err := svc.repo.CreateFileVersion(ctx, fileID, newVersion); err != nil {
+		return nil, err
+	}
+
+	evictedBlobKeys := make([]string, 0)
+	if svc.maxFileVersions <= 0 {
+		return evictedBlobKeys, nil
+	}
+	for idx := svc.maxFileVersions - 1; idx < len(*versions); idx++ {
+		if err := svc.repo.DeleteFileVersion(ctx, fileID, (*versions)[idx].Number); err != nil {
+			return nil, err
+		}
+		evictedBlobKeys = append(evictedBlobKeys, (*versions)[idx].BlobKey)
+	}
+	return evictedBlobKeys, nil
+}
+
+// fileVersions are the versions recorded for the file, or its content as version 1 when none is. The content type of
+// the current version is the one of the file, which can be changed without a new version.
+func (svc FileSystemService) fileVersions(ctx context.Context, file fsmodel.File) (*[]fsmodel.FileVersion, error) {
+	versions, err := svc.repo.GetFileVersions(ctx, file.Id)
+	if err != nil {
+		return nil, withCodeIfItemNotFound(err, NotFound, fmt.Sprintf("Could not find file %s.", file.Id))
+	}
+	if len(*versions) == 0 {
+		return &[]fsmodel.FileVersion{{Number: 1, FileContent: file.FileContent, CreatedAt: file.CreatedAt}}, nil
+	}
+	(*versions)[0].ContentType = file.ContentType
+	return versions, nil
+}
+
+func findFileVersion(versions []fsmodel.FileVersion, fileID string, number int) (*fsmodel.FileVersion, error) {
+	for _, version := range versions {
+		if version.Number == number {
+			return &version, nil
+		}
+	}
+	return nil, errors.WithMessage(errors.New(NotFound), fmt.Sprintf("Could not find version %d of file %s.", number, fileID))
+}
+
+// fileNamed is the file of that name inside the folder, nil when there is none. It only reads the children whose name
+// starts so, not the whole folder.
+func (svc FileSystemService) fileNamed(ctx context.Context, folderID string, name string) (*fsmodel.File, error) {
+	children, err := svc.repo.GetChildrenWithNamePrefix(ctx, folderID, name)
+	if err != nil {
+		return nil, err
+	}
+	for _, child := range *children {
+		if child.Kind == fsmodel.FileKind && child.Name == name {
+			return svc.repo.GetFile(ctx, child.Id)
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,82 @@
+package fsstorage
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/loisfa/remote-file-system/api/fsconfig"
+)
+
+const (
+	BlobNotFound   = "Blob not found"
+	InvalidBlobKey = "Invalid blob key"
+
+	BLOB_STORE_DIR = "BLOB_STORE_DIR"
+
+	defaultBlobStoreDir = "tmp-files"
+)
+
+// keys are opaque for the callers, but they end up as file names in the local store:
+// restrict them so that they can never escape the storage root
+var blobKeyRegexp = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]*$`)
+
+// BlobStore holds the content of the files. The file system tree only references the content by its key,
+// so the storage root (or the storage backend itself) can change without rewriting the tree.
+type BlobStore interface {
+	Put(key string, content io.Reader) (*BlobInfo, error) // streams the content, replaces any existing blob with the same key. Computes the SHA-256 on the fly.
+	Get(key string) (Blob, error)                         // the caller must close the returned blob
+	Stat(key string) (*BlobInfo, error)
+	Rename(key string, newKey string) error // replaces any existing blob with the new key
+	Delete(key string) error
+	List() ([]BlobInfo, error) // every blob, in no particular order
+	// PurgeTemporaryFiles deletes the temporary files of the writes last written before the time, which a crash left
+	// behind. The stores without temporary files purge none.
+	PurgeTemporaryFiles(before time.Time) (int, error)
+}
+
+// Blob is the content of a stored blob. It is seekable so that it can be served with range requests.
+type Blob interface {
+	io.ReadSeeker
+	io.Closer
+}
+
+type BlobInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+	SHA256  string // hex encoded, only filled by Put since it requires reading the whole content
+}
+
+// NewBlobKey generates a new random key. The extension of the file name is kept to ease debugging.
+func NewBlobKey(fileName string) string {
+	randomBytes := make([]byte, 16)
+	if _, err := rand.Read(randomBytes); err != nil {
+		panic(err)
+	}
+
+	key := fmt.Sprintf("upload-%s", hex.EncodeToString(randomBytes))
+	ext := strings.TrimPrefix(filepath.Ext(fileName), ".")
+	if ext != "" && blobKeyRegexp.MatchString(ext) {
+		key = fmt.Sprintf("%s.%s", key, ext)
+	}
+	return key
+}
+
+func IsValidBlobKey(key string) bool {
+	return blobKeyRegexp.MatchString(key)
+}
+
+// NewBlobStore returns the blob store configured through the environment variables
+func NewBlobStore() BlobStore {
+	store, err := NewLocalBlobStore(fsconfig.GetEnvOrDefault(BLOB_STORE_DIR, defaultBlobStoreDir))
+	if err != nil {
+		panic(err)
+	}
+	return store
+}
@@ -0,0 +1,60 @@
+package fsstorage
+
+import (
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const contentKeyPrefix = "sha256-"
+
+// ContentKey is the key under which a content is stored once, whatever the number of files sharing it
+func ContentKey(sha256Hex string) string {
+	return contentKeyPrefix + sha256Hex
+}
+
+func IsContentKey(key string) bool {
+	return strings.HasPrefix(key, contentKeyPrefix)
+}
+
+// StageContent streams the content into a temporary blob, since its digest is only known at the end.
+// The staged blob then has to be committed with CommitContent.
+func StageContent(store BlobStore, content io.Reader) (*BlobInfo, error) {
+	return store.Put(NewBlobKey(""), content)
+}
+
+// CommitContent moves a staged blob to its content key. If the same content is already stored, the staged blob is
+// dropped. Returns whether the content key was created.
+// The caller must make sure the content key is not deleted concurrently.
+func CommitContent(store BlobStore, stagedKey string, contentKey string) (bool, error) {
+	_, err := store.Stat(contentKey)
+	if err != nil && errors.Cause(err).Error() != BlobNotFound {
+		return false, err
+	}
+
+	if err == nil {
+		return false, store.Delete(stagedKey)
+	}
+	if err := store.Rename(stagedKey, contentKey); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// PutContentAddressed stores the content under the key derived from its SHA-256
+func PutContentAddressed(store BlobStore, content io.Reader) (*BlobInfo, error) {
+	info, err := StageContent(store, content)
+	if err != nil {
+		return nil, err
+	}
+
+	contentKey := ContentKey(info.SHA256)
+	if _, err := CommitContent(store, info.Key, contentKey); err != nil {
+		store.Delete(info.Key)
+		return nil, err
+	}
+
+	info.Key = contentKey
+	return info, nil
+}
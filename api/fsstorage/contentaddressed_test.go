@@ -0,0 +1,39 @@
+package fsstorage
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestPutContentAddressedStoresIdenticalContentOnce(t *testing.T) {
+	store := newTestLocalBlobStore(t)
+
+	first, err := PutContentAddressed(store, strings.NewReader("vendor bundle"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := PutContentAddressed(store, strings.NewReader("vendor bundle"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := PutContentAddressed(store, strings.NewReader("another bundle"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first.Key != second.Key || first.Key != ContentKey(first.SHA256) || !IsContentKey(first.Key) {
+		t.Fatalf("identical content should share the key %s, got %s", first.Key, second.Key)
+	}
+	if other.Key == first.Key {
+		t.Fatalf("different content should not share a key")
+	}
+
+	entries, err := ioutil.ReadDir(store.rootDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 stored blobs, got %d", len(entries))
+	}
+}
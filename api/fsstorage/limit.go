@@ -0,0 +1,39 @@
+package fsstorage
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+const BlobTooLarge = "Blob too large"
+
+type sizeLimitedReader struct {
+	reader    io.Reader
+	remaining int64
+	maxSize   int64
+}
+
+// NewSizeLimitedReader fails with BlobTooLarge as soon as more than maxSize bytes are read,
+// contrary to io.LimitReader which silently truncates the content
+func NewSizeLimitedReader(reader io.Reader, maxSize int64) io.Reader {
+	return &sizeLimitedReader{reader: reader, remaining: maxSize, maxSize: maxSize}
+}
+
+func (r *sizeLimitedReader) Read(p []byte) (int, error) {
+	if r.remaining < 0 {
+		return 0, errors.WithMessage(errors.New(BlobTooLarge), fmt.Sprintf("The content exceeds %d bytes", r.maxSize))
+	}
+
+	// read one byte more than allowed to detect the overflow
+	if int64(len(p)) > r.remaining+1 {
+		p = p[:r.remaining+1]
+	}
+	n, err := r.reader.Read(p)
+	r.remaining -= int64(n)
+	if r.remaining < 0 {
+		return n, errors.WithMessage(errors.New(BlobTooLarge), fmt.Sprintf("The content exceeds %d bytes", r.maxSize))
+	}
+	return n, err
+}
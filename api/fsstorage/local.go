@@ -0,0 +1,180 @@
+package fsstorage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// tmpFilePrefix starts the names of the temporary files, which no blob key can start with
+const tmpFilePrefix = ".put-"
+
+// LocalBlobStore stores each blob as a single file inside a root directory
+type LocalBlobStore struct {
+	rootDir string
+}
+
+func NewLocalBlobStore(rootDir string) (LocalBlobStore, error) {
+	if err := os.MkdirAll(rootDir, 0755); err != nil {
+		return LocalBlobStore{}, errors.Wrapf(err, "Could not create the blob store root directory %s", rootDir)
+	}
+	return LocalBlobStore{rootDir: rootDir}, nil
+}
+
+func (store LocalBlobStore) Put(key string, content io.Reader) (*BlobInfo, error) {
+	path, err := store.pathOf(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// write into a temporary file first so that a failed upload never leaves a truncated blob behind
+	tmpFile, err := ioutil.TempFile(store.rootDir, tmpFilePrefix+"*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmpFile.Name()) // no-op once renamed
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmpFile, hash), content); err != nil {
+		tmpFile.Close()
+		return nil, err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return nil, err
+	}
+
+	if err := os.Rename(tmpFile.Name(), path); err != nil {
+		return nil, err
+	}
+
+	info, err := store.Stat(key)
+	if err != nil {
+		return nil, err
+	}
+	info.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	return info, nil
+}
+
+func (store LocalBlobStore) Get(key string) (Blob, error) {
+	path, err := store.pathOf(key)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, errors.WithMessage(errors.New(BlobNotFound), fmt.Sprintf("No blob with key %s", key))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func (store LocalBlobStore) Stat(key string) (*BlobInfo, error) {
+	path, err := store.pathOf(key)
+	if err != nil {
+		return nil, err
+	}
+
+	fileInfo, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil, errors.WithMessage(errors.New(BlobNotFound), fmt.Sprintf("No blob with key %s", key))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &BlobInfo{
+		Key:     key,
+		Size:    fileInfo.Size(),
+		ModTime: fileInfo.ModTime(),
+	}, nil
+}
+
+func (store LocalBlobStore) Rename(key string, newKey string) error {
+	path, err := store.pathOf(key)
+	if err != nil {
+		return err
+	}
+	newPath, err := store.pathOf(newKey)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(path, newPath)
+	if os.IsNotExist(err) {
+		return errors.WithMessage(errors.New(BlobNotFound), fmt.Sprintf("No blob with key %s", key))
+	}
+	return err
+}
+
+// Delete does not fail when the blob does not exist, so that deletions can be retried safely
+func (store LocalBlobStore) Delete(key string) error {
+	path, err := store.pathOf(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (store LocalBlobStore) List() ([]BlobInfo, error) {
+	fileInfos, err := ioutil.ReadDir(store.rootDir)
+	if err != nil {
+		return nil, err
+	}
+
+	blobs := make([]BlobInfo, 0, len(fileInfos))
+	for _, fileInfo := range fileInfos {
+		if fileInfo.IsDir() || strings.HasPrefix(fileInfo.Name(), tmpFilePrefix) || !IsValidBlobKey(fileInfo.Name()) {
+			continue
+		}
+		blobs = append(blobs, BlobInfo{Key: fileInfo.Name(), Size: fileInfo.Size(), ModTime: fileInfo.ModTime()})
+	}
+	return blobs, nil
+}
+
+func (store LocalBlobStore) PurgeTemporaryFiles(before time.Time) (int, error) {
+	paths, err := filepath.Glob(filepath.Join(store.rootDir, tmpFilePrefix+"*"))
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, path := range paths {
+		fileInfo, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			continue // renamed or removed by its write meanwhile
+		}
+		if err != nil {
+			return purged, err
+		}
+		if !fileInfo.ModTime().Before(before) {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+func (store LocalBlobStore) pathOf(key string) (string, error) {
+	if !IsValidBlobKey(key) {
+		return "", errors.WithMessage(errors.New(InvalidBlobKey), fmt.Sprintf("Blob key %q is not valid", key))
+	}
+	return filepath.Join(store.rootDir, key), nil
+}
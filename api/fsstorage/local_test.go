@@ -0,0 +1,157 @@
+package fsstorage
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func newTestLocalBlobStore(t *testing.T) LocalBlobStore {
+	dir, err := ioutil.TempDir("", "blobstore-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	store, err := NewLocalBlobStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestLocalBlobStorePutGetDelete(t *testing.T) {
+	store := newTestLocalBlobStore(t)
+	key := NewBlobKey("report.txt")
+
+	info, err := store.Put(key, strings.NewReader("some content"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != int64(len("some content")) {
+		t.Fatalf("wrong blob size %d", info.Size)
+	}
+
+	blob, err := store.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadAll(blob)
+	blob.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "some content" {
+		t.Fatalf("wrong blob content %q", content)
+	}
+
+	if err := store.Delete(key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Stat(key); err == nil || errors.Cause(err).Error() != BlobNotFound {
+		t.Fatalf("expected %q after delete, got %v", BlobNotFound, err)
+	}
+	// deleting twice is not an error
+	if err := store.Delete(key); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLocalBlobStoreRejectsKeysOutsideRoot(t *testing.T) {
+	store := newTestLocalBlobStore(t)
+
+	for _, key := range []string{"", "../escape", "sub/dir", ".hidden"} {
+		if _, err := store.Put(key, strings.NewReader("x")); err == nil || errors.Cause(err).Error() != InvalidBlobKey {
+			t.Fatalf("expected key %q to be rejected, got %v", key, err)
+		}
+	}
+}
+
+func TestNewBlobKeyKeepsExtension(t *testing.T) {
+	key := NewBlobKey("archive.tar.gz")
+	if !strings.HasSuffix(key, ".gz") || !IsValidBlobKey(key) {
+		t.Fatalf("unexpected key %q", key)
+	}
+	if NewBlobKey("archive.tar.gz") == key {
+		t.Fatalf("keys must be unique")
+	}
+}
+
+func TestLocalBlobStorePutComputesSHA256(t *testing.T) {
+	store := newTestLocalBlobStore(t)
+
+	info, err := store.Put(NewBlobKey("hello.txt"), strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.SHA256 != "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824" {
+		t.Fatalf("wrong sha256 %s", info.SHA256)
+	}
+}
+
+func TestLocalBlobStoreListAndPurgeTemporaryFiles(t *testing.T) {
+	store := newTestLocalBlobStore(t)
+	key := NewBlobKey("report.txt")
+	if _, err := store.Put(key, strings.NewReader("some content")); err != nil {
+		t.Fatal(err)
+	}
+	// the temporary file of a write interrupted by a crash
+	tmpFile, err := ioutil.TempFile(store.rootDir, tmpFilePrefix+"*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpFile.Close()
+
+	blobs, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blobs) != 1 || blobs[0].Key != key || blobs[0].Size != int64(len("some content")) {
+		t.Fatalf("expected only the blob %s, got %v", key, blobs)
+	}
+
+	purged, err := store.PurgeTemporaryFiles(time.Now().Add(-time.Hour))
+	if err != nil || purged != 0 {
+		t.Fatalf("the recent temporary file should be kept, purged %d: %v", purged, err)
+	}
+	purged, err = store.PurgeTemporaryFiles(time.Now().Add(time.Hour))
+	if err != nil || purged != 1 {
+		t.Fatalf("the temporary file should be purged, purged %d: %v", purged, err)
+	}
+	if _, err := os.Stat(tmpFile.Name()); !os.IsNotExist(err) {
+		t.Fatalf("the temporary file was left behind: %v", err)
+	}
+	if _, err := store.Stat(key); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLocalBlobStorePutAbortsWhenTooLarge(t *testing.T) {
+	store := newTestLocalBlobStore(t)
+	key := NewBlobKey("big.bin")
+
+	_, err := store.Put(key, NewSizeLimitedReader(strings.NewReader("0123456789"), 9))
+	if err == nil || errors.Cause(err).Error() != BlobTooLarge {
+		t.Fatalf("expected %q, got %v", BlobTooLarge, err)
+	}
+	if _, err := store.Stat(key); err == nil || errors.Cause(err).Error() != BlobNotFound {
+		t.Fatalf("no blob should be left behind, got %v", err)
+	}
+
+	// exactly at the limit is accepted
+	if _, err := store.Put(key, NewSizeLimitedReader(strings.NewReader("0123456789"), 10)); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := ioutil.ReadDir(store.rootDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("temporary files were left behind: %d entries", len(entries))
+	}
+}
@@ -0,0 +1,19 @@
+package fsstorage
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// KeyLocks are striped locks on keys: the operations on the same key are serialized without keeping one lock per key
+// forever. The keys of the same stripe share their lock. The zero value is ready to use.
+type KeyLocks struct {
+	stripes [64]sync.Mutex
+}
+
+// Of returns the lock of the key
+func (locks *KeyLocks) Of(key string) *sync.Mutex {
+	hash := fnv.New32a()
+	hash.Write([]byte(key))
+	return &locks.stripes[hash.Sum32()%uint32(len(locks.stripes))]
+}
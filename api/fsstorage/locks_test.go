@@ -0,0 +1,10 @@
+package fsstorage
+
+import "testing"
+
+func TestKeyLocksOfSameKey(t *testing.T) {
+	var locks KeyLocks
+	if locks.Of("sha256-abc") != locks.Of("sha256-abc") {
+		t.Fatal("expected the same lock for the same key")
+	}
+}
@@ -0,0 +1,396 @@
+package fsupload
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/loisfa/remote-file-system/api/fsconfig"
+	"github.com/loisfa/remote-file-system/api/fsstorage"
+	"github.com/pkg/errors"
+)
+
+const (
+	UploadNotFound       = "Upload not found"
+	UploadOffsetMismatch = "Upload offset mismatch"
+	UploadTooLarge       = "Upload too large"
+	UploadCompleted      = "Upload already completed"
+	UploadIncomplete     = "Upload incomplete"
+
+	UPLOAD_DIR        = "UPLOAD_DIR"
+	UPLOAD_EXPIRATION = "UPLOAD_EXPIRATION" // go duration, ex: 24h
+
+	defaultUploadDir        = "tmp-uploads"
+	defaultUploadExpiration = 24 * time.Hour
+
+	infoExtension = ".info"
+	dataExtension = ".bin"
+)
+
+var uploadIDRegexp = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// Upload is a resumable upload in progress. Its state lives on disk so that it survives a restart of the API:
+// the metadata in a json file, the content received so far in a data file whose size is the current offset.
+type Upload struct {
+	Id           string `json:"id"`
+	Length       int64  `json:"length"`
+	Offset       int64  `json:"-"` // always computed from the data file
+	FileName     string `json:"fileName"`
+	DestFolderId string `json:"destFolderId"`
+	// what to do when the file name is already used inside the destination folder
+	ConflictPolicy string    `json:"conflictPolicy,omitempty"`
+	CreatedAt      time.Time `json:"createdAt"`
+	ExpiresAt      time.Time `json:"expiresAt"`
+	FileId         *string   `json:"fileId,omitempty"` // set once the upload has been turned into a file
+}
+
+// UnmarshalJSON also reads the uploads started before the ids became opaque, whose folder and file ids are numbers:
+// they are read as their decimal string, the legacy id the API still resolves
+func (upload *Upload) UnmarshalJSON(data []byte) error {
+	type plainUpload Upload
+	var stored struct {
+		plainUpload
+		DestFolderId storedID  `json:"destFolderId"`
+		FileId       *storedID `json:"fileId,omitempty"`
+	}
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return err
+	}
+
+	*upload = Upload(stored.plainUpload)
+	upload.DestFolderId = string(stored.DestFolderId)
+	if stored.FileId != nil {
+		fileID := string(*stored.FileId)
+		upload.FileId = &fileID
+	}
+	return nil
+}
+
+// storedID is an id recorded either as a string or, before the ids became opaque, as a number
+type storedID string
+
+func (id *storedID) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		return json.Unmarshal(data, (*string)(id))
+	}
+	var number json.Number
+	if err := json.Unmarshal(data, &number); err != nil {
+		return err
+	}
+	*id = storedID(number.String())
+	return nil
+}
+
+func (upload Upload) IsComplete() bool {
+	return upload.Offset == upload.Length
+}
+
+type UploadStore struct {
+	dir        string
+	expiration time.Duration
+
+	// serialize the operations on the same upload
+	locks fsstorage.KeyLocks
+}
+
+// NewUploadStore returns the upload store configured through the environment variables
+func NewUploadStore() *UploadStore {
+	dir := fsconfig.GetEnvOrDefault(UPLOAD_DIR, defaultUploadDir)
+
+	expirationStr := fsconfig.GetEnvOrDefault(UPLOAD_EXPIRATION, defaultUploadExpiration.String())
+	expiration, err := time.ParseDuration(expirationStr)
+	if err != nil || expiration <= 0 {
+		panic(fmt.Sprintf("Invalid value '%s' for environment variable %s: expected a positive duration", expirationStr, UPLOAD_EXPIRATION))
+	}
+
+	store, err := NewLocalUploadStore(dir, expiration)
+	if err != nil {
+		panic(err)
+	}
+	return store
+}
+
+func NewLocalUploadStore(dir string, expiration time.Duration) (*UploadStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrapf(err, "Could not create the upload directory %s", dir)
+	}
+	return &UploadStore{
+		dir:        dir,
+		expiration: expiration,
+	}, nil
+}
+
+func (store *UploadStore) Expiration() time.Duration {
+	return store.expiration
+}
+
+func (store *UploadStore) Create(length int64, fileName string, destFolderID string, conflictPolicy string) (*Upload, error) {
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	upload := Upload{
+		Id:             hex.EncodeToString(idBytes),
+		Length:         length,
+		FileName:       fileName,
+		DestFolderId:   destFolderID,
+		ConflictPolicy: conflictPolicy,
+		CreatedAt:      now,
+		ExpiresAt:      now.Add(store.expiration),
+	}
+
+	dataFile, err := os.OpenFile(store.dataPath(upload.Id), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	dataFile.Close()
+
+	if err := store.writeInfo(upload); err != nil {
+		os.Remove(store.dataPath(upload.Id))
+		return nil, err
+	}
+	return &upload, nil
+}
+
+// Get fails with UploadNotFound when the upload does not exist or has expired
+func (store *UploadStore) Get(uploadID string) (*Upload, error) {
+	lock := store.locks.Of(uploadID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	return store.get(uploadID)
+}
+
+// WriteChunk appends the chunk at the given offset, which must be the current offset of the upload.
+// What has been received is kept even if the chunk is interrupted, so that the client can resume from there.
+func (store *UploadStore) WriteChunk(uploadID string, offset int64, chunk io.Reader) (*Upload, error) {
+	lock := store.locks.Of(uploadID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	upload, err := store.get(uploadID)
+	if err != nil {
+		return nil, err
+	}
+	if upload.FileId != nil {
+		return nil, errors.WithMessage(errors.New(UploadCompleted), fmt.Sprintf("Upload %s was already turned into file %s", uploadID, *upload.FileId))
+	}
+	if offset != upload.Offset {
+		return nil, errors.WithMessage(
+			errors.New(UploadOffsetMismatch),
+			fmt.Sprintf("Upload %s is at offset %d, received a chunk for offset %d", uploadID, upload.Offset, offset))
+	}
+
+	dataFile, err := os.OpenFile(store.dataPath(uploadID), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer dataFile.Close()
+
+	written, copyErr := io.CopyN(dataFile, chunk, upload.Length-upload.Offset)
+	if copyErr == io.EOF {
+		copyErr = nil // the chunk was smaller than the remaining length
+	}
+	if copyErr == nil && written == upload.Length-upload.Offset {
+		// the whole length was received, anything else in the chunk is too much
+		if n, _ := chunk.Read(make([]byte, 1)); n > 0 {
+			copyErr = errors.WithMessage(
+				errors.New(UploadTooLarge),
+				fmt.Sprintf("Upload %s received more than its declared length of %d bytes", uploadID, upload.Length))
+		}
+	}
+	if err := dataFile.Sync(); err != nil && copyErr == nil {
+		copyErr = err
+	}
+
+	upload.Offset += written
+	upload.ExpiresAt = time.Now().UTC().Add(store.expiration)
+	if err := store.writeInfo(*upload); err != nil && copyErr == nil {
+		copyErr = err
+	}
+
+	if copyErr != nil {
+		return upload, copyErr
+	}
+	return upload, nil
+}
+
+// Complete turns a fully received upload into a file, exactly once: createFile receives the content while the upload
+// is locked, and the id of the created file is recorded. The upload is kept until it expires, without its content,
+// so that a client which lost the response can still find out the upload succeeded.
+func (store *UploadStore) Complete(uploadID string, createFile func(upload Upload, content io.Reader) (string, error)) (*Upload, error) {
+	lock := store.locks.Of(uploadID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	upload, err := store.get(uploadID)
+	if err != nil {
+		return nil, err
+	}
+	if upload.FileId != nil {
+		return upload, nil
+	}
+	if !upload.IsComplete() {
+		return nil, errors.WithMessage(
+			errors.New(UploadIncomplete),
+			fmt.Sprintf("Upload %s only received %d bytes out of %d", uploadID, upload.Offset, upload.Length))
+	}
+
+	dataFile, err := os.Open(store.dataPath(uploadID))
+	if err != nil {
+		return nil, err
+	}
+	fileID, err := createFile(*upload, dataFile)
+	dataFile.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	upload.FileId = &fileID
+	if err := store.writeInfo(*upload); err != nil {
+		return nil, err
+	}
+	if err := os.Remove(store.dataPath(uploadID)); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return upload, nil
+}
+
+func (store *UploadStore) Delete(uploadID string) error {
+	lock := store.locks.Of(uploadID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if _, err := store.get(uploadID); err != nil {
+		return err
+	}
+	return store.remove(uploadID)
+}
+
+// PurgeExpired removes the expired uploads and returns how many were removed
+func (store *UploadStore) PurgeExpired() (int, error) {
+	entries, err := ioutil.ReadDir(store.dir)
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), infoExtension) {
+			continue
+		}
+		uploadID := strings.TrimSuffix(entry.Name(), infoExtension)
+
+		lock := store.locks.Of(uploadID)
+		lock.Lock()
+		upload, err := store.readInfo(uploadID)
+		if err == nil && time.Now().After(upload.ExpiresAt) {
+			if err = store.remove(uploadID); err == nil {
+				purged++
+			}
+		}
+		lock.Unlock()
+
+		if err != nil {
+			return purged, err
+		}
+	}
+	return purged, nil
+}
+
+func (store *UploadStore) get(uploadID string) (*Upload, error) {
+	if !uploadIDRegexp.MatchString(uploadID) {
+		return nil, notFoundError(uploadID)
+	}
+
+	upload, err := store.readInfo(uploadID)
+	if os.IsNotExist(errors.Cause(err)) {
+		return nil, notFoundError(uploadID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(upload.ExpiresAt) {
+		return nil, errors.WithMessage(errors.New(UploadNotFound), fmt.Sprintf("Upload %s has expired", uploadID))
+	}
+
+	if upload.FileId != nil {
+		upload.Offset = upload.Length
+		return upload, nil
+	}
+
+	dataInfo, err := os.Stat(store.dataPath(uploadID))
+	if err != nil {
+		return nil, err
+	}
+	upload.Offset = dataInfo.Size()
+	return upload, nil
+}
+
+func (store *UploadStore) readInfo(uploadID string) (*Upload, error) {
+	infoBytes, err := ioutil.ReadFile(store.infoPath(uploadID))
+	if err != nil {
+		return nil, err
+	}
+
+	var upload Upload
+	if err := json.Unmarshal(infoBytes, &upload); err != nil {
+		return nil, errors.Wrapf(err, "Could not read the state of upload %s", uploadID)
+	}
+	return &upload, nil
+}
+
+// writeInfo replaces the info file atomically: a crash never leaves a half written state behind
+func (store *UploadStore) writeInfo(upload Upload) error {
+	infoBytes, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+
+	tmpFile, err := ioutil.TempFile(store.dir, ".info-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name()) // no-op once renamed
+
+	if _, err := tmpFile.Write(infoBytes); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile.Name(), store.infoPath(upload.Id))
+}
+
+func (store *UploadStore) remove(uploadID string) error {
+	if err := os.Remove(store.dataPath(uploadID)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Remove(store.infoPath(uploadID)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (store *UploadStore) infoPath(uploadID string) string {
+	return filepath.Join(store.dir, uploadID+infoExtension)
+}
+
+func (store *UploadStore) dataPath(uploadID string) string {
+	return filepath.Join(store.dir, uploadID+dataExtension)
+}
+
+func notFoundError(uploadID string) error {
+	return errors.WithMessage(errors.New(UploadNotFound), fmt.Sprintf("No upload with id %s", uploadID))
+}
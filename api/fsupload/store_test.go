@@ -0,0 +1,172 @@
+package fsupload
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func newTestUploadStore(t *testing.T, expiration time.Duration) (*UploadStore, string) {
+	dir, err := ioutil.TempDir("", "uploadstore-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	store, err := NewLocalUploadStore(dir, expiration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store, dir
+}
+
+func assertErrorCode(t *testing.T, err error, code string) {
+	t.Helper()
+	if err == nil || errors.Cause(err).Error() != code {
+		t.Fatalf("expected error %q, got %v", code, err)
+	}
+}
+
+func TestUploadResumesAfterRestart(t *testing.T) {
+	store, dir := newTestUploadStore(t, time.Hour)
+
+	upload, err := store.Create(10, "report.txt", "folder-3", "rename")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.WriteChunk(upload.Id, 0, strings.NewReader("01234")); err != nil {
+		t.Fatal(err)
+	}
+
+	// a new store on the same directory behaves like a restarted API
+	restartedStore, err := NewLocalUploadStore(dir, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resumed, err := restartedStore.Get(upload.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resumed.Offset != 5 || resumed.FileName != "report.txt" || resumed.DestFolderId != "folder-3" || resumed.ConflictPolicy != "rename" {
+		t.Fatalf("unexpected upload state after restart %+v", resumed)
+	}
+
+	_, err = restartedStore.WriteChunk(upload.Id, 3, strings.NewReader("34567"))
+	assertErrorCode(t, err, UploadOffsetMismatch)
+
+	completed, err := restartedStore.WriteChunk(upload.Id, 5, strings.NewReader("56789"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !completed.IsComplete() {
+		t.Fatalf("upload should be complete %+v", completed)
+	}
+
+	var receivedContent string
+	for i := 0; i < 2; i++ {
+		// completing twice only creates the file once
+		completed, err = restartedStore.Complete(upload.Id, func(upload Upload, content io.Reader) (string, error) {
+			if receivedContent != "" {
+				t.Fatalf("the file was created twice")
+			}
+			contentBytes, err := ioutil.ReadAll(content)
+			receivedContent = string(contentBytes)
+			return "file-42", err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if receivedContent != "0123456789" || completed.FileId == nil || *completed.FileId != "file-42" {
+		t.Fatalf("unexpected completion %q %+v", receivedContent, completed)
+	}
+
+	_, err = restartedStore.WriteChunk(upload.Id, 10, strings.NewReader(""))
+	assertErrorCode(t, err, UploadCompleted)
+}
+
+func TestUploadRejectsMoreThanItsLength(t *testing.T) {
+	store, _ := newTestUploadStore(t, time.Hour)
+
+	upload, err := store.Create(4, "small.bin", "folder-0", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	written, err := store.WriteChunk(upload.Id, 0, strings.NewReader("0123456789"))
+	assertErrorCode(t, err, UploadTooLarge)
+	if written.Offset != 4 {
+		t.Fatalf("the content up to the declared length should be kept, offset %d", written.Offset)
+	}
+
+	_, err = store.Complete(upload.Id, func(upload Upload, content io.Reader) (string, error) { return "file-1", nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIncompleteUploadCannotBeCompleted(t *testing.T) {
+	store, _ := newTestUploadStore(t, time.Hour)
+
+	upload, err := store.Create(4, "small.bin", "folder-0", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.Complete(upload.Id, func(upload Upload, content io.Reader) (string, error) { return "file-1", nil })
+	assertErrorCode(t, err, UploadIncomplete)
+}
+
+func TestExpiredUploadsArePurged(t *testing.T) {
+	store, dir := newTestUploadStore(t, time.Millisecond)
+
+	upload, err := store.Create(4, "small.bin", "folder-0", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	_, err = store.Get(upload.Id)
+	assertErrorCode(t, err, UploadNotFound)
+
+	purged, err := store.PurgeExpired()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged != 1 {
+		t.Fatalf("expected 1 purged upload, got %d", purged)
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("expected an empty upload directory, got %d entries", len(entries))
+	}
+}
+
+func TestUploadWithLegacyIDsIsRead(t *testing.T) {
+	store, dir := newTestUploadStore(t, time.Hour)
+
+	// the state of an upload started before the ids became opaque
+	uploadID := "0123456789abcdef0123456789abcdef"
+	info := `{"id":"` + uploadID + `","length":4,"fileName":"old.bin","destFolderId":3,"createdAt":"2020-01-01T00:00:00Z",` +
+		`"expiresAt":"2999-01-01T00:00:00Z","fileId":42}`
+	if err := ioutil.WriteFile(filepath.Join(dir, uploadID+infoExtension), []byte(info), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	upload, err := store.Get(uploadID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upload.DestFolderId != "3" || upload.FileId == nil || *upload.FileId != "42" {
+		t.Fatalf("unexpected legacy upload state %+v", upload)
+	}
+}
@@ -9,9 +9,13 @@ replace github.com/loisfa/remote-file-system/api => ./
 replace github.com/loisfa/remote-file-system => ./../
 
 require (
+	github.com/google/uuid v1.5.0
 	github.com/gorilla/handlers v1.5.1
 	github.com/gorilla/mux v1.8.0
+	github.com/lib/pq v1.10.9
 	github.com/neo4j/neo4j-go-driver v1.8.3
 	github.com/neo4j/neo4j-go-driver/v4 v4.2.3
 	github.com/pkg/errors v0.9.1
+	go.etcd.io/bbolt v1.3.6
+	modernc.org/sqlite v1.14.1
 )
@@ -1,3 +1,6 @@
+// NOT NEEDED ANYMORE: the API sets up the database on startup, see the migrations of fsrepository/neo4jschema.go.
+// Kept as a reference of what the first migration does.
+
 // Scripts to be executed to initialize the database
 // From a bash run: `cypher-shell -a <ADDRESS>:<PORT> -u <USER> -p <PASSWORD> -f <SCRIPT_FILE_PATH>`
 
@@ -14,6 +17,9 @@ ASSERT folder.id IS UNIQUE;
 // Uniqueness of the root folder
 CREATE CONSTRAINT constraint_unique_is_root ON (folder:Folder) ASSERT folder.is_root IS UNIQUE;
 
+// Uniqueness of the orphan blobs: content of deleted files, waiting to be deleted from the blob store
+CREATE CONSTRAINT unique_orphan_blob_key ON (blob:OrphanBlob) ASSERT blob.blob_key IS UNIQUE;
+
 // Create the root folder, this should maybe be done at runtime
 CREATE (f:Folder  {id:0, is_root: true, name: 'Root folder'});
 
@@ -1,11 +1,11 @@
 import os
 import requests
 import json
-import time
-import math
 import cgi
-import sys
-from model.dto import CreateFolderDTO, UpdateFolderDTO
+import atexit
+import shutil
+import tempfile
+from model.dto import CreateFolderDTO, UpdateFolderDTO, UpdateFileDTO
 
 # TODO think of using env variables
 PORT=8080
@@ -28,13 +28,18 @@ body = json.loads(response.text)
 root_folder_id = body['currentFolder']['id']
 assert root_folder_id is not None, "root folder id is null"
 
+# Ensures the integer id the root folder had before the ids became opaque still designates it
+response = session.get(ROOT_URL + "/folders/0")
+assert response.status_code == 200, "Wrong http code received on retrieve root folder by its legacy id: " + str(response.status_code)
+assert json.loads(response.text)['currentFolder']['id'] == root_folder_id, "The legacy id 0 is not the root folder"
+
 # Ensures GET on non-existing folder returns 404 
 response = session.get(ROOT_URL + "/folders/123456")
 assert response.status_code, "Wrong http code received on retrieve non-existing folder: " + str(response.status_code)
 
 ### CREATE FOLDER
 # Create a folder inside the root folder
-to_create_folder = CreateFolderDTO("Folder 1", int(root_folder_id))
+to_create_folder = CreateFolderDTO("Folder 1", root_folder_id)
 response = session.post(ROOT_URL + "/folders", to_create_folder.toJson())
 assert response.status_code == 201, "Wrong http code received on create new folder in root folder: " + str(response.status_code)
 created_folder_id = response.text
@@ -47,6 +52,10 @@ for folder in folders:
     if str(folder['id']) == str(created_folder_id):
         found_created_folder = True
         assert folder['name'] == "Folder 1", "The name of the folder just created is wrong"
+        assert folder['parentId'] == root_folder_id, "The parent of the folder just created is wrong"
+        assert folder['createdAt'] == folder['modifiedAt'], "The folder just created was modified"
+        assert folder['folderCount'] == 0 and folder['fileCount'] == 0, "The folder just created is not empty"
+        assert folder['hasChildren'] == False, "The folder just created has children"
 assert found_created_folder == True, "Could not find the folder just created"
 
 ### UPDATE FOLDER
@@ -72,7 +81,7 @@ assert body['currentFolder']['name'] == "Folder 1.1", "The name of the folder ju
 response = session.put(ROOT_URL + "/MoveFolder/" + str(root_folder_id) + "?dest=" + str(created_folder_id))
 assert response.status_code == 400, "Wrong http code received on move folder: " + str(response.status_code)
 # Create folder 2 in the root
-to_create_folder_2 = CreateFolderDTO("Folder 2", int(root_folder_id))
+to_create_folder_2 = CreateFolderDTO("Folder 2", root_folder_id)
 response = session.post(ROOT_URL + "/folders", to_create_folder_2.toJson())
 assert response.status_code == 201, "Wrong http code received on create new folder in root folder"
 created_folder_2_id = response.text
@@ -97,7 +106,30 @@ assert folders is not None, "Folder 1 does not contain any folder"
 for folder in folders:
     if str(folder['id']) == str(created_folder_2_id):
         found_created_folder = True
+        assert folder['parentId'] == created_folder_id, "The parent of the moved folder is wrong"
 assert found_created_folder == True, "Could not find the updated folder"
+assert body['currentFolder']['parentId'] == root_folder_id, "The parent of folder 1 is wrong"
+assert body['currentFolder']['folderCount'] == 1, "Folder 1 does not count the moved folder"
+assert body['currentFolder']['hasChildren'] == True, "Folder 1 has no children"
+
+### MOVE FOLDER INSIDE ITSELF OR ITS DESCENDANTS
+# Ensure cannot move folder 1 inside itself
+response = session.put(ROOT_URL + "/MoveFolder/" + created_folder_id + "?dest=" + created_folder_id)
+assert response.status_code == 400, "Wrong http code received on move folder inside itself: " + str(response.status_code)
+# Ensure cannot move folder 1 inside its child folder 2
+response = session.put(ROOT_URL + "/MoveFolder/" + created_folder_id + "?dest=" + created_folder_2_id)
+assert response.status_code == 400, "Wrong http code received on move folder inside its child: " + str(response.status_code)
+# Create folder 2.1 inside folder 2, then ensure cannot move folder 1 inside it
+to_create_folder_2_1 = CreateFolderDTO("Folder 2.1", created_folder_2_id)
+response = session.post(ROOT_URL + "/folders", to_create_folder_2_1.toJson())
+assert response.status_code == 201, "Wrong http code received on create new folder in folder 2: " + str(response.status_code)
+created_folder_2_1_id = response.text
+response = session.put(ROOT_URL + "/MoveFolder/" + created_folder_id + "?dest=" + created_folder_2_1_id)
+assert response.status_code == 400, "Wrong http code received on move folder inside its grandchild: " + str(response.status_code)
+# Ensure folder 1 is still inside the root folder
+response = session.get(ROOT_URL + "/folders")
+body = json.loads(response.text)
+assert any(str(folder['id']) == str(created_folder_id) for folder in body['folders']), "Folder 1 is not inside the root folder anymore"
 
 ### DELETE A FOLDER
 # Ensure cannot delete root folder
@@ -126,17 +158,9 @@ response = session.get(ROOT_URL + "/folders/" + str(created_folder_id))
 assert response.status_code == 404, "Wrong http code received on retrieve deleted folder 2: " + str(response.status_code)
 
 ### Files tests Config
-epoch_seconds = math.floor(time.time())
-tmp_path = 'tmp-' + str(epoch_seconds)
-tmp_files_path = tmp_path + '/files'
-
-try:
-    os.makedirs(tmp_files_path)
-except OSError as err:
-    print("OS error: {0}".format(err))
-except:
-    print("Unexpected error:", sys.exc_info()[0])
-    raise
+# the files are written in a temporary directory, removed even when an assertion fails
+tmp_files_path = tempfile.mkdtemp(prefix='integration-tests-')
+atexit.register(shutil.rmtree, tmp_files_path, True)
 
 file1_name = 'temp_file_1.txt'
 file1_path = tmp_files_path + '/' + file1_name
@@ -149,8 +173,7 @@ response = session.post(
     ROOT_URL + "/UploadFile?dest=" + str(root_folder_id), 
     files = { 'file': open(file1_path, 'rb') })
 assert response.status_code == 201, "Wrong http code received on create file in root folder: " + str(response.status_code)
-body = json.loads(response.text)
-uploaded_file1_id = body
+uploaded_file1_id = response.text
 # Ensures the file is part of the root folder content
 response = session.get(ROOT_URL + "/folders")
 body = json.loads(response.text)
@@ -160,6 +183,9 @@ for file in files:
     if str(file['id']) == str(uploaded_file1_id):
         found_created_file = True
         assert file['name'] == file1_name, "The name of the file just uploaded is wrong"
+        assert file['size'] == os.path.getsize(file1_path), "The size of the file just uploaded is wrong: " + str(file['size'])
+        assert file['contentType'] == "text/plain; charset=utf-8", "The type of the file just uploaded is wrong: " + file['contentType']
+        assert file['createdAt'] == file['modifiedAt'], "The file just uploaded was modified"
 assert found_created_file == True, "Could not find the file just uploaded"
 # Download the file and ensure the content corresponds
 response = session.get(ROOT_URL + "/DownloadFile/" + str(uploaded_file1_id))
@@ -188,6 +214,9 @@ for file in files:
     if str(file['id']) == str(uploaded_file1_id):
         found_created_file = True
         assert file['name'] == file1_name, "The name of the file just uploaded is wrong"
+        assert file['size'] == os.path.getsize(file1_path), "The size of the file just uploaded is wrong: " + str(file['size'])
+        assert file['contentType'] == "text/plain; charset=utf-8", "The type of the file just uploaded is wrong: " + file['contentType']
+        assert file['createdAt'] == file['modifiedAt'], "The file just uploaded was modified"
 assert found_created_file == True, "Could not find the file just uploaded"
 # Ensure the file is NOT anymore in the root folder
 response = session.get(ROOT_URL + "/folders")
@@ -208,6 +237,21 @@ retrieved_filename = params['filename']
 assert retrieved_filename == file1_name, "Wrong file name for downloaded file: " + retrieved_filename
 assert response.content == open(file1.name, 'rb').read(), "Wrong content for the downloaded file: " + str(response.content) 
 
+### RENAME THE FILE
+file1_new_name = 'renamed_file_1.md'
+to_update_file = UpdateFileDTO(file1_new_name, "text/markdown")
+response = session.put(ROOT_URL + "/files/" + str(uploaded_file1_id), to_update_file.toJson())
+assert response.status_code == 204, "Wrong http code received on file update: " + str(response.status_code)
+response = session.get(ROOT_URL + "/folders/" + created_folder_3_id)
+body = json.loads(response.text)
+renamed_files = [file for file in body['files'] if str(file['id']) == str(uploaded_file1_id)]
+assert len(renamed_files) == 1, "Could not find the renamed file"
+assert renamed_files[0]['name'] == file1_new_name, "The name of the file just renamed is wrong: " + renamed_files[0]['name']
+assert renamed_files[0]['contentType'] == "text/markdown", "The type of the file just updated is wrong: " + renamed_files[0]['contentType']
+# Ensures an update of a non-existing file returns 404
+response = session.put(ROOT_URL + "/files/123456", to_update_file.toJson())
+assert response.status_code == 404, "Wrong http code received on update non-existing file: " + str(response.status_code)
+
 ### DELETE THE FILE
 response = session.delete(ROOT_URL + "/files/" + str(uploaded_file1_id))
 assert response.status_code == 204, "Wrong http code received on delte file: " + str(response.status_code)
@@ -232,26 +276,24 @@ assert response.status_code == 404, "Wrong http code received on trying to acces
 to_create_folder1 = CreateFolderDTO("folder1", root_folder_id)
 response = session.post(ROOT_URL + "/folders", to_create_folder1.toJson())
 assert response.status_code == 201, "Wrong http code received on create /folder1 in root folder: " + str(response.status_code)
-created_folder1_id: int = int(response.text)
+created_folder1_id: str = response.text
 # Create /folder1/folder1.1
 to_create_folder1_2 = CreateFolderDTO("folder1.2", created_folder1_id)
 response = session.post(ROOT_URL + "/folders", to_create_folder1_2.toJson())
-created_folder2_id: int = int(response.text)
+created_folder2_id: str = response.text
 assert response.status_code == 201, "Wrong http code received on create /folder1.2 in /folder1: " + str(response.status_code) 
 # Add fileA.txt in /folder1
 response = session.post(
     ROOT_URL + "/UploadFile?dest=" + str(created_folder1_id), 
     files = { 'file': open(file1_path, 'rb') })
 assert response.status_code == 201, "Wrong http code received on create file in /folder1: " + str(response.status_code)
-body = json.loads(response.text)
-uploaded_fileA_id = body
+uploaded_fileA_id = response.text
 # Add fileB.txt in /folder1/folder1.2
 response = session.post(
     ROOT_URL + "/UploadFile?dest=" + str(created_folder2_id), 
     files = { 'file': open(file1_path, 'rb') })
 assert response.status_code == 201, "Wrong http code received on create file in /folder1: " + str(response.status_code)
-body = json.loads(response.text)
-uploaded_fileB_id = body
+uploaded_fileB_id = response.text
 # Ensure both files exist
 response = session.get(ROOT_URL + "/DownloadFile/" + str(uploaded_fileA_id))
 assert response.status_code == 200, "Wrong http code received on download uploaded fileA: " + str(response.status_code)
@@ -271,8 +313,269 @@ assert response.status_code == 404, "Wrong http code received on download delete
 response = session.get(ROOT_URL + "/DownloadFile/" + str(uploaded_fileB_id))
 assert response.status_code == 404, "Wrong http code received on download deleted fileB: " + str(response.status_code)
 
-os.remove(file1.name)
-os.rmdir(tmp_files_path)
-os.rmdir(tmp_path)
+### COPY A FOLDER AND A FILE
+# /template/readme.txt
+# /template/docs/guide.txt
+to_create_template = CreateFolderDTO("template", root_folder_id)
+response = session.post(ROOT_URL + "/folders", to_create_template.toJson())
+assert response.status_code == 201, "Wrong http code received on create /template in root folder: " + str(response.status_code)
+template_id: str = response.text
+to_create_docs = CreateFolderDTO("docs", template_id)
+response = session.post(ROOT_URL + "/folders", to_create_docs.toJson())
+assert response.status_code == 201, "Wrong http code received on create /template/docs: " + str(response.status_code)
+docs_id: str = response.text
+response = session.post(
+    ROOT_URL + "/UploadFile?dest=" + str(template_id),
+    files = { 'file': ('readme.txt', open(file1_path, 'rb')) })
+assert response.status_code == 201, "Wrong http code received on create file in /template: " + str(response.status_code)
+readme_id = response.text
+response = session.post(
+    ROOT_URL + "/UploadFile?dest=" + str(docs_id),
+    files = { 'file': ('guide.txt', open(file1_path, 'rb')) })
+assert response.status_code == 201, "Wrong http code received on create file in /template/docs: " + str(response.status_code)
+# Ensure the copy next to the folder follows the conflict policy
+response = session.post(ROOT_URL + "/CopyFolder/" + template_id + "?dest=" + str(root_folder_id))
+assert response.status_code == 409, "Wrong http code received on copy folder next to itself: " + str(response.status_code)
+response = session.post(ROOT_URL + "/CopyFolder/" + template_id + "?dest=" + str(root_folder_id) + "&conflict=rename")
+assert response.status_code == 201, "Wrong http code received on copy folder with rename policy: " + str(response.status_code)
+template_copy_id: str = response.text
+assert template_copy_id != template_id, "The copy has the id of the folder copied"
+# Ensure the copy holds the whole subtree
+response = session.get(ROOT_URL + "/folders/" + template_copy_id)
+assert response.status_code == 200, "Wrong http code received on get the folder copy: " + str(response.status_code)
+body = json.loads(response.text)
+assert body['currentFolder']['name'] == "template (1)", "Wrong name for the folder copy: " + body['currentFolder']['name']
+assert [folder['name'] for folder in body['folders']] == ["docs"], "Wrong folders inside the folder copy: " + str(body['folders'])
+assert body['folders'][0]['id'] != docs_id, "The copy of the subfolder has the id of the subfolder"
+assert body['folders'][0]['fileCount'] == 1, "Wrong file count for the copy of the subfolder: " + str(body['folders'][0]['fileCount'])
+assert [file['name'] for file in body['files']] == ["readme.txt"], "Wrong files inside the folder copy: " + str(body['files'])
+readme_copy_id = body['files'][0]['id']
+assert readme_copy_id != readme_id, "The copy of the file has the id of the file"
+# Ensure cannot copy a folder inside itself
+response = session.post(ROOT_URL + "/CopyFolder/" + template_id + "?dest=" + docs_id)
+assert response.status_code == 400, "Wrong http code received on copy folder inside its child folder: " + str(response.status_code)
+# Copy the file inside the root folder
+response = session.post(ROOT_URL + "/CopyFile/" + str(readme_id) + "?dest=" + str(root_folder_id))
+assert response.status_code == 201, "Wrong http code received on copy file: " + str(response.status_code)
+readme_root_copy_id = response.text
+# Ensure the copies still have their content once /template is deleted
+response = session.delete(ROOT_URL + "/folders/" + template_id)
+assert response.status_code == 204, "Wrong http code received on delete /template: " + str(response.status_code)
+for copy_id in [readme_copy_id, readme_root_copy_id]:
+    response = session.get(ROOT_URL + "/DownloadFile/" + str(copy_id))
+    assert response.status_code == 200, "Wrong http code received on download the copy of a file: " + str(response.status_code)
+    assert response.content == open(file1.name, 'rb').read(), "Wrong content for the copy of a file: " + str(response.content)
+# Ensures a copy of a non-existing file returns 400
+response = session.post(ROOT_URL + "/CopyFile/" + str(readme_id) + "?dest=" + str(root_folder_id))
+assert response.status_code == 400, "Wrong http code received on copy non-existing file: " + str(response.status_code)
+response = session.delete(ROOT_URL + "/folders/" + template_copy_id)
+assert response.status_code == 204, "Wrong http code received on delete the folder copy: " + str(response.status_code)
+response = session.delete(ROOT_URL + "/files/" + str(readme_root_copy_id))
+assert response.status_code == 204, "Wrong http code received on delete the file copy: " + str(response.status_code)
+
+### NAME CONFLICTS
+# Create /conflicts, with a file and a folder inside
+to_create_conflicts_folder = CreateFolderDTO("conflicts", root_folder_id)
+response = session.post(ROOT_URL + "/folders", to_create_conflicts_folder.toJson())
+assert response.status_code == 201, "Wrong http code received on create /conflicts in root folder: " + str(response.status_code)
+conflicts_folder_id: str = response.text
+response = session.post(
+    ROOT_URL + "/UploadFile?dest=" + str(conflicts_folder_id),
+    files = { 'file': open(file1_path, 'rb') })
+assert response.status_code == 201, "Wrong http code received on create file in /conflicts: " + str(response.status_code)
+conflicting_file_id = response.text
+to_create_subfolder = CreateFolderDTO("subfolder", conflicts_folder_id)
+response = session.post(ROOT_URL + "/folders", to_create_subfolder.toJson())
+assert response.status_code == 201, "Wrong http code received on create /conflicts/subfolder: " + str(response.status_code)
+# Ensure a name already used fails by default, whether by a file or a folder, and on upload when asked to
+response = session.post(ROOT_URL + "/folders", to_create_subfolder.toJson())
+assert response.status_code == 409, "Wrong http code received on create a folder with a name already used: " + str(response.status_code)
+response = session.post(
+    ROOT_URL + "/UploadFile?dest=" + str(conflicts_folder_id) + "&conflict=fail",
+    files = { 'file': open(file1_path, 'rb') })
+assert response.status_code == 409, "Wrong http code received on upload a file with a name already used: " + str(response.status_code)
+to_create_folder_named_as_file = CreateFolderDTO(file1_name, conflicts_folder_id)
+response = session.post(ROOT_URL + "/folders", to_create_folder_named_as_file.toJson())
+assert response.status_code == 409, "Wrong http code received on create a folder named as a file: " + str(response.status_code)
+# Ensure an unknown policy is rejected
+response = session.post(ROOT_URL + "/folders?conflict=ignore", to_create_subfolder.toJson())
+assert response.status_code == 400, "Wrong http code received on unknown conflict policy: " + str(response.status_code)
+# Ensure the rename policy numbers the name before the extension
+response = session.post(
+    ROOT_URL + "/UploadFile?dest=" + str(conflicts_folder_id) + "&conflict=rename",
+    files = { 'file': open(file1_path, 'rb') })
+assert response.status_code == 201, "Wrong http code received on upload with rename policy: " + str(response.status_code)
+renamed_file_id = response.text
+response = session.post(ROOT_URL + "/folders?conflict=rename", to_create_subfolder.toJson())
+assert response.status_code == 201, "Wrong http code received on create folder with rename policy: " + str(response.status_code)
+response = session.get(ROOT_URL + "/folders/" + str(conflicts_folder_id))
+body = json.loads(response.text)
+file_names = sorted([file['name'] for file in body['files']])
+folder_names = sorted([folder['name'] for folder in body['folders']])
+assert file_names == sorted([file1_name, "temp_file_1 (1).txt"]), "Wrong file names with rename policy: " + str(file_names)
+assert folder_names == ["subfolder", "subfolder (1)"], "Wrong folder names with rename policy: " + str(folder_names)
+# Ensure the overwrite policy replaces the file, but never a file by a folder
+response = session.post(
+    ROOT_URL + "/UploadFile?dest=" + str(conflicts_folder_id) + "&conflict=overwrite",
+    files = { 'file': open(file1_path, 'rb') })
+assert response.status_code == 201, "Wrong http code received on upload with overwrite policy: " + str(response.status_code)
+overwriting_file_id = response.text
+response = session.get(ROOT_URL + "/DownloadFile/" + str(conflicting_file_id))
+assert response.status_code == 404, "Wrong http code received on download overwritten file: " + str(response.status_code)
+response = session.get(ROOT_URL + "/trash")
+assert response.status_code == 200, "Wrong http code received on get trash: " + str(response.status_code)
+assert json.loads(response.text)[0]['id'] == conflicting_file_id, "The overwritten file is not in the trash: " + response.text
+response = session.get(ROOT_URL + "/DownloadFile/" + str(overwriting_file_id))
+assert response.status_code == 200, "Wrong http code received on download overwriting file: " + str(response.status_code)
+response = session.post(ROOT_URL + "/folders?conflict=overwrite", to_create_folder_named_as_file.toJson())
+assert response.status_code == 409, "Wrong http code received on overwrite a file by a folder: " + str(response.status_code)
+# Ensure moving a file next to a file of the same name follows the policy
+response = session.post(
+    ROOT_URL + "/UploadFile?dest=" + str(root_folder_id),
+    files = { 'file': open(file1_path, 'rb') })
+assert response.status_code == 201, "Wrong http code received on create file in root folder: " + str(response.status_code)
+root_file_id = response.text
+response = session.put(ROOT_URL + "/MoveFile/" + str(overwriting_file_id) + "?dest=" + str(root_folder_id))
+assert response.status_code == 409, "Wrong http code received on move file next to a file of the same name: " + str(response.status_code)
+response = session.put(ROOT_URL + "/MoveFile/" + str(overwriting_file_id) + "?dest=" + str(root_folder_id) + "&conflict=rename")
+assert response.status_code == 204, "Wrong http code received on move file with rename policy: " + str(response.status_code)
+response = session.delete(ROOT_URL + "/folders/" + str(conflicts_folder_id))
+assert response.status_code == 204, "Wrong http code received on delete /conflicts: " + str(response.status_code)
+response = session.delete(ROOT_URL + "/files/" + str(root_file_id))
+assert response.status_code == 204, "Wrong http code received on delete file in root folder: " + str(response.status_code)
+response = session.delete(ROOT_URL + "/files/" + str(overwriting_file_id))
+assert response.status_code == 204, "Wrong http code received on delete the moved file: " + str(response.status_code)
+
+### VERSIONS
+# Upload a file twice under the same name: the second upload is a new version of the file
+to_create_versions_folder = CreateFolderDTO("versions", root_folder_id)
+response = session.post(ROOT_URL + "/folders", to_create_versions_folder.toJson())
+assert response.status_code == 201, "Wrong http code received on create /versions: " + str(response.status_code)
+versions_folder_id = response.text
+response = session.post(
+    ROOT_URL + "/UploadFile?dest=" + str(versions_folder_id),
+    files = { 'file': (file1_name, b"config: first\n") })
+assert response.status_code == 201, "Wrong http code received on upload the first version: " + str(response.status_code)
+versioned_file_id = response.text
+response = session.post(
+    ROOT_URL + "/UploadFile?dest=" + str(versions_folder_id),
+    files = { 'file': (file1_name, b"config: second\n") })
+assert response.status_code == 201, "Wrong http code received on upload the second version: " + str(response.status_code)
+assert response.text == versioned_file_id, "The second version created another file: " + response.text
+response = session.get(ROOT_URL + "/folders/" + str(versions_folder_id))
+body = json.loads(response.text)
+assert [file['id'] for file in body['files']] == [versioned_file_id], "Wrong files after a new version: " + str(body['files'])
+response = session.get(ROOT_URL + "/files/" + str(versioned_file_id) + "/versions")
+assert response.status_code == 200, "Wrong http code received on get the versions: " + str(response.status_code)
+versions = json.loads(response.text)
+assert [version['number'] for version in versions] == [2, 1], "Wrong versions: " + str(versions)
+assert versions[0]['current'] and not versions[1]['current'], "Wrong current version: " + str(versions)
+assert versions[1]['size'] == len(b"config: first\n"), "Wrong size for the first version: " + str(versions[1])
+# Download the current and the first version
+response = session.get(ROOT_URL + "/DownloadFile/" + str(versioned_file_id))
+assert response.content == b"config: second\n", "Wrong content for the current version: " + str(response.content)
+response = session.get(ROOT_URL + "/DownloadFile/" + str(versioned_file_id) + "?version=1")
+assert response.status_code == 200, "Wrong http code received on download the first version: " + str(response.status_code)
+assert response.content == b"config: first\n", "Wrong content for the first version: " + str(response.content)
+response = session.get(ROOT_URL + "/DownloadFile/" + str(versioned_file_id) + "?version=5")
+assert response.status_code == 404, "Wrong http code received on download a missing version: " + str(response.status_code)
+# Restore the first version: it becomes the third one
+response = session.post(ROOT_URL + "/files/" + str(versioned_file_id) + "/versions/1/restore")
+assert response.status_code == 204, "Wrong http code received on restore the first version: " + str(response.status_code)
+response = session.get(ROOT_URL + "/DownloadFile/" + str(versioned_file_id))
+assert response.content == b"config: first\n", "Wrong content after restoring the first version: " + str(response.content)
+response = session.get(ROOT_URL + "/files/" + str(versioned_file_id) + "/versions")
+versions = json.loads(response.text)
+assert [version['number'] for version in versions] == [3, 2, 1], "Wrong versions after a restore: " + str(versions)
+response = session.post(ROOT_URL + "/files/" + str(versioned_file_id) + "/versions/7/restore")
+assert response.status_code == 404, "Wrong http code received on restore a missing version: " + str(response.status_code)
+# The version policy only applies to the uploads
+response = session.post(ROOT_URL + "/folders?conflict=version", to_create_versions_folder.toJson())
+assert response.status_code == 400, "Wrong http code received on create a folder with the version policy: " + str(response.status_code)
+
+### REPLACE THE CONTENT OF A FILE
+# The file keeps its id, and its download URL gives the new content
+response = session.get(ROOT_URL + "/DownloadFile/" + str(versioned_file_id))
+etag = response.headers['ETag']
+response = session.put(ROOT_URL + "/files/" + str(versioned_file_id) + "/content", b"config: replaced\n", headers = { 'If-Match': etag })
+assert response.status_code == 204, "Wrong http code received on replace the content: " + str(response.status_code)
+replaced_etag = response.headers['ETag']
+assert replaced_etag != etag, "The ETag did not change with the content: " + replaced_etag
+response = session.get(ROOT_URL + "/DownloadFile/" + str(versioned_file_id))
+assert response.content == b"config: replaced\n", "Wrong content after the replacement: " + str(response.content)
+assert response.headers['ETag'] == replaced_etag, "Wrong ETag after the replacement: " + response.headers['ETag']
+response = session.get(ROOT_URL + "/folders/" + str(versions_folder_id))
+body = json.loads(response.text)
+assert body['files'][0]['size'] == len(b"config: replaced\n"), "Wrong size after the replacement: " + str(body['files'][0])
+assert body['files'][0]['digest'] == replaced_etag.strip('"'), "Wrong digest after the replacement: " + str(body['files'][0])
+response = session.get(ROOT_URL + "/files/" + str(versioned_file_id) + "/versions")
+versions = json.loads(response.text)
+assert [version['number'] for version in versions] == [4, 3, 2, 1], "Wrong versions after the replacement: " + str(versions)
+# The replacement fails when the content changed meanwhile
+response = session.put(ROOT_URL + "/files/" + str(versioned_file_id) + "/content", b"config: stale\n", headers = { 'If-Match': etag })
+assert response.status_code == 412, "Wrong http code received on replace a content which changed: " + str(response.status_code)
+response = session.get(ROOT_URL + "/DownloadFile/" + str(versioned_file_id))
+assert response.content == b"config: replaced\n", "The content was replaced despite the precondition: " + str(response.content)
+response = session.put(ROOT_URL + "/files/unknown/content", b"config: missing\n")
+assert response.status_code == 404, "Wrong http code received on replace the content of a missing file: " + str(response.status_code)
+
+response = session.delete(ROOT_URL + "/folders/" + str(versions_folder_id))
+assert response.status_code == 204, "Wrong http code received on delete /versions: " + str(response.status_code)
+
+### TRASH
+# Delete a folder holding a file, and find it in the trash, the most recently deleted item
+to_create_trashed_folder = CreateFolderDTO("trashed", root_folder_id)
+response = session.post(ROOT_URL + "/folders", to_create_trashed_folder.toJson())
+assert response.status_code == 201, "Wrong http code received on create /trashed: " + str(response.status_code)
+trashed_folder_id = response.text
+response = session.post(
+    ROOT_URL + "/UploadFile?dest=" + str(trashed_folder_id),
+    files = { 'file': open(file1_path, 'rb') })
+assert response.status_code == 201, "Wrong http code received on upload file in /trashed: " + str(response.status_code)
+trashed_file_id = response.text
+response = session.delete(ROOT_URL + "/folders/" + str(trashed_folder_id))
+assert response.status_code == 204, "Wrong http code received on delete /trashed: " + str(response.status_code)
+response = session.get(ROOT_URL + "/folders/" + str(trashed_folder_id))
+assert response.status_code == 404, "Wrong http code received on retrieve trashed folder: " + str(response.status_code)
+response = session.get(ROOT_URL + "/trash")
+assert response.status_code == 200, "Wrong http code received on get trash: " + str(response.status_code)
+entries = json.loads(response.text)
+assert entries[0]['id'] == trashed_folder_id, "The last deleted folder is not listed first in the trash: " + str(entries)
+assert entries[0]['kind'] == "folder" and entries[0]['name'] == "trashed" and str(entries[0]['parentId']) == str(root_folder_id), "Wrong trash entry: " + str(entries[0])
+# Restore the folder along with its file
+response = session.post(ROOT_URL + "/trash/" + str(trashed_folder_id) + "/restore")
+assert response.status_code == 204, "Wrong http code received on restore /trashed: " + str(response.status_code)
+response = session.get(ROOT_URL + "/folders/" + str(trashed_folder_id))
+assert response.status_code == 200, "Wrong http code received on retrieve restored folder: " + str(response.status_code)
+body = json.loads(response.text)
+assert [file['id'] for file in body['files']] == [trashed_file_id], "The restored folder lost its file: " + str(body['files'])
+response = session.post(ROOT_URL + "/trash/" + str(trashed_folder_id) + "/restore")
+assert response.status_code == 404, "Wrong http code received on restore an item not in the trash: " + str(response.status_code)
+# Ensure restoring next to an item of the same name follows the conflict policy
+response = session.delete(ROOT_URL + "/folders/" + str(trashed_folder_id))
+assert response.status_code == 204, "Wrong http code received on delete /trashed again: " + str(response.status_code)
+response = session.post(ROOT_URL + "/folders", to_create_trashed_folder.toJson())
+assert response.status_code == 201, "Wrong http code received on create a new /trashed: " + str(response.status_code)
+new_trashed_folder_id = response.text
+response = session.post(ROOT_URL + "/trash/" + str(trashed_folder_id) + "/restore")
+assert response.status_code == 409, "Wrong http code received on restore next to a folder of the same name: " + str(response.status_code)
+response = session.post(ROOT_URL + "/trash/" + str(trashed_folder_id) + "/restore?conflict=rename")
+assert response.status_code == 204, "Wrong http code received on restore with rename policy: " + str(response.status_code)
+response = session.get(ROOT_URL + "/folders/" + str(trashed_folder_id))
+body = json.loads(response.text)
+assert body['currentFolder']['name'] == "trashed (1)", "Wrong name for the restored folder: " + body['currentFolder']['name']
+# Empty the trash: its items are deleted for good
+response = session.delete(ROOT_URL + "/folders/" + str(trashed_folder_id))
+assert response.status_code == 204, "Wrong http code received on delete /trashed (1): " + str(response.status_code)
+response = session.delete(ROOT_URL + "/folders/" + str(new_trashed_folder_id))
+assert response.status_code == 204, "Wrong http code received on delete the new /trashed: " + str(response.status_code)
+response = session.delete(ROOT_URL + "/trash")
+assert response.status_code == 204, "Wrong http code received on empty trash: " + str(response.status_code)
+response = session.get(ROOT_URL + "/trash")
+assert json.loads(response.text) == [], "The trash is not empty: " + response.text
+response = session.post(ROOT_URL + "/trash/" + str(trashed_folder_id) + "/restore")
+assert response.status_code == 404, "Wrong http code received on restore a purged folder: " + str(response.status_code)
+response = session.get(ROOT_URL + "/DownloadFile/" + str(trashed_file_id))
+assert response.status_code == 404, "Wrong http code received on download a purged file: " + str(response.status_code)
 
 print("Integration tests finished successfully.")
@@ -1,17 +1,17 @@
 import json
-from typing import Dict, Union, Optional
+from typing import Dict, Optional
 
 class CreateFolderDTO():
     name: str
-    parent_id: Optional[int]
+    parent_id: Optional[str]
 
-    def __init__(self, name: str, parent_id: Optional[int]) -> None:
+    def __init__(self, name: str, parent_id: Optional[str]) -> None:
         self.id = id
         self.name = name
         self.parent_id = parent_id
 
     def toJson(self) -> str:
-        obj: Dict[str, Union[str, int]] = dict()
+        obj: Dict[str, str] = dict()
         obj['name'] = self.name
         if self.parent_id is not None:
             obj['parentId'] = self.parent_id
@@ -19,3 +19,18 @@ class CreateFolderDTO():
 
 class UpdateFolderDTO(CreateFolderDTO):
     pass
+
+class UpdateFileDTO():
+    name: str
+    content_type: Optional[str]
+
+    def __init__(self, name: str, content_type: Optional[str]) -> None:
+        self.name = name
+        self.content_type = content_type
+
+    def toJson(self) -> str:
+        obj: Dict[str, str] = dict()
+        obj['name'] = self.name
+        if self.content_type is not None:
+            obj['contentType'] = self.content_type
+        return json.dumps(obj)
@@ -1,28 +1,68 @@
 package main
 
 import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
+	"io"
+	"mime/multipart"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 	"github.com/pkg/errors"
 
+	"github.com/loisfa/remote-file-system/api/fsconfig"
 	"github.com/loisfa/remote-file-system/api/fsmodel"
+	"github.com/loisfa/remote-file-system/api/fsrepository"
 	"github.com/loisfa/remote-file-system/api/fsservice"
+	"github.com/loisfa/remote-file-system/api/fsstorage"
+	"github.com/loisfa/remote-file-system/api/fsupload"
 )
 
 // https://itnext.io/golang-error-handling-best-practice-a36f47b0b94c
 // TODO: do not expose the database errors, to be rewritten with message
 
+const (
+	MAX_UPLOAD_SIZE   = "MAX_UPLOAD_SIZE"   // in bytes
+	LEGACY_IDS        = "LEGACY_IDS"        // true or false: whether the integer ids of before the opaque ids are accepted
+	TRASH_RETENTION   = "TRASH_RETENTION"   // how long the deleted items stay in the trash (Go duration, e.g. 720h), 0 until it is emptied
+	MAX_FILE_VERSIONS = "MAX_FILE_VERSIONS" // the versions kept per file, 0 for unlimited
+
+	defaultMaxUploadSize   int64 = 10 << 30 // 10 GB
+	defaultLegacyIDs             = true
+	defaultTrashRetention        = 30 * 24 * time.Hour
+	defaultMaxFileVersions       = 10
+
+	tusVersion          = "1.0.0"
+	tusExtensions       = "creation,expiration,termination"
+	tusContentType      = "application/offset+octet-stream"
+	uploadPurgeInterval = 10 * time.Minute
+
+	contentPurgeInterval   = 10 * time.Minute
+	stagedContentRetention = 24 * time.Hour // the staged content never referenced is purged after it
+	trashPurgeInterval     = 10 * time.Minute
+)
+
 var svc fsservice.IFileSystemService
+var blobs fsstorage.BlobStore
+var uploads *fsupload.UploadStore
+var maxUploadSize int64
+var legacyIDs bool
+var trashRetention time.Duration
 
 func main() {
-	svc = fsservice.NewFileSystemService()
+	blobs = fsstorage.NewBlobStore()
+	svc = fsservice.NewFileSystemService(fsrepository.NewFileSystemRepository(), blobs, fsservice.NewTimeouts(), getMaxFileVersions())
+	uploads = fsupload.NewUploadStore()
+	maxUploadSize = getMaxUploadSize()
+	legacyIDs = getLegacyIDs()
+	trashRetention = getTrashRetention()
 
 	r := mux.NewRouter()
 
@@ -32,29 +72,69 @@ func main() {
 	 * FOLDERS
 	 */
 
-	r.HandleFunc("/folders/{folderId:[0-9]+}", getFolderContent).Methods(http.MethodGet)
+	r.HandleFunc("/folders/{folderId}", getFolderContent).Methods(http.MethodGet)
 	r.HandleFunc("/folders", getRootFolderContent).Methods(http.MethodGet)
 
 	r.HandleFunc("/folders", createFolder).Methods(http.MethodPost)
 
-	r.HandleFunc("/folders/{folderId:[0-9]+}", updateFolder).Methods(http.MethodPut)
+	r.HandleFunc("/folders/{folderId}", updateFolder).Methods(http.MethodPut)
 
-	r.HandleFunc("/folders/{folderId:[0-9]+}", deleteFolderAndContent).Methods(http.MethodDelete, http.MethodOptions) // SEE if can delete methodOptions
+	r.HandleFunc("/folders/{folderId}", deleteFolderAndContent).Methods(http.MethodDelete, http.MethodOptions) // SEE if can delete methodOptions
 
-	r.HandleFunc("/MoveFolder/{folderId:[0-9]+}", moveFolder).Queries("dest", "{destFolderId:[0-9]+}").Methods(http.MethodPut)
+	r.HandleFunc("/MoveFolder/{folderId}", moveFolder).Queries("dest", "{destFolderId}").Methods(http.MethodPut)
+
+	r.HandleFunc("/CopyFolder/{folderId}", copyFolder).Queries("dest", "{destFolderId}").Methods(http.MethodPost)
 
 	// TODO: download selected folder as a .zip?
 
 	/*
 	 * FILES
 	 */
-	r.HandleFunc("/files/{fileId:[0-9]+}", deleteFile).Methods(http.MethodDelete, http.MethodOptions)
+	r.HandleFunc("/files/{fileId}", updateFile).Methods(http.MethodPut)
+
+	r.HandleFunc("/files/{fileId}/content", replaceFileContent).Methods(http.MethodPut)
+
+	r.HandleFunc("/files/{fileId}", deleteFile).Methods(http.MethodDelete, http.MethodOptions)
+
+	r.HandleFunc("/DownloadFile/{fileId}", serveFile).Methods(http.MethodGet) // ?version=N for an older version
+
+	r.HandleFunc("/files/{fileId}/versions", getFileVersions).Methods(http.MethodGet)
+
+	r.HandleFunc("/files/{fileId}/versions/{number:[0-9]+}/restore", restoreFileVersion).Methods(http.MethodPost)
+
+	r.HandleFunc("/UploadFile", uploadFile).Queries("dest", "{destFolderId}").Methods(http.MethodPost)
+
+	r.HandleFunc("/MoveFile/{fileId}", moveFile).Queries("dest", "{destFolderId}").Methods(http.MethodPut)
+
+	r.HandleFunc("/CopyFile/{fileId}", copyFile).Queries("dest", "{destFolderId}").Methods(http.MethodPost)
+
+	/*
+	 * TRASH
+	 */
+	r.HandleFunc("/trash", getTrash).Methods(http.MethodGet)
+
+	r.HandleFunc("/trash/{itemId}/restore", restoreFromTrash).Methods(http.MethodPost)
+
+	r.HandleFunc("/trash", emptyTrash).Methods(http.MethodDelete)
+
+	/*
+	 * RESUMABLE UPLOADS (tus protocol: https://tus.io/protocols/resumable-upload.html)
+	 */
+	r.HandleFunc("/uploads", getTusOptions).Methods(http.MethodOptions)
+
+	r.HandleFunc("/uploads", createUpload).Queries("dest", "{destFolderId}").Methods(http.MethodPost)
 
-	r.HandleFunc("/DownloadFile/{fileId:[0-9]+}", serveFile).Methods(http.MethodGet)
+	r.HandleFunc("/uploads/{uploadId:[0-9a-f]+}", getUploadOffset).Methods(http.MethodHead)
 
-	r.HandleFunc("/UploadFile", uploadFile).Queries("dest", "{destFolderId:[0-9]+}").Methods(http.MethodPost)
+	r.HandleFunc("/uploads/{uploadId:[0-9a-f]+}", patchUpload).Methods(http.MethodPatch)
 
-	r.HandleFunc("/MoveFile/{fileId:[0-9]+}", moveFile).Queries("dest", "{destFolderId:[0-9]+}").Methods(http.MethodPut)
+	r.HandleFunc("/uploads/{uploadId:[0-9a-f]+}", deleteUpload).Methods(http.MethodDelete)
+
+	go purgeExpiredUploadsPeriodically()
+	go purgeDeletedContentPeriodically()
+	if trashRetention > 0 {
+		go purgeTrashPeriodically()
+	}
 
 	http.Handle("/", r)
 
@@ -64,131 +144,305 @@ func main() {
 	// TODO: see if can be deleted (in favor of what is just above)
 	corsObj := handlers.AllowedOrigins([]string{"*"})
 	headersOk := handlers.AllowedHeaders([]string{"X-Requested-With", "Content-Type", "Authorization",
-		"Accept", "Accept-Language", "Content-Language", "Origin"})
-	methodsOk := handlers.AllowedMethods([]string{"GET", "HEAD", "POST", "PUT", "DELETE", "OPTIONS"})
+		"Accept", "Accept-Language", "Content-Language", "Origin", "If-Match",
+		"Tus-Resumable", "Upload-Length", "Upload-Offset", "Upload-Metadata"})
+	exposedHeadersOk := handlers.ExposedHeaders([]string{"Location", "Digest", "ETag",
+		"Tus-Resumable", "Tus-Version", "Tus-Extension", "Tus-Max-Size", "Upload-Length", "Upload-Offset", "Upload-Expires", "X-File-Id"})
+	methodsOk := handlers.AllowedMethods([]string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"})
 
 	fmt.Println("Server running on port 8080...")
 
-	http.ListenAndServe(":8080", handlers.CORS(corsObj, headersOk, methodsOk)(r))
+	http.ListenAndServe(":8080", handlers.CORS(corsObj, headersOk, exposedHeadersOk, methodsOk)(r))
+}
+
+// ApiId is the opaque id of a folder or a file. The clients may still send the integer ids of before as numbers.
+type ApiId string
+
+func (id *ApiId) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		return json.Unmarshal(data, (*string)(id))
+	}
+	var number json.Number
+	if err := json.Unmarshal(data, &number); err != nil {
+		return err
+	}
+	*id = ApiId(number.String())
+	return nil
 }
 
+// ApiFolder omits the timestamps of the root folder and of the folders created before they were recorded. The
+// readonly child counts are the ones of the direct children.
 type ApiFolder struct {
-	Id       int    `json:"id"` // readonly
-	Name     string `json:"name"`
-	ParentId *int   `json:"parentId"` // nil in case folder is at the root
+	Id          ApiId      `json:"id"` // readonly
+	Name        string     `json:"name"`
+	ParentId    *ApiId     `json:"parentId"`             // nil in case folder is at the root
+	CreatedAt   *time.Time `json:"createdAt,omitempty"`  // readonly
+	ModifiedAt  *time.Time `json:"modifiedAt,omitempty"` // readonly
+	FolderCount int        `json:"folderCount"`          // readonly
+	FileCount   int        `json:"fileCount"`            // readonly
+	HasChildren bool       `json:"hasChildren"`          // readonly
 }
 
+// ApiFile omits the metadata of the legacy files, stored before they were recorded
 type ApiFile struct {
-	Id   int    `json:"id"`
-	Name string `json:"name"`
+	Id          ApiId      `json:"id"`
+	Name        string     `json:"name"`
+	Digest      string     `json:"digest,omitempty"`      // hex encoded SHA-256 of the content
+	Size        *int64     `json:"size,omitempty"`        // in bytes
+	ContentType string     `json:"contentType,omitempty"` // MIME type
+	CreatedAt   *time.Time `json:"createdAt,omitempty"`
+	ModifiedAt  *time.Time `json:"modifiedAt,omitempty"`
 }
 
-type ApiFolderContent struct {
-	CurrentFolder ApiFolder   `json:"currentFolder"` // nil in case of root folder
-	Folders       []ApiFolder `json:"folders"`       // readonly
-	Files         []ApiFile   `json:"files"`         // readonly
+// ApiFileVersion is a content the file had, or has when it is the current one
+type ApiFileVersion struct {
+	Number      int       `json:"number"`
+	Digest      string    `json:"digest,omitempty"` // hex encoded SHA-256 of the content
+	Size        *int64    `json:"size,omitempty"`   // in bytes
+	ContentType string    `json:"contentType,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+	Current     bool      `json:"current"`
 }
 
-func getContentIn(folderId int) (*ApiFolderContent, error) {
-	currentFolder, err := svc.GetFolder(folderId)
-	if err != nil {
-		return nil, err
-	}
+// ApiTrashEntry is a deleted folder or file, with the name and the parent it had
+type ApiTrashEntry struct {
+	Id        ApiId     `json:"id"`
+	Kind      string    `json:"kind"` // folder or file
+	Name      string    `json:"name"`
+	ParentId  ApiId     `json:"parentId"`
+	TrashedAt time.Time `json:"trashedAt"`
+}
 
-	subFolders, err := svc.GetFoldersIn(folderId)
-	if err != nil {
-		return nil, err
-	}
+type ApiFolderContent struct {
+	CurrentFolder ApiFolder   `json:"currentFolder"`        // nil in case of root folder
+	Folders       []ApiFolder `json:"folders"`              // readonly
+	Files         []ApiFile   `json:"files"`                // readonly
+	NextCursor    string      `json:"nextCursor,omitempty"` // gives the next page, empty on the last one
+}
 
-	files, err := svc.GetFilesIn(folderId)
+func getContentIn(ctx context.Context, folderId string, options fsmodel.ListOptions) (*ApiFolderContent, error) {
+	content, err := svc.GetFolderContent(ctx, folderId, options)
 	if err != nil {
 		return nil, err
 	}
 
-	apiCurrentFolder := ApiFolder{
-		(*currentFolder).Id,
-		(*currentFolder).Name,
-		(*currentFolder).ParentId}
+	apiCurrentFolder := mapFolderToApiFolder(content.Folder, content.ChildCounts[content.Folder.Id])
 
 	apiFolders := make([]ApiFolder, 0)
-	for idx := range *subFolders {
-		folder := (*subFolders)[idx]
-		apiFolders = append(apiFolders, mapFolderToApiFolder(folder))
+	for idx := range content.Folders {
+		folder := content.Folders[idx]
+		apiFolders = append(apiFolders, mapFolderToApiFolder(folder, content.ChildCounts[folder.Id]))
 	}
 
 	apiFiles := make([]ApiFile, 0)
-	for idx := range *files {
-		file := (*files)[idx]
+	for idx := range content.Files {
+		file := content.Files[idx]
 		apiFiles = append(apiFiles, mapFileToApiFile(file))
 	}
 
-	return &ApiFolderContent{apiCurrentFolder, apiFolders, apiFiles}, nil
+	nextCursor := ""
+	if content.Next != nil {
+		nextCursor = fsservice.EncodeCursor(options, *content.Next)
+	}
+
+	return &ApiFolderContent{apiCurrentFolder, apiFolders, apiFiles, nextCursor}, nil
 }
 
-func mapFolderToApiFolder(folder fsmodel.Folder) ApiFolder {
+func mapFolderToApiFolder(folder fsmodel.Folder, counts fsmodel.ChildCounts) ApiFolder {
 	return ApiFolder{
-		folder.Id,
+		ApiId(folder.Id),
 		folder.Name,
-		&folder.Id}
+		apiIdOf(folder.ParentId),
+		apiTimeOf(folder.CreatedAt),
+		apiTimeOf(folder.ModifiedAt),
+		counts.Folders,
+		counts.Files,
+		counts.Folders+counts.Files > 0}
 }
 
 func mapFileToApiFile(file fsmodel.File) ApiFile {
+	var size *int64
+	if file.Size != fsmodel.UnknownSize {
+		size = &file.Size
+	}
 	return ApiFile{
-		file.Id,
-		file.Name}
+		ApiId(file.Id),
+		file.Name,
+		file.Digest,
+		size,
+		file.ContentType,
+		apiTimeOf(file.CreatedAt),
+		apiTimeOf(file.ModifiedAt)}
+}
+
+// apiTimeOf omits the zero time, unknown
+func apiTimeOf(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func apiIdOf(id *string) *ApiId {
+	if id == nil {
+		return nil
+	}
+	apiId := ApiId(*id)
+	return &apiId
+}
+
+// resolveFolderId returns the id of the folder a client designates: its id, or the integer id it had before the ids
+// became opaque, while they are accepted
+func resolveFolderId(ctx context.Context, idStr string) (string, error) {
+	legacyId, err := strconv.Atoi(idStr)
+	if !legacyIDs || err != nil {
+		return idStr, nil
+	}
+	folderId, err := svc.GetFolderIDByLegacyID(ctx, legacyId)
+	if err != nil {
+		return "", err
+	}
+	return *folderId, nil
+}
+
+// resolveDestFolderId is resolveFolderId for a destination folder: not finding it is a bad request, as for the ids
+func resolveDestFolderId(ctx context.Context, idStr string) (string, error) {
+	destFolderId, err := resolveFolderId(ctx, idStr)
+	if err != nil && errors.Cause(err).Error() == fsservice.NotFound {
+		return "", errors.WithMessage(errors.New(fsservice.BadRequest), fmt.Sprintf("Could not find destination folder %s", idStr))
+	}
+	return destFolderId, err
+}
+
+func resolveFileId(ctx context.Context, idStr string) (string, error) {
+	legacyId, err := strconv.Atoi(idStr)
+	if !legacyIDs || err != nil {
+		return idStr, nil
+	}
+	fileId, err := svc.GetFileIDByLegacyID(ctx, legacyId)
+	if err != nil {
+		return "", err
+	}
+	return *fileId, nil
+}
+
+// resolveItemIds is resolveFolderId and resolveFileId for an item which may be either: the folders and the files had
+// their own integer ids, so the same legacy id may resolve to a folder and to a file
+func resolveItemIds(ctx context.Context, idStr string) ([]string, error) {
+	if _, err := strconv.Atoi(idStr); !legacyIDs || err != nil {
+		return []string{idStr}, nil
+	}
+
+	itemIds := make([]string, 0, 2)
+	for _, resolve := range []func(context.Context, string) (string, error){resolveFolderId, resolveFileId} {
+		itemId, err := resolve(ctx, idStr)
+		if err != nil {
+			if errors.Cause(err).Error() == fsservice.NotFound {
+				continue
+			}
+			return nil, err
+		}
+		itemIds = append(itemIds, itemId)
+	}
+	return itemIds, nil
 }
 
 func serveFile(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 
-	var fileId int
-	fileIdStr := vars["fileId"]
-	var err error
-	if fileId, err = strconv.Atoi(fileIdStr); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+	versionNumber := 0
+	if versionStr := r.URL.Query().Get("version"); versionStr != "" {
+		number, err := strconv.Atoi(versionStr)
+		if err != nil || number <= 0 {
+			http.Error(w, fmt.Sprintf("Invalid version '%s': expected a positive number", versionStr), http.StatusBadRequest)
+			return
+		}
+		versionNumber = number
 	}
 
-	file, err := svc.GetFile(fileId)
+	fileIdStr := vars["fileId"]
+	fileId, err := resolveFileId(r.Context(), fileIdStr)
+	var file *fsmodel.File
+	if err == nil {
+		file, err = svc.GetFile(r.Context(), fileId)
+	}
+	if err == nil && versionNumber > 0 {
+		// the older content is served under the name of the file
+		var version *fsmodel.FileVersion
+		if version, err = svc.GetFileVersion(r.Context(), fileId, versionNumber); err == nil {
+			file.FileContent = version.FileContent
+			file.ModifiedAt = version.CreatedAt
+		}
+	}
 	if err != nil {
 		errorCode := errors.Cause(err).Error()
 		if errorCode == fsservice.NotFound {
-			errorMsg := fmt.Sprintf("Could not find file with id %d when trying to get file", fileId)
+			errorMsg := fmt.Sprintf("Could not find file with id %s when trying to get file", fileIdStr)
+			if versionNumber > 0 {
+				errorMsg = fmt.Sprintf("Could not find version %d of file with id %s when trying to get file", versionNumber, fileIdStr)
+			}
 			fmt.Println(err, errorMsg)
 			http.Error(w, errorMsg, http.StatusNotFound)
 		} else {
-			fmt.Println(err, fmt.Sprintf("Error when trying to get file %d.", fileId))
+			fmt.Println(err, fmt.Sprintf("Error when trying to get file %s.", fileIdStr))
 			http.Error(w, "", mapServiceErrorToHttpStatus(err))
 		}
 		return
 	}
 
+	blobInfo, err := blobs.Stat(file.BlobKey)
+	if err != nil {
+		fmt.Println(err, fmt.Sprintf("Could not find the content of file %s (blob key: %s).", fileId, file.BlobKey))
+		http.Error(w, "", mapStorageErrorToHttpStatus(err))
+		return
+	}
+
+	blob, err := blobs.Get(file.BlobKey)
+	if err != nil {
+		fmt.Println(err, fmt.Sprintf("Could not read the content of file %s (blob key: %s).", fileId, file.BlobKey))
+		http.Error(w, "", mapStorageErrorToHttpStatus(err))
+		return
+	}
+	defer blob.Close()
+
+	setDigestHeader(w, file.Digest)
+	if file.Digest != "" {
+		w.Header().Set("ETag", fmt.Sprintf("\"%s\"", file.Digest))
+	}
+	if file.ContentType != "" {
+		w.Header().Set("Content-Type", file.ContentType)
+	}
+	modTime := blobInfo.ModTime // the content may be older than the file, shared with another one
+	if !file.ModifiedAt.IsZero() {
+		modTime = file.ModifiedAt
+	}
 	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", file.Name))
-	http.ServeFile(w, r, file.Path)
+	http.ServeContent(w, r, file.Name, modTime, blob)
 }
 
 func getFolderContent(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 
-	var folderId int
-	idStr := vars["folderId"]
-	var err error
-	if folderId, err = strconv.Atoi(idStr); err != nil {
-		errMsg := fmt.Sprintf("Could not parse integer 'folderId' when trying to get folder content. folderId: %s", idStr)
-		fmt.Println(err, errMsg)
-		http.Error(w, errMsg, http.StatusBadRequest)
+	options, err := getListOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	apiFolderContent, err := getContentIn(folderId)
+	idStr := vars["folderId"]
+	folderId, err := resolveFolderId(r.Context(), idStr)
+	var apiFolderContent *ApiFolderContent
+	if err == nil {
+		apiFolderContent, err = getContentIn(r.Context(), folderId, options)
+	}
 	if err != nil {
 		errorCode := errors.Cause(err).Error()
 		if errorCode == fsservice.NotFound {
-			errorMsg := fmt.Sprintf("Could not find folder with id %d when trying to get folder content.", folderId)
+			errorMsg := fmt.Sprintf("Could not find folder with id %s when trying to get folder content.", idStr)
 			fmt.Println(err, errorMsg)
 			http.Error(w, errorMsg, http.StatusNotFound)
 		} else {
-			fmt.Println(err, fmt.Sprintf("Error when trying to get folder %d.", folderId))
+			fmt.Println(err, fmt.Sprintf("Error when trying to get folder %s.", idStr))
 			http.Error(w, "", mapServiceErrorToHttpStatus(err))
 		}
 		return
@@ -201,22 +455,28 @@ func getFolderContent(w http.ResponseWriter, r *http.Request) {
 
 // TODO see how this function can be factorized with the one just above
 func getRootFolderContent(w http.ResponseWriter, r *http.Request) {
-	folderId, err := svc.GetRootFolderID()
+	folderId, err := svc.GetRootFolderID(r.Context())
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		fmt.Println(err)
 		return
 	}
 
-	apiFolderContent, err := getContentIn(*folderId)
+	options, err := getListOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	apiFolderContent, err := getContentIn(r.Context(), *folderId, options)
 	if err != nil {
 		errorCode := errors.Cause(err).Error()
 		if errorCode == fsservice.NotFound {
-			errorMsg := fmt.Sprintf("Could not find folder with id %d when trying to get root folder content.", folderId)
+			errorMsg := fmt.Sprintf("Could not find folder with id %s when trying to get root folder content.", *folderId)
 			fmt.Println(err, errorMsg)
 			http.Error(w, errorMsg, http.StatusNotFound)
 		} else {
-			fmt.Println(err, fmt.Sprintf("Error when trying to get root folder content %d.", folderId))
+			fmt.Println(err, fmt.Sprintf("Error when trying to get root folder content %s.", *folderId))
 			http.Error(w, "", mapServiceErrorToHttpStatus(err))
 		}
 		return
@@ -236,52 +496,61 @@ func createFolder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	destFolderId := folder.ParentId
-	if destFolderId == nil {
+	if folder.ParentId == nil {
 		http.Error(w, "Create folder: missing destination folder id", http.StatusBadRequest)
 		return
 	}
 
-	id, err := svc.CreateFolder(folder.Name, *destFolderId)
+	policy, err := getConflictPolicy(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	destFolderId, err := resolveDestFolderId(r.Context(), string(*folder.ParentId))
+	var id *string
+	if err == nil {
+		id, err = svc.CreateFolder(r.Context(), folder.Name, destFolderId, policy)
+	}
 	if err != nil {
-		fmt.Println(err, fmt.Sprintf("Error when trying to create folder named %s inside folder %d.", folder.Name, *destFolderId))
+		fmt.Println(err, fmt.Sprintf("Error when trying to create folder named %s inside folder %s.", folder.Name, *folder.ParentId))
 		http.Error(w, "", mapServiceErrorToHttpStatus(err))
 		return
 	}
 
 	w.WriteHeader(http.StatusCreated)
-	fmt.Fprintf(w, strconv.Itoa(*id))
+	fmt.Fprint(w, *id)
 }
 
 func updateFolder(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 
-	var folderId *int
-	var idInt int
-	var err error
-	idStr := vars["folderId"]
-	if idInt, err = strconv.Atoi(idStr); err != nil {
+	var f ApiFolder
+	err := json.NewDecoder(r.Body).Decode(&f)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	folderId = &idInt
 
-	var f ApiFolder
-	err = json.NewDecoder(r.Body).Decode(&f)
+	policy, err := getConflictPolicy(r)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	err = svc.UpdateFolder(*folderId, f.Name)
+	idStr := vars["folderId"]
+	folderId, err := resolveFolderId(r.Context(), idStr)
+	if err == nil {
+		err = svc.UpdateFolder(r.Context(), folderId, f.Name, policy)
+	}
 	if err != nil {
 		errorCode := errors.Cause(err).Error()
 		if errorCode == fsservice.NotFound {
-			errorMsg := fmt.Sprintf("Could not find folder %d when trying to update the folder.", folderId)
+			errorMsg := fmt.Sprintf("Could not find folder %s when trying to update the folder.", idStr)
 			fmt.Println(err, errorMsg)
 			http.Error(w, errorMsg, http.StatusNotFound)
 		} else {
-			fmt.Println(err, fmt.Sprintf("Error when trying to update folder %d.", folderId))
+			fmt.Println(err, fmt.Sprintf("Error when trying to update folder %s.", idStr))
 			http.Error(w, "", mapServiceErrorToHttpStatus(err))
 		}
 		return
@@ -293,25 +562,19 @@ func updateFolder(w http.ResponseWriter, r *http.Request) {
 func deleteFolderAndContent(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 
-	var folderId *int
-	var idInt int
-	var err error
 	idStr := vars["folderId"]
-	if idInt, err = strconv.Atoi(idStr); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+	folderId, err := resolveFolderId(r.Context(), idStr)
+	if err == nil {
+		err = svc.DeleteFolderAndContent(r.Context(), folderId)
 	}
-	folderId = &idInt
-
-	err = svc.DeleteFolderAndContent(*folderId)
 	if err != nil {
 		errorCode := errors.Cause(err).Error()
 		if errorCode == fsservice.NotFound {
-			errorMsg := fmt.Sprintf("Could not find folder %d when trying to delete the folder.", folderId)
+			errorMsg := fmt.Sprintf("Could not find folder %s when trying to delete the folder.", idStr)
 			fmt.Println(err, errorMsg)
 			http.Error(w, errorMsg, http.StatusNotFound)
 		} else {
-			fmt.Println(err, fmt.Sprintf("Error when trying to delete folder %d.", folderId))
+			fmt.Println(err, fmt.Sprintf("Error when trying to delete folder %s.", idStr))
 			http.Error(w, "", mapServiceErrorToHttpStatus(err))
 		}
 		return
@@ -323,24 +586,127 @@ func deleteFolderAndContent(w http.ResponseWriter, r *http.Request) {
 func moveFolder(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 
-	var folderId int
-	var err error
-	folderIdStr := vars["folderId"]
-	if folderId, err = strconv.Atoi(folderIdStr); err != nil {
+	policy, err := getConflictPolicy(r)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	var destFolderId int
-	destFolderIdStr := vars["destFolderId"]
-	if destFolderId, err = strconv.Atoi(destFolderIdStr); err != nil {
+	folderIdStr, destFolderIdStr := vars["folderId"], vars["destFolderId"]
+	folderId, err := resolveFolderId(r.Context(), folderIdStr)
+	var destFolderId string
+	if err == nil {
+		destFolderId, err = resolveDestFolderId(r.Context(), destFolderIdStr)
+	}
+	if err == nil {
+		err = svc.MoveFolder(r.Context(), folderId, destFolderId, policy)
+	}
+	if err != nil {
+		fmt.Println(err, fmt.Sprintf("Error when trying to move folder %s inside folder %s.", folderIdStr, destFolderIdStr))
+		http.Error(w, "", mapServiceErrorToHttpStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func copyFolder(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	policy, err := getConflictPolicy(r)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	err = svc.MoveFolder(folderId, destFolderId)
+	folderIdStr, destFolderIdStr := vars["folderId"], vars["destFolderId"]
+	folderId, err := resolveFolderId(r.Context(), folderIdStr)
+	var destFolderId string
+	if err == nil {
+		destFolderId, err = resolveDestFolderId(r.Context(), destFolderIdStr)
+	}
+	var id *string
+	if err == nil {
+		id, err = svc.CopyFolder(r.Context(), folderId, destFolderId, policy)
+	}
+	if err != nil {
+		fmt.Println(err, fmt.Sprintf("Error when trying to copy folder %s inside folder %s.", folderIdStr, destFolderIdStr))
+		http.Error(w, "", mapServiceErrorToHttpStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprint(w, *id)
+}
+
+func getTrash(w http.ResponseWriter, r *http.Request) {
+	entries, err := svc.GetTrashEntries(r.Context())
+	if err != nil {
+		fmt.Println(err, "Error when trying to get the trash.")
+		http.Error(w, "", mapServiceErrorToHttpStatus(err))
+		return
+	}
+
+	apiEntries := make([]ApiTrashEntry, 0)
+	for _, entry := range *entries {
+		apiEntries = append(apiEntries, ApiTrashEntry{
+			ApiId(entry.ItemId),
+			string(entry.Kind),
+			entry.Name,
+			ApiId(entry.ParentId),
+			entry.TrashedAt})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(apiEntries)
+}
+
+// restoreFromTrash moves the item back inside the folder it was deleted from, the root folder when that one is gone
+func restoreFromTrash(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	policy, err := getConflictPolicy(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	idStr := vars["itemId"]
+	itemIds, err := resolveItemIds(r.Context(), idStr)
+	if err != nil {
+		fmt.Println(err, fmt.Sprintf("Error when trying to resolve item %s.", idStr))
+		http.Error(w, "", mapServiceErrorToHttpStatus(err))
+		return
+	}
+
+	// of the folder and the file which had the legacy id, the one inside the trash is restored
+	err = errors.WithMessage(errors.New(fsservice.NotFound), fmt.Sprintf("No item %s", idStr))
+	for _, itemId := range itemIds {
+		err = svc.RestoreFromTrash(r.Context(), itemId, policy)
+		if err == nil || errors.Cause(err).Error() != fsservice.NotFound {
+			break
+		}
+	}
 	if err != nil {
-		fmt.Println(err, fmt.Sprintf("Error when trying to move folder %d inside folder %d.", folderId, destFolderId))
+		errorCode := errors.Cause(err).Error()
+		if errorCode == fsservice.NotFound {
+			errorMsg := fmt.Sprintf("Could not find item %s inside the trash when trying to restore it.", idStr)
+			fmt.Println(err, errorMsg)
+			http.Error(w, errorMsg, http.StatusNotFound)
+		} else {
+			fmt.Println(err, fmt.Sprintf("Error when trying to restore item %s.", idStr))
+			http.Error(w, "", mapServiceErrorToHttpStatus(err))
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func emptyTrash(w http.ResponseWriter, r *http.Request) {
+	if _, err := svc.EmptyTrash(r.Context()); err != nil {
+		fmt.Println(err, "Error when trying to empty the trash.")
 		http.Error(w, "", mapServiceErrorToHttpStatus(err))
 		return
 	}
@@ -348,28 +714,193 @@ func moveFolder(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
-func deleteFile(w http.ResponseWriter, r *http.Request) {
+func getFileVersions(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	idStr := vars["fileId"]
+	fileId, err := resolveFileId(r.Context(), idStr)
+	var versions *[]fsmodel.FileVersion
+	if err == nil {
+		versions, err = svc.GetFileVersions(r.Context(), fileId)
+	}
+	if err != nil {
+		errorCode := errors.Cause(err).Error()
+		if errorCode == fsservice.NotFound {
+			errorMsg := fmt.Sprintf("Could not find file %s when trying to get its versions.", idStr)
+			fmt.Println(err, errorMsg)
+			http.Error(w, errorMsg, http.StatusNotFound)
+		} else {
+			fmt.Println(err, fmt.Sprintf("Error when trying to get the versions of file %s.", idStr))
+			http.Error(w, "", mapServiceErrorToHttpStatus(err))
+		}
+		return
+	}
+
+	apiVersions := make([]ApiFileVersion, 0)
+	for idx, version := range *versions {
+		var size *int64
+		if version.Size != fsmodel.UnknownSize {
+			size = &(*versions)[idx].Size
+		}
+		apiVersions = append(apiVersions, ApiFileVersion{
+			version.Number,
+			version.Digest,
+			size,
+			version.ContentType,
+			version.CreatedAt,
+			idx == 0})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(apiVersions)
+}
+
+// restoreFileVersion makes the content of the version the current one, as a new version of the file
+func restoreFileVersion(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 
-	var fileId *int
-	var idInt int
-	var err error
 	idStr := vars["fileId"]
-	if idInt, err = strconv.Atoi(idStr); err != nil {
+	number, err := strconv.Atoi(vars["number"])
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid version '%s'", vars["number"]), http.StatusBadRequest)
+		return
+	}
+
+	fileId, err := resolveFileId(r.Context(), idStr)
+	if err == nil {
+		err = svc.RestoreFileVersion(r.Context(), fileId, number)
+	}
+	if err != nil {
+		errorCode := errors.Cause(err).Error()
+		if errorCode == fsservice.NotFound {
+			errorMsg := fmt.Sprintf("Could not find version %d of file %s when trying to restore it.", number, idStr)
+			fmt.Println(err, errorMsg)
+			http.Error(w, errorMsg, http.StatusNotFound)
+		} else {
+			fmt.Println(err, fmt.Sprintf("Error when trying to restore version %d of file %s.", number, idStr))
+			http.Error(w, "", mapServiceErrorToHttpStatus(err))
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// updateFile renames the file, and replaces its content type when the body gives one
+func updateFile(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	var f ApiFile
+	err := json.NewDecoder(r.Body).Decode(&f)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	policy, err := getConflictPolicy(r)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	fileId = &idInt
 
-	err = svc.DeleteFile(*fileId)
+	idStr := vars["fileId"]
+	fileId, err := resolveFileId(r.Context(), idStr)
+	if err == nil {
+		err = svc.UpdateFile(r.Context(), fileId, f.Name, f.ContentType, policy)
+	}
 	if err != nil {
 		errorCode := errors.Cause(err).Error()
 		if errorCode == fsservice.NotFound {
-			errorMsg := fmt.Sprintf("Could not find file %d when trying to delete the file.", fileId)
+			errorMsg := fmt.Sprintf("Could not find file %s when trying to update the file.", idStr)
 			fmt.Println(err, errorMsg)
 			http.Error(w, errorMsg, http.StatusNotFound)
 		} else {
-			fmt.Println(err, fmt.Sprintf("Error when trying to delete file %d.", fileId))
+			fmt.Println(err, fmt.Sprintf("Error when trying to update file %s.", idStr))
+			http.Error(w, "", mapServiceErrorToHttpStatus(err))
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// replaceFileContent streams the body as the new content of the file, which keeps its id: the previous content stays
+// as a version. With an If-Match header, the content is only replaced when its ETag is one of those given.
+func replaceFileContent(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	idStr := vars["fileId"]
+	ifMatch := getIfMatchDigests(r)
+	fileId, err := resolveFileId(r.Context(), idStr)
+	if err == nil {
+		// fail early rather than after the whole content has been received: the replacement checks If-Match again
+		var file *fsmodel.File
+		if file, err = svc.GetFile(r.Context(), fileId); err == nil {
+			err = fsservice.ErrorIfContentNotMatched(*file, ifMatch)
+		}
+	}
+	if err != nil {
+		errorCode := errors.Cause(err).Error()
+		if errorCode == fsservice.NotFound {
+			errorMsg := fmt.Sprintf("Could not find file %s when trying to replace its content.", idStr)
+			fmt.Println(err, errorMsg)
+			http.Error(w, errorMsg, http.StatusNotFound)
+		} else {
+			fmt.Println(err, fmt.Sprintf("Error when trying to replace the content of file %s.", idStr))
+			http.Error(w, "", mapServiceErrorToHttpStatus(err))
+		}
+		return
+	}
+
+	content, err := svc.StoreFileContent(r.Context(), fsstorage.NewSizeLimitedReader(r.Body, maxUploadSize))
+	if err != nil {
+		if errors.Cause(err).Error() == fsstorage.BlobTooLarge {
+			errorMsg := fmt.Sprintf("The content of file %s exceeds the maximum upload size of %d bytes.", idStr, maxUploadSize)
+			fmt.Println(err, errorMsg)
+			http.Error(w, errorMsg, http.StatusRequestEntityTooLarge)
+			return
+		}
+		fmt.Println(err, fmt.Sprintf("Error when trying to store the new content of file %s.", idStr))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	err = svc.ReplaceFileContent(r.Context(), fileId, *content, ifMatch)
+	if err != nil {
+		errorCode := errors.Cause(err).Error()
+		if errorCode == fsservice.NotFound {
+			errorMsg := fmt.Sprintf("Could not find file %s when trying to replace its content.", idStr)
+			fmt.Println(err, errorMsg)
+			http.Error(w, errorMsg, http.StatusNotFound)
+		} else {
+			fmt.Println(err, fmt.Sprintf("Error when trying to replace the content of file %s.", idStr))
+			http.Error(w, "", mapServiceErrorToHttpStatus(err))
+		}
+		return
+	}
+
+	setDigestHeader(w, content.Digest)
+	w.Header().Set("ETag", fmt.Sprintf("\"%s\"", content.Digest))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func deleteFile(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	idStr := vars["fileId"]
+	fileId, err := resolveFileId(r.Context(), idStr)
+	if err == nil {
+		err = svc.DeleteFile(r.Context(), fileId)
+	}
+	if err != nil {
+		errorCode := errors.Cause(err).Error()
+		if errorCode == fsservice.NotFound {
+			errorMsg := fmt.Sprintf("Could not find file %s when trying to delete the file.", idStr)
+			fmt.Println(err, errorMsg)
+			http.Error(w, errorMsg, http.StatusNotFound)
+		} else {
+			fmt.Println(err, fmt.Sprintf("Error when trying to delete file %s.", idStr))
 			http.Error(w, "", mapServiceErrorToHttpStatus(err))
 		}
 		return
@@ -381,80 +912,492 @@ func deleteFile(w http.ResponseWriter, r *http.Request) {
 func moveFile(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 
-	var fileId int
-	var err error
-	fileIdStr := vars["fileId"]
-	if fileId, err = strconv.Atoi(fileIdStr); err != nil {
+	policy, err := getConflictPolicy(r)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	var destFolderId int
-	destFolderIdStr := vars["destFolderId"]
-	if destFolderId, err = strconv.Atoi(destFolderIdStr); err != nil {
+	fileIdStr, destFolderIdStr := vars["fileId"], vars["destFolderId"]
+	fileId, err := resolveFileId(r.Context(), fileIdStr)
+	var destFolderId string
+	if err == nil {
+		destFolderId, err = resolveDestFolderId(r.Context(), destFolderIdStr)
+	}
+	if err == nil {
+		err = svc.MoveFile(r.Context(), fileId, destFolderId, policy)
+	}
+	if err != nil {
+		fmt.Println(err, fmt.Sprintf("Error when trying to move file %s inside folder %s.", fileIdStr, destFolderIdStr))
+		http.Error(w, "", mapServiceErrorToHttpStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func copyFile(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	policy, err := getConflictPolicy(r)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	err = svc.MoveFile(fileId, destFolderId)
+	fileIdStr, destFolderIdStr := vars["fileId"], vars["destFolderId"]
+	fileId, err := resolveFileId(r.Context(), fileIdStr)
+	var destFolderId string
+	if err == nil {
+		destFolderId, err = resolveDestFolderId(r.Context(), destFolderIdStr)
+	}
+	var id *string
+	if err == nil {
+		id, err = svc.CopyFile(r.Context(), fileId, destFolderId, policy)
+	}
 	if err != nil {
-		fmt.Println(err, fmt.Sprintf("Error when trying to move file %d inside folder %d.", fileId, destFolderId))
+		fmt.Println(err, fmt.Sprintf("Error when trying to copy file %s inside folder %s.", fileIdStr, destFolderIdStr))
 		http.Error(w, "", mapServiceErrorToHttpStatus(err))
 		return
 	}
 
-	w.WriteHeader(http.StatusNoContent)
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprint(w, *id)
 }
 
 func uploadFile(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 
-	var destFolderId int
+	var destFolderId string
 	if destFolderIdStr, found := vars["destFolderId"]; found {
-		id, err := strconv.Atoi(destFolderIdStr)
+		id, err := resolveDestFolderId(r.Context(), destFolderIdStr)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			fmt.Println(err, fmt.Sprintf("Error when trying to upload a file inside folder %s.", destFolderIdStr))
+			http.Error(w, "", mapServiceErrorToHttpStatus(err))
 			return
 		}
 		destFolderId = id
 	}
 
-	// TODO externalize this part of the code
-	// 10 << 20 specifies a maximum upload of 10 MB files.
-	r.ParseMultipartForm(10 << 20)
-	file, handler, err := r.FormFile("file")
+	policy, err := getUploadConflictPolicy(r)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	defer file.Close()
 
-	exts := strings.Split(handler.Filename, ".")
-	ext := exts[len(exts)-1]
-	tempFile, err := ioutil.TempFile("tmp-files", fmt.Sprintf("upload-*.%s", ext))
+	// the content is streamed from the request to the blob store: never hold the whole file in memory
+	part, err := nextFormFilePart(r, "file")
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	defer tempFile.Close()
+	defer part.Close()
+	fileName := part.FileName()
 
-	fileBytes, err := ioutil.ReadAll(file)
+	content, err := svc.StoreFileContent(r.Context(), fsstorage.NewSizeLimitedReader(part, maxUploadSize))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		if errors.Cause(err).Error() == fsstorage.BlobTooLarge {
+			errorMsg := fmt.Sprintf("The file %s exceeds the maximum upload size of %d bytes.", fileName, maxUploadSize)
+			fmt.Println(err, errorMsg)
+			http.Error(w, errorMsg, http.StatusRequestEntityTooLarge)
+			return
+		}
+		fmt.Println(err, fmt.Sprintf("Error when trying to store the content of file named %s.", fileName))
+		http.Error(w, "", http.StatusInternalServerError)
 		return
 	}
 
-	tempFile.Write(fileBytes)
-
-	fileId, err := svc.CreateFile(handler.Filename, tempFile.Name(), destFolderId)
+	fileId, err := svc.CreateFile(r.Context(), fileName, *content, destFolderId, policy)
 	if err != nil {
-		fmt.Println(err, fmt.Sprintf("Error when trying to create file named %s inside folder %d.", handler.Filename, destFolderId))
+		fmt.Println(err, fmt.Sprintf("Error when trying to create file named %s inside folder %s.", fileName, destFolderId))
 		http.Error(w, "", mapServiceErrorToHttpStatus(err))
 		return
 	}
 
+	setDigestHeader(w, content.Digest)
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprint(w, *fileId)
+}
+
+// nextFormFilePart skips the parts of the multipart body until the file part named formName,
+// without buffering anything contrary to r.FormFile
+func nextFormFilePart(r *http.Request, formName string) (*multipart.Part, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, fmt.Errorf("Missing file part '%s' in the multipart body", formName)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() == formName && part.FileName() != "" {
+			return part, nil
+		}
+		part.Close()
+	}
+}
+
+// setDigestHeader lets the client check the integrity of the transferred content (RFC 3230)
+func setDigestHeader(w http.ResponseWriter, sha256Hex string) {
+	sha256Bytes, err := hex.DecodeString(sha256Hex)
+	if err != nil || len(sha256Bytes) == 0 {
+		return
+	}
+	w.Header().Set("Digest", fmt.Sprintf("sha-256=%s", base64.StdEncoding.EncodeToString(sha256Bytes)))
+}
+
+// getConflictPolicy reads what to do when the name is already used inside the folder: ?conflict=fail|rename|overwrite
+func getConflictPolicy(r *http.Request) (fsservice.ConflictPolicy, error) {
+	return fsservice.ParseConflictPolicy(r.URL.Query().Get("conflict"))
+}
+
+// getUploadConflictPolicy is getConflictPolicy for the uploads, which add a new version to the file of the same name
+// unless told otherwise
+func getUploadConflictPolicy(r *http.Request) (fsservice.ConflictPolicy, error) {
+	if r.URL.Query().Get("conflict") == "" {
+		return fsservice.ConflictVersion, nil
+	}
+	return getConflictPolicy(r)
+}
+
+// getIfMatchDigests reads the digests the If-Match header expects, the ETags being the quoted digests (RFC 7232): nil
+// when there is no header, or when it is * which any existing file matches. The weak ETags never match.
+func getIfMatchDigests(r *http.Request) []string {
+	ifMatch := strings.TrimSpace(r.Header.Get("If-Match"))
+	if ifMatch == "" || ifMatch == "*" {
+		return nil
+	}
+
+	digests := make([]string, 0)
+	for _, etag := range strings.Split(ifMatch, ",") {
+		etag = strings.TrimSpace(etag)
+		if len(etag) >= 2 && strings.HasPrefix(etag, "\"") && strings.HasSuffix(etag, "\"") {
+			digests = append(digests, etag[1:len(etag)-1])
+		}
+	}
+	return digests
+}
+
+// getListOptions reads which children of the folder to list, and in which order:
+// ?sort=name|type|size|modified&order=asc|desc&kind=file|folder&ext=jpg&name=photo*&limit=100&cursor=...
+func getListOptions(r *http.Request) (fsmodel.ListOptions, error) {
+	query := r.URL.Query()
+	return fsservice.ParseListOptions(fsservice.ListParams{
+		Sort:   query.Get("sort"),
+		Order:  query.Get("order"),
+		Kind:   query.Get("kind"),
+		Ext:    query.Get("ext"),
+		Name:   query.Get("name"),
+		Limit:  query.Get("limit"),
+		Cursor: query.Get("cursor"),
+	})
+}
+
+func getMaxUploadSize() int64 {
+	maxSizeStr := fsconfig.GetEnvOrDefault(MAX_UPLOAD_SIZE, strconv.FormatInt(defaultMaxUploadSize, 10))
+	maxSize, err := strconv.ParseInt(maxSizeStr, 10, 64)
+	if err != nil || maxSize <= 0 {
+		panic(fmt.Sprintf("Invalid value '%s' for environment variable %s: expected a positive number of bytes", maxSizeStr, MAX_UPLOAD_SIZE))
+	}
+	return maxSize
+}
+
+func getLegacyIDs() bool {
+	legacyIDsStr := fsconfig.GetEnvOrDefault(LEGACY_IDS, strconv.FormatBool(defaultLegacyIDs))
+	accepted, err := strconv.ParseBool(legacyIDsStr)
+	if err != nil {
+		panic(fmt.Sprintf("Invalid value '%s' for environment variable %s: expected true or false", legacyIDsStr, LEGACY_IDS))
+	}
+	return accepted
+}
+
+func getTrashRetention() time.Duration {
+	retentionStr := fsconfig.GetEnvOrDefault(TRASH_RETENTION, defaultTrashRetention.String())
+	retention, err := time.ParseDuration(retentionStr)
+	if err != nil || retention < 0 {
+		panic(fmt.Sprintf("Invalid value '%s' for environment variable %s: expected a duration such as 720h, or 0", retentionStr, TRASH_RETENTION))
+	}
+	return retention
+}
+
+func getMaxFileVersions() int {
+	maxVersionsStr := fsconfig.GetEnvOrDefault(MAX_FILE_VERSIONS, strconv.Itoa(defaultMaxFileVersions))
+	maxVersions, err := strconv.Atoi(maxVersionsStr)
+	if err != nil || maxVersions < 0 {
+		panic(fmt.Sprintf("Invalid value '%s' for environment variable %s: expected a number of versions, or 0", maxVersionsStr, MAX_FILE_VERSIONS))
+	}
+	return maxVersions
+}
+
+func getTusOptions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", tusExtensions)
+	w.Header().Set("Tus-Max-Size", strconv.FormatInt(maxUploadSize, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func createUpload(w http.ResponseWriter, r *http.Request) {
+	if !checkTusResumable(w, r) {
+		return
+	}
+	vars := mux.Vars(r)
+
+	policy, err := getUploadConflictPolicy(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		http.Error(w, "Missing or invalid Upload-Length header", http.StatusBadRequest)
+		return
+	}
+	if length > maxUploadSize {
+		errorMsg := fmt.Sprintf("The upload length %d exceeds the maximum upload size of %d bytes.", length, maxUploadSize)
+		http.Error(w, errorMsg, http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	metadata, err := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fileName := metadata["filename"]
+	if fileName == "" {
+		fileName = metadata["name"]
+	}
+	if fileName == "" {
+		http.Error(w, "Missing 'filename' in the Upload-Metadata header", http.StatusBadRequest)
+		return
+	}
+
+	// fail early rather than after the whole content has been uploaded
+	destFolderIdStr := vars["destFolderId"]
+	destFolderId, err := resolveFolderId(r.Context(), destFolderIdStr)
+	if err == nil {
+		_, err = svc.GetFolder(r.Context(), destFolderId)
+	}
+	if err != nil {
+		fmt.Println(err, fmt.Sprintf("Error when trying to create an upload for file named %s inside folder %s.", fileName, destFolderIdStr))
+		if errors.Cause(err).Error() == fsservice.NotFound {
+			http.Error(w, fmt.Sprintf("Could not find destination folder %s", destFolderIdStr), http.StatusBadRequest)
+		} else {
+			http.Error(w, "", mapServiceErrorToHttpStatus(err))
+		}
+		return
+	}
+
+	upload, err := uploads.Create(length, fileName, destFolderId, string(policy))
+	if err != nil {
+		fmt.Println(err, fmt.Sprintf("Error when trying to create an upload for file named %s inside folder %s.", fileName, destFolderId))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	if upload.IsComplete() {
+		// nothing to wait for with an empty file
+		if upload, err = completeUpload(r.Context(), upload.Id); err != nil {
+			fmt.Println(err, fmt.Sprintf("Error when trying to create file named %s inside folder %s.", fileName, destFolderId))
+			http.Error(w, "", mapServiceErrorToHttpStatus(err))
+			return
+		}
+	}
+
+	setUploadHeaders(w, upload)
+	w.Header().Set("Location", fmt.Sprintf("/uploads/%s", upload.Id))
 	w.WriteHeader(http.StatusCreated)
-	fmt.Fprintf(w, strconv.Itoa(*fileId))
+}
+
+func getUploadOffset(w http.ResponseWriter, r *http.Request) {
+	if !checkTusResumable(w, r) {
+		return
+	}
+	uploadId := mux.Vars(r)["uploadId"]
+
+	upload, err := uploads.Get(uploadId)
+	if err != nil {
+		fmt.Println(err, fmt.Sprintf("Error when trying to get upload %s.", uploadId))
+		http.Error(w, "", mapUploadErrorToHttpStatus(err))
+		return
+	}
+
+	setUploadHeaders(w, upload)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+func patchUpload(w http.ResponseWriter, r *http.Request) {
+	if !checkTusResumable(w, r) {
+		return
+	}
+	uploadId := mux.Vars(r)["uploadId"]
+
+	if r.Header.Get("Content-Type") != tusContentType {
+		http.Error(w, fmt.Sprintf("Expected Content-Type %s", tusContentType), http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, "Missing or invalid Upload-Offset header", http.StatusBadRequest)
+		return
+	}
+
+	upload, err := uploads.WriteChunk(uploadId, offset, r.Body)
+	if err != nil && errors.Cause(err).Error() == fsupload.UploadCompleted {
+		// the client did not receive the response of the last chunk: tell it again
+		upload, err = uploads.Get(uploadId)
+	}
+	if err != nil {
+		fmt.Println(err, fmt.Sprintf("Error when trying to write a chunk at offset %d of upload %s.", offset, uploadId))
+		http.Error(w, "", mapUploadErrorToHttpStatus(err))
+		return
+	}
+
+	if upload.IsComplete() && upload.FileId == nil {
+		if upload, err = completeUpload(r.Context(), uploadId); err != nil {
+			fmt.Println(err, fmt.Sprintf("Error when trying to turn upload %s into a file.", uploadId))
+			http.Error(w, "", mapServiceErrorToHttpStatus(err))
+			return
+		}
+	}
+
+	setUploadHeaders(w, upload)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func deleteUpload(w http.ResponseWriter, r *http.Request) {
+	if !checkTusResumable(w, r) {
+		return
+	}
+	uploadId := mux.Vars(r)["uploadId"]
+
+	if err := uploads.Delete(uploadId); err != nil {
+		fmt.Println(err, fmt.Sprintf("Error when trying to delete upload %s.", uploadId))
+		http.Error(w, "", mapUploadErrorToHttpStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// completeUpload stores the content of a fully received upload and creates the file in the destination folder
+func completeUpload(ctx context.Context, uploadId string) (*fsupload.Upload, error) {
+	return uploads.Complete(uploadId, func(upload fsupload.Upload, uploadContent io.Reader) (string, error) {
+		// the uploads started before the ids became opaque recorded the integer id of their folder
+		destFolderId, err := resolveDestFolderId(ctx, upload.DestFolderId)
+		if err != nil {
+			return "", err
+		}
+
+		content, err := svc.StoreFileContent(ctx, uploadContent)
+		if err != nil {
+			return "", err
+		}
+
+		policy, err := fsservice.ParseConflictPolicy(upload.ConflictPolicy)
+		if err != nil {
+			return "", err
+		}
+		fileId, err := svc.CreateFile(ctx, upload.FileName, *content, destFolderId, policy)
+		if err != nil {
+			return "", err
+		}
+		return *fileId, nil
+	})
+}
+
+func checkTusResumable(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		http.Error(w, fmt.Sprintf("Unsupported tus version, expected %s", tusVersion), http.StatusPreconditionFailed)
+		return false
+	}
+	return true
+}
+
+func setUploadHeaders(w http.ResponseWriter, upload *fsupload.Upload) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	w.Header().Set("Upload-Expires", upload.ExpiresAt.Format(http.TimeFormat))
+	if upload.FileId != nil {
+		w.Header().Set("X-File-Id", *upload.FileId)
+	}
+}
+
+// parseTusMetadata parses the Upload-Metadata header: comma separated "key base64(value)" pairs
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		keyValue := strings.SplitN(pair, " ", 2)
+		value := ""
+		if len(keyValue) == 2 {
+			decoded, err := base64.StdEncoding.DecodeString(keyValue[1])
+			if err != nil {
+				return nil, fmt.Errorf("Could not decode the value of '%s' in the Upload-Metadata header", keyValue[0])
+			}
+			value = string(decoded)
+		}
+		metadata[keyValue[0]] = value
+	}
+	return metadata, nil
+}
+
+func purgeExpiredUploadsPeriodically() {
+	for range time.Tick(uploadPurgeInterval) {
+		purged, err := uploads.PurgeExpired()
+		if err != nil {
+			fmt.Println(err, "Error when trying to purge the expired uploads.")
+		}
+		if purged > 0 {
+			fmt.Printf("Purged %d expired uploads\n", purged)
+		}
+	}
+}
+
+func purgeDeletedContentPeriodically() {
+	for range time.Tick(contentPurgeInterval) {
+		purged, err := svc.PurgeDeletedContent(context.Background())
+		if err != nil {
+			fmt.Println(err, "Error when trying to purge the content of the deleted files.")
+		}
+		if purged > 0 {
+			fmt.Printf("Purged the content of %d deleted files\n", purged)
+		}
+
+		purged, err = svc.PurgeStaleContent(context.Background(), time.Now().Add(-stagedContentRetention))
+		if err != nil {
+			fmt.Println(err, "Error when trying to purge the stale staged content.")
+		}
+		if purged > 0 {
+			fmt.Printf("Purged %d stale staged contents\n", purged)
+		}
+	}
+}
+
+func purgeTrashPeriodically() {
+	for range time.Tick(trashPurgeInterval) {
+		purged, err := svc.PurgeTrash(context.Background(), time.Now().Add(-trashRetention))
+		if err != nil {
+			fmt.Println(err, "Error when trying to purge the trash.")
+		}
+		if purged > 0 {
+			fmt.Printf("Purged %d items from the trash\n", purged)
+		}
+	}
 }
 
 func healthCheckStatusOK(w http.ResponseWriter, r *http.Request) {
@@ -471,6 +1414,36 @@ func mapServiceErrorToHttpStatus(svcError error) int {
 		return http.StatusBadRequest
 	case fsservice.IllegalOperation:
 		return http.StatusBadRequest
+	case fsservice.Conflict:
+		return http.StatusConflict
+	case fsservice.PreconditionFailed:
+		return http.StatusPreconditionFailed
+	case context.DeadlineExceeded.Error():
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func mapStorageErrorToHttpStatus(storageError error) int {
+	errorCode := errors.Cause(storageError).Error()
+	switch errorCode {
+	case fsstorage.BlobNotFound:
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func mapUploadErrorToHttpStatus(uploadError error) int {
+	errorCode := errors.Cause(uploadError).Error()
+	switch errorCode {
+	case fsupload.UploadNotFound:
+		return http.StatusNotFound
+	case fsupload.UploadOffsetMismatch:
+		return http.StatusConflict
+	case fsupload.UploadTooLarge:
+		return http.StatusRequestEntityTooLarge
 	default:
 		return http.StatusInternalServerError
 	}